@@ -60,6 +60,7 @@ const (
 	LK_OPEQ CompareOp = iota // ==
 	LK_OPLT                  // <
 	LK_OPLE                  // <=
+	LK_OPIN                  // in
 )
 
 /* thread status */
@@ -75,3 +76,20 @@ const (
 	LK_ERRERR
 	LK_ERRFILE
 )
+
+/* hook event codes */
+const (
+	LK_HOOKCALL = iota
+	LK_HOOKRET
+	LK_HOOKLINE
+	LK_HOOKCOUNT
+	LK_HOOKTAILCALL // with LK_MASKCALL, no LK_HOOKRET follows
+)
+
+/* hook event masks */
+const (
+	LK_MASKCALL  = 1 << LK_HOOKCALL
+	LK_MASKRET   = 1 << LK_HOOKRET
+	LK_MASKLINE  = 1 << LK_HOOKLINE
+	LK_MASKCOUNT = 1 << LK_HOOKCOUNT
+)
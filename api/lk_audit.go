@@ -0,0 +1,15 @@
+package api
+
+// AuditEvent describes a privileged operation (file system, process,
+// network...) requested by a script, and where it was requested from.
+type AuditEvent struct {
+	Op     string // eg: "os.rm", "http.req"
+	Args   []any
+	Source string
+	Line   int
+}
+
+// AuditFunc is called by the libs before each privileged operation.
+// Embedders running untrusted scripts set it to monitor them.
+// Raising an error with ls (eg: ls.Error2) denies the operation.
+type AuditFunc func(ls LkState, ev AuditEvent)
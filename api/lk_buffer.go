@@ -0,0 +1,53 @@
+package api
+
+import "strings"
+
+// Buffer accumulates a string piece by piece in Go memory
+// and pushes it onto the stack only once, in PushResult.
+// It is the equivalent of luaL_Buffer.
+// http://www.lua.org/manual/5.3/manual.html#luaL_Buffer
+type Buffer struct {
+	ls LkState
+	sb strings.Builder
+}
+
+func NewBuffer(ls LkState) *Buffer {
+	return &Buffer{ls: ls}
+}
+
+// http://www.lua.org/manual/5.3/manual.html#luaL_buffinitsize
+func (b *Buffer) Grow(n int) {
+	b.sb.Grow(n)
+}
+
+func (b *Buffer) Len() int {
+	return b.sb.Len()
+}
+
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_addchar
+func (b *Buffer) AddChar(c byte) {
+	b.sb.WriteByte(c)
+}
+
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_addstring
+func (b *Buffer) AddString(s string) {
+	b.sb.WriteString(s)
+}
+
+// AddValue converts the value on the top of the stack
+// like ToString2 does, adds it to the buffer and pops it.
+// [-1, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_addvalue
+func (b *Buffer) AddValue() {
+	b.sb.WriteString(b.ls.ToString2(-1))
+	b.ls.Pop(2) /* value and its string form */
+}
+
+// [-?, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_pushresult
+func (b *Buffer) PushResult() {
+	b.ls.PushString(b.sb.String())
+	b.sb.Reset()
+}
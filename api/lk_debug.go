@@ -0,0 +1,72 @@
+package api
+
+// Hook is called by the VM on the events selected by the hook mask.
+// line is the line about to be executed for LK_HOOKLINE
+// and LK_HOOKCOUNT events, -1 otherwise.
+// http://www.lua.org/manual/5.3/manual.html#lua_Hook
+type Hook func(ls LkState, event, line int)
+
+// DebugInfo carries information about an active function.
+// It is the equivalent of lua_Debug.
+// http://www.lua.org/manual/5.3/manual.html#lua_Debug
+type DebugInfo struct {
+	Source          string
+	CurrentLine     int
+	LineDefined     int
+	LastLineDefined int
+	What            string // "lk", "Go" or "main"
+	NumParams       int
+	IsVararg        bool
+	NumUpvalues     int
+}
+
+// ChainHook sets f as the hook of ls for the events of mask, counting count
+// instructions between LK_HOOKCOUNT events, in front of the hook already set:
+// that one keeps receiving the events it was set for.
+// restore sets the previous hook back; hooks chained on top of each other
+// must be restored in reverse order.
+func ChainHook(ls LkState, mask, count int, f Hook) (restore func()) {
+	prev, prevMask, prevCount := ls.GetHook(), ls.GetHookMask(), ls.GetHookCount()
+	if prev == nil {
+		prevMask = 0
+	}
+	if count <= 0 {
+		mask &^= LK_MASKCOUNT
+	}
+	// a single count hook fires every step instructions for both
+	step := 0
+	switch {
+	case mask&LK_MASKCOUNT == 0:
+		step = prevCount
+	case prevMask&LK_MASKCOUNT == 0 || count < prevCount:
+		step = count
+	default:
+		step = prevCount
+	}
+	ownN, prevN := 0, 0
+	ls.SetHook(mask|prevMask, step, func(ls LkState, event, line int) {
+		bit := 1 << event
+		if event == LK_HOOKTAILCALL {
+			bit = LK_MASKCALL
+		}
+		if mask&bit != 0 {
+			if event != LK_HOOKCOUNT {
+				f(ls, event, line)
+			} else if ownN += step; ownN >= count {
+				ownN -= count
+				f(ls, event, line)
+			}
+		}
+		if prevMask&bit != 0 {
+			if event != LK_HOOKCOUNT {
+				prev(ls, event, line)
+			} else if prevN += step; prevN >= prevCount {
+				prevN -= prevCount
+				prev(ls, event, line)
+			}
+		}
+	})
+	return func() {
+		ls.SetHook(prevMask, prevCount, prev)
+	}
+}
@@ -1,5 +1,12 @@
 package api
 
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"time"
+)
+
 type GoFunction func(LkState) int
 
 func LkUpvalueIndex(i int) int {
@@ -48,6 +55,7 @@ type BasicAPI interface {
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
 	ToThread(idx int) LkState
+	ToUserData(idx int) any
 	ToPointer(idx int) interface{}
 	/* push functions (Go -> stack) */
 	PushNil()
@@ -60,6 +68,7 @@ type BasicAPI interface {
 	PushGoClosure(f GoFunction, n int)
 	PushGlobalTable()
 	PushThread() bool
+	NewUserData(v any)
 	Push(item any)
 	PushCopyTable(idx int)
 	/* Comparison and arithmetic functions */
@@ -82,23 +91,61 @@ type BasicAPI interface {
 	RawSet(idx int)
 	RawSetI(idx int, i int64)
 	SetGlobal(name string)
+	SetGlobalValue(name string, v any)
 	Register(name string, f GoFunction)
 	/* 'load' and 'call' functions (load and run Lua code) */
 	Load(chunk []byte, chunkName, mode string) LkStatus
+	SetChunkKey(key ed25519.PublicKey)
+	Dump(strip bool) []byte
 	Call(nArgs, nResults int)
 	PCall(nArgs, nResults, msgh int) LkStatus
+	CallContext(ctx context.Context, nArgs, nResults int)
+	PCallContext(ctx context.Context, nArgs, nResults, msgh int) LkStatus
+	CallGlobal(name string, args ...any) ([]any, error)
 	/* miscellaneous functions */
 	Len(idx int)
 	Next(idx int) bool
+	Reserve(idx, n int)
 	Error() int
 	StringToNumber(s string) bool
+	SetContext(ctx context.Context)
+	Context() context.Context
+	Globals() map[string]any // a snapshot, taken on the goroutine of the state
 	/* coroutine functions */
 	NewThread() LkState
 	Resume(from LkState, nArgs int) LkStatus
 	Yield(nResults int) LkStatus
 	Status() LkStatus
 	IsYieldable() bool
+	CloseThread(from LkState) LkStatus
+	Threads() []LkState
+	/* parallel states */
+	NewState() LkState
+	Export(idx int) any
+	Import(v any)
 	GetStack() bool // debug
+	/* debug functions */
+	GetStackInfo(level int) (DebugInfo, bool)
+	GetLocal(level, n int) (string, bool)
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	SetHook(mask, count int, f Hook)
+	GetHook() Hook
+	GetHookMask() int
+	GetHookCount() int
+	SetTrace(w io.Writer)
+	/* audit functions */
+	SetAuditHook(f AuditFunc)
+	Audit(op string, args ...any)
+	/* event functions */
+	Emit(name string, args ...any)
+	PollEvent() (name string, args []any, ok bool)
+	WaitEvent(timeout time.Duration) bool
+	DispatchEvents() (int, error)
+	/* limit functions */
+	SetLimits(maxInstructions int64, maxDuration time.Duration)
+	SetMemLimit(bytes int64)
+	MemUsage() int64
 
 	// isRepl: is in repl mode.
 	// 如果处于 repl，则只输出最后的栈的情况
@@ -131,6 +178,8 @@ type AuxLib interface {
 	LoadFile(filename string) LkStatus
 	LoadFileX(filename, mode string) LkStatus
 	LoadString(s, source string) LkStatus
+	/* Buffer functions */
+	BuffInit() *Buffer
 	/* Other functions */
 	TypeName2(idx int) string
 	ToString2(idx int) string
@@ -138,6 +187,7 @@ type AuxLib interface {
 	GetSubTable(idx int, fname string) bool
 	GetMetafield(obj int, e string) LkType
 	CallMeta(obj int, e string) bool
+	Traceback(msg string, level int) string
 	OpenLibs()
 	RequireF(modname string, openf GoFunction, glb bool)
 	NewLib(l FuncReg)
@@ -7,8 +7,10 @@ type LkVM interface {
 	Fetch() uint32
 	GetConst(idx int)
 	GetRK(rk int)
+	GetTableRK(idx, rk int)
 	RegisterCount() int
 	LoadVararg(n int)
 	LoadProto(idx int)
 	CloseUpvalues(a int)
+	TailCall(nArgs int) bool
 }
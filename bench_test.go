@@ -26,6 +26,10 @@ func contains[T string](list []T, item T) bool {
 }
 
 func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" { /* run as lk, see TestBuildArgs */
+		main()
+		os.Exit(0)
+	}
 	files, err := os.ReadDir("test")
 	if err != nil {
 		panic(err)
@@ -38,6 +42,7 @@ func TestMain(m *testing.M) {
 		println("=== " + name + " ===")
 		runVM("test/" + name)
 	}
+	os.Exit(m.Run())
 }
 
 func BenchmarkRun(b *testing.B) {
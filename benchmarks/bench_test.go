@@ -0,0 +1,85 @@
+package benchmarks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/state"
+)
+
+// run loads the program once, then calls it b.N times.
+// setup, if not nil, prepares the globals of the state.
+func run(b *testing.B, file string, setup func(ls LkState)) {
+	runContext(b, context.Background(), file, setup)
+}
+
+// runContext is run, calling the program with ctx.
+func runContext(b *testing.B, ctx context.Context, file string, setup func(ls LkState)) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ls := state.New()
+	ls.OpenLibs()
+	if setup != nil {
+		setup(ls)
+	}
+	if ls.Load(data, file, "bt") != LK_OK {
+		b.Fatal(ls.ToString2(-1))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ls.PushValue(-1)
+		if ls.PCallContext(ctx, 0, 0, 0) != LK_OK {
+			b.Fatal(ls.ToString2(-1))
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	run(b, "fib.lk", nil)
+}
+
+func BenchmarkLoop(b *testing.B) {
+	run(b, "loop.lk", nil)
+}
+
+// The VM checks a cancelable context before each instruction,
+// while the loop above checks nothing.
+func BenchmarkLoopContext(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runContext(b, ctx, "loop.lk", nil)
+}
+
+func BenchmarkTable(b *testing.B) {
+	run(b, "table.lk", nil)
+}
+
+func BenchmarkStr(b *testing.B) {
+	run(b, "str.lk", nil)
+}
+
+func BenchmarkGlobals(b *testing.B) {
+	run(b, "globals.lk", nil)
+}
+
+func BenchmarkJson(b *testing.B) {
+	run(b, "json.lk", nil)
+}
+
+func BenchmarkHttpEcho(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer srv.Close()
+	run(b, "http_echo.lk", func(ls LkState) {
+		ls.SetGlobalValue("url", srv.URL)
+	})
+}
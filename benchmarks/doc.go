@@ -0,0 +1,4 @@
+// Package benchmarks holds lk programs representative of real scripts,
+// run by `go test -bench . ./benchmarks`.
+// Use scripts/bench.lk to compare the results with the previous run.
+package benchmarks
@@ -1,16 +1,54 @@
 package binchunk
 
 import (
-	"errors"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 
 	"github.com/lollipopkit/lk/consts"
 	. "github.com/lollipopkit/lk/json"
 )
 
+// Version of the chunk format, increased on each incompatible change.
+// Chunks dumped before versioning have version 0.
+// Since version 2, the protos are in a body covered by a checksum.
+// Since version 3, the code may use fused opcodes.
+// Since version 4, the code may use the in operator (OP_IN, OP_IN_JMP).
+const Version = 4
+
 type binaryChunk struct {
-	Sign  string     `json:"si"`
-	Md5   string     `json:"m"`
+	Sign    string `json:"si"`
+	Version int    `json:"v"`
+	Md5     string `json:"m"`
+	// version 2
+	Body json.RawMessage `json:"b,omitempty"`
+	Sum  string          `json:"sum,omitempty"` // sha256 of Body, hex
+	Sig  []byte          `json:"sig,omitempty"` // ed25519 signature of Body
+	// version 0 and 1
+	Proto   *Prototype            `json:"p,omitempty"`
+	Modules map[string]*Prototype `json:"mods,omitempty"`
+}
+
+type chunkBody struct {
 	Proto *Prototype `json:"p"`
+	// modules bundled with the main proto, by import name
+	Modules map[string]*Prototype `json:"mods,omitempty"`
+}
+
+// Chunk is a loaded binary chunk with its metadata.
+type Chunk struct {
+	Version int
+	Md5     string // of the source
+	Signed  bool   // by the key of UndumpOptions
+	Proto   *Prototype
+	Modules map[string]*Prototype // nil if not a bundle
+}
+
+type DumpOptions struct {
+	Strip    bool               // omit the debug info, see Strip
+	Compress bool               // see Compress
+	Key      ed25519.PrivateKey // sign the chunk if set
 }
 
 // function prototype
@@ -42,23 +80,64 @@ type LocVar struct {
 }
 
 func Load(data []byte) (*Prototype, error) {
-	var bin binaryChunk
-	err := Json.Unmarshal(data, &bin)
+	chunk, err := Undump(data, UndumpOptions{})
 	if err != nil {
 		return nil, err
 	}
-	if bin.Sign != consts.SIGNATURE {
-		return nil, errors.New("invalid signature: " + bin.Sign)
-	}
+	return chunk.Proto, nil
+}
 
-	return bin.Proto, nil
+// Dump serializes proto.
+func (proto *Prototype) Dump(md5 string, opts DumpOptions) ([]byte, error) {
+	return DumpBundle(proto, nil, md5, opts)
 }
 
-func (proto *Prototype) Dump(md5 string) ([]byte, error) {
+// DumpBundle dumps main with the protos of the modules it imports,
+// so a single chunk holds a whole program.
+// The package searcher finds the modules by their import name.
+// The encoder sorts map keys, so the output only depends on the inputs.
+func DumpBundle(main *Prototype, modules map[string]*Prototype, md5 string, opts DumpOptions) ([]byte, error) {
+	if opts.Strip {
+		main = main.Strip()
+		stripped := make(map[string]*Prototype, len(modules))
+		for name, proto := range modules {
+			stripped[name] = proto.Strip()
+		}
+		modules = stripped
+	}
+	body, err := Json.Marshal(chunkBody{Proto: main, Modules: modules})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
 	bin := &binaryChunk{
-		Sign:  consts.SIGNATURE,
-		Proto: proto,
-		Md5:   md5,
+		Sign:    consts.SIGNATURE,
+		Version: Version,
+		Md5:     md5,
+		Body:    body,
+		Sum:     hex.EncodeToString(sum[:]),
+	}
+	if opts.Key != nil {
+		bin.Sig = ed25519.Sign(opts.Key, body)
+	}
+	data, err := Json.Marshal(bin)
+	if err != nil || !opts.Compress {
+		return data, err
+	}
+	return Compress(data)
+}
+
+// Strip returns a copy of proto without line info,
+// local variable names and upvalue names.
+// Errors then have no line numbers.
+func (proto *Prototype) Strip() *Prototype {
+	p := *proto
+	p.LineInfo = nil
+	p.LocVars = nil
+	p.UpvalueNames = nil
+	p.Protos = make([]*Prototype, len(proto.Protos))
+	for i, sub := range proto.Protos {
+		p.Protos[i] = sub.Strip()
 	}
-	return Json.Marshal(bin)
+	return &p
 }
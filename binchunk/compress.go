@@ -0,0 +1,52 @@
+package binchunk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// Compressed chunks are gzip streams, told apart
+// from plain chunks (json objects) by their first byte.
+const gzipMagic = 0x1f
+
+// MaxChunkSize is the size compressed chunks can't decompress beyond,
+// so a small malicious chunk can't use up the memory.
+var MaxChunkSize int64 = 256 << 20
+
+// Compress compresses a dumped chunk. Undump decompresses it.
+func Compress(chunk []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(chunk); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isCompressed(data []byte) bool {
+	return len(data) > 0 && data[0] == gzipMagic
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err == nil {
+		data, err = io.ReadAll(io.LimitReader(r, MaxChunkSize+1))
+	}
+	switch {
+	case err == nil && int64(len(data)) > MaxChunkSize:
+		return nil, undumpErr(ErrTooLarge, "compressed chunk: more than %d bytes", MaxChunkSize)
+	case err == nil:
+		return data, nil
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return nil, undumpErr(ErrTruncated, "compressed chunk: %v", err)
+	}
+	return nil, undumpErr(ErrMalformed, "compressed chunk: %v", err)
+}
@@ -0,0 +1,27 @@
+package binchunk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecompressLimit(t *testing.T) {
+	defer func(max int64) { MaxChunkSize = max }(MaxChunkSize)
+	MaxChunkSize = 1 << 10
+	for _, tt := range []struct {
+		size int
+		err  error
+	}{
+		{1 << 10, nil},
+		{1<<10 + 1, ErrTooLarge},
+	} {
+		data, err := Compress(bytes.Repeat([]byte{' '}, tt.size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decompress(data); !errors.Is(err, tt.err) {
+			t.Errorf("%d bytes: got %v, want %v", tt.size, err, tt.err)
+		}
+	}
+}
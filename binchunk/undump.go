@@ -0,0 +1,183 @@
+package binchunk
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lollipopkit/lk/consts"
+	. "github.com/lollipopkit/lk/json"
+)
+
+// Kinds of UndumpError, to be checked with errors.Is
+var (
+	ErrSignature = errors.New("bad signature")
+	ErrVersion   = errors.New("version mismatch")
+	ErrTruncated = errors.New("truncated chunk")
+	ErrMalformed = errors.New("malformed chunk")
+	ErrChecksum  = errors.New("checksum mismatch")
+	ErrVerify    = errors.New("signature verification failed")
+	ErrTooLarge  = errors.New("chunk too large")
+)
+
+type UndumpOptions struct {
+	// if set, only chunks signed by the matching private key are loaded
+	Key ed25519.PublicKey
+}
+
+type UndumpError struct {
+	Err    error // one of the Err* above
+	Detail string
+}
+
+func (e *UndumpError) Error() string {
+	return e.Err.Error() + ": " + e.Detail
+}
+
+func (e *UndumpError) Unwrap() error {
+	return e.Err
+}
+
+func undumpErr(err error, format string, a ...any) *UndumpError {
+	return &UndumpError{Err: err, Detail: fmt.Sprintf(format, a...)}
+}
+
+// Undump reads a chunk written by Dump or DumpBundle, compressed or not.
+// The header, the checksum, the signature and the layout of every proto
+// are checked, errors are *UndumpError.
+func Undump(data []byte, opts UndumpOptions) (*Chunk, error) {
+	if len(data) == 0 {
+		return nil, undumpErr(ErrTruncated, "empty chunk")
+	}
+	if isCompressed(data) {
+		var err error
+		if data, err = decompress(data); err != nil {
+			return nil, err
+		}
+	}
+	var bin binaryChunk
+	if err := Json.Unmarshal(data, &bin); err != nil {
+		return nil, syntaxErr(data, err)
+	}
+	if bin.Sign != consts.SIGNATURE {
+		return nil, undumpErr(ErrSignature, "expect %q, got %q", consts.SIGNATURE, bin.Sign)
+	}
+	if bin.Version > Version {
+		return nil, undumpErr(ErrVersion, "chunk version %d, supported up to %d", bin.Version, Version)
+	}
+
+	body := chunkBody{Proto: bin.Proto, Modules: bin.Modules}
+	if bin.Version >= 2 {
+		if err := checkBody(&bin, opts); err != nil {
+			return nil, err
+		}
+		body = chunkBody{}
+		if err := Json.Unmarshal(bin.Body, &body); err != nil {
+			return nil, undumpErr(ErrMalformed, "body: %v", err)
+		}
+	} else if opts.Key != nil {
+		return nil, undumpErr(ErrVerify, "chunk version %d can't be signed", bin.Version)
+	}
+
+	if body.Proto == nil {
+		return nil, undumpErr(ErrMalformed, "no main function")
+	}
+	if err := checkProto(body.Proto, "main"); err != nil {
+		return nil, err
+	}
+	for name, proto := range body.Modules {
+		if proto == nil {
+			return nil, undumpErr(ErrMalformed, "module %q: no main function", name)
+		}
+		if err := checkProto(proto, "module "+name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Chunk{
+		Version: bin.Version,
+		Md5:     bin.Md5,
+		Signed:  opts.Key != nil,
+		Proto:   body.Proto,
+		Modules: body.Modules,
+	}, nil
+}
+
+func checkBody(bin *binaryChunk, opts UndumpOptions) error {
+	if len(bin.Body) == 0 {
+		return undumpErr(ErrMalformed, "no body")
+	}
+	want, err := hex.DecodeString(bin.Sum)
+	if err != nil || len(want) != sha256.Size {
+		return undumpErr(ErrChecksum, "invalid checksum %q", bin.Sum)
+	}
+	if sum := sha256.Sum256(bin.Body); !bytes.Equal(sum[:], want) {
+		return undumpErr(ErrChecksum, "body was modified")
+	}
+	if opts.Key == nil {
+		return nil
+	}
+	if len(bin.Sig) == 0 {
+		return undumpErr(ErrVerify, "chunk is not signed")
+	}
+	if !ed25519.Verify(opts.Key, bin.Body, bin.Sig) {
+		return undumpErr(ErrVerify, "chunk is not signed by this key")
+	}
+	return nil
+}
+
+// syntaxErr tells a chunk cut short from a corrupted one.
+func syntaxErr(data []byte, err error) *UndumpError {
+	var syntax *json.SyntaxError
+	if !json.Valid(data) && errors.As(json.Unmarshal(data, new(json.RawMessage)), &syntax) &&
+		syntax.Offset >= int64(len(data)) {
+		return undumpErr(ErrTruncated, "unexpected end at byte %d", len(data))
+	}
+	return undumpErr(ErrMalformed, "%v", err)
+}
+
+// checkProto checks the layout of proto. Its code is checked by vm.Verify.
+func checkProto(proto *Prototype, name string) error {
+	switch {
+	case len(proto.Code) == 0:
+		return undumpErr(ErrMalformed, "%s: no code", name)
+	case len(proto.LineInfo) != 0 && len(proto.LineInfo) != len(proto.Code):
+		return undumpErr(ErrMalformed, "%s: %d line infos for %d instructions",
+			name, len(proto.LineInfo), len(proto.Code))
+	case len(proto.UpvalueNames) != 0 && len(proto.UpvalueNames) != len(proto.Upvalues):
+		return undumpErr(ErrMalformed, "%s: %d upvalue names for %d upvalues",
+			name, len(proto.UpvalueNames), len(proto.Upvalues))
+	case proto.NumParams > proto.MaxStackSize:
+		return undumpErr(ErrMalformed, "%s: %d params for stack size %d",
+			name, proto.NumParams, proto.MaxStackSize)
+	case proto.LastLineDefined < proto.LineDefined:
+		return undumpErr(ErrMalformed, "%s: ends at line %d before line %d",
+			name, proto.LastLineDefined, proto.LineDefined)
+	}
+	for _, v := range proto.LocVars {
+		if v.StartPC > v.EndPC || int(v.EndPC) > len(proto.Code) {
+			return undumpErr(ErrMalformed, "%s: local %q: invalid pc range [%d, %d)",
+				name, v.VarName, v.StartPC, v.EndPC)
+		}
+	}
+	for i, k := range proto.Constants {
+		switch k.(type) {
+		case nil, bool, float64, int64, string:
+		default:
+			return undumpErr(ErrMalformed, "%s: constant %d: invalid type %T", name, i, k)
+		}
+	}
+	for i, p := range proto.Protos {
+		if p == nil {
+			return undumpErr(ErrMalformed, "%s: function %d is nil", name, i)
+		}
+		if err := checkProto(p, fmt.Sprintf("%s/fn:%d", name, p.LineDefined)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package binchunk
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// Chunks of a newer version than the runtime are refused.
+func TestUndumpVersion(t *testing.T) {
+	data, err := (&Prototype{Source: "v.lk", Code: []uint32{0}}).Dump("", DumpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		version int
+		err     error
+	}{
+		{Version, nil},
+		{Version + 1, ErrVersion},
+	} {
+		var bin map[string]json.RawMessage
+		if err := json.Unmarshal(data, &bin); err != nil {
+			t.Fatal(err)
+		}
+		bin["v"], _ = json.Marshal(tt.version)
+		chunk, err := json.Marshal(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Undump(chunk, UndumpOptions{}); !errors.Is(err, tt.err) {
+			t.Errorf("version %d: got %v, want %v", tt.version, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/state"
+)
+
+// `lk build` appends the bundled chunk to a copy of the lk executable,
+// followed by this trailer: the chunk size (uint64, big endian) and buildMagic.
+// At startup, lk runs the chunk it finds this way instead of parsing the args.
+const (
+	buildMagic      = "\x00LKBUILD"
+	buildTrailerLen = 8 + len(buildMagic)
+)
+
+// build writes an executable running the file entry and its imports.
+// args are the flags after entry: `lk build main.lk -o app`.
+func build(entry string, args []string, opts binchunk.DumpOptions) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	out := fs.String("o", strings.TrimSuffix(filepath.Base(entry), ".lk"), "Write the executable to file")
+	fs.Parse(args)
+
+	chunk, err := state.BundleChunk(entry, opts)
+	if err != nil {
+		log.Red("[build] " + err.Error())
+		os.Exit(1)
+	}
+	runtime, err := runtimeExecutable()
+	if err != nil {
+		log.Red("[build] can't read lk executable: " + err.Error())
+		os.Exit(1)
+	}
+
+	var trailer [buildTrailerLen]byte
+	binary.BigEndian.PutUint64(trailer[:8], uint64(len(chunk)))
+	copy(trailer[8:], buildMagic)
+	data := bytes.Join([][]byte{runtime, chunk, trailer[:]}, nil)
+	if err := os.WriteFile(*out, data, 0755); err != nil {
+		log.Red("[build] " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// runtimeExecutable returns the content of the running lk executable.
+func runtimeExecutable() ([]byte, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// embeddedChunk returns the chunk appended by `lk build` to the running executable.
+func embeddedChunk() ([]byte, bool) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	end, err := f.Seek(-int64(buildTrailerLen), io.SeekEnd)
+	if err != nil {
+		return nil, false
+	}
+	trailer := make([]byte, buildTrailerLen)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return nil, false
+	}
+	size, ok := chunkSize(trailer)
+	if !ok || size > end {
+		return nil, false
+	}
+	chunk := make([]byte, size)
+	if _, err := f.ReadAt(chunk, end-size); err != nil {
+		return nil, false
+	}
+	return chunk, true
+}
+
+func chunkSize(trailer []byte) (int64, bool) {
+	if len(trailer) != buildTrailerLen || string(trailer[8:]) != buildMagic {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(trailer[:8])), true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// set to run the test binary as lk
+const runMainEnv = "LK_TEST_RUN_MAIN"
+
+// os.args is laid out the same for `lk script.lk` and for an executable
+// built from it, without the flags of lk.
+func TestBuildArgs(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "args.lk")
+	app := filepath.Join(dir, "app")
+	src := "print(#os.args, os.args[2], os.args[3])"
+	if err := os.WriteFile(script, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(name string, args ...string) string {
+		cmd := exec.Command(name, args...)
+		cmd.Env = append(os.Environ(), runMainEnv+"=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", name, err, out)
+		}
+		return string(out)
+	}
+	run(exe, "build", script, "-o", app)
+	const want = "4\ta\t-z\n"
+	if got := run(exe, "-dry-run", script, "a", "-z"); got != want {
+		t.Errorf("lk: got %q", got)
+	}
+	if got := run(app, "a", "-z"); got != want {
+		t.Errorf("built: got %q", got)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/state"
+)
+
+// bundle packs the file entry and the modules it imports into one chunk.
+// args are the flags after entry: `lk bundle main.lk -o app.lkc`.
+func bundle(entry string, args []string, opts binchunk.DumpOptions) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	out := fs.String("o", entry+"c", "Write the bundle to file")
+	fs.Parse(args)
+
+	if err := state.Bundle(entry, *out, opts); err != nil {
+		log.Red("[bundle] " + err.Error())
+		os.Exit(1)
+	}
+}
@@ -38,11 +38,11 @@ func toProtos(fis []*funcInfo) []*Prototype {
 	return protos
 }
 
+// Constants, upvalues... are listed in the order they were added,
+// never by iterating maps, so compiling a file always gives the same chunk.
 func getConstants(fi *funcInfo) []interface{} {
-	consts := make([]interface{}, len(fi.constants))
-	for k := range fi.constants {
-		consts[fi.constants[k]] = k
-	}
+	consts := make([]interface{}, len(fi.constList))
+	copy(consts, fi.constList)
 	return consts
 }
 
@@ -59,21 +59,19 @@ func getLocVars(fi *funcInfo) []LocVar {
 }
 
 func getUpvalues(fi *funcInfo) []Upvalue {
-	upvals := make([]Upvalue, len(fi.upvalues))
-	for i := range fi.upvalues {
-		if fi.upvalues[i].locVarSlot >= 0 { // instack
-			upvals[fi.upvalues[i].index] = Upvalue{1, byte(fi.upvalues[i].locVarSlot)}
+	upvals := make([]Upvalue, len(fi.upvalList))
+	for i, name := range fi.upvalList {
+		if uv := fi.upvalues[name]; uv.locVarSlot >= 0 { // instack
+			upvals[i] = Upvalue{1, byte(uv.locVarSlot)}
 		} else {
-			upvals[fi.upvalues[i].index] = Upvalue{0, byte(fi.upvalues[i].upvalIndex)}
+			upvals[i] = Upvalue{0, byte(uv.upvalIndex)}
 		}
 	}
 	return upvals
 }
 
 func getUpvalueNames(fi *funcInfo) []string {
-	names := make([]string, len(fi.upvalues))
-	for name := range fi.upvalues {
-		names[fi.upvalues[name].index] = name
-	}
+	names := make([]string, len(fi.upvalList))
+	copy(names, fi.upvalList)
 	return names
 }
@@ -47,7 +47,9 @@ type funcInfo struct {
 	locVars   []*locVarInfo
 	locNames  map[string]*locVarInfo
 	upvalues  map[string]upvalInfo
+	upvalList []string // upvalue names, by index
 	constants map[interface{}]int
+	constList []interface{} // constants, by index
 	breaks    [][]int
 	insts     []uint32
 	lineNums  []uint32
@@ -82,8 +84,9 @@ func (self *funcInfo) indexOfConstant(k interface{}) int {
 		return idx
 	}
 
-	idx := len(self.constants)
+	idx := len(self.constList)
 	self.constants[k] = idx
+	self.constList = append(self.constList, k)
 	return idx
 }
 
@@ -210,14 +213,16 @@ func (self *funcInfo) indexOfUpval(name string) int {
 	}
 	if self.parent != nil {
 		if locVar, found := self.parent.locNames[name]; found {
-			idx := len(self.upvalues)
+			idx := len(self.upvalList)
 			self.upvalues[name] = upvalInfo{locVar.slot, -1, idx}
+			self.upvalList = append(self.upvalList, name)
 			locVar.captured = true
 			return idx
 		}
 		if uvIdx := self.parent.indexOfUpval(name); uvIdx >= 0 {
-			idx := len(self.upvalues)
+			idx := len(self.upvalList)
 			self.upvalues[name] = upvalInfo{-1, uvIdx, idx}
+			self.upvalList = append(self.upvalList, name)
 			return idx
 		}
 	}
@@ -465,6 +470,8 @@ func (self *funcInfo) emitBinaryOp(line, op, a, b, c int) {
 			self.emitABC(line, OP_LE, 1, b, c)
 		case TOKEN_OP_GE:
 			self.emitABC(line, OP_LE, 1, c, b)
+		case TOKEN_OP_IN:
+			self.emitABC(line, OP_IN, 1, b, c)
 		}
 		self.emitJmp(line, 0, 1)
 		self.emitLoadBool(line, a, 0, 1)
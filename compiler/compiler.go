@@ -4,11 +4,13 @@ import (
 	"github.com/lollipopkit/lk/binchunk"
 	"github.com/lollipopkit/lk/compiler/codegen"
 	"github.com/lollipopkit/lk/compiler/parser"
+	"github.com/lollipopkit/lk/vm"
 )
 
 func Compile(chunk, chunkName string) *binchunk.Prototype {
 	ast := parser.Parse(chunk, chunkName)
 	proto := codegen.GenProto(ast)
+	vm.Fuse(proto)
 	setSource(proto, chunkName)
 	return proto
 }
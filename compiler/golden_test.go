@@ -0,0 +1,72 @@
+package compiler
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/lk/vm"
+)
+
+// Each testdata/*.lk is compiled and disassembled, then compared with
+// testdata/*.golden. After an intended codegen change, regenerate them with:
+//
+//	go test ./compiler -run TestGolden -update
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.lk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			vm.Disassemble(&got, Compile(string(src), filepath.ToSlash(file)))
+
+			golden := strings.TrimSuffix(file, ".lk") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("disassembly differs from %s (run with -update if intended):\n%s",
+					golden, diffLines(string(want), got.String()))
+			}
+		})
+	}
+}
+
+// diffLines returns the lines of got which differ from want, with their line number.
+func diffLines(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var sb strings.Builder
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			fmt.Fprintf(&sb, "line %d:\n\t- %s\n\t+ %s\n", i+1, wl, gl)
+		}
+	}
+	return sb.String()
+}
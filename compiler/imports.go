@@ -0,0 +1,177 @@
+package compiler
+
+import (
+	"github.com/lollipopkit/lk/binchunk"
+	. "github.com/lollipopkit/lk/compiler/ast"
+	"github.com/lollipopkit/lk/compiler/codegen"
+	"github.com/lollipopkit/lk/compiler/parser"
+	"github.com/lollipopkit/lk/vm"
+)
+
+// CompileImports compiles chunk like Compile,
+// and returns the names of the modules it imports.
+func CompileImports(chunk, chunkName string) (*binchunk.Prototype, []string) {
+	block := parser.Parse(chunk, chunkName)
+	imports := Imports(block)
+	proto := codegen.GenProto(block)
+	vm.Fuse(proto)
+	setSource(proto, chunkName)
+	return proto, imports
+}
+
+// Imports returns the names of the modules imported by block, in order.
+// Only constant names are found: `import 'a'`, `pkg.import('a')`.
+func Imports(block *Block) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	var walkBlock func(b *Block)
+	var walkExp func(exp Exp)
+	walkExps := func(exps []Exp) {
+		for _, exp := range exps {
+			walkExp(exp)
+		}
+	}
+	walkExp = func(exp Exp) {
+		switch exp := exp.(type) {
+		case *ParensExp:
+			walkExp(exp.Exp)
+		case *FuncDefExp:
+			walkBlock(exp.Block)
+		case *TableConstructorExp:
+			walkExps(exp.KeyExps)
+			walkExps(exp.ValExps)
+		case *UnopExp:
+			walkExp(exp.Unop)
+		case *BinopExp:
+			walkExp(exp.Left)
+			walkExp(exp.Right)
+		case *TernaryExp:
+			walkExp(exp.Cond)
+			walkExp(exp.True)
+			walkExp(exp.False)
+		case *TableAccessExp:
+			walkExp(exp.PrefixExp)
+			walkExp(exp.KeyExp)
+		case *FuncCallExp:
+			if name, ok := importName(exp); ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			walkExp(exp.PrefixExp)
+			walkExps(exp.Args)
+		}
+	}
+	walkBlock = func(b *Block) {
+		for _, stat := range b.Stats {
+			switch stat := stat.(type) {
+			case *FuncCallStat:
+				walkExp(stat)
+			case *WhileStat:
+				walkExp(stat.Exp)
+				walkBlock(stat.Block)
+			case *IfStat:
+				walkExps(stat.Exps)
+				for _, b := range stat.Blocks {
+					walkBlock(b)
+				}
+			case *ForNumStat:
+				walkExp(stat.InitExp)
+				walkExp(stat.LimitExp)
+				walkExp(stat.StepExp)
+				walkBlock(stat.Block)
+			case *ForInStat:
+				walkExps(stat.ExpList)
+				walkBlock(stat.Block)
+			case *AssignStat:
+				walkExps(stat.VarList)
+				walkExps(stat.ExpList)
+			case *LocalVarDeclStat:
+				walkExps(stat.ExpList)
+			case *LocalFuncDefStat:
+				walkExp(stat.Exp)
+			}
+		}
+		walkExps(b.RetExps)
+	}
+	walkBlock(block)
+	return names
+}
+
+func importName(exp *FuncCallExp) (string, bool) {
+	if exp.NameExp != nil || len(exp.Args) != 1 {
+		return "", false
+	}
+	arg, ok := exp.Args[0].(*StringExp)
+	if !ok {
+		return "", false
+	}
+	switch fn := exp.PrefixExp.(type) {
+	case *NameExp:
+		return arg.Str, fn.Name == "import"
+	case *TableAccessExp:
+		pkg, ok1 := fn.PrefixExp.(*NameExp)
+		key, ok2 := fn.KeyExp.(*StringExp)
+		return arg.Str, ok1 && ok2 && pkg.Name == "pkg" && key.Str == "import"
+	}
+	return "", false
+}
+
+// ProtoImports returns the names of the modules imported by the compiled
+// proto, in order: the calls found by Imports, as they are compiled.
+func ProtoImports(proto *binchunk.Prototype) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	var walk func(p *binchunk.Prototype)
+	walk = func(p *binchunk.Prototype) {
+		for pc := 2; pc < len(p.Code); pc++ {
+			if name, ok := callImport(p, pc); ok && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		for _, sub := range p.Protos {
+			walk(sub)
+		}
+	}
+	walk(proto)
+	return names
+}
+
+// callImport returns the name imported by the CALL at pc, if it is
+// `GETTABUP import` or `GETTABUP pkg; GETTABLE import`, LOADK name, CALL.
+func callImport(p *binchunk.Prototype, pc int) (string, bool) {
+	inst := func(pc int) vm.Instruction {
+		return vm.Instruction(p.Code[pc]).Unfused()
+	}
+	constant := func(k int) (string, bool) {
+		if k < 0 || k >= len(p.Constants) {
+			return "", false
+		}
+		s, ok := p.Constants[k].(string)
+		return s, ok
+	}
+	isKey := func(i vm.Instruction, op, a int, key string) bool {
+		ia, _, c := i.ABC()
+		s, ok := constant(c - 0x100) /* RK(C) */
+		return i.Opcode() == op && ia == a && ok && s == key
+	}
+
+	a, b, _ := inst(pc).ABC()
+	if inst(pc).Opcode() != vm.OP_CALL || b != 2 {
+		return "", false
+	}
+	argA, bx := inst(pc - 1).ABx()
+	name, ok := constant(bx)
+	if inst(pc-1).Opcode() != vm.OP_LOADK || argA != a+1 || !ok {
+		return "", false
+	}
+	fn := inst(pc - 2)
+	if isKey(fn, vm.OP_GETTABUP, a, "import") {
+		return name, true
+	}
+	if _, pkg, _ := fn.ABC(); isKey(fn, vm.OP_GETTABLE, a, "import") &&
+		pc >= 3 && isKey(inst(pc-3), vm.OP_GETTABUP, pkg, "pkg") {
+		return name, true
+	}
+	return "", false
+}
@@ -0,0 +1,26 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The imports of compiled chunks are the ones of their source.
+func TestProtoImports(t *testing.T) {
+	const src = `
+import 'a'
+shy m = pkg.import('b')
+fn f() { shy x = import('c') }
+import 'a'
+print('d')
+shy n = import(m)
+`
+	proto, names := CompileImports(src, "imports.lk")
+	want := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("Imports: got %v", names)
+	}
+	if got := ProtoImports(proto); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ProtoImports: got %v", got)
+	}
+}
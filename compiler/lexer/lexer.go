@@ -3,24 +3,14 @@ package lexer
 import (
 	"bytes"
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
-// var reSpaces = regexp.MustCompile(`^\s+`)
-var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
-var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)
-var reNumber = regexp.MustCompile(`^0[xX][0-9a-fA-F]*(\.[0-9a-fA-F]*)?([pP][+\-]?[0-9]+)?|^[0-9]*(\.[0-9]*)?([eE][+\-]?[0-9]+)?`)
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
-
-var reDecEscapeSeq = regexp.MustCompile(`^\\[0-9]{1,3}`)
-var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)
-var reUnicodeEscapeSeq = regexp.MustCompile(`^\\u[0-9a-fA-F]+`)
-
 type Lexer struct {
 	chunk         string // source code
 	chunkName     string // source name
+	pos           int    // offset of the next byte to scan in chunk
 	line          int    // current line number
 	nextToken     string
 	nextTokenKind int
@@ -28,7 +18,7 @@ type Lexer struct {
 }
 
 func NewLexer(chunk, chunkName string) *Lexer {
-	return &Lexer{chunk, chunkName, 1, "", 0, 0}
+	return &Lexer{chunk: chunk, chunkName: chunkName, line: 1}
 }
 
 func (self *Lexer) Line() int {
@@ -71,11 +61,12 @@ func (self *Lexer) NextToken() (line, kind int, token string) {
 	}
 
 	self.skipWhiteSpaces()
-	if len(self.chunk) == 0 {
+	if self.pos == len(self.chunk) {
 		return self.line, TOKEN_EOF, "EOF"
 	}
 
-	switch self.chunk[0] {
+	c := self.chunk[self.pos]
+	switch c {
 	case ';':
 		self.next(1)
 		return self.line, TOKEN_SEP_SEMI, ";"
@@ -212,7 +203,7 @@ func (self *Lexer) NextToken() (line, kind int, token string) {
 		if self.test("...") {
 			self.next(3)
 			return self.line, TOKEN_VARARG, "..."
-		} else if len(self.chunk) == 1 || !isDigit(self.chunk[1]) {
+		} else if !isDigit(self.at(1)) {
 			self.next(1)
 			return self.line, TOKEN_SEP_DOT, "."
 		}
@@ -236,7 +227,6 @@ func (self *Lexer) NextToken() (line, kind int, token string) {
 		return self.line, TOKEN_STRING, self.scanRawString()
 	}
 
-	c := self.chunk[0]
 	if c == '.' || isDigit(c) {
 		token := self.scanNumber()
 		return self.line, TOKEN_NUMBER, token
@@ -255,11 +245,24 @@ func (self *Lexer) NextToken() (line, kind int, token string) {
 }
 
 func (self *Lexer) next(n int) {
-	self.chunk = self.chunk[n:]
+	self.pos += n
+}
+
+// rest returns the chunk left to scan.
+func (self *Lexer) rest() string {
+	return self.chunk[self.pos:]
+}
+
+// at returns the i-th byte left to scan, or 0 past the end of the chunk.
+func (self *Lexer) at(i int) byte {
+	if self.pos+i < len(self.chunk) {
+		return self.chunk[self.pos+i]
+	}
+	return 0
 }
 
 func (self *Lexer) test(s string) bool {
-	return strings.HasPrefix(self.chunk, s)
+	return strings.HasPrefix(self.rest(), s)
 }
 
 func (self *Lexer) error(f string, a ...interface{}) {
@@ -269,23 +272,25 @@ func (self *Lexer) error(f string, a ...interface{}) {
 }
 
 func (self *Lexer) skipWhiteSpaces() {
-	for len(self.chunk) > 0 {
-		if self.test("#!/") {
+	for self.pos < len(self.chunk) {
+		switch c := self.at(0); {
+		case c == '#' && self.test("#!/"):
 			self.skipShebang()
-		} else if self.test("//") {
+		case c == '/' && self.at(1) == '/':
 			self.skipComment()
-		} else if self.test("/*") {
+		case c == '/' && self.at(1) == '*':
 			self.skipLongComment()
-		} else if self.test("\r\n") || self.test("\n\r") {
-			self.next(2)
-			self.line += 1
-		} else if isNewLine(self.chunk[0]) {
-			self.next(1)
+		case isNewLine(c):
+			if next := self.at(1); isNewLine(next) && next != c { /* \r\n or \n\r */
+				self.next(2)
+			} else {
+				self.next(1)
+			}
 			self.line += 1
-		} else if isWhiteSpace(self.chunk[0]) {
+		case isWhiteSpace(c):
 			self.next(1)
-		} else {
-			break
+		default:
+			return
 		}
 	}
 }
@@ -294,55 +299,100 @@ func (self *Lexer) skipComment() {
 	self.next(2) // skip `//`
 
 	// short comment
-	for len(self.chunk) > 0 && !isNewLine(self.chunk[0]) {
-		self.next(1)
-	}
+	self.pos = skip(self.chunk, self.pos, isNotNewLine)
 }
 
 func (self *Lexer) skipShebang() {
 	self.next(2) // skip `#!`
 
 	// shebang line
-	for len(self.chunk) > 0 && !isNewLine(self.chunk[0]) {
-		self.next(1)
-	}
+	self.pos = skip(self.chunk, self.pos, isNotNewLine)
 }
 
 func (self *Lexer) skipLongComment() {
 	self.next(2)
-	idx := strings.Index(self.chunk, "*/")
+	idx := strings.Index(self.rest(), "*/")
 	if idx < 0 {
 		self.error("unfinished long comment at line: " + strconv.Itoa(self.line))
 	}
-	self.line += len(reNewLine.FindAllString(self.chunk[:idx], -1))
-	self.next(idx + 2)
+	self.line += countNewLines(self.take(idx))
+	self.next(2)
 }
 
 func (self *Lexer) scanIdentifier() string {
-	return self.scan(reIdentifier)
+	end := skip(self.chunk, self.pos+1, isIdentChar)
+	return self.take(end - self.pos)
 }
 
+// scanNumber scans a decimal or hexadecimal number, with an optional
+// fraction and exponent: 3 3.0 .5 314.16e-2 0xff 0x0.1E 0xA23p-4
 func (self *Lexer) scanNumber() string {
-	return self.scan(reNumber)
+	chunk := self.rest()
+	digit, exp := isDigit, "eE"
+	i := 0
+	if strings.HasPrefix(chunk, "0x") || strings.HasPrefix(chunk, "0X") {
+		digit, exp = isHexDigit, "pP"
+		i = 2
+	}
+	i = skip(chunk, i, digit)
+	if i < len(chunk) && chunk[i] == '.' {
+		i = skip(chunk, i+1, digit)
+	}
+	if i < len(chunk) && strings.IndexByte(exp, chunk[i]) >= 0 {
+		j := i + 1
+		if j < len(chunk) && (chunk[j] == '+' || chunk[j] == '-') {
+			j++
+		}
+		if k := skip(chunk, j, isDigit); k > j { /* else not an exponent */
+			i = k
+		}
+	}
+	return self.take(i)
 }
 
-func (self *Lexer) scan(re *regexp.Regexp) string {
-	if token := re.FindString(self.chunk); token != "" {
-		self.next(len(token))
-		return token
+// skip returns the index of the first byte of s from i which is not ok.
+func skip(s string, i int, ok func(c byte) bool) int {
+	for i < len(s) && ok(s[i]) {
+		i++
 	}
-	panic("unreachable!")
+	return i
+}
+
+// take consumes and returns the first n bytes of the chunk.
+func (self *Lexer) take(n int) string {
+	token := self.chunk[self.pos : self.pos+n]
+	self.next(n)
+	return token
 }
 
 func (self *Lexer) scanShortString() string {
-	if str := reShortStr.FindString(self.chunk); str != "" {
-		self.next(len(str))
-		str = str[1 : len(str)-1]
-		if strings.Index(str, `\`) >= 0 {
-			self.line += len(reNewLine.FindAllString(str, -1))
-			str = self.escape(str)
+	chunk := self.rest()
+	quote := chunk[0]
+	escaped := false
+	for i := 1; i < len(chunk); i++ {
+		switch c := chunk[i]; {
+		case c == quote:
+			str := self.take(i + 1)
+			str = str[1 : len(str)-1]
+			if escaped {
+				self.line += countNewLines(str)
+				str = self.escape(str)
+			}
+			return str
+		case c == '\n':
+			self.error("unfinished string")
+		case c == '\\' && i+1 < len(chunk):
+			escaped = true
+			i++ /* the escaped byte, which may be a quote or a newline */
+			switch chunk[i] {
+			case '\r':
+				if i+1 < len(chunk) && chunk[i+1] == '\n' {
+					i++
+				}
+			case 'z': /* skips the following spaces, newlines included */
+				i = skip(chunk, i+1, isWhiteSpace) - 1
+			}
 		}
-		return str
 	}
 	self.error("unfinished string")
 	return ""
@@ -350,17 +400,17 @@ func (self *Lexer) scanShortString() string {
 
 func (self *Lexer) scanRawString() string {
 	self.next(1)
-	openIdx := strings.Index(self.chunk, "`")
+	openIdx := strings.IndexByte(self.rest(), '`')
 	if openIdx < 0 {
 		self.error("unfinished string")
 	}
 
-	str := self.chunk[:openIdx]
-	self.line += len(reNewLine.FindAllString(str, -1))
+	str := self.take(openIdx)
+	self.line += countNewLines(str)
 	if len(str) > 0 && str[0] == '\n' {
 		str = str[1:]
 	}
-	self.next(openIdx + 1)
+	self.next(1)
 	return str
 }
 
@@ -391,10 +441,17 @@ func (self *Lexer) escape(str string) string {
 			buf.WriteByte('\f')
 			str = str[2:]
 			continue
-		case 'n', '\n':
+		case 'n':
 			buf.WriteByte('\n')
 			str = str[2:]
 			continue
+		case '\r', '\n': // line continuation, skips the indentation of the next line
+			n := 2
+			if len(str) > 2 && isNewLine(str[2]) && str[2] != str[1] { /* \r\n or \n\r */
+				n = 3
+			}
+			str = strings.TrimLeft(str[n:], " \t")
+			continue
 		case 'r':
 			buf.WriteByte('\r')
 			str = str[2:]
@@ -420,7 +477,7 @@ func (self *Lexer) escape(str string) string {
 			str = str[2:]
 			continue
 		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // \ddd
-			if found := reDecEscapeSeq.FindString(str); found != "" {
+			if found := escapeSeq(str, 1, 3, isDigit); found != "" {
 				d, _ := strconv.ParseInt(found[1:], 8, 32)
 				if d <= 0xFF {
 					buf.WriteByte(byte(d))
@@ -430,14 +487,14 @@ func (self *Lexer) escape(str string) string {
 				self.error("decimal escape too large near '%s'", found)
 			}
 		case 'x': // \xXX
-			if found := reHexEscapeSeq.FindString(str); found != "" {
+			if found := escapeSeq(str, 2, 2, isHexDigit); len(found) == 4 {
 				d, _ := strconv.ParseInt(found[2:], 16, 32)
 				buf.WriteByte(byte(d))
 				str = str[len(found):]
 				continue
 			}
 		case 'u': // \u{XXX}
-			if found := reUnicodeEscapeSeq.FindString(str); found != "" {
+			if found := escapeSeq(str, 2, len(str), isHexDigit); found != "" {
 				d, err := strconv.ParseInt(found[2:], 16, 32)
 				if err == nil && d <= 0x10FFFF {
 					buf.WriteRune(rune(d))
@@ -459,6 +516,19 @@ func (self *Lexer) escape(str string) string {
 	return buf.String()
 }
 
+// escapeSeq returns the escape sequence at the start of str: its first
+// n bytes, then up to max digits, or "" if there is no digit.
+func escapeSeq(str string, n, max int, digit func(c byte) bool) string {
+	i := n
+	for i < len(str) && i < n+max && digit(str[i]) {
+		i++
+	}
+	if i == n {
+		return ""
+	}
+	return str[:i]
+}
+
 func isWhiteSpace(c byte) bool {
 	switch c {
 	case '\t', '\n', '\v', '\f', '\r', ' ':
@@ -471,10 +541,36 @@ func isNewLine(c byte) bool {
 	return c == '\r' || c == '\n'
 }
 
+func isNotNewLine(c byte) bool {
+	return !isNewLine(c)
+}
+
+// countNewLines counts the line breaks in s: \n, \r, \r\n or \n\r.
+func countNewLines(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if isNewLine(s[i]) {
+			n++
+			if i+1 < len(s) && isNewLine(s[i+1]) && s[i+1] != s[i] {
+				i++
+			}
+		}
+	}
+	return n
+}
+
 func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isLetter(c) || isDigit(c)
+}
+
 func isLetter(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
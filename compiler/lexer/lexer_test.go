@@ -0,0 +1,115 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+type token struct {
+	line, kind int
+	token      string
+}
+
+func tokens(t testing.TB, chunk string) []token {
+	l := NewLexer(chunk, "test")
+	var toks []token
+	for {
+		line, kind, tok := l.NextToken()
+		if kind == TOKEN_EOF {
+			return toks
+		}
+		toks = append(toks, token{line, kind, tok})
+	}
+}
+
+func TestScan(t *testing.T) {
+	for _, tt := range []struct {
+		chunk string
+		want  []token
+	}{
+		{"foo _bar9 if", []token{{1, TOKEN_IDENTIFIER, "foo"}, {1, TOKEN_IDENTIFIER, "_bar9"}, {1, TOKEN_KW_IF, "if"}}},
+		{"3 3.0 3.1416 314.16e-2 0.31416E1 .5 1e", []token{
+			{1, TOKEN_NUMBER, "3"}, {1, TOKEN_NUMBER, "3.0"}, {1, TOKEN_NUMBER, "3.1416"},
+			{1, TOKEN_NUMBER, "314.16e-2"}, {1, TOKEN_NUMBER, "0.31416E1"}, {1, TOKEN_NUMBER, ".5"},
+			{1, TOKEN_NUMBER, "1"}, {1, TOKEN_IDENTIFIER, "e"},
+		}},
+		{"0xff 0x0.1E 0xA23p-4 0X1.921FB54442D18P+1", []token{
+			{1, TOKEN_NUMBER, "0xff"}, {1, TOKEN_NUMBER, "0x0.1E"},
+			{1, TOKEN_NUMBER, "0xA23p-4"}, {1, TOKEN_NUMBER, "0X1.921FB54442D18P+1"},
+		}},
+		{"a\r\nb\n\rc\rd /* x\r\ny */ e `\r\n` f", []token{
+			{1, TOKEN_IDENTIFIER, "a"}, {2, TOKEN_IDENTIFIER, "b"}, {3, TOKEN_IDENTIFIER, "c"},
+			{4, TOKEN_IDENTIFIER, "d"}, {5, TOKEN_IDENTIFIER, "e"}, {6, TOKEN_STRING, "\r\n"},
+			{6, TOKEN_IDENTIFIER, "f"},
+		}},
+		{"1..2", []token{{1, TOKEN_NUMBER, "1."}, {1, TOKEN_NUMBER, ".2"}}},
+		{`'a' "b" 'it''s' "\"q\"" 'x\'y'`, []token{
+			{1, TOKEN_STRING, "a"}, {1, TOKEN_STRING, "b"}, {1, TOKEN_STRING, "it"},
+			{1, TOKEN_STRING, "s"}, {1, TOKEN_STRING, `"q"`}, {1, TOKEN_STRING, "x'y"},
+		}},
+		/* \ddd is octal; a string is on the line where it ends */
+		{`'\101\x42\u43\n\t\\' 'a\z
+		    b' 'c\
+		    d' e`, []token{
+			{1, TOKEN_STRING, "ABC\n\t\\"}, {2, TOKEN_STRING, "ab"}, {3, TOKEN_STRING, "cd"},
+			{3, TOKEN_IDENTIFIER, "e"},
+		}},
+		/* a line continuation skips one line break, of any kind */
+		{"'a\\\rbc' 'd\\\r\ne' 'f\\\n\rg'", []token{
+			{2, TOKEN_STRING, "abc"}, {3, TOKEN_STRING, "de"}, {4, TOKEN_STRING, "fg"},
+		}},
+	} {
+		got := tokens(t, tt.chunk)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.chunk, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: token %d: got %v, want %v", tt.chunk, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	for chunk, want := range map[string]string{
+		`'abc`:      "unfinished string",
+		"'ab\ncd'":  "unfinished string",
+		`'abc\'`:    "unfinished string",
+		`'\400'`:    "decimal escape too large",
+		`'\q'`:      "invalid escape sequence",
+		"/* never ": "unfinished long comment",
+	} {
+		func() {
+			defer func() {
+				err, _ := recover().(string)
+				if !strings.Contains(err, want) {
+					t.Errorf("%q: got error %q, want %q", chunk, err, want)
+				}
+			}()
+			tokens(t, chunk)
+		}()
+	}
+}
+
+// a chunk of n lines with the usual tokens
+func benchChunk(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n/4; i++ {
+		sb.WriteString("shy fn add_numbers(first, second) => first + second * 3.14e2 // sum\n")
+		sb.WriteString("shy message = 'hello, \\'world\\'\\n' + \"tab\\there\" + fmt('%d', 0xff)\n")
+		sb.WriteString("/* long\n comment */ if message != nil and count >= 10 { count += 1 }\n")
+		sb.WriteString("shy list = {1, 2, 3, 'four', `raw\nstring`}\n")
+	}
+	return sb.String()
+}
+
+func BenchmarkLexer(b *testing.B) {
+	chunk := benchChunk(1000)
+	b.SetBytes(int64(len(chunk)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tokens(b, chunk)
+	}
+}
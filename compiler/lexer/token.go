@@ -86,6 +86,9 @@ const (
 	TOKEN_OP_NILCOALESCING_EQ
 )
 
+// k in t
+const TOKEN_OP_IN = TOKEN_KW_IN
+
 var tokenOpEq = map[int]int{
 	TOKEN_OP_MINUS_EQ: TOKEN_OP_MINUS,
 	TOKEN_OP_ADD_EQ:   TOKEN_OP_ADD,
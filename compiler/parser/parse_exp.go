@@ -29,7 +29,7 @@ exp14 ::= exp13 {'??' exp13}
 exp13 ::= exp12 {'?' exp12 : exp12}
 exp12 ::= exp11 {or exp11}
 exp11 ::= exp10 {and exp10}
-exp10 ::= exp9 {(‘<’ | ‘>’ | ‘<=’ | ‘>=’ | ‘!=’ | ‘==’) exp9}
+exp10 ::= exp9 {(‘<’ | ‘>’ | ‘<=’ | ‘>=’ | ‘!=’ | ‘==’ | in) exp9}
 exp9  ::= exp8 {‘|’ exp8}
 exp8  ::= exp7 {‘~’ exp7}
 exp7  ::= exp6 {‘&’ exp6}
@@ -96,7 +96,7 @@ func parseExp10(lexer *Lexer) Exp {
 	for {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_LT, TOKEN_OP_GT, TOKEN_OP_NE,
-			TOKEN_OP_LE, TOKEN_OP_GE, TOKEN_OP_EQ:
+			TOKEN_OP_LE, TOKEN_OP_GE, TOKEN_OP_EQ, TOKEN_OP_IN:
 			line, op, _ := lexer.NextToken()
 			exp = &BinopExp{line, op, exp, parseExp9(lexer)}
 		default:
@@ -218,8 +218,12 @@ func parseExp0(lexer *Lexer) Exp {
 	case TOKEN_KW_FALSE: // false
 		line, _, _ := lexer.NextToken()
 		return &FalseExp{line}
-	case TOKEN_STRING: // LiteralString
+	case TOKEN_STRING: // LiteralString {LiteralString}
 		line, _, token := lexer.NextToken()
+		for lexer.LookAhead() == TOKEN_STRING { // 'a' 'b' => 'ab'
+			_, _, next := lexer.NextToken()
+			token += next
+		}
 		return &StringExp{line, token}
 	case TOKEN_NUMBER: // Numeral
 		return parseNumberExp(lexer)
@@ -0,0 +1,177 @@
+// Package cover records the lines executed by lk scripts
+// and writes lcov or HTML reports.
+package cover
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/compiler"
+	"github.com/lollipopkit/lk/consts"
+)
+
+type Coverage struct {
+	// source -> line -> hits
+	hits    map[string]map[int]int
+	restore func()
+}
+
+type fileCoverage struct {
+	source string
+	lines  []int       // executable lines, sorted
+	hits   map[int]int // line -> hits
+	code   []string
+}
+
+// Start records the lines executed by ls with a line hook
+// installed in front of any hook set on ls.
+func Start(ls LkState) *Coverage {
+	c := &Coverage{hits: map[string]map[int]int{}}
+	c.restore = ChainHook(ls, LK_MASKLINE, 0, c.hook)
+	return c
+}
+
+func (c *Coverage) hook(ls LkState, _, line int) {
+	info, ok := ls.GetStackInfo(0)
+	if !ok || strings.HasPrefix(info.Source, consts.BuiltinPrefix) {
+		return
+	}
+	lines, ok := c.hits[info.Source]
+	if !ok {
+		lines = map[int]int{}
+		c.hits[info.Source] = lines
+	}
+	lines[line]++
+}
+
+// Stop sets the previous hook back.
+func (c *Coverage) Stop(ls LkState) {
+	c.restore()
+}
+
+// files compiles again every executed source to find its executable lines,
+// so lines that never ran are reported too.
+func (c *Coverage) files() []*fileCoverage {
+	sources := make([]string, 0, len(c.hits))
+	for source := range c.hits {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	files := make([]*fileCoverage, 0, len(sources))
+	for _, source := range sources {
+		fc := &fileCoverage{source: source, hits: c.hits[source]}
+		executable := map[int]bool{}
+		if data, err := os.ReadFile(source); err == nil {
+			fc.code = strings.Split(string(data), "\n")
+			if proto := compileQuietly(string(data), source); proto != nil {
+				collectLines(proto, executable)
+			}
+		}
+		for line := range fc.hits {
+			executable[line] = true
+		}
+		for line := range executable {
+			fc.lines = append(fc.lines, line)
+		}
+		sort.Ints(fc.lines)
+		files = append(files, fc)
+	}
+	return files
+}
+
+func compileQuietly(chunk, source string) (proto *binchunk.Prototype) {
+	defer func() {
+		if recover() != nil {
+			proto = nil
+		}
+	}()
+	if !strings.HasSuffix(source, ".lk") {
+		return nil
+	}
+	return compiler.Compile(chunk, source)
+}
+
+func collectLines(proto *binchunk.Prototype, lines map[int]bool) {
+	for _, line := range proto.LineInfo {
+		lines[int(line)] = true
+	}
+	for _, p := range proto.Protos {
+		collectLines(p, lines)
+	}
+}
+
+// WriteLcov writes the report in the lcov tracefile format.
+func (c *Coverage) WriteLcov(w io.Writer) error {
+	for _, fc := range c.files() {
+		path, err := filepath.Abs(fc.source)
+		if err != nil {
+			path = fc.source
+		}
+		fmt.Fprintf(w, "TN:\nSF:%s\n", path)
+		hit := 0
+		for _, line := range fc.lines {
+			n := fc.hits[line]
+			if n > 0 {
+				hit++
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", line, n)
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(fc.lines), hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const htmlHead = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>lk coverage</title>
+<style>
+body { font-family: monospace; }
+pre { margin: 0; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.n { color: #888; display: inline-block; width: 4em; text-align: right; margin-right: 1em; }
+</style></head><body>
+`
+
+// WriteHTML writes a single page showing every source with covered lines in green
+// and missed lines in red.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	io.WriteString(w, htmlHead)
+	for _, fc := range c.files() {
+		hit := 0
+		executable := map[int]bool{}
+		for _, line := range fc.lines {
+			executable[line] = true
+			if fc.hits[line] > 0 {
+				hit++
+			}
+		}
+		percent := 100.0
+		if len(fc.lines) > 0 {
+			percent = float64(hit) * 100 / float64(len(fc.lines))
+		}
+		fmt.Fprintf(w, "<h3>%s: %.1f%% (%d/%d)</h3>\n", html.EscapeString(fc.source), percent, hit, len(fc.lines))
+		for i, code := range fc.code {
+			line := i + 1
+			class := ""
+			if executable[line] {
+				class = "miss"
+				if fc.hits[line] > 0 {
+					class = "hit"
+				}
+			}
+			fmt.Fprintf(w, "<pre class=\"%s\"><span class=\"n\">%d</span>%s</pre>\n", class, line, html.EscapeString(code))
+		}
+	}
+	_, err := io.WriteString(w, "</body></html>\n")
+	return err
+}
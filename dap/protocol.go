@@ -0,0 +1,104 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	. "github.com/lollipopkit/lk/json"
+)
+
+// Messages of the Debug Adapter Protocol.
+// https://microsoft.github.io/debug-adapter-protocol/specification
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Body       any             `json:"body,omitempty"`
+}
+
+// conn reads and writes base protocol messages:
+// a `Content-Length` header, an empty line and the JSON content.
+type conn struct {
+	r   *textproto.Reader
+	w   io.Writer
+	mu  sync.Mutex
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{
+		r: textproto.NewReader(bufio.NewReader(r)),
+		w: w,
+	}
+}
+
+func (c *conn) read() (*message, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, errors.New("invalid Content-Length: " + header.Get("Content-Length"))
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(c.r.R, data); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	return msg, Json.Unmarshal(data, msg)
+}
+
+func (c *conn) write(msg *message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	msg.Seq = c.seq
+	data, err := Json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+func (c *conn) respond(req *message, body any) error {
+	success := true
+	return c.write(&message{
+		Type:       "response",
+		Command:    req.Command,
+		RequestSeq: req.Seq,
+		Success:    &success,
+		Body:       body,
+	})
+}
+
+func (c *conn) respondErr(req *message, errMsg string) error {
+	success := false
+	return c.write(&message{
+		Type:       "response",
+		Command:    req.Command,
+		RequestSeq: req.Seq,
+		Success:    &success,
+		Message:    errMsg,
+	})
+}
+
+func (c *conn) event(event string, body any) error {
+	return c.write(&message{
+		Type:  "event",
+		Event: event,
+		Body:  body,
+	})
+}
@@ -0,0 +1,411 @@
+package dap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/lollipopkit/lk/api"
+	. "github.com/lollipopkit/lk/json"
+	"github.com/lollipopkit/lk/state"
+)
+
+const threadId = 1
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+type server struct {
+	conn *conn
+	// breakpoints: abs source path -> lines
+	bpMu        sync.RWMutex
+	breakpoints map[string]map[int]bool
+	// source -> abs path, see absPath
+	absPaths sync.Map
+
+	program     string
+	stopOnEntry bool
+	started     bool
+
+	// state of the paused VM, only touched by the VM goroutine
+	// or while the VM is paused
+	mu        sync.Mutex
+	paused    bool
+	pauseReq  bool
+	step      stepMode
+	stepDepth int
+	depth     int
+	cmds      chan *message
+	vars      *varStore
+}
+
+func newServer(r io.Reader, w io.Writer) *server {
+	return &server{
+		conn:        newConn(r, w),
+		breakpoints: map[string]map[int]bool{},
+		cmds:        make(chan *message),
+	}
+}
+
+// Serve runs a debug adapter reading requests from r and writing to w,
+// until the client disconnects.
+func Serve(r io.Reader, w io.Writer) error {
+	return newServer(r, w).serve()
+}
+
+// ServeStdio serves the client on stdin/stdout.
+// Output of the debugged script is sent to the client as events.
+func ServeStdio() error {
+	out := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = w
+	defer func() {
+		os.Stdout = out
+		w.Close()
+	}()
+
+	s := newServer(os.Stdin, out)
+	go s.forwardOutput(r)
+	return s.serve()
+}
+
+func (s *server) serve() error {
+	for {
+		req, err := s.conn.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if s.handle(req) {
+			return nil
+		}
+	}
+}
+
+func (s *server) forwardOutput(r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.conn.event("output", map[string]any{
+				"category": "stdout",
+				"output":   string(buf[:n]),
+			})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle returns true when the session is over.
+func (s *server) handle(req *message) bool {
+	switch req.Command {
+	case "initialize":
+		s.conn.respond(req, map[string]any{
+			"supportsConfigurationDoneRequest": true,
+		})
+		s.conn.event("initialized", nil)
+	case "launch":
+		var args struct {
+			Program     string `json:"program"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		if err := Json.Unmarshal(req.Arguments, &args); err != nil || args.Program == "" {
+			s.conn.respondErr(req, "launch: 'program' is required")
+			return false
+		}
+		s.program = args.Program
+		s.stopOnEntry = args.StopOnEntry
+		s.conn.respond(req, nil)
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		s.conn.respond(req, map[string]any{"breakpoints": []any{}})
+	case "configurationDone":
+		s.conn.respond(req, nil)
+		if !s.started && s.program != "" {
+			s.started = true
+			go s.run()
+		}
+	case "threads":
+		s.conn.respond(req, map[string]any{
+			"threads": []map[string]any{{"id": threadId, "name": "main"}},
+		})
+	case "pause":
+		s.mu.Lock()
+		s.pauseReq = true
+		s.mu.Unlock()
+		s.conn.respond(req, nil)
+	case "stackTrace", "scopes", "variables", "continue", "next", "stepIn", "stepOut":
+		s.mu.Lock()
+		paused := s.paused
+		s.mu.Unlock()
+		if !paused {
+			s.conn.respondErr(req, "not paused")
+			return false
+		}
+		s.cmds <- req
+	case "disconnect", "terminate":
+		s.conn.respond(req, nil)
+		return true
+	default:
+		s.conn.respondErr(req, "unsupported request: "+req.Command)
+	}
+	return false
+}
+
+func (s *server) setBreakpoints(req *message) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := Json.Unmarshal(req.Arguments, &args); err != nil {
+		s.conn.respondErr(req, err.Error())
+		return
+	}
+
+	lines := map[int]bool{}
+	bps := make([]map[string]any, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		lines[bp.Line] = true
+		bps[i] = map[string]any{"verified": true, "line": bp.Line}
+	}
+	s.bpMu.Lock()
+	s.breakpoints[s.absPath(args.Source.Path)] = lines
+	s.bpMu.Unlock()
+	s.conn.respond(req, map[string]any{"breakpoints": bps})
+}
+
+// run executes the program on the current goroutine, which becomes the VM goroutine.
+func (s *server) run() {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.SetHook(LK_MASKCALL|LK_MASKRET|LK_MASKLINE, 0, s.hook)
+
+	exitCode := 0
+	if s.stopOnEntry {
+		s.mu.Lock()
+		s.step = stepIn
+		s.mu.Unlock()
+	}
+	if err := s.load(ls); err != "" {
+		s.output("stderr", err+"\n")
+		exitCode = 1
+	} else if ls.PCall(0, 0, 0) != LK_OK {
+		s.output("stderr", ls.ToString2(-1)+"\n")
+		exitCode = 1
+	}
+
+	s.conn.event("exited", map[string]any{"exitCode": exitCode})
+	s.conn.event("terminated", nil)
+}
+
+func (s *server) load(ls LkState) (err string) {
+	data, e := os.ReadFile(s.program)
+	if e != nil {
+		return e.Error()
+	}
+	// compile errors are raised as panics
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Sprint(r)
+		}
+	}()
+	if ls.Load(data, s.program, "bt") != LK_OK {
+		return ls.ToString(-1)
+	}
+	return ""
+}
+
+func (s *server) output(category, output string) {
+	s.conn.event("output", map[string]any{
+		"category": category,
+		"output":   output,
+	})
+}
+
+func (s *server) hook(ls LkState, event, line int) {
+	switch event {
+	case LK_HOOKCALL:
+		s.depth++
+		return
+	case LK_HOOKRET:
+		s.depth--
+		return
+	case LK_HOOKTAILCALL: /* replaces the frame of the caller */
+		return
+	}
+
+	info, ok := ls.GetStackInfo(0)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	reason := ""
+	switch {
+	case s.pauseReq:
+		reason = "pause"
+	case s.step == stepIn,
+		s.step == stepOver && s.depth <= s.stepDepth,
+		s.step == stepOut && s.depth < s.stepDepth:
+		reason = "step"
+	}
+	s.mu.Unlock()
+	if reason == "" && s.hasBreakpoint(info.Source, line) {
+		reason = "breakpoint"
+	}
+	if reason != "" {
+		s.pause(ls, reason)
+	}
+}
+
+func (s *server) hasBreakpoint(source string, line int) bool {
+	s.bpMu.RLock()
+	defer s.bpMu.RUnlock()
+	if len(s.breakpoints) == 0 {
+		return false
+	}
+	return s.breakpoints[s.absPath(source)][line]
+}
+
+// pause blocks the VM and serves requests that need it until the client resumes.
+func (s *server) pause(ls LkState, reason string) {
+	s.mu.Lock()
+	s.paused = true
+	s.pauseReq = false
+	s.step = stepNone
+	s.vars = newVarStore()
+	s.mu.Unlock()
+
+	s.conn.event("stopped", map[string]any{
+		"reason":            reason,
+		"threadId":          threadId,
+		"allThreadsStopped": true,
+	})
+
+	for req := range s.cmds {
+		switch req.Command {
+		case "stackTrace":
+			s.conn.respond(req, s.stackTrace(ls))
+		case "scopes":
+			s.scopes(req)
+		case "variables":
+			s.variables(ls, req)
+		case "continue", "next", "stepIn", "stepOut":
+			s.mu.Lock()
+			switch req.Command {
+			case "next":
+				s.step = stepOver
+			case "stepIn":
+				s.step = stepIn
+			case "stepOut":
+				s.step = stepOut
+			}
+			s.stepDepth = s.depth
+			s.paused = false
+			s.mu.Unlock()
+			if req.Command == "continue" {
+				s.conn.respond(req, map[string]any{"allThreadsContinued": true})
+			} else {
+				s.conn.respond(req, nil)
+			}
+			return
+		}
+	}
+}
+
+func (s *server) stackTrace(ls LkState) map[string]any {
+	frames := []map[string]any{}
+	for level := 0; ; level++ {
+		info, ok := ls.GetStackInfo(level)
+		if !ok {
+			break
+		}
+		if info.What == "Go" {
+			continue
+		}
+		name := "main"
+		if info.What != "main" {
+			name = fmt.Sprintf("fn@%s:%d", filepath.Base(info.Source), info.LineDefined)
+		}
+		frames = append(frames, map[string]any{
+			"id":     level,
+			"name":   name,
+			"line":   info.CurrentLine,
+			"column": 1,
+			"source": map[string]any{
+				"name": filepath.Base(info.Source),
+				"path": s.absPath(info.Source),
+			},
+		})
+	}
+	return map[string]any{
+		"stackFrames": frames,
+		"totalFrames": len(frames),
+	}
+}
+
+func (s *server) scopes(req *message) {
+	var args struct {
+		FrameId int `json:"frameId"`
+	}
+	Json.Unmarshal(req.Arguments, &args)
+	ref := s.vars.add(localsRef{level: args.FrameId})
+	s.conn.respond(req, map[string]any{
+		"scopes": []map[string]any{{
+			"name":               "Locals",
+			"variablesReference": ref,
+			"expensive":          false,
+		}},
+	})
+}
+
+func (s *server) variables(ls LkState, req *message) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	Json.Unmarshal(req.Arguments, &args)
+	vars, ok := s.vars.expand(ls, args.VariablesReference)
+	if !ok {
+		s.conn.respondErr(req, "invalid variablesReference")
+		return
+	}
+	s.conn.respond(req, map[string]any{"variables": vars})
+}
+
+// absPath returns the absolute path of the source path.
+// It is cached, as the line hook asks for it on every line,
+// and stays the path the source was loaded from if the script changes dir.
+func (s *server) absPath(path string) string {
+	if abs, ok := s.absPaths.Load(path); ok {
+		return abs.(string)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	s.absPaths.Store(path, abs)
+	return abs
+}
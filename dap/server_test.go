@@ -0,0 +1,117 @@
+package dap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/lollipopkit/lk/json"
+)
+
+// client is the other end of a session served over pipes.
+type client struct {
+	t *testing.T
+	c *conn
+}
+
+func (c *client) request(command string, args any) {
+	c.t.Helper()
+	data, err := Json.Marshal(args)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.c.write(&message{Type: "request", Command: command, Arguments: data}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads messages until the response to command or the event
+// of this name, skipping output events, and returns its body.
+func (c *client) expect(typ, name string) map[string]any {
+	c.t.Helper()
+	for {
+		msg, err := c.c.read()
+		if err != nil {
+			c.t.Fatalf("waiting for %s %s: %v", typ, name, err)
+		}
+		if msg.Type == "event" && msg.Event == "output" {
+			continue
+		}
+		if msg.Type != typ || msg.Command+msg.Event != name {
+			c.t.Fatalf("got %s %s%s, want %s %s", msg.Type, msg.Command, msg.Event, typ, name)
+		}
+		if msg.Success != nil && !*msg.Success {
+			c.t.Fatalf("%s failed: %s", name, msg.Message)
+		}
+		body, _ := msg.Body.(map[string]any)
+		return body
+	}
+}
+
+func TestSession(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "main.lk")
+	src := "shy a = 1\nshy b = a + 1\nshy c = b + 1\nrt c\n"
+	if err := os.WriteFile(program, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(inR, outW)
+		outW.Close()
+	}()
+	c := &client{t: t, c: newConn(outR, inW)}
+
+	c.request("initialize", map[string]any{"adapterID": "lk"})
+	c.expect("response", "initialize")
+	c.expect("event", "initialized")
+	c.request("launch", map[string]any{"program": program})
+	c.expect("response", "launch")
+	c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": program},
+		"breakpoints": []map[string]any{{"line": 3}},
+	})
+	bps := c.expect("response", "setBreakpoints")["breakpoints"].([]any)
+	if len(bps) != 1 || bps[0].(map[string]any)["verified"] != true {
+		t.Fatalf("breakpoints %v", bps)
+	}
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone")
+	if reason := c.expect("event", "stopped")["reason"]; reason != "breakpoint" {
+		t.Fatalf("stopped on %v", reason)
+	}
+
+	c.request("stackTrace", map[string]any{"threadId": threadId})
+	frames := c.expect("response", "stackTrace")["stackFrames"].([]any)
+	top := frames[0].(map[string]any)
+	if top["name"] != "main" || fmt.Sprint(top["line"]) != "3" {
+		t.Fatalf("top frame %v", top)
+	}
+	c.request("scopes", map[string]any{"frameId": top["id"]})
+	scopes := c.expect("response", "scopes")["scopes"].([]any)
+	c.request("variables", map[string]any{"variablesReference": scopes[0].(map[string]any)["variablesReference"]})
+	vars := map[string]any{}
+	for _, v := range c.expect("response", "variables")["variables"].([]any) {
+		v := v.(map[string]any)
+		vars[fmt.Sprint(v["name"])] = v["value"]
+	}
+	if vars["a"] != "1" || vars["b"] != "2" {
+		t.Fatalf("locals %v", vars)
+	}
+
+	c.request("continue", map[string]any{"threadId": threadId})
+	c.expect("response", "continue")
+	if code := c.expect("event", "exited")["exitCode"]; fmt.Sprint(code) != "0" {
+		t.Fatalf("exit code %v", code)
+	}
+	c.expect("event", "terminated")
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect")
+	inW.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
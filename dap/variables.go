@@ -0,0 +1,94 @@
+package dap
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+const maxValueLen = 100
+
+type localsRef struct {
+	level int
+}
+
+type tableRef struct {
+	table any
+}
+
+// varStore hands out variablesReference ids for the scopes and tables
+// shown while the VM is paused. Ids are only valid until it resumes.
+type varStore struct {
+	refs []any
+}
+
+func newVarStore() *varStore {
+	return &varStore{}
+}
+
+func (v *varStore) add(ref any) int {
+	v.refs = append(v.refs, ref)
+	return len(v.refs)
+}
+
+func (v *varStore) expand(ls LkState, id int) ([]map[string]any, bool) {
+	if id < 1 || id > len(v.refs) {
+		return nil, false
+	}
+	vars := []map[string]any{}
+	switch ref := v.refs[id-1].(type) {
+	case localsRef:
+		for n := 1; ; n++ {
+			name, ok := ls.GetLocal(ref.level, n)
+			if !ok {
+				break
+			}
+			// skip internal variables, eg: `(for index)`
+			if strings.HasPrefix(name, "(") {
+				ls.Pop(1)
+				continue
+			}
+			vars = append(vars, v.variable(ls, name))
+		}
+	case tableRef:
+		ls.Push(ref.table)
+		ls.PushNil()
+		for ls.Next(-2) {
+			key := ls.ToString2(-2)
+			ls.Pop(1)
+			vars = append(vars, v.variable(ls, key))
+		}
+		ls.Pop(1)
+	}
+	return vars, true
+}
+
+// variable describes the value on the top of the stack and pops it.
+func (v *varStore) variable(ls LkState, name string) map[string]any {
+	ref := 0
+	value := ""
+	typ := ls.Type(-1)
+	switch typ {
+	case LK_TTABLE:
+		ref = v.add(tableRef{ls.ToPointer(-1)})
+		value = ls.ToString2(-1)
+		ls.Pop(1)
+	case LK_TSTRING:
+		value = strconv.Quote(ls.ToString(-1))
+	default:
+		value = ls.ToString2(-1)
+		ls.Pop(1)
+	}
+	ls.Pop(1)
+
+	if len(value) > maxValueLen {
+		value = value[:maxValueLen] + "..."
+	}
+	return map[string]any{
+		"name":               name,
+		"value":              value,
+		"type":               ls.TypeName(typ),
+		"variablesReference": ref,
+	}
+}
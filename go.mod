@@ -4,17 +4,30 @@ go 1.20
 
 require (
 	atomicgo.dev/keyboard v0.2.9
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/fxamacker/cbor/v2 v2.7.0
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/google/pprof v0.0.0-20230602150820-91b7bce49751
+	github.com/gorilla/websocket v1.5.3
 	github.com/json-iterator/go v1.1.12
+	github.com/lib/pq v1.10.9
 	github.com/lollipopkit/gommon v0.4.3
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	go.etcd.io/bbolt v1.3.8
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/containerd/console v1.0.3 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
 
 require (
 	atomicgo.dev/cursor v0.1.1 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 )
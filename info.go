@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/lk/binchunk"
+)
+
+// printInfo prints the metadata of the compiled chunk at path.
+func printInfo(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Red("[info] can't read file: " + err.Error())
+		os.Exit(1)
+	}
+	chunk, err := binchunk.Undump(data, binchunk.UndumpOptions{})
+	if err != nil {
+		log.Red("[info] invalid chunk: " + err.Error())
+		os.Exit(1)
+	}
+
+	protos := []*binchunk.Prototype{}
+	var walk func(p *binchunk.Prototype)
+	walk = func(p *binchunk.Prototype) {
+		protos = append(protos, p)
+		for _, sub := range p.Protos {
+			walk(sub)
+		}
+	}
+	walk(chunk.Proto)
+
+	insts, consts := 0, 0
+	debug := false
+	for _, p := range protos {
+		insts += len(p.Code)
+		consts += len(p.Constants)
+		debug = debug || len(p.LineInfo) > 0 || len(p.LocVars) > 0 || len(p.UpvalueNames) > 0
+	}
+
+	fmt.Printf("version:      %d\n", chunk.Version)
+	fmt.Printf("source:       %s\n", chunk.Proto.Source)
+	fmt.Printf("source md5:   %s\n", chunk.Md5)
+	fmt.Printf("functions:    %d\n", len(protos))
+	fmt.Printf("instructions: %d\n", insts)
+	fmt.Printf("constants:    %d\n", consts)
+	fmt.Printf("debug info:   %v\n", debug)
+	fmt.Printf("modules:      %d\n", len(chunk.Modules))
+	fmt.Println()
+	fmt.Printf("%-24s %8s %8s %8s %8s\n", "function", "insts", "consts", "upvals", "params")
+	for i, p := range protos {
+		name := fmt.Sprintf("fn <%d:%d>", p.LineDefined, p.LastLineDefined)
+		if i == 0 {
+			name = "main"
+		}
+		fmt.Printf("%-24s %8d %8d %8d %8d\n", name, len(p.Code), len(p.Constants), len(p.Upvalues), p.NumParams)
+	}
+}
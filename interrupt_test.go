@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/state"
+)
+
+func TestTrapInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("can't send os.Interrupt on windows")
+	}
+	ls := state.New()
+	ls.OpenLibs()
+	hook := func(ls LkState, event, line int) {}
+	ls.SetHook(LK_MASKCALL, 0, hook)
+	ctx, stop := trapInterrupt()
+	defer stop()
+
+	p, _ := os.FindProcess(os.Getpid())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := p.Signal(os.Interrupt); err != nil {
+			t.Error(err)
+		}
+	}()
+	if ls.LoadString("pcall(fn() { while true {} })\nescaped = true", "loop.lk") != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if ls.PCallContext(ctx, 0, 0, 0) == LK_OK {
+		t.Fatal("not interrupted")
+	}
+	if msg := ls.ToString2(-1); !strings.Contains(msg, "interrupted!") {
+		t.Fatalf("got %q", msg)
+	}
+	if ls.GetGlobal("escaped") != LK_TNIL {
+		t.Fatal("pcall caught the interrupt")
+	}
+	if ls.GetHook() == nil || ls.GetHookMask() != LK_MASKCALL {
+		t.Fatal("the hook was replaced")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lollipopkit/gommon/log"
+)
+
+// keygen writes a new ed25519 key pair to name.key and name.pub, in hex.
+// name.key signs chunks (lk -c -sign), name.pub verifies them (lk -verify).
+func keygen(name string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Red("[keygen] " + err.Error())
+		os.Exit(1)
+	}
+	if err := os.WriteFile(name+".key", []byte(hex.EncodeToString(priv)), 0600); err != nil {
+		log.Red("[keygen] " + err.Error())
+		os.Exit(1)
+	}
+	if err := os.WriteFile(name+".pub", []byte(hex.EncodeToString(pub)), 0644); err != nil {
+		log.Red("[keygen] " + err.Error())
+		os.Exit(1)
+	}
+}
+
+func readKey(path string, size int) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Red("[key] " + err.Error())
+		os.Exit(1)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err == nil && len(key) != size {
+		err = fmt.Errorf("expect %d bytes, got %d", size, len(key))
+	}
+	if err != nil {
+		log.Red("[key] invalid key %s: %s", path, err.Error())
+		os.Exit(1)
+	}
+	return key
+}
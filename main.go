@@ -1,40 +1,119 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/lollipopkit/gommon/log"
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/binchunk"
 	"github.com/lollipopkit/lk/compiler/parser"
+	"github.com/lollipopkit/lk/cover"
+	"github.com/lollipopkit/lk/dap"
 	. "github.com/lollipopkit/lk/json"
+	"github.com/lollipopkit/lk/perm"
+	"github.com/lollipopkit/lk/prof"
 	"github.com/lollipopkit/lk/repl"
 	"github.com/lollipopkit/lk/state"
+	"github.com/lollipopkit/lk/stdlib"
 )
 
 var (
-	args = []string{}
+	args       = []string{}
+	profPath   string
+	recordPath string
+	replayPath string
+	dryRun     bool
+	coverPath  string
+	trace      bool
+	prompt     bool
+	verifyPath string
+	cache      bool
 )
 
 func main() {
+	if chunk, ok := embeddedChunk(); ok {
+		// built by `lk build`: all the args go to the script,
+		// none of the flags of lk apply
+		stdlib.SetArgs(append([]string{os.Args[0], os.Args[0]}, os.Args[1:]...))
+		runChunk(chunk, os.Args[0])
+		return
+	}
+
 	ast := flag.Bool("a", false, "Write AST Tree Json")
 	compile := flag.Bool("c", false, "Compile file")
+	strip := flag.Bool("s", false, "Strip debug info when compiling (with -c, bundle or build)")
+	compress := flag.Bool("z", false, "Compress the compiled chunk (with -c, bundle or build)")
+	signPath := flag.String("sign", "", "Sign the compiled chunk with the private key in file (with -c, bundle or build)")
+	flag.StringVar(&verifyPath, "verify", "", "Only load chunks signed by the public key in file")
+	flag.StringVar(&profPath, "prof", "", "Write CPU profile (pprof) of the script to file")
+	flag.StringVar(&recordPath, "record", "", "Record results of non-deterministic calls (time, rand, os, http) to file")
+	flag.StringVar(&coverPath, "cover", "", "Write line coverage of the script to file (lcov, or HTML if it ends with .html)")
+	flag.BoolVar(&trace, "trace", false, "Print every executed instruction to stderr")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print os mutations (rm, mv, write, exec...) instead of performing them")
+	flag.BoolVar(&prompt, "prompt", false, "Ask before the script uses network, exec or writes files outside cwd")
+	flag.StringVar(&replayPath, "replay", "", "Replay results of non-deterministic calls recorded with -record")
+	flag.BoolVar(&cache, "cache", false, "Cache the compiled script and modules in ~/.cache/lk, to start faster next time")
 
 	flag.Parse()
 	args = flag.Args()
+	if cache {
+		dir, err := state.DefaultChunkCacheDir()
+		if err != nil {
+			log.Yellow("[cache] " + err.Error())
+		} else {
+			state.SetChunkCache(dir)
+		}
+	}
 	if len(args) == 0 {
 		repl.Repl()
 		return
 	}
 
+	if args[0] == "dap" {
+		if err := dap.ServeStdio(); err != nil {
+			log.Red("[dap] " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if args[0] == "keygen" && len(args) > 1 {
+		keygen(args[1])
+		return
+	}
+	if args[0] == "info" && len(args) > 1 {
+		printInfo(args[1])
+		return
+	}
+
+	opts := binchunk.DumpOptions{Strip: *strip, Compress: *compress}
+	if *signPath != "" {
+		opts.Key = readKey(*signPath, ed25519.PrivateKeySize)
+	}
+	if args[0] == "bundle" && len(args) > 1 {
+		bundle(args[1], args[2:], opts)
+		return
+	}
+	if args[0] == "build" && len(args) > 1 {
+		build(args[1], args[2:], opts)
+		return
+	}
+
 	fPath := args[0]
 	if *ast {
 		writeAst(fPath)
 	} else if *compile {
-		state.Compile(fPath)
+		state.Compile(fPath, opts)
 	} else {
 		if strings.HasSuffix(fPath, ".lk") || strings.HasSuffix(fPath, ".lkc") {
+			/* without the flags of lk, like a built executable */
+			stdlib.SetArgs(append([]string{os.Args[0]}, args...))
 			runVM(fPath)
 		} else {
 			log.Yellow("Can't run file without suffix '.lk(c)':\n" + fPath)
@@ -70,9 +149,130 @@ func runVM(path string) {
 		log.Red("[run] can't read file: " + err.Error())
 		os.Exit(1)
 	}
+	runChunk(data, path)
+}
+
+// runChunk runs data, the content of the file path.
+func runChunk(data []byte, path string) {
 	ls := state.New()
 	defer ls.CatchAndPrint(false)
+	ctx, stop := trapInterrupt()
+	defer stop()
+	if profPath != "" {
+		defer writeProfile(ls, prof.Start(ls, prof.DefaultPeriod))
+	}
+	if trace {
+		ls.SetTrace(os.Stderr)
+	}
+	if coverPath != "" {
+		defer writeCoverage(ls, cover.Start(ls))
+	}
+	if err := startReplay(); err != nil {
+		log.Red("[run] " + err.Error())
+		os.Exit(1)
+	}
+	defer stdlib.StopReplay()
 	ls.OpenLibs()
-	ls.Load(data, path, "bt")
-	ls.Call(0, -1)
+	if verifyPath != "" {
+		ls.SetChunkKey(readKey(verifyPath, ed25519.PublicKeySize))
+	}
+	if prompt {
+		perm.Enable(ls, data)
+	}
+	if dryRun {
+		stdlib.SetDryRun(ls, true)
+		defer printPlan(ls)
+	}
+	if ls.Load(data, path, "bt") != LK_OK {
+		log.Red("[load] " + ls.ToString(-1))
+		os.Exit(2)
+	}
+	ls.CallContext(ctx, 0, -1)
+}
+
+func startReplay() error {
+	switch {
+	case recordPath != "" && replayPath != "":
+		return errors.New("can't record and replay at the same time")
+	case recordPath != "":
+		return stdlib.StartRecord(recordPath)
+	case replayPath != "":
+		return stdlib.StartReplay(replayPath)
+	}
+	return nil
+}
+
+func printPlan(ls LkState) {
+	for _, op := range stdlib.DryRunPlan(ls) {
+		args, _ := Json.MarshalToString(op.Args)
+		log.Yellow("[dry-run] %s %s", op.Op, args)
+	}
+}
+
+func writeCoverage(ls LkState, c *cover.Coverage) {
+	c.Stop(ls)
+	f, err := os.Create(coverPath)
+	if err != nil {
+		log.Red("[cover] " + err.Error())
+		return
+	}
+	defer f.Close()
+	if strings.HasSuffix(coverPath, ".html") {
+		err = c.WriteHTML(f)
+	} else {
+		err = c.WriteLcov(f)
+	}
+	if err != nil {
+		log.Red("[cover] " + err.Error())
+	}
+}
+
+func writeProfile(ls LkState, p *prof.Profiler) {
+	p.Stop(ls)
+	f, err := os.Create(profPath)
+	if err != nil {
+		log.Red("[prof] " + err.Error())
+		return
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		log.Red("[prof] " + err.Error())
+	}
+}
+
+var errInterrupted = errors.New("interrupted!")
+
+// trapInterrupt returns the context of the script, canceled by the first
+// Ctrl+C: the VM then stops the running script with an "interrupted!"
+// error, which pcall can't catch, and prints a traceback.
+// A second Ctrl+C kills the process as usual.
+// While the script handles Ctrl+C with os.on_signal, it is left to it.
+// Calling the returned func stops trapping.
+func trapInterrupt() (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		for {
+			select {
+			case <-c:
+				if stdlib.SignalHandled(os.Interrupt) {
+					continue // by os.on_signal
+				}
+				signal.Stop(c)
+				// stops the VM, and wakes up blocking calls such as os.sleep
+				cancel(errInterrupted)
+			case <-done:
+			}
+			return
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(c)
+		close(done)
+		cancel(nil)
+	}
 }
@@ -0,0 +1,199 @@
+// Package perm asks the user before a script uses a sensitive capability
+// (network, exec, file writes outside the working directory),
+// like the permission prompts of Deno.
+// Decisions are saved per script hash, so the user is asked only once.
+package perm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lollipopkit/gommon/term"
+	. "github.com/lollipopkit/lk/api"
+	. "github.com/lollipopkit/lk/json"
+	"github.com/lollipopkit/lk/utils"
+)
+
+const (
+	CapNet   = "net"
+	CapExec  = "exec"
+	CapWrite = "write"
+)
+
+var (
+	cachePath = filepath.Join(os.Getenv("HOME"), ".config", "lk_perms.json")
+
+	// index of the path args written by each file op
+	writeOps = map[string][]int{
+		"os.write": {0},
+		"os.rm":    {0},
+		"os.mkdir": {0},
+		"os.mv":    {0, 1},
+		"os.cp":    {1},
+		"os.link":  {1},
+		"io.open":  {0}, /* unless the mode only reads, see capability */
+
+		"zip.compress_file":   {1},
+		"zip.decompress_file": {1},
+		"archive.zip":         {1},
+		"archive.tar":         {1},
+		"archive.unzip":       {1},
+		"archive.untar":       {1},
+		"kv.open":             {0},
+		"http.download":       {1},
+	}
+)
+
+type prompter struct {
+	sync.Mutex
+	hash string
+	cwd  string
+	// script hash -> capability -> granted
+	cache map[string]map[string]bool
+}
+
+// Enable installs an audit hook on ls which prompts before the
+// sensitive operations of the script whose content is script.
+// It replaces any audit hook set on ls.
+func Enable(ls LkState, script []byte) {
+	cwd, _ := os.Getwd()
+	if real, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = real
+	}
+	p := &prompter{
+		hash:  utils.Md5(script),
+		cwd:   cwd,
+		cache: loadCache(),
+	}
+	ls.SetAuditHook(p.audit)
+}
+
+func (p *prompter) audit(ls LkState, ev AuditEvent) {
+	cap, target := p.capability(ev)
+	if cap == "" {
+		return
+	}
+	if !p.allowed(cap, target) {
+		ls.Error2("permission denied: %s (%s)", cap, ev.Op)
+	}
+}
+
+// capability returns the capability needed by ev, if any,
+// and what it is used on, for the prompt.
+func (p *prompter) capability(ev AuditEvent) (string, string) {
+	switch {
+	case ev.Op == "os.exec" || ev.Op == "os.proc" || ev.Op == "os.kill":
+		return CapExec, ""
+	case ev.Op == "io.open" && len(ev.Args) > 1 && !strings.ContainsAny(argString(ev.Args[1]), "wa+"):
+		return "", ""
+	case writeOps[ev.Op] != nil:
+		/* below, as a file op; the network is audited on its own */
+	case strings.HasPrefix(ev.Op, "http.") || strings.HasPrefix(ev.Op, "net.") ||
+		strings.HasPrefix(ev.Op, "tls.") || strings.HasPrefix(ev.Op, "ws.") ||
+		strings.HasPrefix(ev.Op, "mail."):
+		if len(ev.Args) > 0 {
+			return CapNet, argString(ev.Args[len(ev.Args)-1])
+		}
+		return CapNet, ""
+	case ev.Op == "db.open" && len(ev.Args) > 1:
+		/* a sqlite file is written, other databases are on the network */
+		target := argString(ev.Args[1])
+		if argString(ev.Args[0]) != "sqlite" {
+			return CapNet, target
+		}
+		if target != "" && !p.inCwd(target) {
+			return CapWrite, target
+		}
+		return "", ""
+	}
+	for _, idx := range writeOps[ev.Op] {
+		if idx >= len(ev.Args) {
+			continue
+		}
+		path := argString(ev.Args[idx])
+		if !p.inCwd(path) {
+			return CapWrite, path
+		}
+	}
+	return "", ""
+}
+
+func (p *prompter) inCwd(path string) bool {
+	if p.cwd == "" {
+		return false
+	}
+	rel, err := filepath.Rel(p.cwd, resolve(path, p.cwd))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolve returns the absolute path of path with the symlinks of its
+// existing part resolved, so that a link in the working directory
+// to a file outside of it is outside too.
+// The path is not cleaned before: "link/.." is the parent of the target.
+func resolve(path, cwd string) string {
+	sep := string(filepath.Separator)
+	if !filepath.IsAbs(path) {
+		path = cwd + sep + path
+	}
+	parts := strings.Split(path, sep)
+	for i := len(parts); i > 0; i-- {
+		prefix := strings.Join(parts[:i], sep)
+		if prefix == "" {
+			prefix = sep
+		}
+		if real, err := filepath.EvalSymlinks(prefix); err == nil {
+			return filepath.Join(append([]string{real}, parts[i:]...)...)
+		}
+	}
+	return filepath.Clean(path)
+}
+
+func (p *prompter) allowed(cap, target string) bool {
+	p.Lock()
+	defer p.Unlock()
+	decisions, ok := p.cache[p.hash]
+	if !ok {
+		decisions = map[string]bool{}
+		p.cache[p.hash] = decisions
+	}
+	if granted, ok := decisions[cap]; ok {
+		return granted
+	}
+
+	question := "Allow this script to use " + cap
+	if target != "" {
+		question += " (" + target + ")"
+	}
+	granted := term.Confirm(question + "?")
+	decisions[cap] = granted
+	saveCache(p.cache)
+	return granted
+}
+
+func argString(arg any) string {
+	s, _ := arg.(string)
+	return s
+}
+
+func loadCache() map[string]map[string]bool {
+	cache := map[string]map[string]bool{}
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return cache
+	}
+	if err := Json.Unmarshal(data, &cache); err != nil || cache == nil {
+		return map[string]map[string]bool{}
+	}
+	return cache
+}
+
+func saveCache(cache map[string]map[string]bool) {
+	data, err := Json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	os.MkdirAll(filepath.Dir(cachePath), 0755)
+	os.WriteFile(cachePath, data, 0644)
+}
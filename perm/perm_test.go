@@ -0,0 +1,77 @@
+package perm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+func TestCapability(t *testing.T) {
+	p := &prompter{cwd: "/work"}
+	cases := []struct {
+		op     string
+		args   []any
+		cap    string
+		target string
+	}{
+		{"os.exec", []any{"ls"}, CapExec, ""},
+		{"io.open", []any{"/etc/hosts", "r"}, "", ""},
+		{"io.open", []any{"/etc/hosts", "rb"}, "", ""},
+		{"io.open", []any{"/etc/hosts", "w"}, CapWrite, "/etc/hosts"},
+		{"io.open", []any{"/etc/hosts", "r+"}, CapWrite, "/etc/hosts"},
+		{"io.open", []any{"/work/out.txt", "a"}, "", ""},
+		{"os.rm", []any{"/tmp/x", false}, CapWrite, "/tmp/x"},
+		{"os.mv", []any{"/work/a", "/tmp/b"}, CapWrite, "/tmp/b"},
+		{"kv.open", []any{"/tmp/kv.db"}, CapWrite, "/tmp/kv.db"},
+		{"http.download", []any{"http://h/f", "/tmp/f"}, CapWrite, "/tmp/f"},
+		{"http.download", []any{"http://h/f", "/work/f"}, "", ""},
+		{"http.req", []any{"GET", "http://h/"}, CapNet, "http://h/"},
+		{"net.dial", []any{"tcp", "h:80"}, CapNet, "h:80"},
+		{"ws.connect", []any{"ws://h/"}, CapNet, "ws://h/"},
+		{"mail.send", []any{"a@b", "smtp.h:587"}, CapNet, "smtp.h:587"},
+		{"db.open", []any{"sqlite", "/tmp/a.db"}, CapWrite, "/tmp/a.db"},
+		{"db.open", []any{"sqlite", "/work/a.db"}, "", ""},
+		{"db.open", []any{"postgres", "postgres://h/db"}, CapNet, "postgres://h/db"},
+		{"os.read", []any{"/etc/hosts"}, "", ""},
+	}
+	for _, c := range cases {
+		cap, target := p.capability(AuditEvent{Op: c.op, Args: c.args})
+		if cap != c.cap || target != c.target {
+			t.Errorf("%s%v: got %q %q, want %q %q", c.op, c.args, cap, target, c.cap, c.target)
+		}
+	}
+}
+
+// A symlink in the working directory to a directory outside of it
+// does not make writes through it local.
+func TestInCwdSymlink(t *testing.T) {
+	cwd, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(cwd, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(cwd, "link")); err != nil {
+		t.Skip(err)
+	}
+	p := &prompter{cwd: cwd}
+	cases := map[string]bool{
+		"a.txt":                          true,
+		"dir/new/a.txt":                  true,
+		filepath.Join(cwd, "dir/a.txt"):  true,
+		"link/a.txt":                     false,
+		"link/new/a.txt":                 false,
+		"link/../a.txt":                  false,
+		filepath.Join(cwd, "link/a.txt"): false,
+		"../a.txt":                       false,
+	}
+	for path, want := range cases {
+		if got := p.inCwd(path); got != want {
+			t.Errorf("inCwd(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package prof is a sampling CPU profiler for lk scripts.
+// It writes pprof profiles, see `go tool pprof`.
+package prof
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/pprof/profile"
+	. "github.com/lollipopkit/lk/api"
+)
+
+const (
+	// Instructions between two checks of the sampling timer
+	checkInterval = 1000
+	DefaultPeriod = 10 * time.Millisecond
+)
+
+type frame struct {
+	source string
+	fn     string
+	line   int
+	start  int
+}
+
+type Profiler struct {
+	period  time.Duration
+	due     atomic.Bool
+	stop    chan struct{}
+	start   time.Time
+	end     time.Time
+	samples map[string]*sample
+	restore func()
+}
+
+type sample struct {
+	stack []frame // leaf first
+	count int64
+}
+
+// Start samples the lk call stack of ls every period
+// by installing a count hook in front of any hook set on ls.
+func Start(ls LkState, period time.Duration) *Profiler {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	p := &Profiler{
+		period:  period,
+		stop:    make(chan struct{}),
+		start:   time.Now(),
+		samples: map[string]*sample{},
+	}
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.due.Store(true)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	p.restore = ChainHook(ls, LK_MASKCOUNT, checkInterval, p.hook)
+	return p
+}
+
+func (p *Profiler) hook(ls LkState, _, _ int) {
+	if !p.due.Swap(false) {
+		return
+	}
+	stack := []frame{}
+	var key strings.Builder
+	for level := 0; ; level++ {
+		info, ok := ls.GetStackInfo(level)
+		if !ok {
+			break
+		}
+		if info.What == "Go" {
+			continue
+		}
+		f := frame{
+			source: info.Source,
+			fn:     funcName(info),
+			line:   info.CurrentLine,
+			start:  info.LineDefined,
+		}
+		stack = append(stack, f)
+		fmt.Fprintf(&key, "%s:%d;", f.fn, f.line)
+	}
+	if s, ok := p.samples[key.String()]; ok {
+		s.count++
+	} else {
+		p.samples[key.String()] = &sample{stack: stack, count: 1}
+	}
+}
+
+func funcName(info DebugInfo) string {
+	if info.What == "main" {
+		return "main " + info.Source
+	}
+	return fmt.Sprintf("fn %s:%d", info.Source, info.LineDefined)
+}
+
+// Stop stops sampling and sets the previous hook back.
+func (p *Profiler) Stop(ls LkState) {
+	close(p.stop)
+	p.end = time.Now()
+	p.restore()
+}
+
+// Profile converts the samples to a pprof profile.
+func (p *Profiler) Profile() *profile.Profile {
+	period := p.period.Nanoseconds()
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		PeriodType:    &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:        period,
+		TimeNanos:     p.start.UnixNano(),
+		DurationNanos: p.end.Sub(p.start).Nanoseconds(),
+	}
+
+	funcs := map[string]*profile.Function{}
+	locs := map[string]*profile.Location{}
+	for _, s := range p.samples {
+		ps := &profile.Sample{Value: []int64{s.count, s.count * period}}
+		for _, f := range s.stack {
+			fn, ok := funcs[f.fn]
+			if !ok {
+				fn = &profile.Function{
+					ID:        uint64(len(prof.Function) + 1),
+					Name:      f.fn,
+					Filename:  f.source,
+					StartLine: int64(f.start),
+				}
+				funcs[f.fn] = fn
+				prof.Function = append(prof.Function, fn)
+			}
+			locKey := fmt.Sprintf("%s:%d", f.fn, f.line)
+			loc, ok := locs[locKey]
+			if !ok {
+				loc = &profile.Location{
+					ID:   uint64(len(prof.Location) + 1),
+					Line: []profile.Line{{Function: fn, Line: int64(f.line)}},
+				}
+				locs[locKey] = loc
+				prof.Location = append(prof.Location, loc)
+			}
+			ps.Location = append(ps.Location, loc)
+		}
+		prof.Sample = append(prof.Sample, ps)
+	}
+	return prof
+}
+
+// Write writes the profile in the gzipped protobuf format of pprof.
+func (p *Profiler) Write(w io.Writer) error {
+	return p.Profile().Write(w)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"atomicgo.dev/keyboard/keys"
 	"github.com/lollipopkit/gommon/log"
@@ -28,6 +29,7 @@ var (
 		"`Ctrl + a`: Clear REPL history",
 		"",
 		"`reset()`: Reset REPL state",
+		"`:sandbox on|off`: Try snippets in a restricted state",
 	}
 	printRunesPre  = []rune("print(")
 	printfRunesPre = []rune("printf(")
@@ -35,11 +37,25 @@ var (
 	historyPath    = filepath.Join(os.Getenv("HOME"), ".config", "lk_history.json")
 	ls             api.LkState
 	blockLines     = []string{}
+	// state replaced by the sandbox, nil if sandbox is off
+	savedLs api.LkState
 )
 
+const (
+	// budget of each input in the sandbox
+	sandboxMaxInsts    = 10000000
+	sandboxMaxDuration = 5 * time.Second
+)
+
+// libs and globals removed from the sandbox
+var sandboxDenied = []string{"os", "http", "net", "tls", "ws", "mail", "do_file"}
+
 func newState() {
 	ls = state.New()
 	ls.OpenLibs()
+	if savedLs != nil {
+		restrict(ls)
+	}
 	ls.Register("help", func(ls api.LkState) int {
 		print(strings.Join(helpMsgs, "\n") + "\n")
 		return 0
@@ -51,6 +67,44 @@ func newState() {
 	blockLines = []string{}
 }
 
+// restrict makes ls a sandbox: no file system, process or network access.
+func restrict(ls api.LkState) {
+	ls.GetSubTable(api.LK_REGISTRYINDEX, "_LOADED")
+	for _, name := range sandboxDenied {
+		ls.PushNil()
+		ls.SetGlobal(name)
+		ls.PushNil()
+		ls.SetField(-2, name)
+	}
+	ls.Pop(1)
+	// deny what is left, eg: os.exit through a saved reference
+	ls.SetAuditHook(func(ls api.LkState, ev api.AuditEvent) {
+		ls.Error2("sandbox: %s is denied", ev.Op)
+	})
+}
+
+// sandboxCmd handles `:sandbox on|off`.
+func sandboxCmd(arg string) {
+	switch arg {
+	case "on":
+		if savedLs == nil {
+			savedLs = ls
+			newState()
+		}
+		log.Yellow("[sandbox] on: no %s, %d instructions per input",
+			strings.Join(sandboxDenied, "/"), sandboxMaxInsts)
+	case "off":
+		if savedLs != nil {
+			ls = savedLs
+			savedLs = nil
+			blockLines = []string{}
+		}
+		log.Green("[sandbox] off")
+	default:
+		log.Red("usage: :sandbox on|off")
+	}
+}
+
 func Repl() {
 	fmt.Printf(
 		"lk (v%s) - %s for help\n",
@@ -69,6 +123,10 @@ func Repl() {
 		if line == "" {
 			continue
 		}
+		if len(blockLines) == 0 && strings.HasPrefix(line, ":sandbox") {
+			sandboxCmd(strings.TrimSpace(strings.TrimPrefix(line, ":sandbox")))
+			continue
+		}
 
 		blockLines = append(blockLines, line)
 		blockStr := strings.Join(blockLines, "\n")
@@ -90,6 +148,9 @@ func protectedCall(ls api.LkState, cmd string) {
 	//log.Green(">>> " + cmd)
 	ls.LoadString(cmd, "stdin")
 
+	if savedLs != nil {
+		ls.SetLimits(sandboxMaxInsts, sandboxMaxDuration)
+	}
 	ls.PCall(0, api.LK_MULTRET, 1)
 	updateHistory(cmd)
 }
@@ -1,7 +1,6 @@
 package state
 
 import (
-	"fmt"
 	"math"
 
 	. "github.com/lollipopkit/lk/api"
@@ -67,6 +66,13 @@ func (self *lkState) Arith(op ArithOp) {
 	}
 
 	operator := operators[op]
+	if op == LK_OPMOD || op == LK_OPIDIV {
+		_, ok1 := a.(int64)
+		y, ok2 := b.(int64)
+		if ok1 && ok2 && y == 0 {
+			self.runError("attempt to perform 'n%s0'", operator.symbol)
+		}
+	}
 	if result := _arith(a, b, operator); result != nil {
 		self.stack.push(result)
 		return
@@ -81,25 +87,27 @@ func (self *lkState) Arith(op ArithOp) {
 	aa, oka := a.(string)
 	bb, okb := b.(string)
 	if oka && okb {
+		self.allocString(len(aa) + len(bb))
 		self.stack.push(aa + bb)
 		return
 	}
 
-	panic(fmt.Sprintf("invalid arith: %T %s %T", a, operator.symbol, b))
+	self.runError("invalid arith: %s %s %s",
+		self.typeNameOf(a), operator.symbol, self.typeNameOf(b))
 }
 
 func _arith(a, b any, op operator) any {
 	if op.floatFunc == nil { // bitwise
 		if x, ok := convertToInteger(a); ok {
 			if y, ok := convertToInteger(b); ok {
-				return op.integerFunc(x, y)
+				return boxInt(op.integerFunc(x, y))
 			}
 		}
 	} else { // arith
 		if op.integerFunc != nil { // add,sub,mul,mod,idiv,unm
 			if x, ok := a.(int64); ok {
 				if y, ok := b.(int64); ok {
-					return op.integerFunc(x, y)
+					return boxInt(op.integerFunc(x, y))
 				}
 			}
 		}
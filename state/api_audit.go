@@ -0,0 +1,33 @@
+package state
+
+import (
+	. "github.com/lollipopkit/lk/api"
+)
+
+// SetAuditHook sets the function receiving the privileged operations
+// of this state and all its coroutines. nil turns auditing off.
+func (self *lkState) SetAuditHook(f AuditFunc) {
+	self.g.audit = f
+}
+
+// Audit reports the operation op to the audit hook, if any,
+// with the location of the nearest lk function in the call stack.
+func (self *lkState) Audit(op string, args ...any) {
+	audit := self.g.audit
+	if audit == nil {
+		return
+	}
+	ev := AuditEvent{Op: op, Args: args, Line: -1}
+	for level := 0; ; level++ {
+		stack := self.getStackAt(level)
+		if stack == nil {
+			break
+		}
+		if stack.closure.proto != nil {
+			ev.Source = stack.closure.proto.Source
+			ev.Line = stack.currentLine()
+			break
+		}
+	}
+	audit(self, ev)
+}
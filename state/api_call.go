@@ -1,6 +1,7 @@
 package state
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	. "github.com/lollipopkit/lk/api"
 	"github.com/lollipopkit/lk/consts"
 	"github.com/lollipopkit/lk/mods"
+	"github.com/lollipopkit/lk/stdlib"
 	"github.com/lollipopkit/lk/vm"
 )
 
@@ -37,13 +39,13 @@ func (self *lkState) Call(nArgs, nResults int) {
 			self.callGoClosure(nArgs, nResults, c)
 		}
 	} else {
-		panic(fmt.Sprintf("attempt to call on %T", val))
+		self.runError("attempt to call a %s value", self.typeNameOf(val))
 	}
 }
 
 func (self *lkState) callGoClosure(nArgs, nResults int, c *lkClosure) {
 	// create new lua stack
-	newStack := newLuaStack(nArgs+LK_MINSTACK, self)
+	newStack := self.newFrame(nArgs + self.g.stackSize)
 	newStack.closure = c
 
 	// pass args, pop func
@@ -55,24 +57,58 @@ func (self *lkState) callGoClosure(nArgs, nResults int, c *lkClosure) {
 
 	// run closure
 	self.pushLuaStack(newStack)
+	if self.g.hookMask&LK_MASKCALL != 0 {
+		self.callHook(LK_HOOKCALL, -1)
+	}
 	r := c.goFunc(self)
+	if apiCheck {
+		self.checkResults(c, r)
+	}
+	if self.g.hookMask&LK_MASKRET != 0 {
+		self.callHook(LK_HOOKRET, -1)
+	}
 	self.popLuaStack()
 
 	// return results
 	if nResults != 0 {
 		results := newStack.popN(r)
-		self.stack.check(len(results))
+		self.stack.grow(len(results))
 		self.stack.pushN(results, nResults)
 	}
+	self.freeFrame(newStack)
 }
 
 func (self *lkState) callLuaClosure(nArgs, nResults int, c *lkClosure) {
+	// run closure
+	self.pushLuaStack(self.newLuaFrame(nArgs, c))
+	if self.g.hookMask&LK_MASKCALL != 0 {
+		self.callHook(LK_HOOKCALL, -1)
+	}
+	self.runLuaClosure()
+	if self.g.hookMask&LK_MASKRET != 0 {
+		self.callHook(LK_HOOKRET, -1)
+	}
+	// the frame of c, or of the function it tail called
+	frame := self.stack
+	self.popLuaStack()
+
+	// return results
+	if nResults != 0 {
+		results := frame.popN(frame.top - int(frame.closure.proto.MaxStackSize))
+		self.stack.grow(len(results))
+		self.stack.pushN(results, nResults)
+	}
+	self.freeFrame(frame)
+}
+
+// newLuaFrame pops c and its nArgs args, and returns a new frame calling c.
+func (self *lkState) newLuaFrame(nArgs int, c *lkClosure) *lkStack {
 	nRegs := int(c.proto.MaxStackSize)
 	nParams := int(c.proto.NumParams)
 	isVararg := c.proto.IsVararg == 1
 
 	// create new lua stack
-	newStack := newLuaStack(nRegs+LK_MINSTACK, self)
+	newStack := self.newFrame(nRegs + self.g.stackSize)
 	newStack.closure = c
 
 	// pass args, pop func
@@ -82,40 +118,125 @@ func (self *lkState) callLuaClosure(nArgs, nResults int, c *lkClosure) {
 	if nArgs > nParams && isVararg {
 		newStack.varargs = funcAndArgs[nParams+1:]
 	}
+	return newStack
+}
 
-	// run closure
-	self.pushLuaStack(newStack)
-	self.runLuaClosure()
+// TailCall calls the function below the nArgs args on the top of the stack,
+// as the last action of the running lk function: if it is an lk function,
+// it replaces the running one in its frame and true is returned,
+// so tail calls run in constant stack space.
+// Otherwise, nothing is done and false is returned.
+func (self *lkState) TailCall(nArgs int) bool {
+	val := self.stack.get(-(nArgs + 1))
+	c, ok := val.(*lkClosure)
+	if !ok {
+		if mf := getMetafield(val, "__call", self); mf != nil {
+			if c, ok = mf.(*lkClosure); ok && c.proto != nil {
+				self.stack.push(mf)
+				self.Insert(-(nArgs + 2))
+				nArgs += 1
+			}
+		}
+	}
+	if !ok || c.proto == nil {
+		return false
+	}
+
+	frame := self.newLuaFrame(nArgs, c)
+	frame.isTail = true
+	caller := self.stack
 	self.popLuaStack()
+	self.pushLuaStack(frame)
+	self.freeFrame(caller)
+	if self.g.hookMask&LK_MASKCALL != 0 {
+		self.callHook(LK_HOOKTAILCALL, -1)
+	}
+	return true
+}
 
-	// return results
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		self.stack.check(len(results))
-		self.stack.pushN(results, nResults)
+// CallGlobal calls the global function name with args converted
+// like SetGlobalValue, and returns its results converted like Globals.
+// Errors raised by the function are returned.
+func (self *lkState) CallGlobal(name string, args ...any) ([]any, error) {
+	top := self.GetTop()
+	defer self.SetTop(top)
+	if self.GetGlobal(name) != LK_TFUNCTION {
+		return nil, fmt.Errorf("global '%s' is not a function", name)
+	}
+	for _, arg := range args {
+		stdlib.PushGoValue(self, arg)
+	}
+	if self.PCall(len(args), LK_MULTRET, 0) != LK_OK {
+		return nil, errors.New(errorString(self.stack.get(-1)))
+	}
+	results := make([]any, 0, self.GetTop()-top)
+	for idx := top + 1; idx <= self.GetTop(); idx++ {
+		v, _ := snapshotValue(self.stack.get(idx), callResultDepth, map[*lkTable]bool{})
+		results = append(results, v)
 	}
+	return results, nil
 }
 
 func (self *lkState) runLuaClosure() {
 	for {
-		inst := vm.Instruction(self.Fetch())
-		inst.Execute(self)
-		if inst.Opcode() == vm.OP_RETURN {
+		var i *vm.Decoded
+		if self.g.checked {
+			i = self.fetchChecked()
+		} else {
+			i = &self.stack.closure.code.Insts[self.stack.pc]
+			self.stack.pc++
+		}
+		i.Action(i, self)
+		if i.Inst.Opcode() == vm.OP_RETURN {
 			break
 		}
 	}
 }
 
+// fetchChecked is the fetch of runLuaClosure while a line or count hook,
+// a context, limits or a trace is set: it runs their checks around it.
+func (self *lkState) fetchChecked() *vm.Decoded {
+	g := self.g
+	if g.hookMask&(LK_MASKLINE|LK_MASKCOUNT) != 0 {
+		self.traceExec()
+	}
+	if g.done != nil {
+		self.checkDone()
+	}
+	i := &self.stack.closure.code.Insts[self.stack.pc]
+	if g.limits != nil {
+		self.checkLimits()
+		if i.Unfused != nil { /* count both instructions of the pair */
+			i = i.Unfused
+		}
+	}
+	self.stack.pc++
+	if g.trace != nil {
+		self.traceInst(i.Inst)
+	}
+	return i
+}
+
 func (self *lkState) CatchAndPrint(isRepl bool) {
 	if err := recover(); err != nil {
-		log.Red("%v\n", err)
+		log.Red("%s\n", errorString(err))
 		stack := self.stack
 		if isRepl {
 			_catchEachStack(stack, -1)
 			return
 		}
+		// like luaL_traceback, long stacks only show their ends
+		skip := self.nCalls - 1 - tracebackLevels1 - tracebackLevels2
 		stackIdx := 0
 		for stack.prev != nil {
+			if skip > 0 && stackIdx == tracebackLevels1 {
+				log.Yellow("...\t(skipping %d levels)", skip)
+				for ; skip > 0; skip-- {
+					stack = stack.prev
+					stackIdx++
+				}
+				continue
+			}
 			_catchEachStack(stack, stackIdx)
 			stack = stack.prev
 			stackIdx++
@@ -134,24 +255,7 @@ func _catchEachStack(stack *lkStack, idx int) {
 		return 0
 	}()
 	source := stack.closure.proto.Source
-	code := func() string {
-		var data []byte
-		var err error
-		if strings.HasPrefix(source, consts.BuiltinPrefix) {
-			data, err = mods.Files.ReadFile(source[consts.BuiltinPrefixLen:])
-		} else if sys.Exist(source) {
-			data, err = os.ReadFile(source)
-		}
-
-		if data == nil || len(data) == 0 || err != nil {
-			return ""
-		}
-		splited := strings.Split(string(data), "\n")
-		if int(line) > len(splited) {
-			return fmt.Sprintf("Find code: out of range: line %d >= file len %d", line, len(splited))
-		}
-		return strings.Trim(strings.TrimSpace(splited[line-1]), "\n")
-	}()
+	code := _sourceLine(source, int(line))
 	if source != "" {
 		if idx >= 0 {
 			log.Yellow("%d >> %s:%d", idx, source, line)
@@ -164,6 +268,31 @@ func _catchEachStack(stack *lkStack, idx int) {
 	}
 }
 
+// _sourceLine returns the code at line of the file source,
+// or "" if it can't be read.
+func _sourceLine(source string, line int) string {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, consts.BuiltinPrefix) {
+		data, err = mods.Files.ReadFile(source[consts.BuiltinPrefixLen:])
+	} else if sys.Exist(source) {
+		data, err = os.ReadFile(source)
+	}
+
+	if len(data) == 0 || err != nil || line < 1 {
+		return ""
+	}
+	splited := strings.Split(string(data), "\n")
+	if line > len(splited) {
+		return fmt.Sprintf("Find code: out of range: line %d >= file len %d", line, len(splited))
+	}
+	return strings.Trim(strings.TrimSpace(splited[line-1]), "\n")
+}
+
+// fatalError raises err so pcall can't catch it: it goes up to the PCall
+// called outside of any function, by the host or to run a coroutine.
+type fatalError struct{ err any }
+
 // Calls a function in protected mode.
 // http://www.lua.org/manual/5.3/manual.html#lua_pcall
 func (self *lkState) PCall(nArgs, nResults, msgh int) (status LkStatus) {
@@ -173,9 +302,10 @@ func (self *lkState) PCall(nArgs, nResults, msgh int) (status LkStatus) {
 	// catch error
 	defer func() {
 		if err := recover(); err != nil {
-			if msgh != 0 {
+			if _, ok := err.(fatalError); msgh != 0 || ok && caller.prev != nil {
 				panic(err)
 			}
+			err = self.errorValue(err)
 			for self.stack != caller {
 				self.popLuaStack()
 			}
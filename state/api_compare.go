@@ -1,7 +1,7 @@
 package state
 
 import (
-	"fmt"
+	"strings"
 
 	. "github.com/lollipopkit/lk/api"
 )
@@ -22,6 +22,8 @@ func (self *lkState) Compare(idx1, idx2 int, op CompareOp) bool {
 		return _lt(a, b, self)
 	case LK_OPLE:
 		return _le(a, b, self)
+	case LK_OPIN:
+		return _in(a, b, self)
 	default:
 		panic("invalid compare op!")
 	}
@@ -92,7 +94,8 @@ func _lt(a, b any, ls *lkState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic(fmt.Sprintf("invalid cmp: %v < %v", a, b))
+		ls.runError("invalid cmp: %s < %s", ls.typeNameOf(a), ls.typeNameOf(b))
+		return false
 	}
 }
 
@@ -123,6 +126,31 @@ func _le(a, b any, ls *lkState) bool {
 	} else if result, ok := callMetamethod(b, a, "__lt", ls); ok {
 		return !convertToBoolean(result)
 	} else {
-		panic(fmt.Sprintf("invalid cmp: %v <= %v", a, b))
+		ls.runError("invalid cmp: %s <= %s", ls.typeNameOf(a), ls.typeNameOf(b))
+		return false
+	}
+}
+
+// _in tells whether a is a key of the map b, a value of the list b,
+// or a substring of the string b.
+// A table without hash part is a list, like for json.
+func _in(a, b any, ls *lkState) bool {
+	switch y := b.(type) {
+	case *lkTable:
+		if len(y._map) > 0 {
+			return y.get(a) != nil
+		}
+		for _, v := range y.arr {
+			if _eq(a, v, ls) {
+				return true
+			}
+		}
+		return false
+	case string:
+		if x, ok := a.(string); ok {
+			return strings.Contains(y, x)
+		}
 	}
+	ls.runError("invalid in: %s in %s", ls.typeNameOf(a), ls.typeNameOf(b))
+	return false
 }
@@ -0,0 +1,57 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Instructions between two checks of the context
+const doneCheckInterval = 1024
+
+// CallContext is Call, but the VM stops running lk code with an
+// "execution canceled" error once ctx is done, or with the cause
+// of its cancellation if one was given (see context.WithCancelCause).
+// pcall can't catch the error: it goes up to the host.
+// ctx is also the context of blocking calls during the call, see SetContext.
+func (self *lkState) CallContext(ctx context.Context, nArgs, nResults int) {
+	defer self.withContext(ctx)()
+	self.Call(nArgs, nResults)
+}
+
+// PCallContext is PCall, with the cancellation of CallContext.
+func (self *lkState) PCallContext(ctx context.Context, nArgs, nResults, msgh int) LkStatus {
+	defer self.withContext(ctx)()
+	return self.PCall(nArgs, nResults, msgh)
+}
+
+// withContext sets ctx until the returned func is called.
+func (self *lkState) withContext(ctx context.Context) func() {
+	g := self.g
+	oldCtx, oldDone := g.ctx, g.done
+	g.ctx, g.done = ctx, ctx.Done()
+	g.updateChecked()
+	return func() {
+		g.ctx, g.done = oldCtx, oldDone
+		g.updateChecked()
+	}
+}
+
+func (self *lkState) checkDone() {
+	g := self.g
+	if g.doneCount++; g.doneCount < doneCheckInterval {
+		return
+	}
+	g.doneCount = 0
+	select {
+	case <-g.done:
+		/* the context stays done: a pcall catching this would be stopped again */
+		msg := fmt.Sprintf("execution canceled: %v", g.ctx.Err())
+		if cause := context.Cause(g.ctx); cause != g.ctx.Err() {
+			msg = fmt.Sprint(cause)
+		}
+		panic(fatalError{self.newError(msg)})
+	default:
+	}
+}
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+func TestCallContext(t *testing.T) {
+	for _, tt := range []struct {
+		name, src, err string
+	}{
+		{"loop", "while true {}", "execution canceled: context deadline exceeded"},
+		{"sleep", "os.sleep(100000)", "interrupted: context deadline exceeded"},
+		{"task", "sync.spawn(fn() { os.sleep(100000) })\nsync.run()", "interrupted: context deadline exceeded"},
+		{"tasks", "sync.spawn(fn() { while true { os.sleep(1) } })\nsync.spawn(fn() { os.sleep(100000) })\nsync.run()",
+			"context deadline exceeded"},
+	} {
+		ls := New()
+		ls.OpenLibs()
+		if ls.LoadString(tt.src, tt.name+".lk") != LK_OK {
+			t.Fatal(ls.ToString2(-1))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		status := ls.PCallContext(ctx, 0, 0, 0)
+		cancel()
+		if status == LK_OK {
+			t.Errorf("%s: not canceled", tt.name)
+		} else if msg := ls.ToString2(-1); !strings.Contains(msg, tt.err) {
+			t.Errorf("%s: got %q", tt.name, msg)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: canceled after %v", tt.name, d)
+		}
+	}
+}
+
+// The cause given to cancel is the error.
+func TestCallContextCause(t *testing.T) {
+	ls := New()
+	if ls.LoadString("while true {}", "loop.lk") != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(50*time.Millisecond, func() { cancel(errors.New("stopped")) })
+	if ls.PCallContext(ctx, 0, 0, 0) == LK_OK {
+		t.Fatal("not canceled")
+	}
+	if msg := ls.ToString2(-1); !strings.HasSuffix(msg, "stopped") {
+		t.Fatalf("got %q", msg)
+	}
+}
+
+// pcall doesn't catch the cancellation: the context stays done.
+func TestCallContextPcall(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	if ls.LoadString("pcall(fn() { while true {} })\nescaped = true", "pcall.lk") != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if ls.PCallContext(ctx, 0, 0, 0) == LK_OK {
+		t.Fatal("not canceled")
+	}
+	if msg := ls.ToString2(-1); !strings.Contains(msg, "execution canceled") {
+		t.Fatalf("got %q", msg)
+	}
+	if ls.GetGlobal("escaped") != LK_TNIL {
+		t.Fatal("pcall caught the cancellation")
+	}
+}
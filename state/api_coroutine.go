@@ -1,13 +1,19 @@
 package state
 
-import . "github.com/lollipopkit/lk/api"
+import (
+	"errors"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+var errThreadClosed = fatalError{errors.New("coroutine closed")}
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_newthread
 // lua-5.3.4/src/lstate.c#lua_newthread()
 func (self *lkState) NewThread() LkState {
-	t := &lkState{registry: self.registry}
-	t.pushLuaStack(newLuaStack(LK_MINSTACK, t))
+	t := &lkState{registry: self.registry, g: self.g}
+	t.pushLuaStack(newLuaStack(t.g.stackSize, t))
 	self.stack.push(t)
 	return t
 }
@@ -24,8 +30,12 @@ func (self *lkState) Resume(from LkState, nArgs int) LkStatus {
 		// start coroutine
 		self.coChan = make(chan int)
 		self.coCaller = lsFrom
+		self.coDone = make(chan struct{})
+		self.g.addThread(self)
 		go func() {
+			defer close(self.coDone)
 			self.coStatus = self.PCall(nArgs, -1, 0)
+			self.g.removeThread(self)
 			lsFrom.coChan <- 1
 		}()
 	} else {
@@ -44,23 +54,72 @@ func (self *lkState) Resume(from LkState, nArgs int) LkStatus {
 
 // [-?, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_yield
+// Each coroutine runs on its own goroutine, which simply blocks here until
+// resumed. So Yield can be called below any Go function, such as pcall or
+// a metamethod called by a lib: no continuation (lua_yieldk) is needed,
+// the Go function goes on when Yield returns the resume args.
 func (self *lkState) Yield(nResults int) LkStatus {
-	if self.coCaller == nil { // todo
-		panic("attempt to yield from outside a coroutine")
+	if self.coCaller == nil {
+		self.runError("attempt to yield from outside a coroutine")
 	}
 	self.coStatus = LK_YIELD
 	self.coCaller.coChan <- 1
 	<-self.coChan
+	if self.coClose {
+		panic(errThreadClosed)
+	}
 	return LkStatus(self.GetTop())
 }
 
+// [-0, +?, –]
+// http://www.lua.org/manual/5.4/manual.html#lua_closethread
+// Closes the suspended coroutine: its goroutine ends,
+// and it becomes dead. Running coroutines can't be closed.
+func (self *lkState) CloseThread(from LkState) LkStatus {
+	if self.coChan != nil && self.coStatus == LK_YIELD {
+		self.coClose = true
+		self.coStatus = LK_OK
+		self.coChan <- 1
+		<-self.coCaller.coChan // wait the coroutine to unwind
+		<-self.coDone
+		self.coStatus = LK_OK
+	} else if self.GetStack() || self == from {
+		self.stack.push("cannot close a running coroutine")
+		return LK_ERRRUN
+	}
+	self.SetTop(0)
+	return LK_OK
+}
+
+// Threads returns the coroutines started and not finished yet,
+// running or suspended.
+func (self *lkState) Threads() []LkState {
+	self.g.threadsMu.Lock()
+	defer self.g.threadsMu.Unlock()
+	threads := make([]LkState, 0, len(self.g.threads))
+	for t := range self.g.threads {
+		threads = append(threads, t)
+	}
+	return threads
+}
+
+func (g *lkGlobal) addThread(t *lkState) {
+	g.threadsMu.Lock()
+	defer g.threadsMu.Unlock()
+	g.threads[t] = struct{}{}
+}
+
+func (g *lkGlobal) removeThread(t *lkState) {
+	g.threadsMu.Lock()
+	defer g.threadsMu.Unlock()
+	delete(g.threads, t)
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
+// Any running coroutine can yield, even across Go functions, see Yield.
 func (self *lkState) IsYieldable() bool {
-	if self.isMainThread() {
-		return false
-	}
-	return self.coStatus != LK_YIELD // todo
+	return self.coCaller != nil && !self.isMainThread()
 }
 
 // [-0, +0, –]
@@ -0,0 +1,25 @@
+package state
+
+import "testing"
+
+// pcall can't catch the unwinding of a closed coroutine.
+func TestCloseThreadPcall(t *testing.T) {
+	const src = `
+shy n = sync.count()
+shy co = sync.create(fn() {
+    while true {
+        pcall(sync.yield)
+    }
+})
+sync.resume(co)
+assert(sync.count() == n + 1)
+assert(sync.close(co))
+assert(sync.status(co) == 'dead')
+assert(sync.count() == n)
+`
+	ls := New()
+	ls.OpenLibs()
+	if ls.DoString(src, "close.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+}
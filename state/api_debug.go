@@ -0,0 +1,209 @@
+package state
+
+import (
+	. "github.com/lollipopkit/lk/api"
+)
+
+// level 0 is the running function, level n+1 is the function that called level n.
+func (self *lkState) getStackAt(level int) *lkStack {
+	if level < 0 {
+		return nil
+	}
+	stack := self.stack
+	for ; level > 0 && stack != nil; level-- {
+		stack = stack.prev
+	}
+	if stack == nil || stack.closure == nil {
+		return nil
+	}
+	return stack
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+func (self *lkState) GetStackInfo(level int) (DebugInfo, bool) {
+	stack := self.getStackAt(level)
+	if stack == nil {
+		return DebugInfo{}, false
+	}
+	c := stack.closure
+	info := DebugInfo{
+		NumUpvalues: len(c.upVals),
+		CurrentLine: -1,
+	}
+	if c.proto == nil {
+		info.Source = "[Go]"
+		info.What = "Go"
+		info.LineDefined = -1
+		info.LastLineDefined = -1
+		info.IsVararg = true
+		return info, true
+	}
+
+	proto := c.proto
+	info.Source = proto.Source
+	info.LineDefined = int(proto.LineDefined)
+	info.LastLineDefined = int(proto.LastLineDefined)
+	info.NumParams = int(proto.NumParams)
+	info.IsVararg = proto.IsVararg == 1
+	if proto.LineDefined == 0 {
+		info.What = "main"
+	} else {
+		info.What = "lk"
+	}
+	info.CurrentLine = stack.currentLine()
+	return info, true
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+func (self *lkState) GetLocal(level, n int) (string, bool) {
+	stack := self.getStackAt(level)
+	if stack == nil || stack.closure.proto == nil {
+		return "", false
+	}
+	name, ok := stack.localName(n)
+	if !ok || n > len(stack.slots) {
+		return "", false
+	}
+	self.stack.push(stack.slots[n-1])
+	return name, true
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
+func (self *lkState) GetUpvalue(funcIdx, n int) (string, bool) {
+	c, ok := self.stack.get(funcIdx).(*lkClosure)
+	if !ok || n < 1 || n > len(c.upVals) {
+		return "", false
+	}
+	if uv := c.upVals[n-1]; uv != nil {
+		self.stack.push(*uv)
+	} else {
+		self.stack.push(nil)
+	}
+	return c.upvalueName(n), true
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
+func (self *lkState) SetUpvalue(funcIdx, n int) (string, bool) {
+	c, ok := self.stack.get(funcIdx).(*lkClosure)
+	if !ok || n < 1 || n > len(c.upVals) {
+		return "", false
+	}
+	if val := self.stack.pop(); c.upVals[n-1] != nil {
+		*c.upVals[n-1] = val
+	} else {
+		c.upVals[n-1] = &val
+	}
+	return c.upvalueName(n), true
+}
+
+func (self *lkStack) currentLine() int {
+	proto := self.closure.proto
+	if proto == nil || self.pc < 1 || self.pc > len(proto.LineInfo) {
+		return -1
+	}
+	return int(proto.LineInfo[self.pc-1])
+}
+
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func (self *lkStack) localName(n int) (string, bool) {
+	pc := uint32(self.pc - 1)
+	for _, locVar := range self.closure.proto.LocVars {
+		if locVar.StartPC > pc {
+			break
+		}
+		if pc < locVar.EndPC { /* is variable active? */
+			n--
+			if n == 0 {
+				return locVar.VarName, true
+			}
+		}
+	}
+	return "", false
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+func (self *lkState) SetHook(mask, count int, f Hook) {
+	if f == nil || mask == 0 { /* turn off hooks? */
+		mask = 0
+		f = nil
+	}
+	if count <= 0 {
+		mask &^= LK_MASKCOUNT
+	}
+	self.g.hook = f
+	self.g.baseCount = count
+	self.g.hookMask = mask
+	self.g.updateChecked()
+	self.hookCount = count
+}
+
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
+func (self *lkState) GetHook() Hook {
+	return self.g.hook
+}
+
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
+func (self *lkState) GetHookMask() int {
+	return self.g.hookMask
+}
+
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
+func (self *lkState) GetHookCount() int {
+	return self.g.baseCount
+}
+
+// called before each instruction while a line or count hook is set
+// lua-5.3.4/src/ldebug.c#luaG_traceexec()
+func (self *lkState) traceExec() {
+	if self.inHook {
+		return
+	}
+	mask := self.g.hookMask
+	stack := self.stack
+	lineInfo := stack.closure.proto.LineInfo
+	pc := stack.pc
+
+	/* hooks assume 'pc' is already incremented */
+	stack.pc++
+	defer func() { stack.pc-- }()
+
+	if mask&LK_MASKCOUNT != 0 {
+		self.hookCount--
+		if self.hookCount <= 0 {
+			self.hookCount = self.g.baseCount /* reset count */
+			self.callHook(LK_HOOKCOUNT, stack.currentLine())
+		}
+	}
+	if mask&LK_MASKLINE != 0 && pc < len(lineInfo) {
+		newLine := lineInfo[pc]
+		/* call line hook when entering a new function, when jumping back (loop),
+		   or when entering a new line */
+		if pc == 0 || pc <= stack.oldPC || stack.oldPC >= len(lineInfo) || newLine != lineInfo[stack.oldPC] {
+			self.callHook(LK_HOOKLINE, int(newLine))
+		}
+		stack.oldPC = pc
+	}
+}
+
+// lua-5.3.4/src/ldo.c#luaD_hook()
+func (self *lkState) callHook(event, line int) {
+	hook := self.g.hook
+	if hook == nil || self.inHook {
+		return
+	}
+	top := self.stack.top
+	self.inHook = true
+	defer func() {
+		self.inHook = false
+	}()
+	hook(self, event, line)
+	for self.stack.top > top {
+		self.stack.pop()
+	}
+}
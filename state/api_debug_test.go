@@ -0,0 +1,55 @@
+package state
+
+import (
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// A tail call fires a tail call event, which no return event pairs.
+func TestHookTailCall(t *testing.T) {
+	ls := New()
+	events := map[int]int{}
+	ls.SetHook(LK_MASKCALL|LK_MASKRET, 0, func(ls LkState, event, line int) {
+		events[event]++
+	})
+	if ls.DoString("fn f(n) { if n > 0 { rt f(n - 1) } rt 0 }\nf(3)", "tail.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if events[LK_HOOKTAILCALL] != 3 || events[LK_HOOKCALL] != events[LK_HOOKRET] {
+		t.Fatalf("got %v", events)
+	}
+}
+
+// A chained hook gets its own events and leaves the previous hook its own.
+func TestChainHook(t *testing.T) {
+	ls := New()
+	calls, counts, lines := 0, 0, 0
+	prev := func(ls LkState, event, line int) {
+		switch event {
+		case LK_HOOKCALL:
+			calls++
+		case LK_HOOKCOUNT:
+			counts++
+		default:
+			t.Fatalf("previous hook got event %d", event)
+		}
+	}
+	ls.SetHook(LK_MASKCALL|LK_MASKCOUNT, 10, prev)
+	restore := ChainHook(ls, LK_MASKLINE, 0, func(ls LkState, event, line int) {
+		if event != LK_HOOKLINE {
+			t.Fatalf("chained hook got event %d", event)
+		}
+		lines++
+	})
+	if ls.DoString("fn f() {}\nfor i = 1, 100 {\nf()\n}", "chain.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	restore()
+	if calls == 0 || counts == 0 || lines == 0 {
+		t.Fatalf("calls %d, counts %d, lines %d", calls, counts, lines)
+	}
+	if ls.GetHookMask() != LK_MASKCALL|LK_MASKCOUNT || ls.GetHookCount() != 10 {
+		t.Fatalf("hook not restored: mask %d, count %d", ls.GetHookMask(), ls.GetHookCount())
+	}
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/stdlib"
+)
+
+type event struct {
+	name string
+	args []any
+}
+
+// events emitted by the host, waiting to be handled on the state's goroutine
+type eventQueue struct {
+	sync.Mutex
+	events []event
+	signal chan struct{} // has a value while events is not empty
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{signal: make(chan struct{}, 1)}
+}
+
+// Emit queues the event name with args, converted like SetGlobalValue,
+// for the handlers registered with events.on.
+// Unlike other methods, it can be called from any goroutine:
+// handlers run later, on the goroutine of the state,
+// in DispatchEvents or events.dispatch/wait.
+func (self *lkState) Emit(name string, args ...any) {
+	q := self.g.events
+	q.Lock()
+	q.events = append(q.events, event{name, args})
+	q.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// PollEvent removes the oldest queued event.
+func (self *lkState) PollEvent() (name string, args []any, ok bool) {
+	q := self.g.events
+	q.Lock()
+	defer q.Unlock()
+	if len(q.events) == 0 {
+		return "", nil, false
+	}
+	e := q.events[0]
+	q.events[0] = event{}
+	q.events = q.events[1:]
+	if len(q.events) > 0 {
+		select {
+		case q.signal <- struct{}{}:
+		default:
+		}
+	}
+	return e.name, e.args, true
+}
+
+// WaitEvent blocks until an event is queued, timeout (if > 0) expires
+// or the context of the state is done. It returns whether an event is queued.
+func (self *lkState) WaitEvent(timeout time.Duration) bool {
+	q := self.g.events
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		q.Lock()
+		n := len(q.events)
+		q.Unlock()
+		if n > 0 {
+			return true
+		}
+		select {
+		case <-q.signal:
+		case <-timer:
+			return false
+		case <-self.Context().Done():
+			return false
+		}
+	}
+}
+
+// DispatchEvents runs the handlers of the queued events
+// and returns the number of events handled.
+// It stops at the first error raised by a handler.
+func (self *lkState) DispatchEvents() (int, error) {
+	n := 0
+	for {
+		name, args, ok := self.PollEvent()
+		if !ok {
+			return n, nil
+		}
+		self.PushGoFunction(func(ls LkState) int {
+			stdlib.HandleEvent(ls, name, args)
+			return 0
+		})
+		if self.PCall(0, 0, 0) != LK_OK {
+			return n, errors.New(errorString(self.stack.pop()))
+		}
+		n++
+	}
+}
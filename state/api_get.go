@@ -13,6 +13,7 @@ func (self *lkState) NewTable() {
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_createtable
 func (self *lkState) CreateTable(nArr, nRec int) {
+	self.alloc(tableSize + int64(nArr+nRec)*entrySize)
 	t := newLkTable(nArr, nRec)
 	self.stack.push(t)
 }
@@ -80,17 +81,16 @@ func (self *lkState) GetMetatable(idx int) bool {
 
 // push(t[k])
 func (self *lkState) getTable(t, k any, raw bool) LkType {
-	mf := getMetafield(t, "__index", self)
 	if tbl, ok := t.(*lkTable); ok {
-		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") && mf == nil {
+		/* the metatable is only looked up for absent keys */
+		if v := tbl.get(k); v != nil || raw {
 			self.stack.push(v)
 			return typeOf(v)
 		}
 	}
 
 	if !raw {
-		if mf != nil {
+		if mf := getMetafield(t, "__index", self); mf != nil {
 			switch x := mf.(type) {
 			case *lkTable:
 				return self.getTable(x, k, true)
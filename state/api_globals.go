@@ -0,0 +1,74 @@
+package state
+
+import (
+	"fmt"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Depth of the nested tables converted by Globals and CallGlobal
+const (
+	globalsDepth    = 4
+	callResultDepth = 32
+)
+
+// Globals returns a copy of the global table, for display by the host.
+// Only nil, booleans, numbers, strings and tables are copied:
+// lists become []any, other tables map[string]any.
+// Other values are left out of maps, and are nil in lists,
+// so the elements keep their index.
+// Tables nested deeper than a few levels, or containing themselves,
+// are replaced by "{...}".
+// Like the other methods, and unlike Emit, it must not be called while
+// the state runs on another goroutine (tasks of sync.go have their own
+// states). To show the globals of a running script, take the snapshot
+// in a hook or a Go function the script calls, and hand it over.
+func (self *lkState) Globals() map[string]any {
+	globals := self.registry.get(LK_RIDX_GLOBALS).(*lkTable)
+	return snapshotMap(globals, globalsDepth, map[*lkTable]bool{})
+}
+
+// path holds the tables being copied, to stop at cycles
+func snapshotValue(val any, depth int, path map[*lkTable]bool) (any, bool) {
+	switch x := val.(type) {
+	case nil, bool, int64, float64, string:
+		return x, true
+	case *lkTable:
+		if depth <= 0 || path[x] {
+			return "{...}", true
+		}
+		if len(x._map) == 0 {
+			return snapshotList(x, depth-1, path), true
+		}
+		return snapshotMap(x, depth-1, path), true
+	}
+	return nil, false
+}
+
+func snapshotList(t *lkTable, depth int, path map[*lkTable]bool) []any {
+	path[t] = true
+	defer delete(path, t)
+	list := make([]any, 0, len(t.arr))
+	for _, v := range t.arr {
+		sv, _ := snapshotValue(v, depth, path)
+		list = append(list, sv)
+	}
+	return list
+}
+
+func snapshotMap(t *lkTable, depth int, path map[*lkTable]bool) map[string]any {
+	path[t] = true
+	defer delete(path, t)
+	m := make(map[string]any, len(t.arr)+len(t._map))
+	for i, v := range t.arr {
+		if sv, ok := snapshotValue(v, depth, path); ok {
+			m[fmt.Sprint(i)] = sv
+		}
+	}
+	for k, v := range t._map {
+		if sv, ok := snapshotValue(v, depth, path); ok {
+			m[fmt.Sprint(k)] = sv
+		}
+	}
+	return m
+}
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+func TestGlobals(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	if ls.DoString(`
+		items = {1, print, 'a', {'x': true}}
+		dict = {'n': 1.5, 'f': print}
+		cycle = {}
+		cycle.self = cycle
+	`, "globals.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	g := ls.Globals()
+	for name, want := range map[string]any{
+		"items": []any{int64(1), nil, "a", map[string]any{"x": true}},
+		"dict":  map[string]any{"n": 1.5},
+		"cycle": map[string]any{"self": "{...}"},
+	} {
+		if !reflect.DeepEqual(g[name], want) {
+			t.Errorf("%s: got %#v, want %#v", name, g[name], want)
+		}
+	}
+}
+
+// A snapshot taken on the goroutine of the state can be read on another
+// one while the script goes on changing its globals.
+func TestGlobalsHandOver(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	snapshots := make(chan map[string]any, 1)
+	ls.Register("publish", func(ls LkState) int {
+		snapshots <- ls.Globals()
+		return 0
+	})
+	done := make(chan map[string]any)
+	go func() {
+		done <- (<-snapshots)["counter"].(map[string]any)
+	}()
+	if ls.DoString(`
+		counter = {'n': 1}
+		publish()
+		for i = 1, 1000 {
+			counter.n = i
+		}
+	`, "globals.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if got := <-done; got["n"] != int64(1) {
+		t.Fatalf("got %v", got)
+	}
+}
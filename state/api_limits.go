@@ -0,0 +1,51 @@
+package state
+
+import (
+	"time"
+)
+
+// Instructions between two checks of the clock
+const limitsClockInterval = 1024
+
+type limits struct {
+	maxInsts int64 // 0: unlimited
+	executed int64
+	deadline time.Time // zero: unlimited
+	exceeded bool
+}
+
+// SetLimits stops the scripts of this state and all its coroutines
+// with an "execution budget exceeded" error once they have executed
+// maxInstructions instructions, or run for maxDuration from now.
+// 0 means no limit. Each call starts a new budget.
+func (self *lkState) SetLimits(maxInstructions int64, maxDuration time.Duration) {
+	if maxInstructions <= 0 && maxDuration <= 0 {
+		self.g.limits = nil
+		self.g.updateChecked()
+		return
+	}
+	l := &limits{maxInsts: maxInstructions}
+	if maxDuration > 0 {
+		l.deadline = time.Now().Add(maxDuration)
+	}
+	self.g.limits = l
+	self.g.updateChecked()
+}
+
+// checkLimits is called before each instruction while limits are set,
+// which are not fused then, see runLuaClosure.
+// Once exceeded, the error can't be caught with pcall, and it is raised
+// again by every following instruction until SetLimits is called.
+func (self *lkState) checkLimits() {
+	l := self.g.limits
+	l.executed++
+	if l.maxInsts > 0 && l.executed > l.maxInsts {
+		l.exceeded = true
+	} else if !l.deadline.IsZero() && l.executed%limitsClockInterval == 0 &&
+		time.Now().After(l.deadline) {
+		l.exceeded = true
+	}
+	if l.exceeded {
+		panic(fatalError{self.newError("execution budget exceeded")})
+	}
+}
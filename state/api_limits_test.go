@@ -0,0 +1,92 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// A fused pair counts as its two instructions: pairs spanning two lines
+// are not fused, and the same code split differently has the same cost.
+func TestInstructionLimit(t *testing.T) {
+	for _, src := range []string{
+		"shy a = 1\nif a == 1 { a = 2 }",
+		"shy a = 1\nif a ==\n1 { a = 2 }",
+	} {
+		/* LOADK, EQ, JMP, LOADBOOL, TEST, LOADK, MOVE, RETURN */
+		const insts = 8
+		for _, n := range []int64{insts - 1, insts} {
+			ls := New()
+			ls.SetLimits(n, 0)
+			failed := ls.DoString(src, "limits.lk")
+			if failed != (n < insts) {
+				t.Errorf("%q with %d instructions: failed %v", src, n, failed)
+			}
+			if failed && !strings.Contains(ls.ToString2(-1), "execution budget exceeded") {
+				t.Errorf("%q: got %q", src, ls.ToString2(-1))
+			}
+		}
+	}
+}
+
+// pcall can't catch the exceeded budget.
+func TestInstructionLimitPcall(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetLimits(1000, 0)
+	if !ls.DoString("pcall(fn() { while true {} })\nescaped = true", "limits.lk") {
+		t.Fatal("the budget was escaped")
+	}
+	if msg := ls.ToString2(-1); !strings.Contains(msg, "execution budget exceeded") {
+		t.Fatalf("got %q", msg)
+	}
+	ls.SetLimits(0, 0)
+	if ls.GetGlobal("escaped") != LK_TNIL {
+		t.Fatal("pcall caught the error")
+	}
+}
+
+func TestCallLimit(t *testing.T) {
+	const src = "fn f(n) { if n > 0 { rt f(n - 1) + 1 } rt 0 }\nrt f(%d)"
+	for _, tt := range []struct {
+		depth int
+		ok    bool
+	}{
+		{40, true},
+		{60, false},
+	} {
+		ls := NewWithOptions(Options{MaxCallDepth: 50})
+		failed := ls.DoString(fmt.Sprintf(src, tt.depth), "calls.lk")
+		if failed == tt.ok {
+			t.Errorf("depth %d: failed %v", tt.depth, failed)
+		}
+		if failed && !strings.Contains(ls.ToString2(-1), "too much recursion") {
+			t.Errorf("depth %d: got %q", tt.depth, ls.ToString2(-1))
+		}
+	}
+}
+
+// Frames grow from StackSize up to MaxStackSize slots.
+func TestStackLimit(t *testing.T) {
+	ls := NewWithOptions(Options{StackSize: 10, MaxStackSize: 100})
+	ls.OpenLibs()
+	ls.Register("push", func(ls LkState) int {
+		n := int(ls.CheckInteger(1))
+		ls.CheckStack2(n, "")
+		for i := 0; i < n; i++ {
+			ls.PushInteger(int64(i))
+		}
+		return n
+	})
+	if ls.DoString("shy a, b = push(50)\nassert(b == 1)", "stack.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if !ls.DoString("push(200)", "stack.lk") || !strings.Contains(ls.ToString2(-1), "stack overflow") {
+		t.Fatalf("got %q", ls.ToString2(-1))
+	}
+	if ls.CheckStack(1000) {
+		t.Fatal("CheckStack beyond MaxStackSize")
+	}
+}
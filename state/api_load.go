@@ -1,6 +1,8 @@
 package state
 
 import (
+	"crypto/ed25519"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -9,10 +11,14 @@ import (
 	. "github.com/lollipopkit/lk/api"
 	"github.com/lollipopkit/lk/binchunk"
 	"github.com/lollipopkit/lk/compiler"
+	"github.com/lollipopkit/lk/consts"
+	"github.com/lollipopkit/lk/stdlib"
 	"github.com/lollipopkit/lk/utils"
+	"github.com/lollipopkit/lk/vm"
 )
 
-func Compile(source string) *binchunk.Prototype {
+// Compile compiles the file source to source+"c".
+func Compile(source string, opts binchunk.DumpOptions) *binchunk.Prototype {
 	if !utils.Exist(source) {
 		log.Red("[compile] file not found: " + source)
 		os.Exit(2)
@@ -26,7 +32,7 @@ func Compile(source string) *binchunk.Prototype {
 
 	bin := compiler.Compile(string(data), source)
 
-	compiledData, err := bin.Dump(utils.Md5(data))
+	compiledData, err := bin.Dump(utils.Md5(data), opts)
 	if err != nil {
 		log.Red("[compile] dump file failed: " + err.Error())
 		os.Exit(2)
@@ -39,26 +45,232 @@ func Compile(source string) *binchunk.Prototype {
 	return bin
 }
 
+// Bundle compiles the file entry and the modules it imports, recursively,
+// into the single chunk out. Builtin modules are not bundled.
+func Bundle(entry, out string, opts binchunk.DumpOptions) error {
+	bundled, err := BundleChunk(entry, opts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(out, bundled, 0744)
+}
+
+// BundleChunk is Bundle, returning the chunk instead of writing it.
+func BundleChunk(entry string, opts binchunk.DumpOptions) ([]byte, error) {
+	data, err := ioutil.ReadFile(entry)
+	if err != nil {
+		return nil, err
+	}
+	main, queue := compiler.CompileImports(string(data), entry)
+	modules := map[string]*binchunk.Prototype{}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := modules[name]; ok {
+			continue
+		}
+		c, filename, err := stdlib.SearchModule(name)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(filename, consts.BuiltinPrefix) {
+			continue
+		}
+		if !strings.HasSuffix(filename, ".lk") {
+			bin, err := binchunk.Undump(c, binchunk.UndumpOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", filename, err.Error())
+			}
+			modules[name] = bin.Proto
+			for sub, proto := range bin.Modules { /* a bundle itself */
+				if _, ok := modules[sub]; !ok {
+					modules[sub] = proto
+				}
+			}
+			queue = append(queue, compiler.ProtoImports(bin.Proto)...)
+			continue
+		}
+		proto, imports := compiler.CompileImports(string(c), filename)
+		modules[name] = proto
+		queue = append(queue, imports...)
+	}
+
+	return binchunk.DumpBundle(main, modules, utils.Md5(data), opts)
+}
+
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_load
+// On error, the message is pushed instead of the function.
 func (self *lkState) Load(chunk []byte, chunkName, mode string) LkStatus {
-	var proto *binchunk.Prototype
 	if chunkName == "stdin" || strings.HasSuffix(chunkName, ".lk") {
-		proto = compiler.Compile(string(chunk), chunkName)
-	} else {
-		var err error
-		proto, err = binchunk.Load(chunk)
-		if err != nil {
-			log.Red("[load] load chunk failed: " + err.Error())
-			os.Exit(2)
+		if !strings.Contains(mode, "t") {
+			return self.loadError("attempt to load a text chunk (mode is '%s')", mode)
+		}
+		if self.g.chunkKey != nil && !strings.HasPrefix(chunkName, consts.BuiltinPrefix) {
+			return self.loadError("%s: text chunk, only signed chunks can be loaded", chunkName)
 		}
+		self.loadText(chunk, chunkName)
+		return LK_OK
 	}
 
-	c := newLuaClosure(proto)
-	self.stack.push(c)
-	if len(proto.Upvalues) > 0 {
-		env := self.registry.get(LK_RIDX_GLOBALS)
-		c.upVals[0] = &env
+	if !strings.Contains(mode, "b") {
+		return self.loadError("attempt to load a binary chunk (mode is '%s')", mode)
+	}
+	bin, err := binchunk.Undump(chunk, binchunk.UndumpOptions{Key: self.g.chunkKey})
+	if err != nil {
+		return self.loadError("%s: %s", chunkName, err.Error())
 	}
+	proto, modules := bin.Proto, bin.Modules
+	if err := vm.Verify(proto); err != nil {
+		return self.loadError("%s: invalid code: %s", chunkName, err.Error())
+	}
+	for name, p := range modules {
+		if err := vm.Verify(p); err != nil {
+			return self.loadError("%s: module %s: invalid code: %s", chunkName, name, err.Error())
+		}
+	}
+
+	self.internConstants(proto)
+	for _, p := range modules {
+		self.internConstants(p)
+	}
+	if len(modules) > 0 {
+		self.loadBundle(modules)
+	}
+	self.stack.push(self.newMainClosure(proto))
 	return LK_OK
 }
+
+// loadText pushes the main closure of a source chunk,
+// compiled by this state or taken from the proto cache.
+func (self *lkState) loadText(chunk []byte, chunkName string) {
+	key := protoKey(chunk, chunkName)
+	cp, ok := getCachedProto(key)
+	if ok {
+		self.adoptConstants(cp.proto)
+	} else {
+		proto := compileCached(chunk, chunkName)
+		self.internConstants(proto)
+		cp = cachedProto{proto: proto, code: vm.Decode(proto)}
+		putCachedProto(key, cp)
+	}
+	self.allocClosure(len(cp.proto.Upvalues))
+	self.stack.push(self.newClosure(cp.proto, cp.code))
+}
+
+// SetChunkKey makes Load refuse binary chunks not signed
+// by the private key matching key, and text chunks but the builtin modules,
+// so imports only load signed modules too.
+// nil accepts unsigned and text chunks again.
+func (self *lkState) SetChunkKey(key ed25519.PublicKey) {
+	self.g.chunkKey = key
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_dump
+// Dump serializes the lk function on the top of the stack to a chunk,
+// which Load reads back. Returns nil if it is not an lk function.
+func (self *lkState) Dump(strip bool) []byte {
+	c, ok := self.stack.get(-1).(*lkClosure)
+	if !ok || c.proto == nil {
+		return nil
+	}
+	data, err := c.proto.Dump("", binchunk.DumpOptions{Strip: strip})
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (self *lkState) loadError(format string, a ...any) LkStatus {
+	self.PushFString(format, a...)
+	return LK_ERRSYNTAX
+}
+
+// loadBundle stores the loaders of bundled modules,
+// where the package searcher looks first.
+func (self *lkState) loadBundle(modules map[string]*binchunk.Prototype) {
+	self.GetSubTable(LK_REGISTRYINDEX, stdlib.LK_BUNDLE_TABLE)
+	bundle := self.stack.get(-1).(*lkTable)
+	for name, proto := range modules {
+		bundle.put(name, self.newMainClosure(proto))
+	}
+	self.Pop(1)
+}
+
+// Bounds of the interned strings, so that loading generated code
+// again and again doesn't grow them forever: past maxInterned strings,
+// the constants not interned yet keep their own values.
+// Long strings are seldom keys or names, and are not interned.
+const maxInterned, maxInternedLen = 1 << 16, 256
+
+// _internable reports whether the constant s can be added to strings.
+func _internable(strings map[string]any, s string) bool {
+	return len(strings) < maxInterned && len(s) <= maxInternedLen
+}
+
+// adoptConstants interns the string constants of a cached proto,
+// which is shared so it keeps its own values.
+func (self *lkState) adoptConstants(proto *binchunk.Prototype) {
+	g := self.g
+	g.stringsMu.Lock()
+	defer g.stringsMu.Unlock()
+	var adopt func(p *binchunk.Prototype)
+	adopt = func(p *binchunk.Prototype) {
+		for _, k := range p.Constants {
+			if s, ok := k.(string); ok {
+				if _, ok := g.strings[s]; !ok && _internable(g.strings, s) {
+					g.strings[s] = k
+				}
+			}
+		}
+		for _, sub := range p.Protos {
+			adopt(sub)
+		}
+	}
+	adopt(proto)
+}
+
+// internConstants makes the equal string constants of all the chunks
+// loaded by the state share one boxed value: table keys and names
+// are then compared by pointer, without comparing their bytes.
+func (self *lkState) internConstants(proto *binchunk.Prototype) {
+	g := self.g
+	g.stringsMu.Lock()
+	defer g.stringsMu.Unlock()
+	var intern func(p *binchunk.Prototype)
+	intern = func(p *binchunk.Prototype) {
+		for i, k := range p.Constants {
+			if s, ok := k.(string); ok {
+				if v, ok := g.strings[s]; ok {
+					p.Constants[i] = v
+				} else if _internable(g.strings, s) {
+					g.strings[s] = k
+				}
+			}
+		}
+		for _, sub := range p.Protos {
+			intern(sub)
+		}
+	}
+	intern(proto)
+}
+
+func (self *lkState) newMainClosure(proto *binchunk.Prototype) *lkClosure {
+	self.allocClosure(len(proto.Upvalues))
+	return self.newClosure(proto, vm.Decode(proto))
+}
+
+// newClosure returns a main closure of proto, with the globals as _ENV.
+// The other upvalues, of a dumped closure, start as nil.
+func (self *lkState) newClosure(proto *binchunk.Prototype, code *vm.Code) *lkClosure {
+	c := newLuaClosure(proto, code)
+	for i := range c.upVals {
+		var val any
+		if i == 0 {
+			val = self.registry.get(LK_RIDX_GLOBALS)
+		}
+		c.upVals[i] = &val
+	}
+	return c
+}
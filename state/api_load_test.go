@@ -0,0 +1,134 @@
+package state
+
+import (
+	"crypto/ed25519"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/compiler"
+)
+
+// A dumped closure gets the globals as first upvalue when loaded,
+// and nil as the others.
+func TestDumpUpvalues(t *testing.T) {
+	const src = `
+shy a, b = 1, 2
+shy f = load(str.dump(fn() { shy x = a; rt b }))
+assert(f() == nil)
+`
+	ls := New()
+	ls.OpenLibs()
+	if ls.DoString(src, "dump.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+}
+
+// A bundle holds the modules imported by the program, recursively,
+// through the compiled modules too.
+func TestBundle(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for name, src := range map[string]string{
+		"main.lk": "shy a = import('a')\nshy b = import('b')\nrt a.v + b.v",
+		"a.lk":    "shy c = import('c')\nrt {'v': 1 + c.v}",
+		"b.lk":    "shy d = import('d')\nrt {'v': 10 + d.v}",
+		"c.lk":    "rt {'v': 100}",
+		"d.lk":    "rt {'v': 1000}",
+	} {
+		if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Compile("b.lk", binchunk.DumpOptions{})
+	os.Remove("b.lk") /* imported as b.lkc */
+	chunk, err := BundleChunk("main.lk", binchunk.DumpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"main.lk", "a.lk", "b.lkc", "c.lk", "d.lk"} {
+		os.Remove(name)
+	}
+
+	ls := New()
+	ls.OpenLibs()
+	if ls.Load(chunk, "app", "b") != LK_OK || ls.PCall(0, 1, 0) != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if v := ls.ToInteger(-1); v != 1111 {
+		t.Fatalf("got %d", v)
+	}
+}
+
+// With a chunk key, only signed chunks load, imported modules included.
+func TestChunkKey(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.WriteFile("m.lk", []byte("rt 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := compiler.Compile("rt import('m')", "main.lk")
+	signed, err := proto.Dump("", binchunk.DumpOptions{Key: priv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := proto.Dump("", binchunk.DumpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ls := New()
+	ls.OpenLibs()
+	ls.SetChunkKey(pub)
+	if ls.LoadString("rt 1", "text.lk") == LK_OK {
+		t.Fatal("text chunk loaded")
+	}
+	ls.Pop(1)
+	if ls.Load(unsigned, "unsigned", "b") == LK_OK {
+		t.Fatal("unsigned chunk loaded")
+	}
+	ls.Pop(1)
+	if ls.Load(signed, "signed", "b") != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if ls.PCall(0, 1, 0) == LK_OK || !strings.Contains(ls.ToString2(-1), "only signed chunks") {
+		t.Fatalf("import of a text module: got %q", ls.ToString2(-1))
+	}
+}
+
+// The interned strings stay bounded, whatever the constants loaded.
+func TestInternBound(t *testing.T) {
+	ls := New().(*lkState)
+	proto := &binchunk.Prototype{Constants: make([]any, maxInterned+10)}
+	for i := range proto.Constants {
+		proto.Constants[i] = strconv.Itoa(i)
+	}
+	proto.Constants = append(proto.Constants, strings.Repeat("x", maxInternedLen+1))
+	ls.internConstants(proto)
+	if n := len(ls.g.strings); n > maxInterned {
+		t.Fatalf("%d strings interned", n)
+	}
+	if _, ok := ls.g.strings[proto.Constants[len(proto.Constants)-1].(string)]; ok {
+		t.Fatal("long string interned")
+	}
+}
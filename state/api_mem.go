@@ -0,0 +1,133 @@
+package state
+
+// Approximate sizes, in bytes, counted by the memory limit
+const (
+	tableSize   = 64
+	entrySize   = 32
+	stringSize  = 16
+	closureSize = 48
+	upvalSize   = 16
+)
+
+// SetMemLimit caps the memory kept by the scripts of this state
+// and all its coroutines to about bytes, counting tables, table entries,
+// strings and closures. Allocations are counted until they reach the cap,
+// then the usage is recounted from the values still reachable, and
+// only if those are past the cap the allocation raises a
+// "memory limit exceeded" error. Allocations by the host outside of
+// any call are counted but never raise.
+// 0 removes the limit. Each call resets the usage.
+func (self *lkState) SetMemLimit(bytes int64) {
+	self.g.memLimit = bytes
+	self.g.memUsed = 0
+}
+
+// MemUsage returns the memory counted since the last SetMemLimit,
+// or the last recount.
+func (self *lkState) MemUsage() int64 {
+	return self.g.memUsed
+}
+
+func (self *lkState) alloc(n int64) {
+	g := self.g
+	if g.memLimit <= 0 {
+		return
+	}
+	g.memUsed += n
+	if g.memUsed <= g.memLimit {
+		return
+	}
+	g.memUsed = self.liveMem() + n
+	if g.memUsed > g.memLimit && self.stack.prev != nil {
+		g.memUsed -= n
+		// not PushString: the message must not be counted
+		self.stack.push("memory limit exceeded")
+		self.Error()
+	}
+}
+
+func (self *lkState) allocString(n int) {
+	self.alloc(stringSize + int64(n))
+}
+
+func (self *lkState) allocClosure(nUpvals int) {
+	self.alloc(closureSize + int64(nUpvals)*upvalSize)
+}
+
+// liveMem returns the memory of the values reachable from the registry
+// and the stacks of the threads, counted as alloc does.
+func (self *lkState) liveMem() int64 {
+	m := memCounter{seen: map[any]bool{}}
+	m.value(self.registry)
+	m.thread(self)
+	self.g.threadsMu.Lock()
+	for t := range self.g.threads {
+		m.thread(t)
+	}
+	self.g.threadsMu.Unlock()
+	return m.n
+}
+
+type memCounter struct {
+	n    int64
+	seen map[any]bool
+}
+
+func (m *memCounter) value(v any) {
+	switch x := v.(type) {
+	case string:
+		m.n += stringSize + int64(len(x))
+	case *lkTable:
+		if m.seen[x] {
+			return
+		}
+		m.seen[x] = true
+		m.n += tableSize + int64(len(x.arr)+len(x._map))*entrySize
+		for _, v := range x.arr {
+			m.value(v)
+		}
+		for k, v := range x._map {
+			m.value(k)
+			m.value(v)
+		}
+	case *lkClosure:
+		if m.seen[x] {
+			return
+		}
+		m.seen[x] = true
+		m.n += closureSize + int64(len(x.upVals))*upvalSize
+		for _, uv := range x.upVals {
+			if uv != nil {
+				m.value(*uv)
+			}
+		}
+	case *userdata:
+		if m.seen[x] {
+			return
+		}
+		m.seen[x] = true
+		if x.meta != nil {
+			m.value(x.meta)
+		}
+	case *lkState:
+		m.thread(x)
+	}
+}
+
+func (m *memCounter) thread(t *lkState) {
+	if m.seen[t] {
+		return
+	}
+	m.seen[t] = true
+	for s := t.stack; s != nil; s = s.prev {
+		for _, v := range s.slots[:s.top] {
+			m.value(v)
+		}
+		for _, v := range s.varargs {
+			m.value(v)
+		}
+		if s.closure != nil {
+			m.value(s.closure)
+		}
+	}
+}
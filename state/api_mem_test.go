@@ -0,0 +1,55 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+func TestMemLimitKept(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemLimit(1 << 20)
+	err := ls.LoadString(`
+		shy keep = {}
+		for i = 1, 100000 {
+			keep[i] = {i, i}
+		}
+	`, "kept.lk")
+	if err != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if ls.PCall(0, 0, 0) == LK_OK {
+		t.Fatal("no error past the limit")
+	}
+	/* the host still pushes, though the memory is not freed yet */
+	msg := ls.ToString2(-1)
+	if !strings.Contains(msg, "memory limit exceeded") {
+		t.Fatalf("got %q", msg)
+	}
+	ls.PushString(strings.Repeat("x", 1<<20))
+	ls.SetTop(0)
+}
+
+func TestMemLimitLive(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemLimit(1 << 20)
+	/* allocates far more than the limit, keeping little of it */
+	if ls.DoString(`
+		shy last
+		for i = 1, 100000 {
+			last = {i, i, 'item' + fmt('%d', i)}
+		}
+	`, "live.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if used := ls.MemUsage(); used <= 0 || used > 1<<20 {
+		t.Fatalf("usage %d", used)
+	}
+	ls.SetMemLimit(0)
+	if used := ls.MemUsage(); used != 0 {
+		t.Fatalf("usage %d after reset", used)
+	}
+}
@@ -6,6 +6,21 @@ import (
 	"github.com/lollipopkit/lk/utils"
 )
 
+// [-0, +0, m]
+// Reserve makes room for n items in the list part of the table at idx,
+// so filling it up to n items doesn't reallocate.
+func (self *lkState) Reserve(idx, n int) {
+	val := self.stack.get(idx)
+	t, ok := val.(*lkTable)
+	if !ok {
+		self.runError("table expected, got %s", self.typeNameOf(val))
+	}
+	if grow := n - cap(t.arr); grow > 0 {
+		self.alloc(int64(grow) * entrySize)
+		t.reserve(n)
+	}
+}
+
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_len
 func (self *lkState) Len(idx int) {
@@ -18,7 +33,7 @@ func (self *lkState) Len(idx int) {
 	} else if t, ok := val.(*lkTable); ok {
 		self.stack.push(int64(t.len()))
 	} else {
-		panic(fmt.Sprintf("attempt to get length of %#v (a %T value)", val, val))
+		self.runError("attempt to get length of a %s value", self.typeNameOf(val))
 	}
 }
 
@@ -35,7 +50,8 @@ func (self *lkState) Next(idx int) bool {
 		}
 		return false
 	}
-	panic(fmt.Sprintf("table expected, got %T", val))
+	self.runError("table expected, got %s", self.typeNameOf(val))
+	return false
 }
 
 // [-1, +0, v]
@@ -45,6 +61,27 @@ func (self *lkState) Error() int {
 	panic(err)
 }
 
+// runError raises an error object for a failed VM operation.
+// lua-5.3.4/src/ldebug.c#luaG_runerror()
+func (self *lkState) runError(format string, a ...any) {
+	panic(self.newError(fmt.Sprintf(format, a...)))
+}
+
+// errorValue returns the lk value of the error err recovered from a panic:
+// Go errors, such as runtime errors, become error objects.
+func (self *lkState) errorValue(err any) any {
+	switch x := err.(type) {
+	case nil, bool, int64, float64, string, *lkTable, *lkClosure, *lkState:
+		return err
+	case fatalError:
+		return self.errorValue(x.err)
+	case error:
+		return self.newError(x.Error())
+	default:
+		return self.newError(fmt.Sprint(x))
+	}
+}
+
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_stringtoutils
 func (self *lkState) StringToNumber(s string) bool {
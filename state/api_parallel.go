@@ -0,0 +1,108 @@
+package state
+
+import (
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Parallel states run on their own goroutines, so they share no values:
+// values go from one state to another as copies made by Export.
+
+// envValue stands for the globals of the exporting state in exported
+// values, so functions see the globals of the importing state.
+type envValue struct{}
+
+// NewState returns a new state with the standard libs opened, to run code
+// in parallel with self. It shares the options, the context, the audit
+// hook and the chunk key of self, but no values.
+func (self *lkState) NewState() LkState {
+	ls := NewWithOptions(Options{
+		StackSize:    self.g.stackSize,
+		MaxStackSize: self.g.maxStack,
+		MaxCallDepth: self.g.maxCalls,
+	}).(*lkState)
+	ls.g.ctx, ls.g.done = self.g.ctx, self.g.done
+	ls.g.updateChecked()
+	ls.g.audit = self.g.audit
+	ls.g.chunkKey = self.g.chunkKey
+	ls.OpenLibs()
+	return ls
+}
+
+// [-0, +0, e]
+// Export returns a deep copy of the value at idx, which Import pushes
+// on another state. Tables and functions, with their upvalues, are copied;
+// userdata are shared, so their Go values must be safe for concurrent use.
+// Threads can't be exported.
+func (self *lkState) Export(idx int) any {
+	c := &copier{
+		ls:   self,
+		from: self.registry.get(LK_RIDX_GLOBALS),
+		to:   envValue{},
+		seen: map[any]any{},
+	}
+	return c.copy(self.stack.get(idx))
+}
+
+// [-0, +1, –]
+// Import pushes a copy of v, returned by Export,
+// so v can be imported many times, by many states.
+func (self *lkState) Import(v any) {
+	c := &copier{
+		ls:   self,
+		from: envValue{},
+		to:   self.registry.get(LK_RIDX_GLOBALS),
+		seen: map[any]any{},
+	}
+	self.stack.push(c.copy(v))
+}
+
+// copier deep copies values, replacing the globals from by to.
+type copier struct {
+	ls       *lkState
+	from, to any
+	seen     map[any]any // copies of the tables, closures and upvalues
+}
+
+func (c *copier) copy(val any) any {
+	if val == c.from {
+		return c.to
+	}
+	if cp, ok := c.seen[val]; ok {
+		return cp
+	}
+	switch x := val.(type) {
+	case *lkTable:
+		t := newLkTable(len(x.arr), len(x._map))
+		c.seen[x] = t
+		for i, v := range x.arr {
+			t.put(int64(i), c.copy(v))
+		}
+		for k, v := range x._map {
+			t.put(c.copy(k), c.copy(v))
+		}
+		return t
+	case *lkClosure:
+		if x.proto == nil && len(x.upVals) == 0 {
+			return x /* nothing to copy */
+		}
+		/* lk closures are always copied, for their inline caches */
+		cl := &lkClosure{proto: x.proto, code: x.code, goFunc: x.goFunc, upVals: make([]*any, len(x.upVals))}
+		c.seen[x] = cl
+		for i, uv := range x.upVals {
+			if uv == nil {
+				continue
+			}
+			if cuv, ok := c.seen[uv]; ok {
+				cl.upVals[i] = cuv.(*any)
+			} else {
+				v := c.copy(*uv)
+				cl.upVals[i] = &v
+				c.seen[uv] = cl.upVals[i]
+			}
+		}
+		return cl
+	case *lkState:
+		c.ls.runError("cannot copy a thread to another state")
+	}
+	return val
+}
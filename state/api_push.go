@@ -21,7 +21,7 @@ func (self *lkState) PushBoolean(b bool) {
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushinteger
 func (self *lkState) PushInteger(n int64) {
-	self.stack.push(n)
+	self.stack.push(boxInt(n))
 }
 
 // [-0, +1, –]
@@ -33,6 +33,7 @@ func (self *lkState) PushNumber(n float64) {
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushstring
 func (self *lkState) PushString(s string) {
+	self.allocString(len(s))
 	self.stack.push(s)
 }
 
@@ -40,18 +41,21 @@ func (self *lkState) PushString(s string) {
 // http://www.lua.org/manual/5.3/manual.html#lua_pushfstring
 func (self *lkState) PushFString(fmtStr string, a ...interface{}) {
 	str := fmt.Sprintf(fmtStr, a...)
+	self.allocString(len(str))
 	self.stack.push(str)
 }
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushcfunction
 func (self *lkState) PushGoFunction(f GoFunction) {
+	self.allocClosure(0)
 	self.stack.push(newGoClosure(f, 0))
 }
 
 // [-n, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushcclosure
 func (self *lkState) PushGoClosure(f GoFunction, n int) {
+	self.allocClosure(n)
 	closure := newGoClosure(f, n)
 	for i := n; i > 0; i-- {
 		val := self.stack.pop()
@@ -74,6 +78,13 @@ func (self *lkState) PushThread() bool {
 	return self.isMainThread()
 }
 
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+// NewUserData pushes a full userdata holding v, without metatable.
+func (self *lkState) NewUserData(v any) {
+	self.stack.push(&userdata{value: v})
+}
+
 func (self *lkState) Push(item any) {
 	self.stack.push(item)
 }
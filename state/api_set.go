@@ -1,9 +1,10 @@
 package state
 
 import (
-	"fmt"
+	"math"
 
 	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/stdlib"
 )
 
 // [-2, +0, e]
@@ -56,6 +57,13 @@ func (self *lkState) SetGlobal(name string) {
 	self.setTable(t, name, v, false)
 }
 
+// SetGlobalValue sets the global name to v,
+// converted like the values returned by the libs (see stdlib.PushGoValue).
+func (self *lkState) SetGlobalValue(name string, v any) {
+	stdlib.PushGoValue(self, v)
+	self.SetGlobal(name)
+}
+
 // [-0, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_register
 func (self *lkState) Register(name string, f GoFunction) {
@@ -82,6 +90,14 @@ func (self *lkState) SetMetatable(idx int) {
 func (self *lkState) setTable(t, k, v any, raw bool) {
 	if tbl, ok := t.(*lkTable); ok {
 		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+			if k == nil {
+				self.runError("table index is nil")
+			} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+				self.runError("table index is NaN")
+			}
+			if self.g.memLimit > 0 && v != nil && tbl.get(k) == nil {
+				self.alloc(entrySize)
+			}
 			tbl.put(k, v)
 			return
 		}
@@ -104,5 +120,5 @@ func (self *lkState) setTable(t, k, v any, raw bool) {
 		}
 	}
 
-	panic("expect table, got " + fmt.Sprintf("%v", t))
+	self.runError("attempt to index a %s value", self.typeNameOf(t))
 }
@@ -17,8 +17,7 @@ func (self *lkState) AbsIndex(idx int) int {
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_checkstack
 func (self *lkState) CheckStack(n int) bool {
-	self.stack.check(n)
-	return true // never fails
+	return self.stack.check(n)
 }
 
 // [-n, +0, –]
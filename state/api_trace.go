@@ -0,0 +1,70 @@
+package state
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lollipopkit/lk/vm"
+)
+
+// SetTrace prints every instruction executed by this state and its coroutines
+// to w, with its source line and the registers it reads. nil turns tracing off.
+func (self *lkState) SetTrace(w io.Writer) {
+	self.g.trace = w
+	self.g.updateChecked()
+}
+
+// eg: test.lk:3	[4]	ADD      2 0 1	; R0=1 R1=2
+func (self *lkState) traceInst(inst vm.Instruction) {
+	stack := self.stack
+	proto := stack.closure.proto
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s:%d\t[%d]\t%s", proto.Source, stack.currentLine(), stack.pc-1, inst)
+
+	regs := make([]int, 0, 3)
+	switch inst.OpMode() {
+	case vm.IABC:
+		a, b, c := inst.ABC()
+		regs = append(regs, a)
+		if mode := inst.BMode(); mode == vm.OpArgR || mode == vm.OpArgK && b <= 0xFF {
+			regs = append(regs, b)
+		}
+		if mode := inst.CMode(); mode == vm.OpArgR || mode == vm.OpArgK && c <= 0xFF {
+			regs = append(regs, c)
+		}
+	case vm.IABx, vm.IAsBx:
+		a, _ := inst.ABx()
+		regs = append(regs, a)
+	}
+
+	sep := "\t; "
+	for _, r := range regs {
+		if r >= len(stack.slots) {
+			continue
+		}
+		fmt.Fprintf(&sb, "%sR%d=%s", sep, r, traceValue(stack.slots[r]))
+		sep = " "
+	}
+	sb.WriteByte('\n')
+	io.WriteString(self.g.trace, sb.String())
+}
+
+func traceValue(val any) string {
+	switch x := val.(type) {
+	case nil:
+		return "nil"
+	case string:
+		if len(x) > 32 {
+			x = x[:32] + "..."
+		}
+		return fmt.Sprintf("%q", x)
+	case *lkTable:
+		return fmt.Sprintf("table: %p", x)
+	case *lkClosure:
+		return "fn: " + x.String()
+	case *lkState:
+		return fmt.Sprintf("thread: %p", x)
+	}
+	return fmt.Sprint(val)
+}
@@ -0,0 +1,28 @@
+package state
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	ls := New()
+	var sb strings.Builder
+	ls.SetTrace(&sb)
+	if ls.DoString("shy a = 1\nshy b = a + 2\nrt 'some long string, longer than 32 bytes'", "trace.lk") {
+		t.Fatal(ls.ToString2(-1))
+	}
+	ls.SetTrace(nil)
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	for _, want := range []string{
+		"trace.lk:1\t[0]\tLOADK    0 -1\t; R0=nil",
+		"trace.lk:2\t[1]\tADD      1 0 -2\t; R1=nil R0=1",
+		"trace.lk:3\t[2]\tLOADK    2 -3\t; R2=nil",
+		"trace.lk:3\t[3]\tRETURN   2 2\t; R2=\"some long string, longer than 32...\"",
+	} {
+		if len(lines) == 0 || lines[0] != want {
+			t.Fatalf("got %q, want %q", lines, want)
+		}
+		lines = lines[1:]
+	}
+}
@@ -174,6 +174,16 @@ func (self *lkState) ToGoFunction(idx int) GoFunction {
 	return nil
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_touserdata
+// ToUserData returns the Go value of the userdata at idx, or nil.
+func (self *lkState) ToUserData(idx int) any {
+	if u, ok := self.stack.get(idx).(*userdata); ok {
+		return u.value
+	}
+	return nil
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_tothread
 func (self *lkState) ToThread(idx int) LkState {
@@ -192,3 +202,7 @@ func (self *lkState) ToPointer(idx int) interface{} {
 	// todo
 	return self.stack.get(idx)
 }
+
+func (self *lkState) typeNameOf(val any) string {
+	return self.TypeName(typeOf(val))
+}
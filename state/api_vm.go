@@ -27,6 +27,33 @@ func (self *lkState) GetRK(rk int) {
 	}
 }
 
+// GetTableRK pushes t[RK(rk)], where t is the value at the absolute
+// index idx. Reads of constant string keys are cached by instruction.
+func (self *lkState) GetTableRK(idx, rk int) {
+	if rk > 0xFF {
+		c := self.stack.closure
+		k := c.proto.Constants[rk&0xFF]
+		t, ok := self.stack.get(idx).(*lkTable)
+		if _, isStr := k.(string); ok && isStr {
+			if c.caches == nil {
+				c.caches = make([]inlineCache, len(c.proto.Code))
+			}
+			ic := &c.caches[self.stack.pc-1]
+			if ic.t == t && ic.version == t.version {
+				self.stack.push(ic.v)
+				return
+			}
+			if v := t.get(k); v != nil {
+				*ic = inlineCache{t: t, version: t.version, v: v}
+				self.stack.push(v)
+				return
+			}
+		}
+	}
+	self.GetRK(rk)
+	self.GetTable(idx)
+}
+
 func (self *lkState) RegisterCount() int {
 	return int(self.stack.closure.proto.MaxStackSize)
 }
@@ -36,14 +63,15 @@ func (self *lkState) LoadVararg(n int) {
 		n = len(self.stack.varargs)
 	}
 
-	self.stack.check(n)
+	self.stack.grow(n)
 	self.stack.pushN(self.stack.varargs, n)
 }
 
 func (self *lkState) LoadProto(idx int) {
 	stack := self.stack
 	subProto := stack.closure.proto.Protos[idx]
-	closure := newLuaClosure(subProto)
+	self.allocClosure(len(subProto.Upvalues))
+	closure := newLuaClosure(subProto, stack.closure.code.Protos[idx])
 	stack.push(closure)
 
 	for i := range subProto.Upvalues {
@@ -0,0 +1,21 @@
+//go:build lkcheck
+
+package state
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// Built with -tags lkcheck, each Go function is checked to return
+// a count of results it really left on its stack:
+// a wrong count would corrupt the stack of the caller.
+const apiCheck = true
+
+func (self *lkState) checkResults(c *lkClosure, r int) {
+	if n := self.stack.top; r < 0 || r > n {
+		name := runtime.FuncForPC(reflect.ValueOf(c.goFunc).Pointer()).Name()
+		panic(fmt.Sprintf("lkcheck: %s returned %d results, but has %d values on its stack", name, r, n))
+	}
+}
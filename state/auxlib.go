@@ -12,6 +12,7 @@ import (
 // http://www.lua.org/manual/5.3/manual.html#luaL_error
 func (self *lkState) Error2(fmt string, a ...interface{}) int {
 	self.PushFString(fmt, a...) // todo
+	self.stack.push(self.newError(self.stack.pop().(string)))
 	return self.Error()
 }
 
@@ -85,6 +86,9 @@ func (self *lkState) CheckNumber(arg int) float64 {
 func (self *lkState) CheckString(arg int) string {
 	s, ok := self.ToStringX(arg)
 	if !ok {
+		if t, isTable := self.stack.get(arg).(*lkTable); isTable && isError(t) {
+			return errorString(t)
+		}
 		self.tagError(arg, LK_TSTRING)
 	}
 	return s
@@ -166,6 +170,12 @@ func (self *lkState) LoadString(s, source string) LkStatus {
 	return self.Load([]byte(s), source, "bt")
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_buffinit
+func (self *lkState) BuffInit() *Buffer {
+	return NewBuffer(self)
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#luaL_typename
 func (self *lkState) TypeName2(idx int) string {
@@ -285,23 +295,51 @@ func (self *lkState) CallMeta(obj int, event string) bool {
 // [-0, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_openlibs
 func (self *lkState) OpenLibs() {
-	libs := map[string]GoFunction{
-		"_G":    stdlib.OpenBaseLib,
-		"math":  stdlib.OpenMathLib,
-		"str":   stdlib.OpenStringLib,
-		"utf8":  stdlib.OpenUTF8Lib,
-		"os":    stdlib.OpenOSLib,
-		"pkg":   stdlib.OpenPackageLib,
-		"sync":  stdlib.OpenCoroutineLib,
-		"http":  stdlib.OpenHttpLib,
-		"table": stdlib.OpenTableLib,
-		"num":   stdlib.OpenNumLib,
-		"term":  stdlib.OpenTermLib,
+	// in a fixed order, so the globals are the same on every run
+	libs := []struct {
+		name string
+		open GoFunction
+	}{
+		{"_G", stdlib.OpenBaseLib},
+		{"math", stdlib.OpenMathLib},
+		{"str", stdlib.OpenStringLib},
+		{"utf8", stdlib.OpenUTF8Lib},
+		{"os", stdlib.OpenOSLib},
+		{"pkg", stdlib.OpenPackageLib},
+		{"sync", stdlib.OpenCoroutineLib},
+		{"http", stdlib.OpenHttpLib},
+		{"table", stdlib.OpenTableLib},
+		{"num", stdlib.OpenNumLib},
+		{"term", stdlib.OpenTermLib},
+		{"debug", stdlib.OpenDebugLib},
+		{"events", stdlib.OpenEventsLib},
+		{"async", stdlib.OpenAsyncLib},
+		{"time", stdlib.OpenTimeLib},
+		{"cron", stdlib.OpenCronLib},
+		{"crypto", stdlib.OpenCryptoLib},
+		{"enc", stdlib.OpenEncLib},
+		{"io", stdlib.OpenIOLib},
+		{"env", stdlib.OpenEnvLib},
+		{"zip", stdlib.OpenZipLib},
+		{"archive", stdlib.OpenArchiveLib},
+		{"csv", stdlib.OpenCsvLib},
+		{"yaml", stdlib.OpenYamlLib},
+		{"toml", stdlib.OpenTomlLib},
+		{"xml", stdlib.OpenXmlLib},
+		{"msgpack", stdlib.OpenMsgpackLib},
+		{"cbor", stdlib.OpenCborLib},
+		{"db", stdlib.OpenDbLib},
+		{"kv", stdlib.OpenKvLib},
+		{"net", stdlib.OpenNetLib},
+		{"tls", stdlib.OpenTlsLib},
+		{"ws", stdlib.OpenWsLib},
+		{"mail", stdlib.OpenMailLib},
 	}
 
-	for name := range libs {
-		// Only add "_G" as global
-		self.RequireF(name, libs[name], true)
+	for _, lib := range libs {
+		// str and num replace the base functions of the same name,
+		// which they still do when called
+		self.RequireF(lib.name, lib.open, true)
 		self.Pop(1)
 	}
 }
@@ -326,6 +364,15 @@ func (self *lkState) RequireF(modname string, openf GoFunction, glb bool) {
 	}
 }
 
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_traceback
+// Traceback describes the call stack from level, 0 being the running
+// function: how each function was called, its current line and its code.
+// The description is preceded by msg if it is not empty.
+func (self *lkState) Traceback(msg string, level int) string {
+	return self.traceback(msg, level, true)
+}
+
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_newlib
 func (self *lkState) NewLib(l FuncReg) {
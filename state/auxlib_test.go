@@ -0,0 +1,21 @@
+package state
+
+import "testing"
+
+// str and num are both the libs and the conversion functions,
+// whatever the order the libs are opened in.
+func TestOpenLibsGlobals(t *testing.T) {
+	const src = `
+assert(str(1) == '1' and num('2') == 2 and num('ff', 16) == 255)
+assert(('ab'):upper() == 'AB' and str.upper('ab') == 'AB')
+shy f = load(str.dump(fn() { rt 3 }))
+assert(f() == 3)
+`
+	for i := 0; i < 20; i++ {
+		ls := New()
+		ls.OpenLibs()
+		if ls.DoString(src, "globals.lk") {
+			t.Fatal(ls.ToString2(-1))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/compiler"
+	"github.com/lollipopkit/lk/consts"
+	"github.com/lollipopkit/lk/utils"
+	"github.com/lollipopkit/lk/vm"
+)
+
+// The chunk cache stores on disk the chunks compiled from source files,
+// so the next runs load them instead of compiling the files again.
+// It is off by default, see SetChunkCache.
+// Entries are keyed by the path, mtime and content of the file, and by
+// the versions of lk and of the chunk format: a stale entry is never used,
+// it is only left behind.
+
+var chunkCacheDir atomic.Value // string
+
+// DefaultChunkCacheDir returns ~/.cache/lk, or its equivalent on the OS.
+func DefaultChunkCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "lk"), nil
+}
+
+// SetChunkCache makes all states cache the chunks they compile in dir.
+// An empty dir turns the cache off.
+func SetChunkCache(dir string) {
+	chunkCacheDir.Store(dir)
+}
+
+func chunkCachePath(chunk []byte, chunkName string) string {
+	dir, _ := chunkCacheDir.Load().(string)
+	if dir == "" {
+		return ""
+	}
+	info, err := os.Stat(chunkName)
+	if err != nil {
+		return "" /* not a file: stdin, load()... */
+	}
+	path, err := filepath.Abs(chunkName)
+	if err != nil {
+		return ""
+	}
+
+	h := sha256.New()
+	h.Write([]byte(consts.VERSION))
+	h.Write([]byte(strconv.Itoa(binchunk.Version)))
+	h.Write([]byte(path))
+	h.Write([]byte(strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+	h.Write([]byte{0})
+	h.Write(chunk)
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".lkc")
+}
+
+// compileCached compiles chunk, or loads it from the chunk cache.
+func compileCached(chunk []byte, chunkName string) *binchunk.Prototype {
+	path := chunkCachePath(chunk, chunkName)
+	if path == "" {
+		return compiler.Compile(string(chunk), chunkName)
+	}
+	md5 := utils.Md5(chunk)
+	if data, err := os.ReadFile(path); err == nil {
+		bin, err := binchunk.Undump(data, binchunk.UndumpOptions{})
+		if err == nil && bin.Md5 == md5 && vm.Verify(bin.Proto) == nil {
+			return bin.Proto
+		}
+	}
+
+	proto := compiler.Compile(string(chunk), chunkName)
+	writeCachedChunk(path, proto, md5)
+	return proto
+}
+
+// writeCachedChunk writes the chunk of proto to path, ignoring errors:
+// the cache is only an optimization.
+// The chunk is renamed into place, so other runs never read half of it.
+func writeCachedChunk(path string, proto *binchunk.Prototype, md5 string) {
+	data, err := proto.Dump(md5, binchunk.DumpOptions{})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "tmp-*.lkc")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
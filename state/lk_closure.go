@@ -5,16 +5,28 @@ import (
 
 	. "github.com/lollipopkit/lk/api"
 	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/vm"
 )
 
 type lkClosure struct {
 	proto  *binchunk.Prototype // lua closure
+	code   *vm.Code            // decoded code of proto
 	goFunc GoFunction          // go closure
 	upVals []*any
+	caches []inlineCache // by pc, allocated on first use
 }
 
-func newLuaClosure(proto *binchunk.Prototype) *lkClosure {
-	c := &lkClosure{proto: proto}
+// inlineCache remembers the value an instruction read from a table
+// with a constant string key. It is valid while the table is unchanged,
+// so globals and fields are read without hashing their names.
+type inlineCache struct {
+	t       *lkTable
+	version uint64
+	v       any
+}
+
+func newLuaClosure(proto *binchunk.Prototype, code *vm.Code) *lkClosure {
+	c := &lkClosure{proto: proto, code: code}
 	if nUpvals := len(proto.Upvalues); nUpvals > 0 {
 		c.upVals = make([]*any, nUpvals)
 	}
@@ -35,3 +47,11 @@ func (c *lkClosure) String() string {
 	}
 	return fmt.Sprintf("%p", c.proto)
 }
+
+// go closures have no names for their upvalues
+func (c *lkClosure) upvalueName(n int) string {
+	if c.proto != nil && n <= len(c.proto.UpvalueNames) {
+		return c.proto.UpvalueNames[n-1]
+	}
+	return ""
+}
@@ -0,0 +1,196 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/vm"
+)
+
+// Runtime errors, raised by the VM and by Error2, are error objects:
+// tables with the fields msg, file, line and traceback.
+// Their __str gives "file:line: msg", like the error strings of Lua,
+// so str(err) and printing them work as before. They also have the
+// methods of strings, and the libs take them where strings are expected.
+
+// frames shown at the top and at the bottom of long tracebacks
+const tracebackLevels1, tracebackLevels2 = 10, 11
+
+// __str of all error objects, also used to tell them from other tables
+var errorStr *lkClosure
+
+func init() {
+	errorStr = newGoClosure(errorToString, 0)
+}
+
+// newError returns an error object for msg,
+// located at the nearest lk function of the call stack.
+// Its traceback is only built when read, from the frames kept here.
+func (self *lkState) newError(msg string) *lkTable {
+	t := newLkTable(0, 5)
+	t.put("msg", msg)
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		if stack.closure != nil && stack.closure.proto != nil {
+			if line := stack.currentLine(); line >= 0 {
+				t.put("file", stack.closure.proto.Source)
+				t.put("line", int64(line))
+			}
+			break
+		}
+	}
+	frames, skip := self.frames(0)
+	t.put("__str", errorStr)
+	t.put("__index", newGoClosure(func(ls LkState) int {
+		return errorIndex(ls, frames, skip)
+	}, 0))
+	return t
+}
+
+// frameInfo is what a traceback shows of a frame.
+type frameInfo struct {
+	closure  *lkClosure
+	line     int
+	caller   *lkClosure // lk function calling it, nil if none
+	callerPc int
+	isTail   bool
+}
+
+// frames returns the frames of the call stack from the function at level.
+// Like luaL_traceback, long stacks only keep their ends:
+// skip frames are left out after the first tracebackLevels1.
+func (self *lkState) frames(level int) (frames []frameInfo, skip int) {
+	first := self.stack
+	for ; level > 0 && first.prev != nil; level-- {
+		first = first.prev
+	}
+	n := 0
+	for stack := first; stack.prev != nil; stack = stack.prev {
+		n++
+	}
+	if skip = n - tracebackLevels1 - tracebackLevels2; skip < 0 {
+		skip = 0
+	}
+	frames = make([]frameInfo, 0, n-skip)
+	level = 0
+	for stack := first; stack.prev != nil; stack = stack.prev {
+		if level < tracebackLevels1 || level >= tracebackLevels1+skip {
+			f := frameInfo{closure: stack.closure, line: -1, isTail: stack.isTail}
+			if stack.closure != nil && stack.closure.proto != nil {
+				f.line = stack.currentLine()
+			}
+			if caller := stack.prev; caller.closure != nil && caller.closure.proto != nil {
+				f.caller, f.callerPc = caller.closure, caller.pc
+			}
+			frames = append(frames, f)
+		}
+		level++
+	}
+	return
+}
+
+// traceback describes the call stack, from the function at level.
+// With code, the lines of lk functions are followed by their source.
+func (self *lkState) traceback(msg string, level int, code bool) string {
+	frames, skip := self.frames(level)
+	return formatTraceback(msg, frames, skip, code)
+}
+
+func formatTraceback(msg string, frames []frameInfo, skip int, code bool) string {
+	var sb strings.Builder
+	if msg != "" {
+		sb.WriteString(msg)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("stack traceback:")
+	for i, f := range frames {
+		if skip > 0 && i == tracebackLevels1 {
+			fmt.Fprintf(&sb, "\n\t...\t(skipping %d levels)", skip)
+		}
+		if f.closure != nil && f.closure.proto != nil {
+			source := f.closure.proto.Source
+			fmt.Fprintf(&sb, "\n\t%s:%d: in %s", source, f.line, f.desc())
+			if code {
+				if s := _sourceLine(source, f.line); s != "" {
+					sb.WriteString("\n\t\t" + s)
+				}
+			}
+		} else {
+			sb.WriteString("\n\t[Go]: in " + f.desc())
+		}
+	}
+	return sb.String()
+}
+
+// desc describes the function of the frame,
+// by the name its caller called it with if known.
+// lua-5.3.4/src/lauxlib.c#pushfuncname()
+func (f frameInfo) desc() string {
+	kind, name := "", ""
+	if !f.isTail && f.caller != nil {
+		kind, name = vm.FuncName(f.caller.proto, f.callerPc-1)
+	}
+	var proto *binchunk.Prototype
+	if f.closure != nil {
+		proto = f.closure.proto
+	}
+	switch {
+	case kind == "for iterator":
+		return kind
+	case kind == "global":
+		return fmt.Sprintf("function '%s'", name)
+	case kind != "":
+		return fmt.Sprintf("%s '%s'", kind, name)
+	case proto == nil:
+		return "?"
+	case proto.LineDefined == 0:
+		return "main chunk"
+	}
+	return fmt.Sprintf("function <%s:%d>", proto.Source, proto.LineDefined)
+}
+
+func errorToString(ls LkState) int {
+	ls.PushString(errorString(ls.(*lkState).stack.get(1)))
+	return 1
+}
+
+// errorIndex is the __index of the error object with the frames:
+// it builds the traceback once, and gives the methods of strings.
+func errorIndex(ls LkState, frames []frameInfo, skip int) int {
+	key, ok := ls.ToStringX(2)
+	switch {
+	case !ok:
+		ls.PushNil()
+	case key == "traceback":
+		ls.PushString(formatTraceback("", frames, skip, false))
+		ls.PushValue(-1)
+		ls.SetField(1, key)
+	default:
+		ls.PushString("")
+		ls.GetField(-1, key) /* method of strings */
+	}
+	return 1
+}
+
+// isError tells if t is an error object.
+func isError(t *lkTable) bool {
+	return t.get("__str") == errorStr
+}
+
+// errorString returns the message of the error value err.
+func errorString(err any) string {
+	switch x := err.(type) {
+	case string:
+		return x
+	case *lkTable:
+		if isError(x) {
+			msg := fmt.Sprint(x.get("msg"))
+			if file, ok := x.get("file").(string); ok {
+				return fmt.Sprintf("%s:%v: %s", file, x.get("line"), msg)
+			}
+			return msg
+		}
+	}
+	return fmt.Sprint(err)
+}
@@ -12,6 +12,8 @@ type lkStack struct {
 	varargs []any
 	openuvs map[int]*any
 	pc      int
+	oldPC   int  // pc of the last instruction traced by line hooks
+	isTail  bool // entered by a tail call, so its caller is unknown
 	/* linked list */
 	prev *lkStack
 }
@@ -24,16 +26,64 @@ func newLuaStack(size int, state *lkState) *lkStack {
 	}
 }
 
-func (self *lkStack) check(n int) {
+// Frames of returned calls are kept by their thread, up to maxFreeFrames,
+// and reused by the next calls, so calls don't allocate.
+const maxFreeFrames = 64
+
+// newFrame returns an empty frame with size slots, reused if possible.
+func (self *lkState) newFrame(size int) *lkStack {
+	n := len(self.freeFrames)
+	if n == 0 {
+		return newLuaStack(size, self)
+	}
+	frame := self.freeFrames[n-1]
+	self.freeFrames[n-1] = nil
+	self.freeFrames = self.freeFrames[:n-1]
+	if cap(frame.slots) >= size {
+		frame.slots = frame.slots[:size]
+	} else {
+		frame.slots = make([]any, size)
+	}
+	return frame
+}
+
+// freeFrame makes frame, popped once its call returned, reusable.
+// Frames with open upvalues are left alone: closures point to their slots.
+func (self *lkState) freeFrame(frame *lkStack) {
+	if len(frame.openuvs) > 0 || len(self.freeFrames) >= maxFreeFrames {
+		return
+	}
+	slots := frame.slots[:cap(frame.slots)]
+	for i := range slots {
+		slots[i] = nil
+	}
+	*frame = lkStack{slots: slots, state: self}
+	self.freeFrames = append(self.freeFrames, frame)
+}
+
+// check grows the stack to have n free slots, and reports whether it could:
+// a frame holds at most Options.MaxStackSize slots.
+func (self *lkStack) check(n int) bool {
+	if self.top+n > self.state.g.maxStack {
+		return false
+	}
 	free := len(self.slots) - self.top
 	for i := free; i < n; i++ {
 		self.slots = append(self.slots, nil)
 	}
+	return true
+}
+
+// grow is check, raising a "stack overflow" error if the stack can't grow.
+func (self *lkStack) grow(n int) {
+	if !self.check(n) {
+		self.state.runError("stack overflow")
+	}
 }
 
 func (self *lkStack) push(val any) {
 	if self.top == len(self.slots) {
-		panic("stack overflow!")
+		self.state.runError("stack overflow")
 	}
 	self.slots[self.top] = val
 	self.top++
@@ -118,7 +168,12 @@ func (self *lkStack) set(idx int, val any) {
 		uvIdx := LK_REGISTRYINDEX - idx - 1
 		c := self.closure
 		if c != nil && uvIdx < len(c.upVals) {
-			c.upVals[uvIdx] = &val
+			if uv := c.upVals[uvIdx]; uv != nil {
+				*uv = val /* write through: the upvalue may be shared */
+			} else {
+				v := val /* so val doesn't escape, on every set */
+				c.upVals[uvIdx] = &v
+			}
 		}
 		return
 	}
@@ -1,25 +1,110 @@
 package state
 
-import . "github.com/lollipopkit/lk/api"
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"sync"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Options of a state, zero values mean the defaults.
+type Options struct {
+	// initial free slots of each call frame, after its registers.
+	// Go functions needing more call CheckStack. Default LK_MINSTACK.
+	StackSize int
+	// max slots of each call frame: CheckStack fails beyond,
+	// and lk code gets a "stack overflow" error. Default LK_MAXSTACK.
+	MaxStackSize int
+	// max depth of the call stack of each thread: deeper calls raise
+	// a "too much recursion" error, before the goroutine stack is exhausted.
+	// Default DefaultMaxCallDepth.
+	MaxCallDepth int
+}
+
+const DefaultMaxCallDepth = 200000
 
 type lkState struct {
 	registry *lkTable
 	stack    *lkStack
+	nCalls   int // depth of stack
+	/* frames of returned calls, see newFrame */
+	freeFrames []*lkStack
 	/* coroutine */
 	coStatus LkStatus
 	coCaller *lkState
 	coChan   chan int
+	coClose  bool          // set by CloseThread, unwinds the suspended coroutine
+	coDone   chan struct{} // closed when the goroutine of the coroutine exits
+	/* hook */
+	hookCount int  // instructions left before the next count event
+	inHook    bool // hooks are not called from inside a hook
+	/* shared by all threads of a state */
+	g *lkGlobal
+}
+
+type lkGlobal struct {
+	ctx       context.Context
+	done      <-chan struct{} // checked by the VM, see CallContext
+	doneCount int
+	hook      Hook
+	hookMask  int
+	baseCount int
+	audit     AuditFunc
+	trace     io.Writer
+	limits    *limits
+	checked   bool // see updateChecked
+	memLimit  int64
+	memUsed   int64
+	events    *eventQueue
+	chunkKey  ed25519.PublicKey
+	stackSize int
+	maxStack  int
+	maxCalls  int
+	threadsMu sync.Mutex
+	threads   map[*lkState]struct{} // started, not finished coroutines
+	stringsMu sync.Mutex
+	strings   map[string]any // interned string constants
+}
+
+// updateChecked records whether the VM has to check anything
+// before each instruction, see fetchChecked.
+// Called whenever one of the fields checked changes.
+func (g *lkGlobal) updateChecked() {
+	g.checked = g.hookMask&(LK_MASKLINE|LK_MASKCOUNT) != 0 ||
+		g.done != nil || g.limits != nil || g.trace != nil
 }
 
 func New() LkState {
-	ls := &lkState{}
+	return NewWithOptions(Options{})
+}
+
+func NewWithOptions(opts Options) LkState {
+	if opts.StackSize <= 0 {
+		opts.StackSize = LK_MINSTACK
+	}
+	if opts.MaxStackSize <= 0 {
+		opts.MaxStackSize = LK_MAXSTACK
+	}
+	if opts.MaxCallDepth <= 0 {
+		opts.MaxCallDepth = DefaultMaxCallDepth
+	}
+	ls := &lkState{g: &lkGlobal{
+		events:    newEventQueue(),
+		threads:   map[*lkState]struct{}{},
+		strings:   map[string]any{},
+		stackSize: opts.StackSize,
+		maxStack:  opts.MaxStackSize,
+		maxCalls:  opts.MaxCallDepth,
+	}}
 
 	registry := newLkTable(8, 0)
 	registry.put(LK_RIDX_MAINTHREAD, ls)
 	registry.put(LK_RIDX_GLOBALS, newLkTable(0, 20))
 
 	ls.registry = registry
-	ls.pushLuaStack(newLuaStack(LK_MINSTACK, ls))
+	ls.pushLuaStack(newLuaStack(ls.g.stackSize, ls))
 	return ls
 }
 
@@ -28,6 +113,10 @@ func (self *lkState) isMainThread() bool {
 }
 
 func (self *lkState) pushLuaStack(stack *lkStack) {
+	if self.nCalls >= self.g.maxCalls {
+		self.runError("too much recursion")
+	}
+	self.nCalls++
 	stack.prev = self.stack
 	self.stack = stack
 }
@@ -36,4 +125,18 @@ func (self *lkState) popLuaStack() {
 	stack := self.stack
 	self.stack = stack.prev
 	stack.prev = nil
+	self.nCalls--
+}
+
+// SetContext sets the context watched by blocking calls (os.sleep, http...)
+// of this state and all its coroutines. Canceling it interrupts them.
+func (self *lkState) SetContext(ctx context.Context) {
+	self.g.ctx = ctx
+}
+
+func (self *lkState) Context() context.Context {
+	if ctx := self.g.ctx; ctx != nil {
+		return ctx
+	}
+	return context.Background()
 }
@@ -18,6 +18,7 @@ type lkTable struct {
 	keys    map[any]any // used by next()
 	lastKey any         // used by next()
 	changed bool        // used by next()
+	version uint64      // increased by each put, see inlineCache
 }
 
 func (self *lkTable) copy() *lkTable {
@@ -37,12 +38,17 @@ func (self *lkTable) String() (string, error) {
 }
 
 func (t *lkTable) Json() any {
+	if isError(t) {
+		return errorString(t)
+	}
 	tb := t.copy()
 	if len(tb._map) == 0 {
 		for i := range tb.arr {
 			switch v := tb.arr[i].(type) {
 			case *lkClosure:
 				tb.arr[i] = v.String()
+			case *userdata:
+				tb.arr[i] = v.String()
 			case *lkTable:
 				tb.arr[i] = v.Json()
 			}
@@ -53,6 +59,8 @@ func (t *lkTable) Json() any {
 		switch v := tb._map[k].(type) {
 		case *lkClosure:
 			tb._map[k] = v.String()
+		case *userdata:
+			tb._map[k] = v.String()
 		case *lkTable:
 			tb._map[k] = v.Json()
 		}
@@ -119,6 +127,7 @@ func (self *lkTable) put(key, val any) {
 	}
 
 	self.changed = true
+	self.version++
 	key = _floatToInteger(key)
 	if idx, ok := key.(int64); ok && idx >= 0 {
 		arrLen := int64(len(self.arr))
@@ -132,6 +141,9 @@ func (self *lkTable) put(key, val any) {
 		if idx == arrLen {
 			delete(self._map, key)
 			if val != nil {
+				if len(self.arr) == cap(self.arr) {
+					self.reserve(2 * len(self.arr))
+				}
 				self.arr = append(self.arr, val)
 				self._expandArray()
 			}
@@ -148,6 +160,23 @@ func (self *lkTable) put(key, val any) {
 	}
 }
 
+// minimum capacity of a growing list part
+const minArrCap = 4
+
+// reserve makes room for n items in the list part.
+// Appends double the capacity, where append grows large slices by 1.25x.
+func (self *lkTable) reserve(n int) {
+	if n <= cap(self.arr) {
+		return
+	}
+	if n < minArrCap {
+		n = minArrCap
+	}
+	arr := make([]any, len(self.arr), n)
+	copy(arr, self.arr)
+	self.arr = arr
+}
+
 func (self *lkTable) _shrinkArray() {
 	for i := len(self.arr) - 1; i >= 0; i-- {
 		if self.arr[i] == nil {
@@ -0,0 +1,15 @@
+package state
+
+import "fmt"
+
+// userdata is a Go value given to scripts by the libs, such as a channel.
+// Unlike tables, it keeps its metatable apart, so the values of a kind
+// share the metatable holding their methods.
+type userdata struct {
+	value any
+	meta  *lkTable
+}
+
+func (u *userdata) String() string {
+	return fmt.Sprintf("%p", u)
+}
@@ -7,6 +7,27 @@ import (
 	"github.com/lollipopkit/lk/utils"
 )
 
+// Boxed small integers, so pushing them doesn't allocate.
+// Go only avoids the allocation for values below 256.
+// Bools never allocate.
+const minBoxedInt, maxBoxedInt = -256, 4095
+
+var boxedInts = func() []any {
+	ints := make([]any, maxBoxedInt-minBoxedInt+1)
+	for i := range ints {
+		ints[i] = int64(i + minBoxedInt)
+	}
+	return ints
+}()
+
+// boxInt returns n as a value, shared if n is small.
+func boxInt(n int64) any {
+	if n >= minBoxedInt && n <= maxBoxedInt {
+		return boxedInts[n-minBoxedInt]
+	}
+	return n
+}
+
 func typeOf(val any) LkType {
 	switch val.(type) {
 	case nil:
@@ -23,6 +44,8 @@ func typeOf(val any) LkType {
 		return LK_TFUNCTION
 	case *lkState:
 		return LK_TTHREAD
+	case *userdata:
+		return LK_TUSERDATA
 	default:
 		panic(fmt.Sprintf("invalid type: %T<%v>", val, val))
 	}
@@ -87,22 +110,38 @@ func _stringToInteger(s string) (int64, bool) {
 
 /* metatable */
 
+// registry keys of the metatables shared by all values of a type
+var mtKeys = func() []string {
+	keys := make([]string, LK_TTHREAD+1)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("_MT%d", i)
+	}
+	return keys
+}()
+
 func getMetatable(val any, ls *lkState) (mt, global *lkTable) {
-	key := fmt.Sprintf("_MT%d", typeOf(val))
-	if gmt := ls.registry.get(key); gmt != nil {
+	if gmt := ls.registry.get(mtKeys[typeOf(val)]); gmt != nil {
 		global = gmt.(*lkTable)
 	}
-	mt, _ = val.(*lkTable)
+	switch x := val.(type) {
+	case *lkTable:
+		mt = x
+	case *userdata:
+		mt = x.meta
+	}
 	return
 }
 
 func setMetatable(val any, mt *lkTable, ls *lkState) {
+	if u, ok := val.(*userdata); ok {
+		u.meta = mt
+		return
+	}
 	if t, ok := val.(*lkTable); ok {
 		t.combine(mt)
 		//return
 	}
-	key := fmt.Sprintf("_MT%d", typeOf(val))
-	ls.registry.put(key, mt)
+	ls.registry.put(mtKeys[typeOf(val)], mt)
 }
 
 func getMetafield(val any, fieldName string, ls *lkState) any {
@@ -127,7 +166,7 @@ func callMetamethod(a, b any, mmName string, ls *lkState) (any, bool) {
 		}
 	}
 
-	ls.stack.check(4)
+	ls.stack.grow(4)
 	ls.stack.push(mm)
 	ls.stack.push(a)
 	ls.stack.push(b)
@@ -0,0 +1,69 @@
+package state
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/lollipopkit/lk/binchunk"
+	"github.com/lollipopkit/lk/vm"
+)
+
+// Text chunks compiled by any state are cached by the hash of their name
+// and source, so loading the same file in many states (one per request,
+// one per parallel task...) compiles and decodes it once.
+// Cached protos are shared, so they are never modified once cached.
+
+// max count of cached protos, the oldest ones are dropped first
+const maxCachedProtos = 256
+
+type cachedProto struct {
+	proto *binchunk.Prototype
+	code  *vm.Code
+}
+
+var protoCache = struct {
+	sync.Mutex
+	m     map[[sha256.Size]byte]cachedProto
+	order [][sha256.Size]byte // keys, oldest first
+}{m: map[[sha256.Size]byte]cachedProto{}}
+
+func protoKey(chunk []byte, chunkName string) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(chunkName))
+	h.Write([]byte{0})
+	h.Write(chunk)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+func getCachedProto(key [sha256.Size]byte) (cachedProto, bool) {
+	protoCache.Lock()
+	defer protoCache.Unlock()
+	cp, ok := protoCache.m[key]
+	return cp, ok
+}
+
+func putCachedProto(key [sha256.Size]byte, cp cachedProto) {
+	protoCache.Lock()
+	defer protoCache.Unlock()
+	if _, ok := protoCache.m[key]; ok {
+		return
+	}
+	if len(protoCache.order) >= maxCachedProtos {
+		delete(protoCache.m, protoCache.order[0])
+		protoCache.order = protoCache.order[1:]
+	}
+	protoCache.m[key] = cp
+	protoCache.order = append(protoCache.order, key)
+}
+
+// ClearProtoCache drops the protos compiled so far,
+// so the next loads compile their chunks again.
+// A changed source is never served from the cache anyway.
+func ClearProtoCache() {
+	protoCache.Lock()
+	defer protoCache.Unlock()
+	protoCache.m = map[[sha256.Size]byte]cachedProto{}
+	protoCache.order = nil
+}
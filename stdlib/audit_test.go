@@ -0,0 +1,68 @@
+package stdlib_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+func TestAuditEvents(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	ls := newState()
+	var events []AuditEvent
+	ls.SetAuditHook(func(ls LkState, ev AuditEvent) {
+		events = append(events, ev)
+	})
+	ls.PushString(dir)
+	ls.SetGlobal("dir")
+	run(t, ls, `shy a, b = dir + '/a', dir + '/b'
+		os.write(a, 'x')
+		os.read(a)
+		os.stat(a)
+		os.ls(dir)
+		io.open(a):close()
+		io.open(a, 'a'):close()
+		os.mv(a, b)
+		os.rm(b)
+		os.get_env('HOME')
+	`)
+
+	want := []AuditEvent{
+		{Op: "os.write", Args: []any{a, "x", int64(0744)}, Source: "test.lk", Line: 2},
+		{Op: "os.read", Args: []any{a}, Source: "test.lk", Line: 3},
+		{Op: "os.stat", Args: []any{a}, Source: "test.lk", Line: 4},
+		{Op: "os.ls", Args: []any{dir}, Source: "test.lk", Line: 5},
+		{Op: "io.open", Args: []any{a, "r"}, Source: "test.lk", Line: 6},
+		{Op: "io.open", Args: []any{a, "a"}, Source: "test.lk", Line: 7},
+		{Op: "os.mv", Args: []any{a, b}, Source: "test.lk", Line: 8},
+		{Op: "os.rm", Args: []any{b, false}, Source: "test.lk", Line: 9},
+		{Op: "os.get_env", Args: []any{"HOME"}, Source: "test.lk", Line: 10},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got\n%v\nwant\n%v", events, want)
+	}
+}
+
+// An error raised by the hook denies the operation.
+func TestAuditDeny(t *testing.T) {
+	dir := t.TempDir()
+	ls := newState()
+	ls.SetAuditHook(func(ls LkState, ev AuditEvent) {
+		if ev.Op == "os.write" {
+			ls.Error2("%s denied", ev.Op)
+		}
+	})
+	ls.PushString(dir)
+	ls.SetGlobal("dir")
+	if err := runErr(ls, `os.write(dir + '/a', 'x')`); !strings.Contains(err, "os.write denied") {
+		t.Fatalf("got %q", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Fatalf("written: %v", err)
+	}
+}
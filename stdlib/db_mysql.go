@@ -0,0 +1,23 @@
+package stdlib
+
+import (
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	dbDrivers["mysql"] = &dbDriver{
+		name: "mysql",
+		dsn: func(dsn string) string {
+			return strings.TrimPrefix(dsn, "mysql://")
+		},
+		target: func(dsn string) string {
+			cfg, err := mysql.ParseDSN(strings.TrimPrefix(dsn, "mysql://"))
+			if err != nil {
+				return "mysql"
+			}
+			return cfg.Addr
+		},
+	}
+}
@@ -0,0 +1,10 @@
+package stdlib
+
+import (
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	dbDrivers["postgres"] = &dbDriver{name: "postgres"}
+	dbDrivers["postgresql"] = dbDrivers["postgres"]
+}
@@ -0,0 +1,44 @@
+//go:build cgo
+
+package stdlib
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlite needs cgo: db.open has no sqlite in the builds without it.
+
+// number of the in-memory databases opened
+var sqliteMemories atomic.Int64
+
+func init() {
+	dbDrivers["sqlite"] = &dbDriver{
+		name: "sqlite3",
+		dsn: func(dsn string) string {
+			path := _sqlitePath(dsn)
+			if path == ":memory:" {
+				/* shared by the connections of the pool, not by the opens:
+				   it lives while the pool keeps idle connections */
+				return fmt.Sprintf("file:lk_memory_%d?mode=memory&cache=shared", sqliteMemories.Add(1))
+			}
+			return path
+		},
+		target: func(dsn string) string {
+			path, _, _ := strings.Cut(strings.TrimPrefix(_sqlitePath(dsn), "file:"), "?")
+			if path == ":memory:" || strings.Contains(dsn, "mode=memory") {
+				return ""
+			}
+			return path
+		},
+	}
+}
+
+// _sqlitePath returns the path of 'sqlite:path' or 'sqlite://path'.
+func _sqlitePath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "sqlite:")
+	return strings.TrimPrefix(path, "//")
+}
@@ -0,0 +1,68 @@
+package stdlib
+
+import (
+	. "github.com/lollipopkit/lk/api"
+)
+
+// registry key of the dry-run plan.
+// While it is set, os mutations are appended to the plan instead of being performed.
+const dryRunKey = "_DRYRUN"
+
+type PlannedOp struct {
+	Op   string `json:"op"`
+	Args []any  `json:"args"`
+}
+
+// SetDryRun turns the dry-run mode of ls on or off.
+// Turning it on starts a new, empty plan.
+func SetDryRun(ls LkState, on bool) {
+	if on {
+		ls.NewTable()
+	} else {
+		ls.PushNil()
+	}
+	ls.SetField(LK_REGISTRYINDEX, dryRunKey)
+}
+
+func IsDryRun(ls LkState) bool {
+	t := ls.GetField(LK_REGISTRYINDEX, dryRunKey)
+	ls.Pop(1)
+	return t == LK_TTABLE
+}
+
+// DryRunPlan returns the operations skipped so far, in order.
+func DryRunPlan(ls LkState) []PlannedOp {
+	if ls.GetField(LK_REGISTRYINDEX, dryRunKey) != LK_TTABLE {
+		ls.Pop(1)
+		return nil
+	}
+	n := ls.Len2(-1)
+	plan := make([]PlannedOp, 0, n)
+	for i := int64(0); i < n; i++ {
+		ls.GetI(-1, i)
+		ls.GetField(-1, "op")
+		op := ls.ToString(-1)
+		ls.GetField(-2, "args")
+		args := getList(ls, ls.GetTop())
+		ls.Pop(3)
+		plan = append(plan, PlannedOp{Op: op, Args: args})
+	}
+	ls.Pop(1)
+	return plan
+}
+
+// _mutate must be called by lib functions before changing anything outside the state.
+// The operation is audited, then in dry-run mode, added to the plan and false is returned:
+// the caller must then skip the operation and return as if it succeeded.
+func _mutate(ls LkState, op string, args ...any) bool {
+	ls.Audit(op, args...)
+	if ls.GetField(LK_REGISTRYINDEX, dryRunKey) != LK_TTABLE {
+		ls.Pop(1)
+		return true
+	}
+	n := ls.Len2(-1)
+	pushTable(ls, lkMap{"op": op, "args": args})
+	ls.SetI(-2, n)
+	ls.Pop(1)
+	return false
+}
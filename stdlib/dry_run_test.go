@@ -0,0 +1,80 @@
+package stdlib_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lollipopkit/lk/stdlib"
+)
+
+// snapshot returns the content of the files under dir, by path.
+func snapshot(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			files[path] = "dir"
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, dir)
+
+	ls := newState()
+	stdlib.SetDryRun(ls, true)
+	ls.PushString(dir)
+	ls.SetGlobal("dir")
+	run(t, ls, `
+		shy a = dir + '/a.txt'
+		assert(os.write(a, 'changed') == nil)
+		assert(os.write(dir + '/new.txt', 'new') == nil)
+		assert(os.mkdir(dir + '/new') == nil)
+		assert(os.rm(dir + '/sub', true) == nil)
+		assert(os.mv(a, dir + '/b.txt') == nil)
+		assert(os.cp(a, dir + '/c.txt') == nil)
+		assert(os.link(a, dir + '/d.txt') == nil)
+		shy f, err = io.open(a, 'w')
+		assert(err == nil)
+		f:write('w')
+		f:close()
+		assert(zip.compress_file(a, a + '.gz') == nil)
+		assert(archive.zip(dir, dir + '/all.zip') == nil)
+		shy s, err = kv.open(dir + '/kv.db')
+		assert(err == nil)
+		shy b, err = s:bucket('b')
+		assert(err == nil)
+		assert(b:put('k', 'v') == nil)
+		s:close()
+	`)
+
+	if after := snapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("files changed: %v, were %v", after, before)
+	}
+	ops := []string{}
+	for _, op := range stdlib.DryRunPlan(ls) {
+		ops = append(ops, op.Op)
+	}
+	want := []string{"os.write", "os.write", "os.mkdir", "os.rm", "os.mv", "os.cp", "os.link",
+		"io.open", "zip.compress_file", "archive.zip", "kv.open", "kv.bucket", "kv.put"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("plan %v, want %v", ops, want)
+	}
+}
@@ -0,0 +1,646 @@
+package stdlib
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// A function of http.listen returns the code and the body of the response,
+// or a response table, like the ones of http.response, with the fields:
+// code (default 200);
+// headers, a table of strings;
+// cookies, a list of cookie tables, with the fields name, value, path,
+// domain, max_age (in s), expires (a time in ms), secure, http_only
+// and same_site ('lax', 'strict' or 'none');
+// body, a string, or a function called with a writer to stream it.
+//
+// Middlewares are functions (req, next) returning a response, as the
+// functions of http.listen, where next(req) returns the response table of
+// the rest of the chain.
+
+// registry keys of the metatables
+const (
+	httpServerMeta = "_HTTP_SERVER"
+	httpWriterMeta = "_HTTP_WRITER" // of the writers of streamed bodies
+)
+
+var httpServerMethods = map[string]GoFunction{
+	"addr": httpServerAddr,
+	"stop": httpServerStop,
+}
+
+var httpResponseMethods = map[string]GoFunction{
+	"set_cookie": httpRespSetCookie,
+	"set_header": httpRespSetHeader,
+}
+
+var httpWriterMethods = map[string]GoFunction{
+	"write": httpWriterWrite,
+	"flush": httpWriterFlush,
+}
+
+// the server of http.listen, handing its requests to a task
+type lkHttpServer struct {
+	srv     *http.Server
+	l       net.Listener
+	reqs    chan *httpExchange
+	stopped atomic.Bool
+	done    chan struct{} // closed once stopped
+}
+
+// the allowed methods of cors, by default
+const corsMethods = "GET, HEAD, POST, PUT, PATCH, DELETE"
+
+// _httpServe listens on addr, over tls if cfg is not nil, spawns the task
+// handing the requests to the function at 2, and pushes the server.
+// Each request is answered in a task of its own.
+func _httpServe(ls LkState, addr string, cfg *tls.Config) int {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	s := &lkHttpServer{l: l, reqs: make(chan *httpExchange), done: make(chan struct{})}
+	s.srv = &http.Server{Handler: s, TLSConfig: cfg}
+	go func() {
+		if cfg != nil {
+			s.srv.ServeTLS(l, "", "")
+		} else {
+			s.srv.Serve(l)
+		}
+	}()
+	ctx := ls.Context()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop(ctx)
+		case <-s.done:
+		}
+	}()
+
+	ls.Remove(1) /* keep f, the arg of the task */
+	ls.PushGoFunction(func(ls LkState) int {
+		for {
+			var ex *httpExchange
+			_await(ls, func() {
+				select {
+				case ex = <-s.reqs:
+				case <-s.done:
+				}
+			})
+			if ex == nil {
+				if ctx.Err() != nil {
+					return ls.Error2("interrupted: %v", ctx.Err())
+				}
+				return 0
+			}
+			ls.PushGoFunction(func(ls LkState) int {
+				_httpAnswer(ls, ex)
+				return 0
+			})
+			ls.PushValue(1)
+			_spawn(ls, 1)
+		}
+	})
+	ls.Insert(1)
+	coSpawn(ls)
+	ls.Pop(1)
+	_pushUserData(ls, s, httpServerMeta, httpServerMethods)
+	ls.PushNil()
+	return 2
+}
+
+// ServeHTTP hands the request to the task of the server, and waits for
+// it to be answered.
+func (s *lkHttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := genReqTable(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ex := &httpExchange{w: w, r: r, req: req, done: make(chan struct{})}
+	select {
+	case s.reqs <- ex:
+		<-ex.done
+	case <-s.done:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+}
+
+// stop stops accepting requests, waits for the ones being answered until
+// ctx is done, and closes the server.
+func (s *lkHttpServer) stop(ctx context.Context) error {
+	if s.stopped.Swap(true) {
+		return nil
+	}
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		s.srv.Close()
+	}
+	close(s.done)
+	return err
+}
+
+// _httpAnswer answers ex with the function at 1.
+func _httpAnswer(ls LkState, ex *httpExchange) {
+	defer close(ex.done)
+	pushTable(ls, ex.req)
+	key := ls.ToPointer(-1)
+	httpExchanges.Store(key, ex)
+	defer httpExchanges.Delete(key)
+
+	ls.PushGoFunction(func(ls LkState) int {
+		ls.Call(1, 2)
+		if ex.replied {
+			return 0
+		}
+		ex.replied = true
+		if ls.Type(-2) == LK_TTABLE {
+			_writeResponse(ls, ex.w, -2)
+			return 0
+		}
+		ex.w.WriteHeader(int(ls.ToInteger(-2)))
+		ex.w.Write([]byte(ls.ToString(-1)))
+		return 0
+	})
+	ls.Insert(1)
+	if ls.PCall(2, 0, 0) != LK_OK {
+		fmt.Fprintf(os.Stderr, "http: %s %s: %s\n", ex.r.Method, ex.r.URL, ls.ToString(-1))
+		if !ex.replied {
+			http.Error(ex.w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+	if ex.upgraded != nil { /* the connection is not http anymore */
+		ex.upgraded.Close()
+	}
+}
+
+// server:addr ()
+// Returns the address listened on, with the port chosen if it was 0.
+func httpServerAddr(ls LkState) int {
+	s := _checkUserData[*lkHttpServer](ls, 1, "server")
+	ls.PushString(s.l.Addr().String())
+	return 1
+}
+
+// server:stop ([timeout])
+// Stops accepting requests, and waits for the ones being answered, at most
+// timeout ms (default no limit), before closing their connections.
+// In a task, the requests are answered meanwhile.
+// Returns the error, or nil.
+func httpServerStop(ls LkState) int {
+	s := _checkUserData[*lkHttpServer](ls, 1, "server")
+	ctx := context.Background()
+	if !ls.IsNoneOrNil(2) {
+		var cancel context.CancelFunc
+		timeout := time.Duration(ls.CheckInteger(2)) * time.Millisecond
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var err error
+	_await(ls, func() {
+		err = s.stop(ctx)
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// http.chain (mw1 [, mw2 ...], f)
+// Returns the function of http.listen passing each request through the
+// middlewares, the first one first, and then to f.
+// It returns response tables.
+func httpChain(ls LkState) int {
+	n := ls.GetTop()
+	ls.ArgCheck(n > 0, 1, "function expected")
+	for i := 1; i <= n; i++ {
+		ls.CheckType(i, LK_TFUNCTION)
+	}
+	ls.CreateTable(n, 0)
+	for i := 1; i <= n; i++ {
+		ls.PushValue(i)
+		ls.SetI(-2, int64(i-1))
+	}
+	ls.PushInteger(0)
+	ls.PushGoClosure(_chainNext, 2)
+	return 1
+}
+
+// _chainNext calls the function i (upvalue 2) of the chain (upvalue 1)
+// with the req, and the next one if it is a middleware.
+// Returns the response table.
+func _chainNext(ls LkState) int {
+	ls.SetTop(1)
+	i := ls.ToInteger(LkUpvalueIndex(2))
+	n := ls.Len2(LkUpvalueIndex(1))
+	ls.GetI(LkUpvalueIndex(1), i)
+	ls.PushValue(1)
+	nargs := 1
+	if i < n-1 {
+		ls.PushValue(LkUpvalueIndex(1))
+		ls.PushInteger(i + 1)
+		ls.PushGoClosure(_chainNext, 2)
+		nargs = 2
+	}
+	ls.Call(nargs, 2)
+	_toResponse(ls, 2)
+	return 1
+}
+
+// _toResponse pushes the response of the results at idx: a response
+// table, or the code and the body.
+func _toResponse(ls LkState, idx int) {
+	if ls.Type(idx) == LK_TTABLE {
+		ls.PushValue(idx)
+	} else {
+		ls.CreateTable(0, 4)
+		ls.PushValue(idx)
+		ls.SetField(-2, "code")
+		ls.PushValue(idx + 1)
+		ls.SetField(-2, "body")
+	}
+	/* the methods of http.response, for the middlewares */
+	for name, f := range httpResponseMethods {
+		if ls.GetField(-1, name) == LK_TNIL {
+			ls.PushGoFunction(f)
+			ls.SetField(-3, name)
+		}
+		ls.Pop(1)
+	}
+}
+
+// http.response ([code [, body]])
+// Returns a response table of code (default 200) and body,
+// with the methods set_cookie and set_header.
+func httpResponse(ls LkState) int {
+	ls.SetTop(2)
+	if ls.IsNil(1) {
+		ls.PushInteger(http.StatusOK)
+		ls.Replace(1)
+	}
+	ls.CheckInteger(1)
+	_toResponse(ls, 1)
+	return 1
+}
+
+// resp:set_cookie (cookie)
+// Adds the cookie table to the cookies of resp. Returns resp.
+func httpRespSetCookie(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	_checkCookie(ls, 2)
+	ls.SetTop(2)
+	_addCookie(ls, 1)
+	return 1
+}
+
+// resp:set_header (key, value)
+// Sets the header key of resp. Returns resp.
+func httpRespSetHeader(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	key := ls.CheckString(2)
+	value := ls.CheckString(3)
+	if ls.GetField(1, "headers") != LK_TTABLE {
+		ls.Pop(1)
+		ls.NewTable()
+		ls.PushValue(-1)
+		ls.SetField(1, "headers")
+	}
+	ls.PushString(value)
+	ls.SetField(-2, key)
+	ls.SetTop(1)
+	return 1
+}
+
+// _addCookie pops the cookie table at the top, and adds it to the cookies
+// of the response at idx.
+func _addCookie(ls LkState, idx int) {
+	idx = ls.AbsIndex(idx)
+	if ls.GetField(idx, "cookies") != LK_TTABLE {
+		ls.Pop(1)
+		ls.NewTable()
+		ls.PushValue(-1)
+		ls.SetField(idx, "cookies")
+	}
+	ls.Insert(-2)
+	ls.SetI(-2, ls.Len2(-2))
+	ls.Pop(1)
+}
+
+// http.logger ([f])
+// Returns the middleware logging each request: its method, url, response
+// code and duration, to stderr, or calling f with the line.
+func httpLogger(ls LkState) int {
+	ls.SetTop(1)
+	if !ls.IsNil(1) {
+		ls.CheckType(1, LK_TFUNCTION)
+	}
+	ls.PushGoClosure(func(ls LkState) int {
+		ls.CheckType(1, LK_TTABLE)
+		ls.SetTop(2)
+		start := time.Now()
+		ls.PushValue(2)
+		ls.PushValue(1)
+		ls.Call(1, 1) /* at 3 */
+		ls.GetField(1, "method")
+		ls.GetField(1, "url")
+		ls.GetField(3, "code")
+		line := fmt.Sprintf("%s %s %s %d %s", start.Format("2006/01/02 15:04:05"),
+			ls.ToString(4), ls.ToString(5), _responseCode(ls, 6), time.Since(start))
+		ls.Pop(3)
+		if ls.IsNil(LkUpvalueIndex(1)) {
+			fmt.Fprintln(os.Stderr, line)
+		} else {
+			ls.PushValue(LkUpvalueIndex(1))
+			ls.PushString(line)
+			ls.Call(1, 0)
+		}
+		return 1
+	}, 1)
+	return 1
+}
+
+// http.recover ()
+// Returns the middleware answering 500 to the requests raising an error,
+// which is written to stderr.
+func httpRecover(ls LkState) int {
+	ls.PushGoFunction(func(ls LkState) int {
+		ls.SetTop(2)
+		ls.PushValue(2)
+		ls.PushValue(1)
+		if ls.PCall(1, 1, 0) == LK_OK {
+			return 1
+		}
+		ls.GetField(1, "method")
+		ls.GetField(1, "url")
+		fmt.Fprintf(os.Stderr, "http: %s %s: %s\n", ls.ToString(-2), ls.ToString(-1), ls.ToString(-3))
+		ls.CreateTable(0, 2)
+		ls.PushInteger(http.StatusInternalServerError)
+		ls.SetField(-2, "code")
+		ls.PushString(http.StatusText(http.StatusInternalServerError))
+		ls.SetField(-2, "body")
+		return 1
+	})
+	return 1
+}
+
+// http.cors ([opts])
+// Returns the middleware allowing the requests of the pages of other
+// sites, with the options:
+// origins, the list of the allowed ones (default all);
+// methods, the list of the allowed methods (default the usual ones);
+// headers, the list of the allowed request headers (default the asked ones);
+// credentials, to allow cookies;
+// max_age, in s, how long browsers keep the answer of a preflight request.
+// Preflight requests are answered by the middleware.
+func httpCors(ls LkState) int {
+	var opts map[string]any
+	if !ls.IsNoneOrNil(1) {
+		ls.CheckType(1, LK_TTABLE)
+		v, err := getValue(ls, 1)
+		if err != nil {
+			ls.ArgError(1, err.Error())
+		}
+		opts, _ = v.(map[string]any)
+	}
+	origins := _optStrings(ls, opts, "origins")
+	methods := strings.Join(_optStrings(ls, opts, "methods"), ", ")
+	if methods == "" {
+		methods = corsMethods
+	}
+	headers := strings.Join(_optStrings(ls, opts, "headers"), ", ")
+	credentials := _optField[bool](ls, opts, "credentials", "bool")
+	maxAge := _optField[int64](ls, opts, "max_age", "integer")
+
+	ls.PushGoFunction(func(ls LkState) int {
+		ls.CheckType(1, LK_TTABLE)
+		ls.SetTop(2)
+		ls.GetField(1, "headers")
+		req := _checkStrings(ls, 3)
+		ls.Pop(1)
+		origin := req["Origin"]
+		allowed := origin != "" && len(origins) == 0
+		for _, o := range origins {
+			allowed = allowed || o == origin
+		}
+		h := map[string]string{}
+		if allowed {
+			h["Access-Control-Allow-Origin"] = origin
+			if len(origins) == 0 && !credentials {
+				h["Access-Control-Allow-Origin"] = "*"
+			}
+			if credentials {
+				h["Access-Control-Allow-Credentials"] = "true"
+			}
+		}
+		if len(origins) > 0 || credentials {
+			h["Vary"] = "Origin"
+		}
+
+		ls.GetField(1, "method")
+		preflight := ls.ToString(-1) == http.MethodOptions && req["Access-Control-Request-Method"] != ""
+		ls.Pop(1)
+		if preflight {
+			if allowed {
+				h["Access-Control-Allow-Methods"] = methods
+				h["Access-Control-Allow-Headers"] = headers
+				if headers == "" {
+					h["Access-Control-Allow-Headers"] = req["Access-Control-Request-Headers"]
+				}
+				if maxAge > 0 {
+					h["Access-Control-Max-Age"] = strconv.FormatInt(maxAge, 10)
+				}
+			}
+			ls.CreateTable(0, 2)
+			ls.PushInteger(http.StatusNoContent)
+			ls.SetField(-2, "code")
+			pushTable(ls, h)
+			ls.SetField(-2, "headers")
+			return 1
+		}
+
+		ls.PushValue(2)
+		ls.PushValue(1)
+		ls.Call(1, 1) /* at 3 */
+		if ls.GetField(3, "headers") != LK_TTABLE {
+			ls.Pop(1)
+			ls.NewTable()
+			ls.PushValue(-1)
+			ls.SetField(3, "headers")
+		}
+		for k, v := range h {
+			ls.PushString(v)
+			ls.SetField(-2, k)
+		}
+		ls.SetTop(3)
+		return 1
+	})
+	return 1
+}
+
+// _optStrings returns the list of strings key of the options m, if any.
+func _optStrings(ls LkState, m map[string]any, key string) []string {
+	l := _optField[[]any](ls, m, key, "list")
+	strs := make([]string, len(l))
+	for i, v := range l {
+		s, ok := v.(string)
+		if !ok {
+			ls.Error2("field '%s' is not a list of strings", key)
+		}
+		strs[i] = s
+	}
+	return strs
+}
+
+// _responseCode returns the code of a response, at idx.
+func _responseCode(ls LkState, idx int) int {
+	if ls.IsNil(idx) {
+		return http.StatusOK
+	}
+	return int(ls.ToInteger(idx))
+}
+
+// _writeResponse answers with the response table at idx.
+func _writeResponse(ls LkState, w http.ResponseWriter, idx int) {
+	idx = ls.AbsIndex(idx)
+	ls.GetField(idx, "code")
+	code := _responseCode(ls, -1)
+	ls.Pop(1)
+	ls.GetField(idx, "headers")
+	for k, v := range _checkStrings(ls, ls.GetTop()) {
+		w.Header().Set(k, v)
+	}
+	ls.Pop(1)
+	if ls.GetField(idx, "cookies") == LK_TTABLE {
+		n := ls.Len2(-1)
+		for i := int64(0); i < n; i++ {
+			ls.GetI(-1, i)
+			http.SetCookie(w, _checkCookie(ls, ls.GetTop()))
+			ls.Pop(1)
+		}
+	}
+	ls.Pop(1)
+
+	switch ls.GetField(idx, "body") {
+	case LK_TFUNCTION:
+		w.WriteHeader(code)
+		_pushUserData(ls, w, httpWriterMeta, httpWriterMethods)
+		ls.Call(1, 0)
+	case LK_TNIL:
+		w.WriteHeader(code)
+		ls.Pop(1)
+	default:
+		w.WriteHeader(code)
+		w.Write([]byte(ls.ToString(-1)))
+		ls.Pop(1)
+	}
+}
+
+// _pushCookie pushes the table of c, as _checkCookie reads it.
+func _pushCookie(ls LkState, c *http.Cookie) {
+	m := lkMap{
+		"name":      c.Name,
+		"value":     c.Value,
+		"max_age":   int64(c.MaxAge),
+		"secure":    c.Secure,
+		"http_only": c.HttpOnly,
+	}
+	if c.Path != "" {
+		m["path"] = c.Path
+	}
+	if c.Domain != "" {
+		m["domain"] = c.Domain
+	}
+	if !c.Expires.IsZero() {
+		m["expires"] = c.Expires.UnixMilli()
+	}
+	switch c.SameSite {
+	case http.SameSiteLaxMode:
+		m["same_site"] = "lax"
+	case http.SameSiteStrictMode:
+		m["same_site"] = "strict"
+	case http.SameSiteNoneMode:
+		m["same_site"] = "none"
+	}
+	pushTable(ls, m)
+}
+
+// _checkCookie returns the cookie of the table at idx.
+func _checkCookie(ls LkState, idx int) *http.Cookie {
+	if ls.Type(idx) != LK_TTABLE {
+		ls.Error2("cookie table expected")
+	}
+	m := getTable(ls, idx)
+	c := &http.Cookie{
+		Name:     _optField[string](ls, m, "name", "str"),
+		Value:    _optField[string](ls, m, "value", "str"),
+		Path:     _optField[string](ls, m, "path", "str"),
+		Domain:   _optField[string](ls, m, "domain", "str"),
+		MaxAge:   int(_optField[int64](ls, m, "max_age", "integer")),
+		Secure:   _optField[bool](ls, m, "secure", "bool"),
+		HttpOnly: _optField[bool](ls, m, "http_only", "bool"),
+	}
+	if c.Name == "" {
+		ls.Error2("cookie without name")
+	}
+	if ms := _optField[int64](ls, m, "expires", "integer"); ms != 0 {
+		c.Expires = time.UnixMilli(ms)
+	}
+	c.SameSite = _sameSite(ls, _optField[string](ls, m, "same_site", "str"))
+	return c
+}
+
+// _sameSite returns the mode named s ('lax', 'strict' or 'none'),
+// the default one if s is empty.
+func _sameSite(ls LkState, s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "":
+		return http.SameSiteDefaultMode
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	ls.Error2("invalid same_site '%s'", s)
+	return 0
+}
+
+// writer:write (···)
+// Sends the strings.
+// Returns the error, or nil.
+func httpWriterWrite(ls LkState) int {
+	w := _checkUserData[http.ResponseWriter](ls, 1, "writer")
+	for i := 2; i <= ls.GetTop(); i++ {
+		if _, err := w.Write([]byte(ls.CheckString(i))); err != nil {
+			ls.PushString(err.Error())
+			return 1
+		}
+	}
+	ls.PushNil()
+	return 1
+}
+
+// writer:flush ()
+// Sends what was written so far to the client at once.
+func httpWriterFlush(ls LkState) int {
+	w := _checkUserData[http.ResponseWriter](ls, 1, "writer")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return 0
+}
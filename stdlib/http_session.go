@@ -0,0 +1,150 @@
+package stdlib
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+	. "github.com/lollipopkit/lk/json"
+)
+
+// Sessions are kept by the clients, in a cookie of the session table
+// encoded in json, with the time it was set and their hmac.
+
+// the length a secret must have at least
+const sessionSecretLen = 16
+
+type sessionStore struct {
+	secret []byte
+	cookie http.Cookie // the one of a session, without value
+}
+
+// http.session (secret [, opts])
+// Returns the middleware giving each request the table req.session,
+// saved in a cookie signed with secret (16 bytes or more) once changed.
+// An empty session removes the cookie. The options are:
+// name, of the cookie (default 'session');
+// max_age, in s, after which the session is dropped (default none,
+// the browser keeps it until it is closed);
+// path (default '/'), domain, secure, http_only (default true)
+// and same_site (default 'lax'), as the cookies of responses.
+func httpSession(ls LkState) int {
+	secret := ls.CheckString(1)
+	ls.ArgCheck(len(secret) >= sessionSecretLen, 1, "secret of 16 bytes or more expected")
+	opts := OptTable(ls, 2, lkMap{})
+	s := &sessionStore{secret: []byte(secret)}
+	s.cookie = http.Cookie{
+		Name:     _optField[string](ls, opts, "name", "str"),
+		Path:     _optField[string](ls, opts, "path", "str"),
+		Domain:   _optField[string](ls, opts, "domain", "str"),
+		MaxAge:   int(_optField[int64](ls, opts, "max_age", "integer")),
+		Secure:   _optField[bool](ls, opts, "secure", "bool"),
+		HttpOnly: opts["http_only"] == nil || _optField[bool](ls, opts, "http_only", "bool"),
+		SameSite: _sameSite(ls, _optField[string](ls, opts, "same_site", "str")),
+	}
+	if s.cookie.Name == "" {
+		s.cookie.Name = "session"
+	}
+	if s.cookie.Path == "" {
+		s.cookie.Path = "/"
+	}
+	if s.cookie.SameSite == http.SameSiteDefaultMode {
+		s.cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	ls.PushGoFunction(func(ls LkState) int {
+		ls.CheckType(1, LK_TTABLE)
+		ls.SetTop(2)
+		ls.GetField(1, "cookies")
+		cookies := _checkStrings(ls, 3)
+		ls.Pop(1)
+		var data any
+		payload, ok := s.verify(cookies[s.cookie.Name], time.Now())
+		if ok && Json.Unmarshal(payload, &data) == nil {
+			pushValue(ls, data)
+		}
+		if ls.Type(3) != LK_TTABLE {
+			ls.SetTop(2)
+			ls.NewTable()
+		}
+		ls.SetField(1, "session")
+
+		ls.PushValue(2)
+		ls.PushValue(1)
+		ls.Call(1, 1) /* at 3 */
+		var saved []byte
+		if ls.GetField(1, "session") == LK_TTABLE && !_isEmpty(ls, 4) {
+			v, err := getValue(ls, 4)
+			if err == nil {
+				saved, err = Json.Marshal(v)
+			}
+			if err != nil {
+				return ls.Error2("session: %s", err.Error())
+			}
+		}
+		ls.Pop(1)
+		if bytes.Equal(saved, payload) {
+			return 1
+		}
+		c := s.cookie
+		if saved == nil {
+			c.MaxAge = -1
+		} else {
+			c.Value = s.sign(saved, time.Now())
+		}
+		_pushCookie(ls, &c)
+		_addCookie(ls, 3)
+		return 1
+	})
+	return 1
+}
+
+// _isEmpty returns whether the table at idx is empty.
+func _isEmpty(ls LkState, idx int) bool {
+	ls.PushNil()
+	if ls.Next(idx) {
+		ls.Pop(2)
+		return false
+	}
+	return true
+}
+
+// sign returns the value of the cookie of payload, set at t.
+func (s *sessionStore) sign(payload []byte, t time.Time) string {
+	msg := base64.RawURLEncoding.EncodeToString(payload) + "." + strconv.FormatInt(t.Unix(), 10)
+	return msg + "." + base64.RawURLEncoding.EncodeToString(s.mac(msg))
+}
+
+// verify returns the payload of the value of a cookie, and whether its
+// signature is valid, and it is not expired at t.
+func (s *sessionStore) verify(value string, t time.Time) ([]byte, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return nil, false
+	}
+	msg := value[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil || !hmac.Equal(mac, s.mac(msg)) {
+		return nil, false
+	}
+	data, ts, _ := strings.Cut(msg, ".")
+	set, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || s.cookie.MaxAge > 0 && t.Unix()-set > int64(s.cookie.MaxAge) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(data)
+	return payload, err == nil
+}
+
+// mac returns the hmac of msg, for the cookie of the store.
+func (s *sessionStore) mac(msg string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(s.cookie.Name + "|" + msg))
+	return h.Sum(nil)
+}
@@ -0,0 +1,130 @@
+package stdlib
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// http.static (dir [, prefix])
+// Returns the function of http.listen answering the GET and HEAD requests
+// with the files of dir: the one at the path of the url, without prefix,
+// or its index.html for a directory. Answers 304 if the file was not
+// modified since the If-Modified-Since of the request, 404 if there is
+// no such file, and 405 to the other methods.
+func httpStatic(ls LkState) int {
+	dir := http.Dir(ls.CheckString(1))
+	prefix := ls.OptString(2, "")
+	ls.PushGoFunction(func(ls LkState) int {
+		ls.CheckType(1, LK_TTABLE)
+		req := getTable(ls, 1)
+		method := _optField[string](ls, req, "method", "str")
+		ls.GetField(1, "headers")
+		headers := _checkStrings(ls, ls.GetTop())
+
+		if method != http.MethodGet && method != http.MethodHead {
+			_pushStatic(ls, http.StatusMethodNotAllowed, map[string]string{"Allow": "GET, HEAD"})
+			return 1
+		}
+		u, err := neturl.Parse(_optField[string](ls, req, "url", "str"))
+		if err != nil {
+			_pushStatic(ls, http.StatusBadRequest, nil)
+			return 1
+		}
+		name, ok := strings.CutPrefix(u.Path, prefix)
+		if !ok || name != "" && name[0] != '/' {
+			_pushStatic(ls, http.StatusNotFound, nil)
+			return 1
+		}
+		f, info, err := _staticFile(dir, name)
+		if err != nil {
+			_pushStatic(ls, http.StatusNotFound, nil)
+			return 1
+		}
+		defer f.Close()
+
+		modified := info.ModTime().UTC()
+		if t, err := http.ParseTime(headers["If-Modified-Since"]); err == nil && !modified.Truncate(time.Second).After(t) {
+			_pushStatic(ls, http.StatusNotModified, nil)
+			return 1
+		}
+		ctype := mime.TypeByExtension(filepath.Ext(info.Name()))
+		if ctype == "" {
+			var buf [512]byte
+			n, _ := io.ReadFull(f, buf[:])
+			ctype = http.DetectContentType(buf[:n])
+		}
+		_pushStatic(ls, http.StatusOK, map[string]string{
+			"Content-Type":   ctype,
+			"Content-Length": strconv.FormatInt(info.Size(), 10),
+			"Last-Modified":  modified.Format(http.TimeFormat),
+		})
+		if method == http.MethodGet {
+			ls.PushGoFunction(func(ls LkState) int {
+				w := _checkUserData[http.ResponseWriter](ls, 1, "writer")
+				f, _, err := _staticFile(dir, name)
+				if err != nil {
+					return ls.Error2("%s", err.Error())
+				}
+				defer f.Close()
+				_await(ls, func() {
+					io.Copy(w, f)
+				})
+				return 0
+			})
+			ls.SetField(-2, "body")
+		}
+		return 1
+	})
+	return 1
+}
+
+// _staticFile opens the file name of dir, or its index.html if it is
+// a directory.
+func _staticFile(dir http.Dir, name string) (http.File, fs.FileInfo, error) {
+	f, err := dir.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.IsDir() {
+		f.Close()
+		if f, err = dir.Open(path.Join(name, "index.html")); err != nil {
+			return nil, nil, err
+		}
+		info, err = f.Stat()
+	}
+	if err == nil && info.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// _pushStatic pushes the response table of code, with the headers,
+// and the status text as body if it is an error.
+func _pushStatic(ls LkState, code int, headers map[string]string) {
+	ls.CreateTable(0, 3)
+	ls.PushInteger(int64(code))
+	ls.SetField(-2, "code")
+	if headers != nil {
+		pushTable(ls, headers)
+		ls.SetField(-2, "headers")
+	}
+	if code >= 400 {
+		ls.PushString(http.StatusText(code))
+		ls.SetField(-2, "body")
+	}
+}
@@ -0,0 +1,210 @@
+package stdlib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// a body whose size is known before it is read
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+const (
+	// bytes saved between two checks for a progress report
+	downloadChunk = 256 << 10
+	// minimum time between two progress reports
+	progressInterval = 100 * time.Millisecond
+)
+
+// http.upload (url, form [, opts])
+// Posts the file form.file as the field form.field (default 'file') of a
+// multipart form, with the text fields of the table form.fields,
+// reading the file as it is sent. The name of the file sent is form.name
+// (default the base name of form.file).
+// opts are the ones of http.req, with the table of headers opts.headers.
+// Returns as http.req.
+func httpUpload(ls LkState) int {
+	url := ls.CheckString(1)
+	ls.CheckType(2, LK_TTABLE)
+	form := getTable(ls, 2)
+	path := _optField[string](ls, form, "file", "str")
+	ls.ArgCheck(path != "", 2, "field 'file' expected")
+	field := _optField[string](ls, form, "field", "str")
+	if field == "" {
+		field = "file"
+	}
+	name := _optField[string](ls, form, "name", "str")
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	ls.SetTop(3)
+	ls.GetField(2, "fields")
+	fields := _checkStrings(ls, 4)
+	ls.Pop(1)
+	opts := _checkHttpOpts(ls, 3)
+	headers := _optHeaders(ls, 3)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return _pushHttpErr(ls, &httpResp{}, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return _pushHttpErr(ls, &httpResp{}, err)
+	}
+
+	/* the parts around the file */
+	var head, tail bytes.Buffer
+	mw := multipart.NewWriter(&head)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	mw.CreateFormFile(field, name)
+	headers["Content-Type"] = mw.FormDataContentType()
+	end := multipart.NewWriter(&tail)
+	end.SetBoundary(mw.Boundary())
+	end.Close()
+
+	body := &sizedReader{
+		Reader: io.MultiReader(&head, f, &tail),
+		size:   int64(head.Len()) + info.Size() + int64(tail.Len()),
+	}
+	resp, err := _httpDo(ls, http.MethodPost, url, headers, body, opts)
+	if err != nil {
+		return _pushHttpErr(ls, resp, err)
+	}
+	return _pushHttpResp(ls, string(resp.body), resp)
+}
+
+// http.download (url, path [, opts])
+// Saves the body of the response to url in the file path, as it comes,
+// if the status code is 2xx.
+// opts are the ones of http.req, with the table of headers opts.headers,
+// and opts.progress, a function called with the bytes saved so far and
+// the total (nil if unknown), at most every 100 ms and at the end.
+// Returns the size of the file, the status code, nil and the response
+// headers, or nil, the status code (0 if none) and the error.
+func httpDownload(ls LkState) int {
+	url := ls.CheckString(1)
+	path := ls.CheckString(2)
+	opts := _checkHttpOpts(ls, 3)
+	headers := _optHeaders(ls, 3)
+	ls.SetTop(3)
+	progress := ls.Type(3) == LK_TTABLE && ls.GetField(3, "progress") == LK_TFUNCTION /* at 4 */
+	ls.Audit("http.req", http.MethodGet, url)
+	if !_mutate(ls, "http.download", url, path) {
+		ls.PushInteger(0)
+		ls.PushInteger(http.StatusOK)
+		ls.PushNil()
+		ls.NewTable()
+		return 4
+	}
+
+	c, err := opts.client()
+	if err != nil {
+		return _pushHttpErr(ls, &httpResp{}, err)
+	}
+	ctx := ls.Context()
+	var r *http.Response
+	_await(ls, func() {
+		r, err = _sendReq(ctx, c, http.MethodGet, url, nil, headers, opts)
+	})
+	if err != nil {
+		return _pushHttpErr(ls, &httpResp{}, err)
+	}
+	defer r.Body.Close()
+	resp := &httpResp{code: r.StatusCode, header: r.Header}
+	if r.StatusCode/100 != 2 {
+		return _pushHttpErr(ls, resp, fmt.Errorf("status %s", r.Status))
+	}
+	if opts.maxBody > 0 && r.ContentLength > opts.maxBody {
+		return _pushHttpErr(ls, resp, fmt.Errorf("body larger than %d bytes", opts.maxBody))
+	}
+
+	/* into a temporary file, renamed once complete */
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return _pushHttpErr(ls, resp, err)
+	}
+	saved := false
+	defer func() {
+		if !saved {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	var size int64
+	body := io.Reader(r.Body)
+	if opts.maxBody > 0 {
+		body = io.LimitReader(r.Body, opts.maxBody+1)
+	}
+	buf := make([]byte, 32<<10)
+	last := time.Now()
+	for done := false; !done; {
+		var n int64
+		_await(ls, func() {
+			if !progress {
+				n, err = io.CopyBuffer(f, body, buf)
+				done = true
+				return
+			}
+			n, err = io.CopyBuffer(f, io.LimitReader(body, downloadChunk), buf)
+			done = n < downloadChunk
+		})
+		size += n
+		if err != nil {
+			return _pushHttpErr(ls, resp, err)
+		}
+		if opts.maxBody > 0 && size > opts.maxBody {
+			return _pushHttpErr(ls, resp, fmt.Errorf("body larger than %d bytes", opts.maxBody))
+		}
+		if progress && (done || time.Since(last) >= progressInterval) {
+			last = time.Now()
+			ls.PushValue(4)
+			ls.PushInteger(size)
+			if r.ContentLength >= 0 {
+				ls.PushInteger(r.ContentLength)
+			} else {
+				ls.PushNil()
+			}
+			ls.Call(2, 0)
+		}
+	}
+
+	if err = f.Chmod(0644); err == nil {
+		if err = f.Close(); err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+	}
+	if err != nil {
+		return _pushHttpErr(ls, resp, err)
+	}
+	saved = true
+	ls.PushInteger(size)
+	ls.PushInteger(int64(resp.code))
+	ls.PushNil()
+	pushTable(ls, genHeaderMap(&resp.header))
+	return 4
+}
+
+// _optHeaders returns the headers of the options at arg, if any.
+func _optHeaders(ls LkState, arg int) map[string]string {
+	if ls.Type(arg) != LK_TTABLE {
+		return map[string]string{}
+	}
+	ls.GetField(arg, "headers")
+	defer ls.Pop(1)
+	return _checkStrings(ls, ls.GetTop())
+}
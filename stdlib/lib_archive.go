@@ -0,0 +1,435 @@
+package stdlib
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Archives are read by their content: zip, tar or tar.gz, whatever
+// their names. Extracting refuses the entries which would be written
+// out of the destination dir.
+
+var archiveLib = map[string]GoFunction{
+	"zip":     archiveZip,
+	"unzip":   archiveUnzip,
+	"tar":     archiveTar,
+	"untar":   archiveUntar,
+	"entries": archiveEntries,
+	"read":    archiveRead,
+}
+
+func OpenArchiveLib(ls LkState) int {
+	ls.NewLib(archiveLib)
+	return 1
+}
+
+// an entry of an archive
+type archiveEntry struct {
+	name string
+	info fs.FileInfo
+	link string // target of a symlink
+	open func() (io.Reader, error)
+}
+
+// archive.zip (dir, out)
+// Writes the zip file out with the files under dir.
+// Returns the error, or nil.
+func archiveZip(ls LkState) int {
+	dir := ls.CheckString(1)
+	out := ls.CheckString(2)
+	if !_mutate(ls, "archive.zip", dir, out) {
+		ls.PushNil()
+		return 1
+	}
+	return _pushArchiveErr(ls, _writeArchive(out, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		err := _walkArchive(dir, func(name string, info fs.FileInfo, path string) error {
+			h, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			h.Name = name
+			if info.IsDir() {
+				h.Name += "/"
+			} else {
+				h.Method = zip.Deflate
+			}
+			fw, err := zw.CreateHeader(h)
+			if err != nil || info.IsDir() {
+				return err
+			}
+			if info.Mode()&fs.ModeSymlink != 0 { /* stored as its target */
+				link, err := os.Readlink(path)
+				if err == nil {
+					_, err = io.WriteString(fw, link)
+				}
+				return err
+			}
+			return _copyFrom(fw, path)
+		})
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	}))
+}
+
+// archive.tar (dir, out)
+// Writes the tar file out with the files under dir, compressed with
+// gzip if out ends with .gz or .tgz. Returns the error, or nil.
+func archiveTar(ls LkState) int {
+	dir := ls.CheckString(1)
+	out := ls.CheckString(2)
+	if !_mutate(ls, "archive.tar", dir, out) {
+		ls.PushNil()
+		return 1
+	}
+	gz := strings.HasSuffix(out, ".gz") || strings.HasSuffix(out, ".tgz")
+	return _pushArchiveErr(ls, _writeArchive(out, func(w io.Writer) error {
+		var zw *gzip.Writer
+		if gz {
+			zw = gzip.NewWriter(w)
+			w = zw
+		}
+		tw := tar.NewWriter(w)
+		err := _walkArchive(dir, func(name string, info fs.FileInfo, path string) error {
+			link := ""
+			if info.Mode()&fs.ModeSymlink != 0 {
+				var err error
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			h, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			h.Name = name
+			if info.IsDir() {
+				h.Name += "/"
+			}
+			if err := tw.WriteHeader(h); err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			return _copyFrom(tw, path)
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		if err == nil && zw != nil {
+			err = zw.Close()
+		}
+		return err
+	}))
+}
+
+// archive.unzip (file, dest)
+// archive.untar (file, dest)
+// Extracts the archive file into the dir dest, which is created if needed.
+// Both read zip, tar and tar.gz files. Returns the error, or nil.
+func archiveUnzip(ls LkState) int {
+	return _extract(ls, "archive.unzip")
+}
+
+func archiveUntar(ls LkState) int {
+	return _extract(ls, "archive.untar")
+}
+
+func _extract(ls LkState, op string) int {
+	file := ls.CheckString(1)
+	dest := ls.CheckString(2)
+	if !_mutate(ls, op, file, dest) {
+		ls.PushNil()
+		return 1
+	}
+	err := _readArchive(file, func(e *archiveEntry) (bool, error) {
+		return true, _extractEntry(dest, e)
+	})
+	return _pushArchiveErr(ls, err)
+}
+
+// archive.entries (file)
+// Returns the list of the entries of the archive file, without
+// extracting it: {'name', 'size', 'mode', 'time', 'is_dir'} like the
+// info of os.stat, with 'link', the target of a symlink.
+// Or nil and the error.
+func archiveEntries(ls LkState) int {
+	file := ls.CheckString(1)
+	ls.Audit("archive.entries", file)
+	entries := []lkMap{}
+	err := _readArchive(file, func(e *archiveEntry) (bool, error) {
+		entries = append(entries, lkMap{
+			"name":   e.name,
+			"size":   e.info.Size(),
+			"mode":   e.info.Mode().String(),
+			"time":   e.info.ModTime().UnixMilli(),
+			"is_dir": e.info.IsDir(),
+			"link":   e.link,
+		})
+		return true, nil
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushList(ls, entries)
+	ls.PushNil()
+	return 2
+}
+
+// archive.read (file, name)
+// Returns the content of the entry name of the archive file,
+// without extracting it, or nil and the error.
+func archiveRead(ls LkState) int {
+	file := ls.CheckString(1)
+	name := ls.CheckString(2)
+	ls.Audit("archive.read", file, name)
+	var data []byte
+	found := false
+	err := _readArchive(file, func(e *archiveEntry) (bool, error) {
+		if e.name != name || e.info.IsDir() {
+			return true, nil
+		}
+		found = true
+		r, err := e.open()
+		if err == nil {
+			data, err = io.ReadAll(r)
+		}
+		return false, err
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("%s: no entry %s", file, name)
+	}
+	return _pushResult(ls, string(data), err)
+}
+
+func _pushArchiveErr(ls LkState, err error) int {
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// _walkArchive calls add for the files and dirs under dir, with their
+// names in the archive, relative to dir and with slashes.
+func _walkArchive(dir string, add func(name string, info fs.FileInfo, path string) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return add(filepath.ToSlash(rel), info, path)
+	})
+}
+
+// _writeArchive writes out with write. out is removed if write fails.
+func _writeArchive(out string, write func(w io.Writer) error) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+	}
+	return err
+}
+
+func _copyFrom(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// _readArchive calls each for the entries of the archive file,
+// while it returns true.
+func _readArchive(file string, each func(e *archiveEntry) (bool, error)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(magic[:n], []byte("PK")) {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, zf := range zr.File {
+			zf := zf
+			e := &archiveEntry{
+				name: strings.TrimSuffix(zf.Name, "/"),
+				info: zf.FileInfo(),
+				open: func() (io.Reader, error) { return zf.Open() },
+			}
+			if e.info.Mode()&fs.ModeSymlink != 0 {
+				r, err := zf.Open()
+				if err != nil {
+					return fmt.Errorf("%s: %v", file, err)
+				}
+				link, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					return fmt.Errorf("%s: %v", file, err)
+				}
+				e.link = string(link)
+			}
+			if ok, err := each(e); !ok || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = bufio.NewReader(f)
+	if bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		e := &archiveEntry{
+			name: strings.TrimSuffix(h.Name, "/"),
+			info: h.FileInfo(),
+			link: h.Linkname,
+			open: func() (io.Reader, error) { return tr, nil },
+		}
+		if ok, err := each(e); !ok || err != nil {
+			return err
+		}
+	}
+}
+
+// _extractEntry writes e under dest.
+func _extractEntry(dest string, e *archiveEntry) error {
+	path, err := _destPath(dest, e.name)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		os.Remove(path) /* don't write through it */
+	}
+	mode := e.info.Mode()
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(path, 0755)
+	case mode&fs.ModeSymlink != 0:
+		target := filepath.Join(filepath.Dir(path), filepath.FromSlash(e.link))
+		if filepath.IsAbs(e.link) || !_inDir(dest, target) {
+			return fmt.Errorf("%s: link to %s out of %s", e.name, e.link, dest)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(e.link, path)
+	case !mode.IsRegular():
+		return nil /* devices, fifos... */
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	r, err := e.open()
+	if err != nil {
+		return err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		os.Chtimes(path, e.info.ModTime(), e.info.ModTime())
+	}
+	return err
+}
+
+// _destPath returns the path of the entry name under dest,
+// or an error if it is out of dest.
+func _destPath(dest, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid entry name %q", name)
+	}
+	path := filepath.Join(dest, filepath.FromSlash(name))
+	if !_inDir(dest, path) {
+		return "", errors.New("entry " + name + " out of " + dest)
+	}
+	/* nor through a symlink, which may point anywhere */
+	dir := dest
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(filepath.FromSlash(name))), "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			break
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return "", errors.New("entry " + name + " through the link " + dir)
+		}
+	}
+	return path, nil
+}
+
+func _inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
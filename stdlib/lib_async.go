@@ -0,0 +1,130 @@
+package stdlib
+
+import (
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Promises, run by the scheduler of sync.run.
+// async.spawn starts a task like sync.spawn, and returns a promise of its
+// results: p:await() suspends the running task until they are known,
+// while the other tasks go on.
+// A task failing while nobody awaits it raises its error in the scheduler.
+
+var asyncLib = map[string]GoFunction{
+	"run":   asyncRun,
+	"spawn": asyncSpawn,
+	"all":   asyncAll,
+	"sleep": asyncSleep,
+}
+
+const (
+	// registry key of the metatable of promises
+	promiseMeta = "_PROMISE"
+	// registry key of the promises of the tasks spawned but not yet run:
+	// thread -> promise
+	promisesKey = "_PROMISES"
+)
+
+var promiseMethods = map[string]GoFunction{
+	"await": promiseAwait,
+}
+
+type promise struct {
+	done    bool
+	results []any
+	failed  bool
+	err     any
+	awaited bool
+	waiters []LkState // tasks suspended in await
+}
+
+func OpenAsyncLib(ls LkState) int {
+	ls.NewLib(asyncLib)
+	return 1
+}
+
+// async.spawn (f, ···)
+// Creates a task calling f with the args, like sync.spawn,
+// and returns the promise of its results.
+func asyncSpawn(ls LkState) int {
+	coSpawn(ls)
+	_pushUserData(ls, &promise{}, promiseMeta, promiseMethods)
+	ls.GetSubTable(LK_REGISTRYINDEX, promisesKey)
+	ls.PushValue(-3) /* thread */
+	ls.PushValue(-3) /* promise */
+	ls.SetTable(-3)
+	ls.Pop(1)
+	ls.Remove(-2) /* thread */
+	return 1
+}
+
+// async.run (f, ···)
+// Calls f with the args as a task, runs the tasks until all are done,
+// and returns the results of f.
+func asyncRun(ls LkState) int {
+	ls.CheckType(1, LK_TFUNCTION)
+	asyncSpawn(ls)
+	p := ls.ToUserData(-1).(*promise)
+	p.awaited = true
+	coRun(ls)
+	return _awaitPromise(ls, p)
+}
+
+// p:await ()
+// Returns the results of the task of p, once it is done.
+// The error of the task is raised here.
+func promiseAwait(ls LkState) int {
+	return _awaitPromise(ls, _checkUserData[*promise](ls, 1, "promise"))
+}
+
+func _awaitPromise(ls LkState, p *promise) int {
+	if !p.done {
+		s := _schedOf(ls)
+		if s == nil {
+			return ls.Error2("attempt to await outside a task")
+		}
+		p.waiters = append(p.waiters, ls)
+		s.parked = ls
+		ls.Yield(0) /* resumed once p is settled */
+	}
+	p.awaited = true
+	if p.failed {
+		ls.Push(p.err)
+		return ls.Error()
+	}
+	ls.CheckStack(len(p.results))
+	for _, v := range p.results {
+		ls.Push(v)
+	}
+	return len(p.results)
+}
+
+// async.all (promises)
+// Awaits the promises, and returns the list of their first results.
+func asyncAll(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	n := ls.Len2(1)
+	ls.CreateTable(int(n), 0)
+	for i := int64(0); i < n; i++ {
+		ls.GetI(1, i)
+		p, ok := ls.ToUserData(-1).(*promise)
+		if !ok {
+			return ls.Error2("bad promise #%d to 'all'", i)
+		}
+		ls.Pop(1)
+		if r := _awaitPromise(ls, p); r != 1 {
+			ls.SetTop(ls.GetTop() - r + 1)
+		}
+		ls.SetI(-2, i)
+	}
+	return 1
+}
+
+// async.sleep (ms)
+// Returns a promise settled after ms milliseconds.
+func asyncSleep(ls LkState) int {
+	ls.CheckInteger(1)
+	ls.PushGoFunction(osSleep)
+	ls.Insert(1)
+	return asyncSpawn(ls)
+}
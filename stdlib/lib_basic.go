@@ -55,7 +55,7 @@ func baseNew(ls LkState) int {
 		} else {
 			ls.PushValue(-2)
 		}
-		ls.SetTable(-5)
+		ls.RawSet(-5) /* a copied __newindex must not catch the next fields */
 		ls.Pop(1)
 	}
 	return 1
@@ -83,7 +83,7 @@ func strFormat(ls LkState) int {
 		return 1
 	}
 
-	ls.PushString(_fmt(fmtStr, ls))
+	_fmt(fmtStr, ls)
 	return 1
 }
 
@@ -114,7 +114,8 @@ func basePrintf(ls LkState) int {
 		return 0
 	}
 
-	print(_fmt(fmtStr, ls))
+	_fmt(fmtStr, ls)
+	print(ls.ToString(-1))
 	return 0
 }
 
@@ -140,16 +141,21 @@ func baseError(ls LkState) int {
 
 func baseErrorf(ls LkState) int {
 	fmtStr := ls.CheckString(1)
-	ls.PushString(_fmt(fmtStr, ls))
+	_fmt(fmtStr, ls)
 	return ls.Error()
 }
 
 // pairs (t)
 // http://www.lua.org/manual/5.3/manual.html#pdf-pairs
 // lua-5.3.4/src/lbaselib.c#luaB_pairs()
+// A function is its own generator, so `for line in io.lines(path)` works.
 func basePairs(ls LkState) int {
 	ls.CheckAny(1)
-	if ls.GetMetafield(1, "__iter") == LK_TNIL { /* no metamethod? */
+	if ls.IsFunction(1) {
+		ls.SetTop(1) /* generator, */
+		ls.PushNil() /* state, */
+		ls.PushNil()
+	} else if ls.GetMetafield(1, "__iter") == LK_TNIL { /* no metamethod? */
 		ls.PushGoFunction(baseNext) /* will return generator, */
 		ls.PushValue(1)             /* state, */
 		ls.PushNil()
@@ -0,0 +1,75 @@
+package stdlib
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Values are converted as for msgpack. Map keys are sorted as in
+// canonical CBOR. The tags other than timestamps and big integers
+// are dropped, keeping their content.
+
+var cborLib = map[string]GoFunction{
+	"encode": cborEncode,
+	"decode": cborDecode,
+}
+
+var (
+	cborEnc, _ = cbor.EncOptions{Sort: cbor.SortCanonical}.EncMode()
+	cborDec, _ = cbor.DecOptions{MaxNestedLevels: 100}.DecMode()
+)
+
+func OpenCborLib(ls LkState) int {
+	ls.NewLib(cborLib)
+	return 1
+}
+
+// cbor.encode (v)
+// Returns the CBOR of v, or nil and the error.
+func cborEncode(ls LkState) int {
+	v, err := getValue(ls, 1)
+	var data []byte
+	if err == nil {
+		data, err = cborEnc.Marshal(_binEncodable(v))
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(string(data))
+	ls.PushNil()
+	return 2
+}
+
+// cbor.decode (s)
+// Returns the value of the CBOR s, or nil and the error.
+func cborDecode(ls LkState) int {
+	s := ls.CheckString(1)
+	var v any
+	if err := cborDec.Unmarshal([]byte(s), &v); err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushValue(ls, _binValue(_cborUntag(v)))
+	ls.PushNil()
+	return 2
+}
+
+// _cborUntag replaces the tags in v with their content.
+func _cborUntag(v any) any {
+	switch x := v.(type) {
+	case cbor.Tag:
+		return _cborUntag(x.Content)
+	case map[any]any:
+		for k := range x {
+			x[k] = _cborUntag(x[k])
+		}
+	case []any:
+		for i := range x {
+			x[i] = _cborUntag(x[i])
+		}
+	}
+	return v
+}
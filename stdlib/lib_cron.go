@@ -0,0 +1,304 @@
+package stdlib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Like timers, cron jobs run their function as tasks of sync.run
+// (or async.run): they don't block the other tasks while waiting.
+
+var cronLib = map[string]GoFunction{
+	"schedule": cronSchedule,
+	"next":     cronNext,
+}
+
+// registry key of the metatable of cron jobs
+const cronMeta = "_CRON"
+
+var cronMethods = map[string]GoFunction{
+	"cancel": cronCancel,
+	"next":   cronJobNext,
+}
+
+func OpenCronLib(ls LkState) int {
+	ls.NewLib(cronLib)
+	return 1
+}
+
+// a parsed cron spec: the bits of the allowed values of each field
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	loc                           *time.Location
+}
+
+type lkCronJob struct {
+	next   atomic.Int64 // ms of the next run, 0 if none
+	active atomic.Bool
+	once   sync.Once
+	cancel chan struct{} // closed by cancel
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// cron.schedule (spec, f [, zone])
+// Calls f(ts) at each time matching spec, in zone, from the next sync.run,
+// until the job is canceled. ts is the scheduled time.
+// spec has 5 fields: minute, hour, day of month, month and day of week,
+// each *, a value, a range a-b, a list a,b, with an optional step:
+// '*/5 * * * *', '0 9 * * mon-fri'. Or @yearly, @monthly, @weekly,
+// @daily, @hourly.
+// Returns the job, or nil and the error.
+func cronSchedule(ls LkState) int {
+	spec := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	sched, err := _parseCron(spec, _checkLocation(ls, 3))
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	j := &lkCronJob{cancel: make(chan struct{})}
+	j.active.Store(true)
+	j.next.Store(_cronMs(sched.after(time.Now())))
+
+	ls.SetTop(2)
+	ls.Remove(1) /* keep f, the arg of the task */
+	ls.PushGoFunction(func(ls LkState) int {
+		ctx := ls.Context()
+		for {
+			next := j.next.Load()
+			if next == 0 {
+				j.active.Store(false)
+				return 0
+			}
+			timer := time.NewTimer(time.Until(time.UnixMilli(next)))
+			fired := false
+			var err error
+			_await(ls, func() {
+				select {
+				case <-timer.C:
+					fired = true
+				case <-j.cancel:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			})
+			timer.Stop()
+			if err != nil {
+				ls.Error2("interrupted: %v", err)
+			}
+			if !fired {
+				return 0
+			}
+			j.next.Store(_cronMs(sched.after(time.Now())))
+			ls.PushValue(1)
+			ls.PushInteger(next)
+			ls.Call(1, 0)
+			if !j.active.Load() {
+				return 0
+			}
+		}
+	})
+	ls.Insert(1)
+	coSpawn(ls)
+	ls.Pop(1)
+	_pushUserData(ls, j, cronMeta, cronMethods)
+	ls.PushNil()
+	return 2
+}
+
+// cron.next (spec [, ts [, zone]])
+// Returns the first time after ts (default now) matching spec, in zone,
+// nil if there is none, or nil and the error.
+func cronNext(ls LkState) int {
+	spec := ls.CheckString(1)
+	t := time.Now()
+	if !ls.IsNoneOrNil(2) {
+		t = time.UnixMilli(ls.CheckInteger(2))
+	}
+	sched, err := _parseCron(spec, _checkLocation(ls, 3))
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	if next := _cronMs(sched.after(t)); next == 0 {
+		ls.PushNil()
+	} else {
+		ls.PushInteger(next)
+	}
+	ls.PushNil()
+	return 2
+}
+
+// j:cancel ()
+// Stops the job, and returns whether it was still active.
+func cronCancel(ls LkState) int {
+	j := _checkUserData[*lkCronJob](ls, 1, "cron job")
+	ls.PushBoolean(j.active.Swap(false))
+	j.once.Do(func() { close(j.cancel) })
+	return 1
+}
+
+// j:next ()
+// Returns the time of the next run, or nil if the job is done.
+func cronJobNext(ls LkState) int {
+	j := _checkUserData[*lkCronJob](ls, 1, "cron job")
+	if next := j.next.Load(); j.active.Load() && next != 0 {
+		ls.PushInteger(next)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+func _parseCron(spec string, loc *time.Location) (*cronSpec, error) {
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q: expected 5 fields, got %d", spec, len(fields))
+	}
+	s := &cronSpec{loc: loc}
+	var err error
+	parse := func(i, min, max int, names []string) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = _parseCronField(fields[i], min, max, names)
+		if err != nil {
+			err = fmt.Errorf("cron spec %q: %v", spec, err)
+		}
+		return bits
+	}
+	s.minute = parse(0, 0, 59, nil)
+	s.hour = parse(1, 0, 23, nil)
+	s.dom = parse(2, 1, 31, nil)
+	s.month = parse(3, 1, 12, cronMonths)
+	s.dow = parse(4, 0, 7, cronDays)
+	if err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 { /* 7 is sunday too */
+		s.dow |= 1
+	}
+	s.domStar = fields[2][0] == '*' || fields[2] == "?"
+	s.dowStar = fields[4][0] == '*' || fields[4] == "?"
+	return s, nil
+}
+
+// _parseCronField returns the bits of the values of a field: *, a, a-b,
+// or a list of them, each with an optional /step.
+// names, if any, are the names of the values from min.
+func _parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("invalid value '%s' in '%s'", s, field)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s' in '%s'", stepStr, field)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" && rng != "?" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(a); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if hi, err = value(b); err != nil {
+					return 0, err
+				}
+			case !hasStep: /* a/step runs up to max */
+				hi = lo
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range '%s' in '%s'", rng, field)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// after returns the first minute after t matching s,
+// or the zero time if there is none in the next 5 years.
+func (s *cronSpec) after(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	end := t.Year() + 5
+	for t.Year() < end {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// _cronMs returns the ms of t, or 0 for the zero time.
+func _cronMs(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// dayMatches reports whether the day of t matches s. Like cron, when
+// both the day of month and the day of week are restricted, either
+// may match.
+func (s *cronSpec) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,212 @@
+package stdlib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// The hashes are returned in hex, or as raw bytes when raw is true.
+
+var cryptoLib = map[string]GoFunction{
+	"md5":        _hashFunc(md5.New),
+	"sha1":       _hashFunc(sha1.New),
+	"sha256":     _hashFunc(sha256.New),
+	"sha512":     _hashFunc(sha512.New),
+	"crc32":      _hashFunc(func() hash.Hash { return crc32.NewIEEE() }),
+	"hmac":       cryptoHmac,
+	"equal":      cryptoEqual,
+	"encrypt":    _nondet("crypto.encrypt", cryptoEncrypt),
+	"decrypt":    cryptoDecrypt,
+	"rand_bytes": _nondet("crypto.rand_bytes", cryptoRandBytes),
+
+	"keygen_ed25519": _nondet("crypto.keygen_ed25519", cryptoKeygenEd25519),
+	"sign":           cryptoSign,
+	"verify":         cryptoVerify,
+}
+
+var hashAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func OpenCryptoLib(ls LkState) int {
+	ls.NewLib(cryptoLib)
+	return 1
+}
+
+// crypto.md5 (data [, raw])
+// crypto.sha1 (data [, raw])
+// crypto.sha256 (data [, raw])
+// crypto.sha512 (data [, raw])
+// crypto.crc32 (data [, raw])
+// Returns the hash of data.
+func _hashFunc(newHash func() hash.Hash) GoFunction {
+	return func(ls LkState) int {
+		data := ls.CheckString(1)
+		h := newHash()
+		h.Write([]byte(data))
+		_pushSum(ls, h.Sum(nil), ls.ToBoolean(2))
+		return 1
+	}
+}
+
+// crypto.hmac (algo, key, data [, raw])
+// Returns the HMAC of data with key, where algo is
+// 'md5', 'sha1', 'sha256' or 'sha512'.
+func cryptoHmac(ls LkState) int {
+	algo := ls.CheckString(1)
+	key := ls.CheckString(2)
+	data := ls.CheckString(3)
+	newHash, ok := hashAlgos[algo]
+	ls.ArgCheck(ok, 1, "invalid algorithm '"+algo+"'")
+	mac := hmac.New(newHash, []byte(key))
+	mac.Write([]byte(data))
+	_pushSum(ls, mac.Sum(nil), ls.ToBoolean(4))
+	return 1
+}
+
+// crypto.equal (a, b)
+// Reports whether a and b are equal, in a time independent of their
+// contents: compare MACs with it, not with ==.
+func cryptoEqual(ls LkState) int {
+	a := ls.CheckString(1)
+	b := ls.CheckString(2)
+	ls.PushBoolean(subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1)
+	return 1
+}
+
+func _pushSum(ls LkState, sum []byte, raw bool) {
+	if raw {
+		ls.PushString(string(sum))
+	} else {
+		ls.PushString(hex.EncodeToString(sum))
+	}
+}
+
+// crypto.rand_bytes (n)
+// Returns n cryptographically secure random bytes:
+// crypto.rand_bytes(32) is a key for crypto.encrypt.
+func cryptoRandBytes(ls LkState) int {
+	n := ls.CheckInteger(1)
+	ls.ArgCheck(n >= 0, 1, "negative length")
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return ls.Error2("rand_bytes: %v", err)
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// crypto.encrypt (key, plaintext)
+// Encrypts plaintext with AES-256-GCM, where key is 32 bytes.
+// Returns the nonce followed by the sealed text, as raw bytes,
+// or nil and the error.
+func cryptoEncrypt(ls LkState) int {
+	aead := _checkAEAD(ls)
+	plaintext := ls.CheckString(2)
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return _pushResult(ls, "", err)
+	}
+	return _pushResult(ls, string(aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil)
+}
+
+// crypto.decrypt (key, ciphertext)
+// Decrypts a ciphertext of crypto.encrypt, checking that it was not
+// changed. Returns the plaintext, or nil and the error.
+func cryptoDecrypt(ls LkState) int {
+	aead := _checkAEAD(ls)
+	ciphertext := ls.CheckString(2)
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return _pushResult(ls, "", errors.New("ciphertext too short"))
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, []byte(nonce), []byte(sealed), nil)
+	return _pushResult(ls, string(plaintext), err)
+}
+
+// _checkAEAD returns the AES-256-GCM cipher of the key at 1.
+func _checkAEAD(ls LkState) cipher.AEAD {
+	key := ls.CheckString(1)
+	ls.ArgCheck(len(key) == 32, 1, "key must be 32 bytes")
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		ls.Error2("%v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		ls.Error2("%v", err)
+	}
+	return aead
+}
+
+// crypto.keygen_ed25519 ()
+// Returns a new Ed25519 public key and private key, in hex,
+// like the ones of lk keygen.
+func cryptoKeygenEd25519(ls LkState) int {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return ls.Error2("keygen_ed25519: %v", err)
+	}
+	ls.PushString(hex.EncodeToString(pub))
+	ls.PushString(hex.EncodeToString(priv))
+	return 2
+}
+
+// crypto.sign (priv, msg [, raw])
+// Returns the Ed25519 signature of msg by the private key priv.
+func cryptoSign(ls LkState) int {
+	priv := _checkBytes(ls, 1, ed25519.PrivateKeySize, "private key")
+	msg := ls.CheckString(2)
+	_pushSum(ls, ed25519.Sign(priv, []byte(msg)), ls.ToBoolean(3))
+	return 1
+}
+
+// crypto.verify (pub, msg, sig)
+// Reports whether sig is a valid Ed25519 signature of msg
+// by the public key pub.
+func cryptoVerify(ls LkState) int {
+	pub := _checkBytes(ls, 1, ed25519.PublicKeySize, "public key")
+	msg := ls.CheckString(2)
+	sig := ls.CheckString(3)
+	if len(sig) == 2*ed25519.SignatureSize {
+		if b, err := hex.DecodeString(sig); err == nil {
+			sig = string(b)
+		}
+	}
+	ls.PushBoolean(ed25519.Verify(pub, []byte(msg), []byte(sig)))
+	return 1
+}
+
+// _checkBytes returns the arg of size bytes, raw or in hex.
+func _checkBytes(ls LkState, arg, size int, name string) []byte {
+	s := ls.CheckString(arg)
+	switch len(s) {
+	case size:
+		return []byte(s)
+	case 2 * size:
+		if b, err := hex.DecodeString(s); err == nil {
+			return b
+		}
+	}
+	ls.ArgError(arg, fmt.Sprintf("invalid %s, expected %d bytes", name, size))
+	return nil
+}
@@ -0,0 +1,283 @@
+package stdlib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// opts of the csv functions:
+//   - header: true if the first row names the columns: rows are then
+//     maps of the column names to the values, instead of lists.
+//     For csv.encode, the list of the columns.
+//   - sep: the separator, ',' by default
+//   - comment: lines starting with it are ignored
+
+var csvLib = map[string]GoFunction{
+	"parse":  csvParse,
+	"encode": csvEncode,
+	"reader": csvReader,
+}
+
+type csvOpts struct {
+	header  bool
+	columns []string // header of csv.encode
+	sep     rune
+	comment rune
+}
+
+func OpenCsvLib(ls LkState) int {
+	ls.NewLib(csvLib)
+	return 1
+}
+
+// csv.parse (s [, opts])
+// Returns the rows of the CSV s, or nil and the error.
+func csvParse(ls LkState) int {
+	s := ls.CheckString(1)
+	opts := _csvOpts(ls, 2)
+	r := opts.reader(strings.NewReader(s))
+	records, err := r.ReadAll()
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	var header []string
+	if opts.header && len(records) > 0 {
+		header, records = records[0], records[1:]
+	}
+	ls.CreateTable(len(records), 0)
+	for i, record := range records {
+		_pushCsvRow(ls, record, header)
+		ls.SetI(-2, int64(i))
+	}
+	ls.PushNil()
+	return 2
+}
+
+// csv.reader (file [, opts])
+// for row in csv.reader('data.csv', {'header': true}) {}
+// Returns an iterator over the rows of file, a path or a file of io.open,
+// read one by one. A path is closed at the end.
+// Raises the read errors.
+func csvReader(ls LkState) int {
+	opts := _csvOpts(ls, 2)
+	var f *lkFile
+	autoClose := false
+	if ls.IsString(1) {
+		path := ls.ToString(1)
+		ls.Audit("io.open", path, "r")
+		of, err := os.Open(path)
+		if err != nil {
+			return ls.Error2("%s", err.Error())
+		}
+		f, autoClose = &lkFile{f: of}, true
+	} else {
+		f = _checkFile(ls)
+	}
+
+	r := opts.reader(f.reader())
+	var header []string
+	ls.PushGoFunction(func(ls LkState) int {
+		if f.closed {
+			if autoClose { /* called again after the end */
+				ls.PushNil()
+				return 1
+			}
+			return ls.Error2("file is already closed")
+		}
+		var record []string
+		var err error
+		read := func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			record, err = r.Read()
+			if err == nil && opts.header && header == nil {
+				header = record
+				record, err = r.Read()
+			}
+		}
+		if f.std {
+			_await(ls, read)
+		} else {
+			read()
+		}
+
+		if err == io.EOF {
+			if autoClose {
+				f.close()
+			}
+			ls.PushNil()
+			return 1
+		}
+		if err != nil {
+			return ls.Error2("%s", err.Error())
+		}
+		_pushCsvRow(ls, record, header)
+		return 1
+	})
+	return 1
+}
+
+// csv.encode (rows [, opts])
+// Returns the CSV of rows, lists or maps, or nil and the error.
+// For maps, the header is written first: opts.header or,
+// by default, all the keys of the rows, sorted.
+func csvEncode(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	opts := _csvOpts(ls, 2)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if opts.sep != 0 {
+		w.Comma = opts.sep
+	}
+
+	n := ls.Len2(1)
+	columns := opts.columns
+	for i := int64(0); i < n && columns == nil; i++ {
+		if ls.GetI(1, i) == LK_TTABLE && !_isCsvList(ls, -1) {
+			columns = _csvKeys(ls, 1, n)
+		}
+		ls.Pop(1)
+	}
+	var err error
+	if columns != nil {
+		err = w.Write(columns)
+	}
+	for i := int64(0); i < n && err == nil; i++ {
+		if ls.GetI(1, i) != LK_TTABLE {
+			return ls.Error2("row %d is not a table", i)
+		}
+		var record []string
+		if _isCsvList(ls, -1) {
+			for j := int64(0); j < ls.Len2(-1); j++ {
+				ls.GetI(-1, j)
+				record = append(record, _csvValue(ls))
+			}
+		} else {
+			for _, col := range columns {
+				ls.GetField(-1, col)
+				record = append(record, _csvValue(ls))
+			}
+		}
+		ls.Pop(1)
+		err = w.Write(record)
+	}
+	if w.Flush(); err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(buf.String())
+	ls.PushNil()
+	return 2
+}
+
+func _csvOpts(ls LkState, arg int) *csvOpts {
+	opts := &csvOpts{}
+	if ls.IsNoneOrNil(arg) {
+		return opts
+	}
+	ls.CheckType(arg, LK_TTABLE)
+	switch ls.GetField(arg, "header") {
+	case LK_TBOOLEAN:
+		opts.header = ls.ToBoolean(-1)
+	case LK_TTABLE:
+		opts.header = true
+		for i := int64(0); i < ls.Len2(-1); i++ {
+			ls.GetI(-1, i)
+			opts.columns = append(opts.columns, ls.ToString(-1))
+			ls.Pop(1)
+		}
+	}
+	ls.Pop(1)
+	for _, field := range []string{"sep", "comment"} {
+		ls.GetField(arg, field)
+		if !ls.IsNil(-1) {
+			s, _ := ls.ToStringX(-1)
+			c, size := utf8.DecodeRuneInString(s)
+			if size == 0 || size != len(s) {
+				ls.Error2("field '%s' is not a character", field)
+			}
+			if field == "sep" {
+				opts.sep = c
+			} else {
+				opts.comment = c
+			}
+		}
+		ls.Pop(1)
+	}
+	return opts
+}
+
+func (opts *csvOpts) reader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	if opts.sep != 0 {
+		cr.Comma = opts.sep
+	}
+	cr.Comment = opts.comment
+	cr.FieldsPerRecord = -1 /* rows may have different lengths */
+	return cr
+}
+
+// _pushCsvRow pushes record as a list, or as a map with header.
+// Missing values are nil, extra ones are dropped.
+func _pushCsvRow(ls LkState, record, header []string) {
+	if header == nil {
+		pushList(ls, record)
+		return
+	}
+	ls.CreateTable(0, len(header))
+	for i, col := range header {
+		if i < len(record) {
+			ls.PushString(record[i])
+			ls.SetField(-2, col)
+		}
+	}
+}
+
+// _isCsvList reports whether the row at idx is a list.
+func _isCsvList(ls LkState, idx int) bool {
+	defer ls.Pop(1)
+	return ls.GetI(idx, 0) != LK_TNIL
+}
+
+// _csvKeys returns the sorted keys of the n map rows at idx.
+func _csvKeys(ls LkState, idx int, n int64) []string {
+	seen := map[string]bool{}
+	for i := int64(0); i < n; i++ {
+		if ls.GetI(idx, i) == LK_TTABLE && !_isCsvList(ls, -1) {
+			for k := range getTable(ls, ls.GetTop()) {
+				seen[k] = true
+			}
+		}
+		ls.Pop(1)
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// _csvValue pops a value and returns it as a string, empty for nil.
+func _csvValue(ls LkState) string {
+	defer ls.Pop(1)
+	if ls.IsNil(-1) {
+		return ""
+	}
+	s := ls.ToString2(-1)
+	ls.Pop(1)
+	return s
+}
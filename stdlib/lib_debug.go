@@ -0,0 +1,190 @@
+package stdlib
+
+import (
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+var debugLib = map[string]GoFunction{
+	"get_info":    dbgGetInfo,
+	"get_local":   dbgGetLocal,
+	"get_upvalue": dbgGetUpvalue,
+	"set_upvalue": dbgSetUpvalue,
+	"set_hook":    dbgSetHook,
+	"get_hook":    dbgGetHook,
+	"traceback":   dbgTraceback,
+}
+
+// registry key of the function set by debug.set_hook
+const hookKey = "_HOOKKEY"
+
+var hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+func OpenDebugLib(ls LkState) int {
+	ls.NewLib(debugLib)
+	return 1
+}
+
+// debug.get_info ([level])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+func dbgGetInfo(ls LkState) int {
+	level := ls.OptInteger(1, 1)
+	info, ok := ls.GetStackInfo(int(level))
+	if !ok { /* level out of range */
+		ls.PushNil()
+		return 1
+	}
+	pushTable(ls, lkMap{
+		"source":            info.Source,
+		"line":              info.CurrentLine,
+		"line_defined":      info.LineDefined,
+		"last_line_defined": info.LastLineDefined,
+		"what":              info.What,
+		"params":            info.NumParams,
+		"vararg":            info.IsVararg,
+		"upvalues":          info.NumUpvalues,
+	})
+	return 1
+}
+
+// debug.traceback ([msg [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// msg is returned untouched if it is neither a string nor nil.
+func dbgTraceback(ls LkState) int {
+	if !ls.IsNoneOrNil(1) && !ls.IsString(1) {
+		ls.SetTop(1)
+		return 1
+	}
+	msg := ls.OptString(1, "")
+	level := ls.OptInteger(2, 1)
+	ls.PushString(ls.Traceback(msg, int(level)))
+	return 1
+}
+
+// debug.get_local (level, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+func dbgGetLocal(ls LkState) int {
+	level := ls.CheckInteger(1)
+	n := ls.CheckInteger(2)
+	if _, ok := ls.GetStackInfo(int(level)); !ok {
+		return ls.ArgError(1, "level out of range")
+	}
+	name, ok := ls.GetLocal(int(level), int(n))
+	if !ok {
+		ls.PushNil()
+		return 1
+	}
+	ls.PushString(name)
+	ls.Insert(-2)
+	return 2 /* return name + value */
+}
+
+// debug.get_upvalue (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+func dbgGetUpvalue(ls LkState) int {
+	ls.CheckType(1, LK_TFUNCTION)
+	n := ls.CheckInteger(2)
+	name, ok := ls.GetUpvalue(1, int(n))
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	ls.Insert(-2)
+	return 2 /* return name + value */
+}
+
+// debug.set_upvalue (f, n, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+func dbgSetUpvalue(ls LkState) int {
+	ls.CheckType(1, LK_TFUNCTION)
+	n := ls.CheckInteger(2)
+	ls.CheckAny(3)
+	ls.SetTop(3)
+	name, ok := ls.GetUpvalue(1, int(n))
+	if !ok {
+		return 0
+	}
+	ls.Pop(1)
+	ls.SetUpvalue(1, int(n))
+	ls.PushString(name)
+	return 1
+}
+
+// debug.set_hook ([f [, mask [, count]]])
+// mask is made of 'c' (call), 'r' (return) and 'l' (line)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
+func dbgSetHook(ls LkState) int {
+	if ls.IsNoneOrNil(1) { /* no hook? */
+		ls.SetTop(1)
+		ls.SetField(LK_REGISTRYINDEX, hookKey)
+		ls.SetHook(0, 0, nil) /* turn off hooks */
+		return 0
+	}
+
+	ls.CheckType(1, LK_TFUNCTION)
+	smask := ls.OptString(2, "")
+	count := int(ls.OptInteger(3, 0))
+	mask := 0
+	if strings.ContainsRune(smask, 'c') {
+		mask |= LK_MASKCALL
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= LK_MASKRET
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= LK_MASKLINE
+	}
+	if count > 0 {
+		mask |= LK_MASKCOUNT
+	}
+
+	ls.PushValue(1)
+	ls.SetField(LK_REGISTRYINDEX, hookKey)
+	ls.SetHook(mask, count, _hookF)
+	return 0
+}
+
+// debug.get_hook ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
+func dbgGetHook(ls LkState) int {
+	mask := ls.GetHookMask()
+	if ls.GetHook() == nil { /* no hook? */
+		ls.PushNil()
+		return 1
+	}
+	if mask == 0 || ls.GetField(LK_REGISTRYINDEX, hookKey) != LK_TFUNCTION {
+		ls.PushString("external hook") /* hook set by the host */
+	}
+
+	smask := ""
+	if mask&LK_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&LK_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&LK_MASKLINE != 0 {
+		smask += "l"
+	}
+	ls.PushString(smask)
+	ls.PushInteger(int64(ls.GetHookCount()))
+	return 3
+}
+
+// calls the lk hook function with the event name and the current line
+// lua-5.3.4/src/ldblib.c#hookf()
+func _hookF(ls LkState, event, line int) {
+	if ls.GetField(LK_REGISTRYINDEX, hookKey) != LK_TFUNCTION {
+		return
+	}
+	ls.PushString(hookNames[event])
+	if line >= 0 {
+		ls.PushInteger(int64(line))
+	} else {
+		ls.PushNil()
+	}
+	ls.Call(2, 0)
+}
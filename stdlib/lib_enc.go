@@ -0,0 +1,104 @@
+package stdlib
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// The decode functions return the decoded string, or nil and the error.
+
+var encLib = map[string]GoFunction{
+	"b64_encode":    encB64Encode,
+	"b64_decode":    encB64Decode,
+	"hex_encode":    encHexEncode,
+	"hex_decode":    encHexDecode,
+	"url_encode":    encUrlEncode,
+	"url_decode":    encUrlDecode,
+	"html_escape":   encHtmlEscape,
+	"html_unescape": encHtmlUnescape,
+}
+
+func OpenEncLib(ls LkState) int {
+	ls.NewLib(encLib)
+	return 1
+}
+
+// enc.b64_encode (s [, url])
+// Returns s in base64, with the URL-safe alphabet if url is true.
+func encB64Encode(ls LkState) int {
+	s := ls.CheckString(1)
+	if ls.ToBoolean(2) {
+		ls.PushString(base64.URLEncoding.EncodeToString([]byte(s)))
+	} else {
+		ls.PushString(base64.StdEncoding.EncodeToString([]byte(s)))
+	}
+	return 1
+}
+
+// enc.b64_decode (s [, url])
+// Decodes s from base64, with the URL-safe alphabet if url is true.
+// The padding is optional.
+func encB64Decode(ls LkState) int {
+	s := strings.TrimRight(ls.CheckString(1), "=")
+	enc := base64.RawStdEncoding
+	if ls.ToBoolean(2) {
+		enc = base64.RawURLEncoding
+	}
+	data, err := enc.DecodeString(s)
+	return _pushResult(ls, string(data), err)
+}
+
+// enc.hex_encode (s)
+func encHexEncode(ls LkState) int {
+	ls.PushString(hex.EncodeToString([]byte(ls.CheckString(1))))
+	return 1
+}
+
+// enc.hex_decode (s)
+func encHexDecode(ls LkState) int {
+	data, err := hex.DecodeString(ls.CheckString(1))
+	return _pushResult(ls, string(data), err)
+}
+
+// enc.url_encode (s)
+// Returns s escaped for a query string, or, if s is a table,
+// the query string of its fields, sorted by key: 'a=1&b=x+y'.
+func encUrlEncode(ls LkState) int {
+	if !ls.IsTable(1) {
+		ls.PushString(url.QueryEscape(ls.CheckString(1)))
+		return 1
+	}
+	query := url.Values{}
+	for k, v := range getTable(ls, 1) {
+		query.Set(k, fmt.Sprint(v))
+	}
+	ls.PushString(query.Encode())
+	return 1
+}
+
+// enc.url_decode (s)
+// Unescapes s, from a query string.
+func encUrlDecode(ls LkState) int {
+	s, err := url.QueryUnescape(ls.CheckString(1))
+	return _pushResult(ls, s, err)
+}
+
+// enc.html_escape (s)
+// Escapes <, >, &, ' and " in s.
+func encHtmlEscape(ls LkState) int {
+	ls.PushString(html.EscapeString(ls.CheckString(1)))
+	return 1
+}
+
+// enc.html_unescape (s)
+// Unescapes the entities in s, like &lt; or &#39;.
+func encHtmlUnescape(ls LkState) int {
+	ls.PushString(html.UnescapeString(ls.CheckString(1)))
+	return 1
+}
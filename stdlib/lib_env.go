@@ -0,0 +1,171 @@
+package stdlib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+var envLib = map[string]GoFunction{
+	"all":    _nondet("env.all", envAll),
+	"load":   envLoad,
+	"expand": _nondet("env.expand", envExpand),
+}
+
+func OpenEnvLib(ls LkState) int {
+	ls.NewLib(envLib)
+	return 1
+}
+
+// env.all ()
+// Returns a table of all the environment variables.
+func envAll(ls LkState) int {
+	ls.Audit("env.all")
+	vars := lkMap{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" {
+			vars[k] = v
+		}
+	}
+	pushTable(ls, vars)
+	return 1
+}
+
+// env.expand (s)
+// Returns s with $VAR and ${VAR} replaced by the values of the
+// environment variables, or '' for the unset ones.
+func envExpand(ls LkState) int {
+	s := ls.CheckString(1)
+	ls.Audit("env.expand", s)
+	ls.PushString(os.ExpandEnv(s))
+	return 1
+}
+
+// env.load ([path [, override]])
+// Sets the environment variables of the dotenv file path (default '.env').
+// Unless override is true, variables already set are kept.
+// Returns a table of the variables of the file, or nil and the error.
+func envLoad(ls LkState) int {
+	path := ls.OptString(1, ".env")
+	override := ls.OptBool(2, false)
+	ls.Audit("env.load", path)
+	f, err := os.Open(path)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	defer f.Close()
+	vars, err := _parseDotenv(f)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(fmt.Sprintf("%s:%v", path, err))
+		return 2
+	}
+
+	loaded := lkMap{}
+	for _, kv := range vars {
+		loaded[kv[0]] = kv[1]
+		if _, set := os.LookupEnv(kv[0]); set && !override {
+			continue
+		}
+		os.Setenv(kv[0], kv[1])
+	}
+	pushTable(ls, loaded)
+	ls.PushNil()
+	return 2
+}
+
+// _parseDotenv returns the KEY=value pairs of a dotenv file, in order.
+// Lines may start with export, and # starts a comment.
+// Values may be quoted: in double quotes, \n, \t, \", \\ are escaped and
+// variables expanded, as in unquoted values; single quotes keep the
+// value as is. A quoted value may span many lines.
+func _parseDotenv(f *os.File) ([][2]string, error) {
+	vars := [][2]string{}
+	values := map[string]string{} /* for expansion */
+	lookup := func(k string) string {
+		if v, ok := values[k]; ok {
+			return v
+		}
+		return os.Getenv(k)
+	}
+
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%d: invalid line", lineNo)
+		}
+		value = strings.TrimSpace(value)
+
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			quote := value[0]
+			value = value[1:]
+			/* read up to the closing quote, maybe on a next line */
+			for !_hasClosingQuote(value, quote) {
+				if !sc.Scan() {
+					return nil, fmt.Errorf("%d: unterminated quote", lineNo)
+				}
+				lineNo++
+				value += "\n" + sc.Text()
+			}
+			end := _closingQuote(value, quote)
+			rest := strings.TrimSpace(value[end+1:])
+			if rest != "" && rest[0] != '#' {
+				return nil, fmt.Errorf("%d: unexpected %q after the value", lineNo, rest)
+			}
+			value = value[:end]
+			if quote == '"' {
+				value = os.Expand(_unescapeDotenv(value), lookup)
+			}
+		} else {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			value = os.Expand(value, lookup)
+		}
+		values[key] = value
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, sc.Err()
+}
+
+func _hasClosingQuote(s string, quote byte) bool {
+	return _closingQuote(s, quote) >= 0
+}
+
+// _closingQuote returns the index of the quote closing s, or -1.
+// In double quotes, a quote may be escaped.
+func _closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func _unescapeDotenv(s string) string {
+	return strings.NewReplacer(
+		`\n`, "\n",
+		`\t`, "\t",
+		`\r`, "\r",
+		`\"`, `"`,
+		`\\`, `\`,
+	).Replace(s)
+}
@@ -0,0 +1,148 @@
+package stdlib
+
+import (
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+var eventsLib = map[string]GoFunction{
+	"on":       evOn,
+	"off":      evOff,
+	"emit":     evEmit,
+	"dispatch": evDispatch,
+	"wait":     evWait,
+}
+
+// registry key of the handlers: event name -> list of functions
+const eventsKey = "_EVENTS"
+
+func OpenEventsLib(ls LkState) int {
+	ls.NewLib(eventsLib)
+	return 1
+}
+
+// pushes the list of handlers of name, creating it if needed
+func _handlers(ls LkState, name string) {
+	ls.GetSubTable(LK_REGISTRYINDEX, eventsKey)
+	ls.GetSubTable(-1, name)
+	ls.Remove(-2)
+}
+
+// events.on (name, f)
+func evOn(ls LkState) int {
+	name := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	_handlers(ls, name)
+	ls.PushValue(2)
+	ls.SetI(-2, ls.Len2(-2))
+	return 0
+}
+
+// events.off (name [, f])
+// Without f, removes all the handlers of name.
+func evOff(ls LkState) int {
+	name := ls.CheckString(1)
+	if ls.IsNoneOrNil(2) {
+		ls.GetSubTable(LK_REGISTRYINDEX, eventsKey)
+		ls.PushNil()
+		ls.SetField(-2, name)
+		return 0
+	}
+	ls.CheckType(2, LK_TFUNCTION)
+	_handlers(ls, name)
+	list := ls.GetTop()
+	n := ls.Len2(list)
+	ls.CreateTable(int(n), 0)
+	kept := int64(0)
+	for i := int64(0); i < n; i++ {
+		ls.GetI(list, i)
+		if ls.Compare(-1, 2, LK_OPEQ) {
+			ls.Pop(1)
+			continue
+		}
+		ls.SetI(-2, kept)
+		kept++
+	}
+	ls.GetSubTable(LK_REGISTRYINDEX, eventsKey)
+	ls.Insert(-2)
+	ls.SetField(-2, name)
+	return 0
+}
+
+// events.emit (name, ···)
+// Runs the handlers of name now, with the other arguments.
+func evEmit(ls LkState) int {
+	name := ls.CheckString(1)
+	nArgs := ls.GetTop() - 1
+	_callHandlers(ls, name, nArgs, func() {
+		for i := 2; i <= nArgs+1; i++ {
+			ls.PushValue(i)
+		}
+	})
+	return 0
+}
+
+// events.dispatch ()
+// Runs the handlers of the events emitted by the host, returns their number.
+func evDispatch(ls LkState) int {
+	ls.PushInteger(int64(DispatchEvents(ls)))
+	return 1
+}
+
+// events.wait ([timeout])
+// Waits up to timeout ms (forever if omitted) for events emitted by the host,
+// then dispatches them. Returns the number of events handled.
+func evWait(ls LkState) int {
+	timeout := time.Duration(ls.OptInteger(1, 0)) * time.Millisecond
+	if !ls.WaitEvent(timeout) {
+		ls.PushInteger(0)
+		return 1
+	}
+	return evDispatch(ls)
+}
+
+// DispatchEvents runs the handlers of the queued events
+// and returns the number of events handled.
+func DispatchEvents(ls LkState) int {
+	n := 0
+	for {
+		name, args, ok := ls.PollEvent()
+		if !ok {
+			return n
+		}
+		HandleEvent(ls, name, args)
+		n++
+	}
+}
+
+// HandleEvent calls the handlers of name with args.
+func HandleEvent(ls LkState, name string, args []any) {
+	_callHandlers(ls, name, len(args), func() {
+		for _, arg := range args {
+			pushValue(ls, arg)
+		}
+	})
+}
+
+// _callHandlers calls each handler of name with the nArgs values
+// pushed by pushArgs. Handlers added or removed meanwhile
+// take effect at the next event.
+func _callHandlers(ls LkState, name string, nArgs int, pushArgs func()) {
+	_handlers(ls, name)
+	list := ls.GetTop()
+	n := ls.Len2(list)
+	ls.CreateTable(int(n), 0) /* copy, as handlers may call on/off */
+	for i := int64(0); i < n; i++ {
+		ls.GetI(list, i)
+		ls.SetI(-2, i)
+	}
+	handlers := ls.GetTop()
+	for i := int64(0); i < n; i++ {
+		ls.CheckStack(nArgs + 1)
+		ls.GetI(handlers, i)
+		pushArgs()
+		ls.Call(nArgs, 0)
+	}
+	ls.Pop(2)
+}
@@ -1,19 +1,56 @@
 package stdlib
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"strings"
+	"sync"
+	"time"
 
-	http_ "github.com/lollipopkit/gommon/http"
 	. "github.com/lollipopkit/lk/api"
+	. "github.com/lollipopkit/lk/json"
 )
 
+// the request being handled by a function of http.listen,
+// for the functions taking its req table, like ws.upgrade
+type httpExchange struct {
+	w        http.ResponseWriter
+	r        *http.Request
+	req      lkMap
+	replied  bool          // the results of the function are ignored
+	upgraded io.Closer     // closed once the function returns
+	done     chan struct{} // closed once answered
+}
+
 var (
+	// req table -> *httpExchange
+	httpExchanges sync.Map
+
 	client  = http.Client{}
 	httpLib = map[string]GoFunction{
-		"req":    httpReq,
-		"listen": httpListen,
+		"req":        _nondet("http.req", httpReq),
+		"get":        _nondet("http.get", _httpMethod(http.MethodGet)),
+		"post":       _nondet("http.post", _httpMethod(http.MethodPost)),
+		"put":        _nondet("http.put", _httpMethod(http.MethodPut)),
+		"delete":     _nondet("http.delete", _httpMethod(http.MethodDelete)),
+		"get_json":   _nondet("http.get_json", _httpJson(http.MethodGet)),
+		"post_json":  _nondet("http.post_json", _httpJson(http.MethodPost)),
+		"put_json":   _nondet("http.put_json", _httpJson(http.MethodPut)),
+		"upload":     _nondet("http.upload", httpUpload),
+		"download":   _nondet("http.download", httpDownload),
+		"listen":     httpListen,
+		"listen_tls": httpListenTls,
+		"static":     httpStatic,
+		"response":   httpResponse,
+		"session":    httpSession,
+		"chain":      httpChain,
+		"logger":     httpLogger,
+		"recover":    httpRecover,
+		"cors":       httpCors,
 	}
 )
 
@@ -22,61 +59,302 @@ func OpenHttpLib(ls LkState) int {
 	return 1
 }
 
+// http.req (method, url [, headers [, body [, opts]]])
+// Sends the request, with the options:
+// timeout, in ms, of the whole request (default none);
+// follow_redirects (default true);
+// proxy, the url of the proxy (default from the environment);
+// user and password, for basic authentication;
+// max_body, the size in bytes over which the response is an error;
+// and the tls options, as tls.dial.
+// Returns the body, the status code, nil and the response headers,
+// or nil, the status code (0 if none) and the error.
 func httpReq(ls LkState) int {
 	method := strings.ToUpper(ls.CheckString(1))
 	url := ls.CheckString(2)
-	headers := make(map[string]string)
-	ls.PushNil()
-	for ls.Next(3) {
-		key := ls.ToString(-2)
-		val := ls.ToString(-1)
-		headers[key] = val
+	headers := _checkStrings(ls, 3)
+	opts := _checkHttpOpts(ls, 5)
+	var body io.Reader
+	if !ls.IsNoneOrNil(4) {
+		// Always convert body to string
+		body = strings.NewReader(ls.ToString2(4))
 		ls.Pop(1)
 	}
+	resp, err := _httpDo(ls, method, url, headers, body, opts)
+	if err != nil {
+		return _pushHttpErr(ls, resp, err)
+	}
+	return _pushHttpResp(ls, string(resp.body), resp)
+}
+
+// http.get (url [, headers [, opts]])
+// http.delete (url [, headers [, opts]])
+// http.post (url, body [, headers [, opts]])
+// http.put (url, body [, headers [, opts]])
+// As http.req with the method.
+func _httpMethod(method string) GoFunction {
+	hasBody := method == http.MethodPost || method == http.MethodPut
+	return func(ls LkState) int {
+		url := ls.CheckString(1)
+		arg := 2
+		var body io.Reader
+		if hasBody {
+			body = strings.NewReader(ls.CheckString(2))
+			arg = 3
+		}
+		resp, err := _httpDo(ls, method, url, _checkStrings(ls, arg), body, _checkHttpOpts(ls, arg+1))
+		if err != nil {
+			return _pushHttpErr(ls, resp, err)
+		}
+		return _pushHttpResp(ls, string(resp.body), resp)
+	}
+}
 
-	// Always convert body to string
-	data, code, err := http_.Do(method, url, ls.ToString2(4), headers)
+// http.get_json (url [, headers [, opts]])
+// http.post_json (url, value [, headers [, opts]])
+// http.put_json (url, value [, headers [, opts]])
+// As http.req with the method, sending value encoded in json.
+// Returns the response decoded from json (nil if empty), the status code,
+// nil and the response headers, or nil, the status code (0 if none)
+// and the error.
+func _httpJson(method string) GoFunction {
+	hasBody := method != http.MethodGet
+	return func(ls LkState) int {
+		url := ls.CheckString(1)
+		arg := 2
+		var body io.Reader
+		if hasBody {
+			v, err := getValue(ls, 2)
+			if err != nil {
+				return ls.ArgError(2, err.Error())
+			}
+			data, err := Json.Marshal(v)
+			if err != nil {
+				return ls.ArgError(2, err.Error())
+			}
+			body = bytes.NewReader(data)
+			arg = 3
+		}
+		headers := _checkStrings(ls, arg)
+		_setDefault(headers, "Accept", "application/json")
+		if hasBody {
+			_setDefault(headers, "Content-Type", "application/json")
+		}
+		resp, err := _httpDo(ls, method, url, headers, body, _checkHttpOpts(ls, arg+1))
+		var v any
+		if err == nil && len(bytes.TrimSpace(resp.body)) > 0 {
+			err = Json.Unmarshal(resp.body, &v)
+		}
+		if err != nil {
+			return _pushHttpErr(ls, resp, err)
+		}
+		return _pushHttpResp(ls, v, resp)
+	}
+}
+
+// _sendReq returns the response to the request, whose body is to be read.
+func _sendReq(ctx context.Context, c *http.Client, method, url string, body io.Reader, headers map[string]string, opts httpOpts) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		ls.PushNil()
-		ls.Push(code)
-		ls.PushString(err.Error())
-		return 3
+		return nil, err
+	}
+	if s, ok := body.(*sizedReader); ok {
+		req.ContentLength = s.size
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
+	if opts.user != "" {
+		req.SetBasicAuth(opts.user, opts.password)
+	}
+	return c.Do(req)
+}
 
-	ls.PushString(string(data))
-	ls.Push(code)
+// _httpDo sends the request of http.req. The response is never nil.
+func _httpDo(ls LkState, method, url string, headers map[string]string, body io.Reader, opts httpOpts) (*httpResp, error) {
+	ls.Audit("http.req", method, url)
+	c, err := opts.client()
+	if err != nil {
+		return &httpResp{}, err
+	}
+	ctx := ls.Context()
+	var resp *httpResp
+	_await(ls, func() {
+		resp, err = _doReq(ctx, c, method, url, body, headers, opts)
+	})
+	return resp, err
+}
+
+func _pushHttpResp(ls LkState, body any, resp *httpResp) int {
+	pushValue(ls, body)
+	ls.PushInteger(int64(resp.code))
 	ls.PushNil()
+	pushTable(ls, genHeaderMap(&resp.header))
+	return 4
+}
+
+func _pushHttpErr(ls LkState, resp *httpResp, err error) int {
+	ls.PushNil()
+	ls.PushInteger(int64(resp.code))
+	ls.PushString(err.Error())
 	return 3
 }
 
-// eg:
-// http.listen(addr, fn(req) {rt code, data})
-// return err
+// _checkStrings returns the table of strings at arg, if any, like headers.
+func _checkStrings(ls LkState, arg int) map[string]string {
+	m := make(map[string]string)
+	if ls.IsNoneOrNil(arg) {
+		return m
+	}
+	ls.CheckType(arg, LK_TTABLE)
+	ls.PushNil()
+	for ls.Next(arg) {
+		key := ls.ToString(-2)
+		val := ls.ToString(-1)
+		m[key] = val
+		ls.Pop(1)
+	}
+	return m
+}
+
+// _setDefault sets the header key to v, unless it is set.
+func _setDefault(headers map[string]string, key, v string) {
+	for k := range headers {
+		if strings.EqualFold(k, key) {
+			return
+		}
+	}
+	headers[key] = v
+}
+
+// the options of http.req
+type httpOpts struct {
+	tls            tlsOpts
+	timeout        int64
+	noRedirects    bool
+	proxy          string
+	user, password string
+	maxBody        int64
+}
+
+type httpResp struct {
+	code   int
+	header http.Header
+	body   []byte
+}
+
+// _checkHttpOpts returns the options of http.req at arg, if any.
+func _checkHttpOpts(ls LkState, arg int) httpOpts {
+	if ls.IsNoneOrNil(arg) {
+		return httpOpts{}
+	}
+	ls.CheckType(arg, LK_TTABLE)
+	m := getTable(ls, arg)
+	opts := httpOpts{
+		tls:      _tlsOptsOf(ls, m),
+		timeout:  _optField[int64](ls, m, "timeout", "integer"),
+		proxy:    _optField[string](ls, m, "proxy", "str"),
+		user:     _optField[string](ls, m, "user", "str"),
+		password: _optField[string](ls, m, "password", "str"),
+		maxBody:  _optField[int64](ls, m, "max_body", "integer"),
+	}
+	if m["follow_redirects"] != nil {
+		opts.noRedirects = !_optField[bool](ls, m, "follow_redirects", "bool")
+	}
+	return opts
+}
+
+// client returns the client sending the requests with o.
+func (o httpOpts) client() (*http.Client, error) {
+	if o.tls.isZero() && o.timeout == 0 && !o.noRedirects && o.proxy == "" {
+		return &client, nil
+	}
+	c := &http.Client{Timeout: time.Duration(o.timeout) * time.Millisecond}
+	if o.noRedirects {
+		c.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if !o.tls.isZero() || o.proxy != "" {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if !o.tls.isZero() {
+			cfg, err := o.tls.config(false)
+			if err != nil {
+				return nil, err
+			}
+			t.TLSClientConfig = cfg
+		}
+		if o.proxy != "" {
+			u, err := neturl.Parse(o.proxy)
+			if err != nil {
+				return nil, err
+			}
+			t.Proxy = http.ProxyURL(u)
+		}
+		c.Transport = t
+	}
+	return c, nil
+}
+
+// _doReq is canceled with ctx, so a blocking request can be interrupted.
+// The response is never nil.
+func _doReq(ctx context.Context, c *http.Client, method, url string, body io.Reader, headers map[string]string, opts httpOpts) (*httpResp, error) {
+	resp := &httpResp{}
+	r, err := _sendReq(ctx, c, method, url, body, headers, opts)
+	if err != nil {
+		return resp, err
+	}
+	defer r.Body.Close()
+	resp.code, resp.header = r.StatusCode, r.Header
+	var rd io.Reader = r.Body
+	if opts.maxBody > 0 {
+		rd = io.LimitReader(r.Body, opts.maxBody+1)
+	}
+	if resp.body, err = io.ReadAll(rd); err != nil {
+		return resp, err
+	}
+	if opts.maxBody > 0 && int64(len(resp.body)) > opts.maxBody {
+		resp.body = nil
+		return resp, fmt.Errorf("body larger than %d bytes", opts.maxBody)
+	}
+	return resp, nil
+}
+
+// http.listen (addr, f)
+// Serves http on addr ('host:port', port 0 for any): in a task run by
+// sync.run, f(req) is called for each request, with the table of its
+// method, url, headers, cookies (name -> value) and body.
+// f returns the code and the body of the response, or a response table,
+// see http_server.go.
+// The requests f raises an error for are answered 500.
+// Returns the server, or nil and the error.
 func httpListen(ls LkState) int {
 	addr := ls.CheckString(1)
 	ls.CheckType(2, LK_TFUNCTION)
-	err := http.ListenAndServe(addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		req, err := genReqTable(r)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
-		}
-		ls.PushValue(-1)
-		pushTable(ls, req)
-		ls.Call(1, 2)
-		code := ls.ToInteger(-2)
-		data := ls.ToString(-1)
-		w.WriteHeader(int(code))
-		w.Write([]byte(data))
-		ls.Pop(2)
-	}))
+	ls.Audit("http.listen", addr)
+	ls.SetTop(2)
+	return _httpServe(ls, addr, nil)
+}
+
+// http.listen_tls (addr, cert, key, f [, ca])
+// As http.listen, over https: cert and key are the certificate of the
+// server and its private key, each the path of a pem file, or pem data.
+// With ca, the clients must have a certificate signed by it.
+func httpListenTls(ls LkState) int {
+	addr := ls.CheckString(1)
+	opts := tlsOpts{cert: ls.CheckString(2), key: ls.CheckString(3), ca: ls.OptString(5, "")}
+	ls.CheckType(4, LK_TFUNCTION)
+	ls.Audit("http.listen_tls", addr)
+	cfg, err := opts.config(true)
 	if err != nil {
+		ls.PushNil()
 		ls.PushString(err.Error())
-		return 1
+		return 2
 	}
-	ls.PushNil()
-	return 1
+	ls.PushValue(4)
+	ls.Replace(2)
+	ls.SetTop(2)
+	return _httpServe(ls, addr, cfg)
 }
 
 func genHeaderMap(h *http.Header) lkMap {
@@ -94,10 +372,17 @@ func genReqTable(r *http.Request) (lkMap, error) {
 		return nil, err
 	}
 	headers := genHeaderMap(&r.Header)
+	cookies := lkMap{}
+	for _, c := range r.Cookies() {
+		if cookies[c.Name] == nil {
+			cookies[c.Name] = c.Value
+		}
+	}
 	return lkMap{
 		"method":  r.Method,
 		"url":     r.URL.String(),
 		"headers": headers,
+		"cookies": cookies,
 		"body":    string(body),
 	}, nil
 }
@@ -0,0 +1,368 @@
+package stdlib
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// registry key of the metatable of files
+const fileMeta = "_FILE"
+
+// lkFile is a file opened by io.open, or a standard file.
+// Reads are buffered; writes are not, so they never need flushing
+// and interleave with print.
+type lkFile struct {
+	mu     sync.Mutex // files are shared by parallel states
+	f      *os.File
+	r      *bufio.Reader // nil until the first read
+	std    bool          // io.stdin, io.stdout or io.stderr
+	closed bool
+}
+
+var ioLib = map[string]GoFunction{
+	"open":  ioOpen,
+	"lines": ioLines,
+}
+
+var fileMethods = map[string]GoFunction{
+	"read":   fileRead,
+	"write":  fileWrite,
+	"seek":   fileSeek,
+	"flush":  fileFlush,
+	"close":  fileClose,
+	"lines":  fileLines,
+	"__iter": fileLines,
+}
+
+// standard files, shared by all states so they share the input buffered
+var (
+	stdinFile  = &lkFile{f: os.Stdin, std: true}
+	stdoutFile = &lkFile{f: os.Stdout, std: true}
+	stderrFile = &lkFile{f: os.Stderr, std: true}
+)
+
+func OpenIOLib(ls LkState) int {
+	ls.NewLib(ioLib)
+	for name, f := range map[string]*lkFile{
+		"stdin":  stdinFile,
+		"stdout": stdoutFile,
+		"stderr": stderrFile,
+	} {
+		_pushUserData(ls, f, fileMeta, fileMethods)
+		ls.SetField(-2, name)
+	}
+	return 1
+}
+
+// _openFlags returns the os.OpenFile flags of a Lua file mode:
+// r, w, a, r+, w+ or a+, with an optional b, which is ignored.
+func _openFlags(mode string) (int, bool) {
+	switch strings.TrimSuffix(mode, "b") {
+	case "r":
+		return os.O_RDONLY, true
+	case "w":
+		return os.O_WRONLY | os.O_CREATE | os.O_TRUNC, true
+	case "a":
+		return os.O_WRONLY | os.O_CREATE | os.O_APPEND, true
+	case "r+":
+		return os.O_RDWR, true
+	case "w+":
+		return os.O_RDWR | os.O_CREATE | os.O_TRUNC, true
+	case "a+":
+		return os.O_RDWR | os.O_CREATE | os.O_APPEND, true
+	}
+	return 0, false
+}
+
+// io.open (path [, mode [, perm]])
+// Opens the file path in mode (default 'r'), like fopen.
+// Returns the file, or nil and the error.
+// In dry-run mode, files opened for writing discard what is written.
+func ioOpen(ls LkState) int {
+	path := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	perm := os.FileMode(ls.OptInteger(3, 0644))
+	flags, ok := _openFlags(mode)
+	ls.ArgCheck(ok, 2, "invalid mode")
+
+	if flags == os.O_RDONLY {
+		ls.Audit("io.open", path, mode)
+	} else if !_mutate(ls, "io.open", path, mode) {
+		path, flags = os.DevNull, os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushUserData(ls, &lkFile{f: f}, fileMeta, fileMethods)
+	ls.PushNil()
+	return 2
+}
+
+func _checkFile(ls LkState) *lkFile {
+	f := _checkUserData[*lkFile](ls, 1, "file")
+	if f.closed {
+		ls.Error2("attempt to use a closed file")
+	}
+	return f
+}
+
+func (f *lkFile) reader() *bufio.Reader {
+	if f.r == nil {
+		f.r = bufio.NewReader(f.f)
+	}
+	return f.r
+}
+
+// unread drops the buffered input, moving the file offset back to
+// what was actually read, so writes and seeks happen there.
+func (f *lkFile) unread() error {
+	if f.r == nil || f.r.Buffered() == 0 {
+		return nil
+	}
+	_, err := f.f.Seek(-int64(f.r.Buffered()), io.SeekCurrent)
+	f.r.Reset(f.f)
+	return err
+}
+
+// readLine reads a line, keeping its newline if keep is set.
+// The last line may have no newline; past it, the error is io.EOF.
+func (f *lkFile) readLine(keep bool) (string, error) {
+	s, err := f.reader().ReadString('\n')
+	if err == io.EOF && s != "" {
+		err = nil
+	}
+	if !keep {
+		s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+	}
+	return s, err
+}
+
+func (f *lkFile) close() error {
+	f.closed = true
+	return f.f.Close()
+}
+
+// file:read ([fmt])
+// Reads according to fmt:
+// 'l' (default) a line without its newline, 'L' a line with it,
+// 'a' the rest of the file, n up to n bytes.
+// Returns the string, nil at the end of the file, or nil and the error.
+func fileRead(ls LkState) int {
+	f := _checkFile(ls)
+	return _read(ls, f, _readFunc(ls, f, 2, "l"))
+}
+
+// _readFunc returns the read of f for the format at arg, def if none.
+func _readFunc(ls LkState, f *lkFile, arg int, def string) func() (string, error) {
+	if ls.Type(arg) == LK_TNUMBER {
+		n := ls.CheckInteger(arg)
+		ls.ArgCheck(n >= 0, arg, "invalid size")
+		return func() (string, error) {
+			data, err := io.ReadAll(io.LimitReader(f.reader(), n))
+			if err == nil && len(data) == 0 && n > 0 {
+				err = io.EOF
+			}
+			return string(data), err
+		}
+	}
+	switch format := strings.TrimPrefix(ls.OptString(arg, def), "*"); format {
+	case "l", "L":
+		return func() (string, error) {
+			return f.readLine(format == "L")
+		}
+	case "a":
+		return func() (string, error) {
+			data, err := io.ReadAll(f.reader())
+			return string(data), err
+		}
+	}
+	ls.ArgError(arg, "invalid format")
+	return nil
+}
+
+// _read calls read and pushes its results, as file:read.
+// Reading a standard file may block for long, so in a task,
+// other tasks run meanwhile.
+func _read(ls LkState, f *lkFile, read func() (string, error)) int {
+	var s string
+	var err error
+	work := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		s, err = read()
+	}
+	if f.std {
+		_await(ls, work)
+	} else {
+		work()
+	}
+
+	if err == io.EOF {
+		ls.PushNil()
+		ls.PushNil()
+	} else if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+	} else {
+		ls.PushString(s)
+		ls.PushNil()
+	}
+	return 2
+}
+
+// file:write (···)
+// Writes the strings.
+// Returns the error, or nil.
+func fileWrite(ls LkState) int {
+	f := _checkFile(ls)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.unread(); err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	for i := 2; i <= ls.GetTop(); i++ {
+		if _, err := f.f.WriteString(ls.CheckString(i)); err != nil {
+			ls.PushString(err.Error())
+			return 1
+		}
+	}
+	ls.PushNil()
+	return 1
+}
+
+var seekWhences = map[string]int{
+	"set": io.SeekStart,
+	"cur": io.SeekCurrent,
+	"end": io.SeekEnd,
+}
+
+// file:seek ([whence [, offset]])
+// Sets the offset of the file to offset (default 0) from whence:
+// 'set' the start, 'cur' (default) the current offset, 'end' the end.
+// Returns the new offset from the start, or nil and the error.
+func fileSeek(ls LkState) int {
+	f := _checkFile(ls)
+	whence, ok := seekWhences[ls.OptString(2, "cur")]
+	ls.ArgCheck(ok, 2, "invalid option")
+	offset := ls.OptInteger(3, 0)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pos, err := int64(0), f.unread()
+	if err == nil {
+		pos, err = f.f.Seek(offset, whence)
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushInteger(pos)
+	ls.PushNil()
+	return 2
+}
+
+// file:flush ()
+// Commits the written data to the disk.
+// Returns the error, or nil.
+func fileFlush(ls LkState) int {
+	f := _checkFile(ls)
+	if !f.std {
+		if err := f.f.Sync(); err != nil {
+			ls.PushString(err.Error())
+			return 1
+		}
+	}
+	ls.PushNil()
+	return 1
+}
+
+// file:close ()
+// Closes the file. Standard files can't be closed.
+// Returns the error, or nil.
+func fileClose(ls LkState) int {
+	f := _checkFile(ls)
+	if f.std {
+		ls.PushString("cannot close standard file")
+		return 1
+	}
+	if err := f.close(); err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// _pushLines pushes an iterator over the lines of f, for a for-in loop.
+// It raises read errors; with autoClose, it closes f at the end.
+func _pushLines(ls LkState, f *lkFile, keep, autoClose bool) {
+	ls.PushGoFunction(func(ls LkState) int {
+		if f.closed {
+			if autoClose { /* called again after the end */
+				ls.PushNil()
+				return 1
+			}
+			return ls.Error2("file is already closed")
+		}
+		_read(ls, f, func() (string, error) {
+			return f.readLine(keep)
+		})
+		if !ls.IsNil(-1) {
+			return ls.Error()
+		}
+		ls.Pop(1)
+		if autoClose && ls.IsNil(-1) {
+			f.close()
+		}
+		return 1
+	})
+}
+
+// _linesFormat checks the optional format of lines at arg:
+// 'l' (default) or 'L', and returns whether newlines are kept.
+func _linesFormat(ls LkState, arg int) bool {
+	format := strings.TrimPrefix(ls.OptString(arg, "l"), "*")
+	ls.ArgCheck(format == "l" || format == "L", arg, "invalid format")
+	return format == "L"
+}
+
+// io.lines (path [, fmt])
+// for line in io.lines(path) {}
+// Opens the file path and returns an iterator over its lines,
+// read one by one as by file:read(fmt), and closes the file at the end.
+// Raises an error if the file can't be opened.
+func ioLines(ls LkState) int {
+	path := ls.CheckString(1)
+	keep := _linesFormat(ls, 2)
+	ls.Audit("io.open", path, "r")
+	f, err := os.Open(path)
+	if err != nil {
+		return ls.Error2("%s", err.Error())
+	}
+	_pushLines(ls, &lkFile{f: f}, keep, true)
+	return 1
+}
+
+// file:lines ([fmt])
+// for line in f:lines() {} or for line in f {}
+// Returns an iterator over the next lines of the file, read one by one
+// as by file:read(fmt). Unlike io.lines, the file is left open.
+func fileLines(ls LkState) int {
+	f := _checkFile(ls)
+	keep := false
+	if ls.Type(2) == LK_TSTRING { /* not the nil of __iter */
+		keep = _linesFormat(ls, 2)
+	}
+	_pushLines(ls, f, keep, false)
+	return 1
+}
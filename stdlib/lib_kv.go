@@ -0,0 +1,382 @@
+package stdlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+// A store is a file of buckets of keys and values, strings.
+// Out of kv:update and kv:view, each operation is a transaction of its
+// own, which doesn't block the other tasks of sync.run while waiting
+// for the file.
+
+var kvLib = map[string]GoFunction{
+	"open": kvOpen,
+}
+
+// registry keys of the metatables of stores, transactions and buckets
+const (
+	kvMeta     = "_KV"
+	kvTxMeta   = "_KVTX"
+	bucketMeta = "_BUCKET"
+)
+
+var kvMethods = map[string]GoFunction{
+	"bucket":  kvBucket,
+	"buckets": kvBuckets,
+	"drop":    kvDrop,
+	"update":  kvUpdate,
+	"view":    kvView,
+	"close":   kvClose,
+}
+
+var kvTxMethods = map[string]GoFunction{
+	"bucket":  kvBucket,
+	"buckets": kvBuckets,
+	"drop":    kvDrop,
+}
+
+var bucketMethods = map[string]GoFunction{
+	"get":    bucketGet,
+	"put":    bucketPut,
+	"delete": bucketDelete,
+	"iter":   bucketIter,
+	"__iter": bucketIter,
+}
+
+type lkKv struct {
+	db   *bolt.DB
+	path string
+}
+
+// the transaction of kv:update or kv:view
+type lkKvTx struct {
+	kv   *lkKv
+	tx   *bolt.Tx
+	done bool
+}
+
+type lkBucket struct {
+	kv   *lkKv
+	tx   *lkKvTx // nil out of a transaction
+	name []byte
+}
+
+func OpenKvLib(ls LkState) int {
+	ls.NewLib(kvLib)
+	return 1
+}
+
+// kv.open (path [, timeout])
+// Opens the store of the file path, created if needed.
+// A file is opened by one process at a time: waits up to timeout ms
+// (default 1000) for the others to close it.
+// Returns the store, or nil and the error.
+// In dry-run mode, the file is only read.
+func kvOpen(ls LkState) int {
+	path := ls.CheckString(1)
+	timeout := ls.OptInteger(2, 1000)
+	opts := &bolt.Options{Timeout: time.Duration(timeout) * time.Millisecond}
+	open := path
+	if !_mutate(ls, "kv.open", path) {
+		if _, err := os.Stat(path); err == nil {
+			opts.ReadOnly = true
+		} else if f, err := os.CreateTemp("", "lk_kv_*.db"); err == nil {
+			f.Close()
+			open = f.Name() /* an empty store instead */
+			defer os.Remove(open)
+		}
+	}
+	var db *bolt.DB
+	var err error
+	_await(ls, func() {
+		db, err = bolt.Open(open, 0644, opts)
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(fmt.Sprintf("%s: %v", path, err))
+		return 2
+	}
+	_pushUserData(ls, &lkKv{db: db, path: path}, kvMeta, kvMethods)
+	ls.PushNil()
+	return 2
+}
+
+// kv:bucket (name)
+// tx:bucket (name)
+// Returns the bucket name, created if needed (but not in kv:view),
+// or nil and the error.
+func kvBucket(ls LkState) int {
+	kv, tx := _checkKv(ls)
+	name := []byte(ls.CheckString(2))
+	var err error
+	if tx == nil || tx.tx.Writable() {
+		if _mutate(ls, "kv.bucket", kv.path, string(name)) {
+			err = _kvDo(ls, kv, tx, true, func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketIfNotExists(name)
+				return err
+			})
+		}
+	} else if tx.tx.Bucket(name) == nil {
+		err = fmt.Errorf("no bucket %s", name)
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushUserData(ls, &lkBucket{kv: kv, tx: tx, name: name}, bucketMeta, bucketMethods)
+	ls.PushNil()
+	return 2
+}
+
+// kv:buckets ()
+// tx:buckets ()
+// Returns the list of the names of the buckets, or nil and the error.
+func kvBuckets(ls LkState) int {
+	kv, tx := _checkKv(ls)
+	names := []string{}
+	err := _kvDo(ls, kv, tx, false, func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushList(ls, names)
+	ls.PushNil()
+	return 2
+}
+
+// kv:drop (name)
+// tx:drop (name)
+// Deletes the bucket name and its keys. Returns the error, or nil.
+func kvDrop(ls LkState) int {
+	kv, tx := _checkKv(ls)
+	name := []byte(ls.CheckString(2))
+	if !_mutate(ls, "kv.drop", kv.path, string(name)) {
+		ls.PushNil()
+		return 1
+	}
+	err := _kvDo(ls, kv, tx, true, func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(name)
+	})
+	return _pushKvErr(ls, err)
+}
+
+// kv:update (f)
+// Calls f(tx) in a transaction: all the changes of f are done, or none
+// if f raises an error or returns one. Returns the error, or nil.
+// f must use the buckets of tx: the others wait for its end.
+func kvUpdate(ls LkState) int {
+	return _kvTx(ls, true)
+}
+
+// kv:view (f)
+// Calls f(tx) in a read-only transaction, which sees the store as it
+// was at the start. Returns the error, or nil.
+func kvView(ls LkState) int {
+	return _kvTx(ls, false)
+}
+
+func _kvTx(ls LkState, writable bool) int {
+	kv := _checkUserData[*lkKv](ls, 1, "kv")
+	ls.CheckType(2, LK_TFUNCTION)
+	ls.SetTop(2)
+	var btx *bolt.Tx
+	var err error
+	_await(ls, func() {
+		btx, err = kv.db.Begin(writable)
+	})
+	if err != nil {
+		return _pushKvErr(ls, err)
+	}
+	tx := &lkKvTx{kv: kv, tx: btx}
+	_pushUserData(ls, tx, kvTxMeta, kvTxMethods)
+	if ls.PCall(1, 1, 0) != LK_OK || !ls.IsNil(-1) {
+		err = errors.New(ls.ToString2(-1))
+	}
+	tx.done = true
+	if err != nil || !writable {
+		btx.Rollback()
+		return _pushKvErr(ls, err)
+	}
+	_await(ls, func() {
+		err = btx.Commit()
+	})
+	return _pushKvErr(ls, err)
+}
+
+// kv:close ()
+// Returns the error, or nil.
+func kvClose(ls LkState) int {
+	kv := _checkUserData[*lkKv](ls, 1, "kv")
+	var err error
+	_await(ls, func() {
+		err = kv.db.Close()
+	})
+	return _pushKvErr(ls, err)
+}
+
+// bucket:get (key)
+// Returns the value of key, nil if there is none, or nil and the error.
+func bucketGet(ls LkState) int {
+	b := _checkUserData[*lkBucket](ls, 1, "bucket")
+	key := []byte(ls.CheckString(2))
+	var value []byte
+	err := b.do(ls, false, func(bk *bolt.Bucket) error {
+		if v := bk.Get(key); v != nil {
+			value = bytes.Clone(v) /* only valid in the transaction */
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		ls.PushNil()
+		if err != nil {
+			ls.PushString(err.Error())
+			return 2
+		}
+		return 1
+	}
+	ls.PushString(string(value))
+	return 1
+}
+
+// bucket:put (key, value)
+// Sets key to value. Returns the error, or nil.
+func bucketPut(ls LkState) int {
+	b := _checkUserData[*lkBucket](ls, 1, "bucket")
+	key := []byte(ls.CheckString(2))
+	value := []byte(ls.CheckString(3))
+	if !_mutate(ls, "kv.put", b.kv.path, string(b.name), string(key)) {
+		ls.PushNil()
+		return 1
+	}
+	return _pushKvErr(ls, b.do(ls, true, func(bk *bolt.Bucket) error {
+		return bk.Put(key, value)
+	}))
+}
+
+// bucket:delete (key)
+// Deletes key, if any. Returns the error, or nil.
+func bucketDelete(ls LkState) int {
+	b := _checkUserData[*lkBucket](ls, 1, "bucket")
+	key := []byte(ls.CheckString(2))
+	if !_mutate(ls, "kv.delete", b.kv.path, string(b.name), string(key)) {
+		ls.PushNil()
+		return 1
+	}
+	return _pushKvErr(ls, b.do(ls, true, func(bk *bolt.Bucket) error {
+		return bk.Delete(key)
+	}))
+}
+
+// bucket:iter ([prefix])
+// for k, v in bucket {}
+// Returns an iterator over the keys (starting with prefix) and values,
+// sorted by key. Out of a transaction, each step is a transaction:
+// the keys changed meanwhile may be seen.
+// Raises the errors.
+func bucketIter(ls LkState) int {
+	b := _checkUserData[*lkBucket](ls, 1, "bucket")
+	prefix := []byte(ls.OptString(2, ""))
+	var last []byte // key of the last step, nil at the start
+	ls.PushGoFunction(func(ls LkState) int {
+		var key, value []byte
+		err := b.do(ls, false, func(bk *bolt.Bucket) error {
+			c := bk.Cursor()
+			var k, v []byte
+			if last == nil {
+				k, v = c.Seek(prefix)
+			} else if k, v = c.Seek(last); bytes.Equal(k, last) {
+				k, v = c.Next()
+			}
+			for k != nil && v == nil { /* a nested bucket */
+				k, v = c.Next()
+			}
+			if k != nil && bytes.HasPrefix(k, prefix) {
+				key, value = bytes.Clone(k), bytes.Clone(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return ls.Error2("%s", err.Error())
+		}
+		if key == nil {
+			ls.PushNil()
+			return 1
+		}
+		last = key
+		ls.PushString(string(key))
+		ls.PushString(string(value))
+		return 2
+	})
+	return 1
+}
+
+// _checkKv returns the store at 1, or the transaction and its store.
+func _checkKv(ls LkState) (*lkKv, *lkKvTx) {
+	switch x := ls.ToUserData(1).(type) {
+	case *lkKv:
+		return x, nil
+	case *lkKvTx:
+		if x.done {
+			ls.Error2("transaction is over")
+		}
+		return x.kv, x
+	}
+	ls.ArgError(1, "kv or tx expected")
+	return nil, nil
+}
+
+// _kvDo calls f in tx, or in a transaction of its own if tx is nil.
+func _kvDo(ls LkState, kv *lkKv, tx *lkKvTx, writable bool, f func(tx *bolt.Tx) error) error {
+	if tx != nil {
+		if writable && !tx.tx.Writable() {
+			return errors.New("read-only transaction")
+		}
+		return f(tx.tx)
+	}
+	var err error
+	_await(ls, func() {
+		if writable {
+			err = kv.db.Update(f)
+		} else {
+			err = kv.db.View(f)
+		}
+	})
+	return err
+}
+
+// do calls f with the bucket, in its transaction if any.
+func (b *lkBucket) do(ls LkState, writable bool, f func(bk *bolt.Bucket) error) error {
+	if b.tx != nil && b.tx.done {
+		return errors.New("transaction is over")
+	}
+	return _kvDo(ls, b.kv, b.tx, writable, func(tx *bolt.Tx) error {
+		bk := tx.Bucket(b.name)
+		if bk == nil {
+			return fmt.Errorf("no bucket %s", b.name)
+		}
+		return f(bk)
+	})
+}
+
+func _pushKvErr(ls LkState, err error) int {
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
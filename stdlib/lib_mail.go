@@ -0,0 +1,318 @@
+package stdlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+var mailLib = map[string]GoFunction{
+	"send": mailSend,
+}
+
+const (
+	mailPort    = 587 // submission, with STARTTLS
+	mailTlsPort = 465 // submission over tls
+)
+
+// a part of a message, or a whole one
+type mailPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func OpenMailLib(ls LkState) int {
+	ls.NewLib(mailLib)
+	return 1
+}
+
+// mail.send (msg)
+// Sends the mail msg through the smtp server msg.host, on msg.port
+// (default 587), over tls from the start if the port is 465, else once
+// upgraded with STARTTLS if the server can. The fields of msg are:
+// user and pass, to log in (only over tls, or to localhost);
+// from, the address of the sender; to, an address or a list of them;
+// subject; body, the text, and html, its html version, either optional;
+// attachments, a list of the paths of files;
+// timeout, in ms, of the whole sending (default none);
+// and the tls options, as tls.dial.
+// Returns the error, or nil.
+func mailSend(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	m := getTable(ls, 1)
+	host := _optField[string](ls, m, "host", "str")
+	ls.ArgCheck(host != "", 1, "field 'host' expected")
+	port := _optField[int64](ls, m, "port", "integer")
+	if port == 0 {
+		port = mailPort
+	}
+	from := _optField[string](ls, m, "from", "str")
+	ls.ArgCheck(from != "", 1, "field 'from' expected")
+	to := _mailAddrs(ls, 1, "to")
+	ls.ArgCheck(len(to) > 0, 1, "field 'to' expected")
+	user := _optField[string](ls, m, "user", "str")
+	pass := _optField[string](ls, m, "pass", "str")
+	timeout := time.Duration(_optField[int64](ls, m, "timeout", "integer")) * time.Millisecond
+	opts := _tlsOptsOf(ls, m)
+	if opts.serverName == "" {
+		opts.serverName = host
+	}
+
+	ls.GetField(1, "attachments")
+	files := []string{}
+	for _, f := range OptList(ls, ls.GetTop(), nil) {
+		path, ok := f.(string)
+		ls.ArgCheck(ok, 1, "field 'attachments' is not a list of paths")
+		files = append(files, path)
+	}
+	ls.Pop(1)
+	data, err := _mailData(from, to,
+		_optField[string](ls, m, "subject", "str"),
+		_optField[string](ls, m, "body", "str"),
+		_optField[string](ls, m, "html", "str"), files)
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+
+	addr := net.JoinHostPort(host, strconv.FormatInt(port, 10))
+	if !_mutate(ls, "mail.send", strings.Join(to, ", "), addr) {
+		ls.PushNil()
+		return 1
+	}
+	cfg, err := opts.config(false)
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, pass, host)
+	}
+	ctx := ls.Context()
+	_await(ls, func() {
+		err = _smtpSend(ctx, addr, port == mailTlsPort, cfg, timeout, auth, from, to, data)
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// _mailAddrs returns the address, or the list of them, of the field key
+// of the table at arg.
+func _mailAddrs(ls LkState, arg int, key string) []string {
+	defer ls.Pop(1)
+	switch ls.GetField(arg, key) {
+	case LK_TNIL:
+		return nil
+	case LK_TSTRING:
+		return []string{ls.ToString(-1)}
+	case LK_TTABLE:
+		addrs := []string{}
+		for _, a := range getList(ls, ls.GetTop()) {
+			s, ok := a.(string)
+			if !ok {
+				ls.Error2("field '%s' is not a list of addresses", key)
+			}
+			addrs = append(addrs, s)
+		}
+		return addrs
+	}
+	ls.Error2("field '%s' is not an address", key)
+	return nil
+}
+
+// _smtpSend sends data from the address from to the ones of to, through
+// the server at addr.
+func _smtpSend(ctx context.Context, addr string, implicitTls bool, cfg *tls.Config, timeout time.Duration,
+	auth smtp.Auth, from string, to []string, data []byte) error {
+	d := &net.Dialer{Timeout: timeout}
+	var c net.Conn
+	var err error
+	if implicitTls {
+		c, err = (&tls.Dialer{NetDialer: d, Config: cfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		c, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	/* stop waiting on the server once ctx is done */
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	if timeout > 0 {
+		c.SetDeadline(time.Now().Add(timeout))
+	}
+
+	cl, err := smtp.NewClient(c, cfg.ServerName)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	defer cl.Close()
+	if !implicitTls {
+		if ok, _ := cl.Extension("STARTTLS"); ok {
+			if err = cl.StartTLS(cfg); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if err = cl.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = cl.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = cl.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := cl.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return cl.Quit()
+}
+
+// _mailData returns the message, in the mime format: the text, the html
+// or both as alternatives, followed by the files if any.
+func _mailData(from string, to []string, subject, text, html string, files []string) ([]byte, error) {
+	var content mailPart
+	switch {
+	case html == "":
+		content = _mailText("text/plain", text)
+	case text == "":
+		content = _mailText("text/html", html)
+	default:
+		content = _mailMultipart("alternative", _mailText("text/plain", text), _mailText("text/html", html))
+	}
+	if len(files) > 0 {
+		parts := []mailPart{content}
+		for _, path := range files {
+			part, err := _mailFile(path)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		content = _mailMultipart("mixed", parts...)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	_writeMailHeader(&buf, content.header)
+	buf.WriteString("\r\n")
+	buf.Write(content.body)
+	return buf.Bytes(), nil
+}
+
+func _mailText(ctype, s string) mailPart {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return mailPart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {ctype + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		body: buf.Bytes(),
+	}
+}
+
+func _mailFile(path string) (mailPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mailPart{}, err
+	}
+	name := filepath.Base(path)
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	/* base64 in lines of 76 chars */
+	enc := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(enc) > 76 {
+		buf.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	buf.WriteString(enc)
+	return mailPart{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {ctype},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+		},
+		body: buf.Bytes(),
+	}, nil
+}
+
+func _mailMultipart(kind string, parts ...mailPart) mailPart {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		w, _ := mw.CreatePart(p.header)
+		w.Write(p.body)
+	}
+	mw.Close()
+	return mailPart{
+		header: textproto.MIMEHeader{
+			"Content-Type": {"multipart/" + kind + "; boundary=" + mw.Boundary()},
+		},
+		body: buf.Bytes(),
+	}
+}
+
+// _writeMailHeader writes h, sorted.
+func _writeMailHeader(buf *bytes.Buffer, h textproto.MIMEHeader) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+}
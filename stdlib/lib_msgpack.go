@@ -0,0 +1,128 @@
+package stdlib
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Like json, maps are tables and arrays are lists.
+// Strings which are not UTF-8 are encoded as binary,
+// and binary is decoded as strings. Timestamps are decoded as ms.
+
+var msgpackLib = map[string]GoFunction{
+	"encode": msgpackEncode,
+	"decode": msgpackDecode,
+}
+
+func OpenMsgpackLib(ls LkState) int {
+	ls.NewLib(msgpackLib)
+	return 1
+}
+
+// msgpack.encode (v)
+// Returns the MessagePack of v, or nil and the error.
+func msgpackEncode(ls LkState) int {
+	v, err := getValue(ls, 1)
+	var buf bytes.Buffer
+	if err == nil {
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetSortMapKeys(true)
+		enc.UseCompactInts(true)
+		err = enc.Encode(_binEncodable(v))
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(buf.String())
+	ls.PushNil()
+	return 2
+}
+
+// msgpack.decode (s)
+// Returns the value of the MessagePack s, or nil and the error.
+func msgpackDecode(ls LkState) int {
+	s := ls.CheckString(1)
+	dec := msgpack.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseLooseInterfaceDecoding(true)
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushValue(ls, _binValue(v))
+	ls.PushNil()
+	return 2
+}
+
+// _binEncodable returns v of getValue with the strings which are not
+// UTF-8 as []byte.
+func _binEncodable(v any) any {
+	switch x := v.(type) {
+	case string:
+		if !utf8.ValidString(x) {
+			return []byte(x)
+		}
+	case map[string]any:
+		for k := range x {
+			x[k] = _binEncodable(x[k])
+		}
+	case []any:
+		for i := range x {
+			x[i] = _binEncodable(x[i])
+		}
+	}
+	return v
+}
+
+// _binValue returns the decoded v with string keys, timestamps as ms
+// and big integers as numbers.
+func _binValue(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k := range x {
+			x[k] = _binValue(x[k])
+		}
+	case map[any]any:
+		m := make(map[string]any, len(x))
+		for k := range x {
+			m[fmt.Sprint(_binValue(k))] = _binValue(x[k])
+		}
+		return m
+	case []any:
+		for i := range x {
+			x[i] = _binValue(x[i])
+		}
+	case []byte:
+		return string(x)
+	case uint64:
+		if x > math.MaxInt64 {
+			return float64(x)
+		}
+		return int64(x)
+	case time.Time:
+		return x.UnixMilli()
+	case big.Int:
+		return _bigValue(&x)
+	case *big.Int:
+		return _bigValue(x)
+	}
+	return v
+}
+
+func _bigValue(x *big.Int) any {
+	if x.IsInt64() {
+		return x.Int64()
+	}
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return f
+}
@@ -0,0 +1,582 @@
+package stdlib
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// The connections block only the task using them: in sync.run,
+// the other tasks run while a connection waits for the network.
+
+var netLib = map[string]GoFunction{
+	"dial":          netDial,
+	"listen":        netListen,
+	"listen_packet": netListenPacket,
+	"lookup_ip":     _nondet("net.lookup_ip", netLookupIp),
+	"lookup_txt":    _nondet("net.lookup_txt", netLookupTxt),
+	"lookup_mx":     _nondet("net.lookup_mx", netLookupMx),
+	"lookup_cname":  _nondet("net.lookup_cname", netLookupCname),
+}
+
+// registry keys of the metatables of connections, servers
+// and packet connections
+const (
+	connMeta   = "_CONN"
+	serverMeta = "_SERVER"
+	packetMeta = "_PACKET"
+)
+
+var connMethods = map[string]GoFunction{
+	"read":        connRead,
+	"write":       connWrite,
+	"deadline":    connDeadline,
+	"local_addr":  connLocalAddr,
+	"remote_addr": connRemoteAddr,
+	"close":       connClose,
+}
+
+var packetMethods = map[string]GoFunction{
+	"recv":       packetRecv,
+	"send":       packetSend,
+	"deadline":   connDeadline,
+	"local_addr": connLocalAddr,
+	"close":      connClose,
+}
+
+var serverMethods = map[string]GoFunction{
+	"addr":  serverAddr,
+	"close": serverClose,
+}
+
+// what connections have in common
+type socket interface {
+	LocalAddr() net.Addr
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// the size of the biggest datagram
+const maxDatagram = 65535
+
+type lkConn struct {
+	c  net.Conn
+	r  *bufio.Reader
+	mu sync.Mutex // of r
+}
+
+// a connection of udp or unixgram, not bound to a peer
+type lkPacketConn struct {
+	c       net.PacketConn
+	network string
+}
+
+type lkServer struct {
+	l      net.Listener
+	closed atomic.Bool
+	done   chan struct{}
+}
+
+func OpenNetLib(ls LkState) int {
+	ls.NewLib(netLib)
+	return 1
+}
+
+// net.dial (network, addr [, timeout])
+// Connects to addr over network: 'tcp', 'tcp4', 'tcp6', 'udp', 'udp4',
+// 'udp6' ('host:port'), 'unix' or 'unixgram' (the path of a socket),
+// giving up after timeout ms (default none).
+// Over udp and unixgram, each write sends a datagram.
+// Returns the connection, or nil and the error.
+func netDial(ls LkState) int {
+	network := ls.CheckString(1)
+	addr := ls.CheckString(2)
+	timeout := ls.OptInteger(3, 0)
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram":
+	default:
+		ls.ArgError(1, "unsupported network")
+	}
+	ls.Audit("net.dial", network, addr)
+
+	d := net.Dialer{Timeout: time.Duration(timeout) * time.Millisecond}
+	ctx := ls.Context()
+	var c net.Conn
+	var err error
+	_await(ls, func() {
+		c, err = d.DialContext(ctx, network, addr)
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushUserData(ls, _newConn(c), connMeta, connMethods)
+	ls.PushNil()
+	return 2
+}
+
+// net.listen (addr, f [, network])
+// Listens on addr over network: 'tcp' (default), 'tcp4', 'tcp6'
+// ('host:port', port 0 for any) or 'unix' (the path of a socket),
+// and calls f(conn) in a task of its own for each connection,
+// from the next sync.run, until the server is closed.
+// conn is closed once f returns.
+// Returns the server, or nil and the error.
+func netListen(ls LkState) int {
+	addr := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	network := ls.OptString(3, "tcp")
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		ls.ArgError(3, "unsupported network")
+	}
+	ls.Audit("net.listen", network, addr)
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	return _serve(ls, l, _openConn)
+}
+
+// _serve spawns the task accepting the connections of l, for the
+// function at 2, and pushes the server, as net.listen.
+// In the task of each connection, open pushes the arg of the function,
+// and returns what to close once it returns, or nil to drop c.
+func _serve(ls LkState, l net.Listener, open func(ls LkState, c net.Conn) io.Closer) int {
+	srv := &lkServer{l: l, done: make(chan struct{})}
+	ctx := ls.Context()
+	go func() {
+		select {
+		case <-ctx.Done():
+			srv.close()
+		case <-srv.done:
+		}
+	}()
+
+	ls.SetTop(2)
+	ls.Remove(1) /* keep f, the arg of the task */
+	ls.PushGoFunction(func(ls LkState) int {
+		for {
+			var c net.Conn
+			var err error
+			_await(ls, func() {
+				c, err = srv.l.Accept()
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return ls.Error2("interrupted: %v", ctx.Err())
+				}
+				if srv.closed.Load() {
+					return 0
+				}
+				return ls.Error2("%s", err.Error())
+			}
+			ls.PushGoFunction(func(ls LkState) int {
+				closer := open(ls, c)
+				if closer == nil {
+					return 0
+				}
+				status := ls.PCall(1, 0, 0)
+				closer.Close()
+				if status != LK_OK {
+					return ls.Error()
+				}
+				return 0
+			})
+			ls.PushValue(1)
+			_spawn(ls, 1)
+		}
+	})
+	ls.Insert(1)
+	coSpawn(ls)
+	ls.Pop(1)
+	_pushUserData(ls, srv, serverMeta, serverMethods)
+	ls.PushNil()
+	return 2
+}
+
+// server:addr ()
+// Returns the address listened on, with the port chosen if it was 0.
+func serverAddr(ls LkState) int {
+	srv := _checkUserData[*lkServer](ls, 1, "server")
+	ls.PushString(srv.l.Addr().String())
+	return 1
+}
+
+// server:close ()
+// Stops accepting connections, the open ones stay open.
+// Returns the error, or nil.
+func serverClose(ls LkState) int {
+	srv := _checkUserData[*lkServer](ls, 1, "server")
+	if err := srv.close(); err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+func (srv *lkServer) close() error {
+	if srv.closed.Swap(true) {
+		return nil
+	}
+	close(srv.done)
+	return srv.l.Close()
+}
+
+// net.listen_packet (network, addr)
+// Listens for the datagrams sent to addr over network: 'udp', 'udp4',
+// 'udp6' ('host:port', port 0 for any) or 'unixgram' (the path of a socket).
+// Returns the connection, or nil and the error.
+func netListenPacket(ls LkState) int {
+	network := ls.CheckString(1)
+	addr := ls.CheckString(2)
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+	default:
+		ls.ArgError(1, "unsupported network")
+	}
+	ls.Audit("net.listen_packet", network, addr)
+	c, err := net.ListenPacket(network, addr)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushUserData(ls, &lkPacketConn{c: c, network: network}, packetMeta, packetMethods)
+	ls.PushNil()
+	return 2
+}
+
+// pc:recv ([n])
+// Waits for a datagram, and returns its first n bytes (default all),
+// and the address of its sender, or nil, nil and the error.
+func packetRecv(ls LkState) int {
+	pc := _checkUserData[*lkPacketConn](ls, 1, "packet conn")
+	n := ls.OptInteger(2, maxDatagram)
+	ls.ArgCheck(n > 0, 2, "invalid size")
+	if n > maxDatagram {
+		n = maxDatagram
+	}
+	buf := make([]byte, n)
+	var k int
+	var from net.Addr
+	err := _netDo(ls, pc.c, func() (err error) {
+		k, from, err = pc.c.ReadFrom(buf)
+		return err
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 3
+	}
+	ls.PushString(string(buf[:k]))
+	if from != nil {
+		ls.PushString(from.String())
+	} else { /* an unbound unix socket */
+		ls.PushNil()
+	}
+	ls.PushNil()
+	return 3
+}
+
+// pc:send (data, addr)
+// Sends the datagram data to addr. Returns the error, or nil.
+func packetSend(ls LkState) int {
+	pc := _checkUserData[*lkPacketConn](ls, 1, "packet conn")
+	data := ls.CheckString(2)
+	addr := ls.CheckString(3)
+	var to net.Addr
+	var err error
+	if pc.network == "unixgram" {
+		to, err = net.ResolveUnixAddr(pc.network, addr)
+	} else {
+		to, err = net.ResolveUDPAddr(pc.network, addr)
+	}
+	if err == nil {
+		err = _netDo(ls, pc.c, func() error {
+			_, err := pc.c.WriteTo([]byte(data), to)
+			return err
+		})
+	}
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// net.lookup_ip (host [, network])
+// Returns the list of the addresses of host, of network: 'ip' (default),
+// 'ip4' or 'ip6', or nil and the error.
+func netLookupIp(ls LkState) int {
+	network := ls.OptString(2, "ip")
+	switch network {
+	case "ip", "ip4", "ip6":
+	default:
+		ls.ArgError(2, "invalid network")
+	}
+	return _lookup(ls, func(ctx context.Context, host string) (any, error) {
+		ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = ip.String()
+		}
+		return addrs, err
+	})
+}
+
+// net.lookup_txt (host)
+// Returns the list of the txt records of host, or nil and the error.
+func netLookupTxt(ls LkState) int {
+	return _lookup(ls, func(ctx context.Context, host string) (any, error) {
+		return net.DefaultResolver.LookupTXT(ctx, host)
+	})
+}
+
+// net.lookup_mx (host)
+// Returns the list of the mx records of host, by preference:
+// {host, pref}, or nil and the error.
+func netLookupMx(ls LkState) int {
+	return _lookup(ls, func(ctx context.Context, host string) (any, error) {
+		mxs, err := net.DefaultResolver.LookupMX(ctx, host)
+		records := make([]lkMap, len(mxs))
+		for i, mx := range mxs {
+			records[i] = lkMap{"host": mx.Host, "pref": int64(mx.Pref)}
+		}
+		return records, err
+	})
+}
+
+// net.lookup_cname (host)
+// Returns the canonical name of host, or nil and the error.
+func netLookupCname(ls LkState) int {
+	return _lookup(ls, func(ctx context.Context, host string) (any, error) {
+		return net.DefaultResolver.LookupCNAME(ctx, host)
+	})
+}
+
+// _lookup pushes the results of lookup of the host at 1.
+func _lookup(ls LkState, lookup func(ctx context.Context, host string) (any, error)) int {
+	host := ls.CheckString(1)
+	ls.Audit("net.lookup", host)
+	ctx := ls.Context()
+	var v any
+	var err error
+	_await(ls, func() {
+		v, err = lookup(ctx, host)
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushValue(ls, v)
+	ls.PushNil()
+	return 2
+}
+
+// conn:read ([fmt])
+// Reads according to fmt:
+// 'l' (default) a line without its newline, 'L' a line with it,
+// 'a' all until the peer closes, n up to n bytes, as soon as some came
+// (at most the size of the read buffer, whatever n).
+// Returns the string, nil once the peer closed, or nil and the error.
+func connRead(ls LkState) int {
+	c := _checkUserData[*lkConn](ls, 1, "conn")
+	var read func() (string, error)
+	if ls.Type(2) == LK_TNUMBER {
+		n := ls.CheckInteger(2)
+		ls.ArgCheck(n > 0, 2, "invalid size")
+		if size := int64(c.r.Size()); n > size { /* don't allocate n bytes up front */
+			n = size
+		}
+		read = func() (string, error) {
+			buf := make([]byte, n)
+			k, err := c.r.Read(buf)
+			if k > 0 {
+				err = nil
+			}
+			return string(buf[:k]), err
+		}
+	} else {
+		switch format := strings.TrimPrefix(ls.OptString(2, "l"), "*"); format {
+		case "l", "L":
+			read = func() (string, error) {
+				s, err := c.r.ReadString('\n')
+				if err == io.EOF && s != "" {
+					err = nil
+				}
+				if format == "l" {
+					s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+				}
+				return s, err
+			}
+		case "a":
+			read = func() (string, error) {
+				data, err := io.ReadAll(c.r)
+				return string(data), err
+			}
+		default:
+			ls.ArgError(2, "invalid format")
+		}
+	}
+
+	var s string
+	err := _netDo(ls, c.c, func() (err error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		s, err = read()
+		return err
+	})
+	if err == io.EOF {
+		ls.PushNil()
+		ls.PushNil()
+	} else if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+	} else {
+		ls.PushString(s)
+		ls.PushNil()
+	}
+	return 2
+}
+
+// conn:write (···)
+// Writes the strings.
+// Returns the error, or nil.
+func connWrite(ls LkState) int {
+	c := _checkUserData[*lkConn](ls, 1, "conn")
+	var sb strings.Builder
+	for i := 2; i <= ls.GetTop(); i++ {
+		sb.WriteString(ls.CheckString(i))
+	}
+	err := _netDo(ls, c.c, func() error {
+		_, err := io.WriteString(c.c, sb.String())
+		return err
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// conn:deadline (ts [, which])
+// pc:deadline (ts [, which])
+// Makes the reads ('r'), the writes ('w') or both (default) fail after
+// the time ts (in ms), or never if ts is 0.
+func connDeadline(ls LkState) int {
+	c := _checkSocket(ls)
+	ts := ls.CheckInteger(2)
+	which := ls.OptString(3, "rw")
+	var t time.Time
+	if ts != 0 {
+		t = time.UnixMilli(ts)
+	}
+	var err error
+	switch which {
+	case "r":
+		err = c.SetReadDeadline(t)
+	case "w":
+		err = c.SetWriteDeadline(t)
+	case "rw":
+		err = c.SetDeadline(t)
+	default:
+		ls.ArgError(3, "invalid option")
+	}
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// conn:local_addr ()
+// pc:local_addr ()
+func connLocalAddr(ls LkState) int {
+	ls.PushString(_checkSocket(ls).LocalAddr().String())
+	return 1
+}
+
+// conn:remote_addr ()
+func connRemoteAddr(ls LkState) int {
+	c := _checkUserData[*lkConn](ls, 1, "conn")
+	ls.PushString(c.c.RemoteAddr().String())
+	return 1
+}
+
+// conn:close ()
+// pc:close ()
+// Returns the error, or nil.
+func connClose(ls LkState) int {
+	if err := _checkSocket(ls).Close(); err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+func _newConn(c net.Conn) *lkConn {
+	size := 4096
+	if _, ok := c.(net.PacketConn); ok {
+		size = maxDatagram /* a read must not cut a datagram */
+	}
+	return &lkConn{c: c, r: bufio.NewReaderSize(c, size)}
+}
+
+// _openConn pushes the connection c, for _serve.
+func _openConn(ls LkState, c net.Conn) io.Closer {
+	_pushUserData(ls, _newConn(c), connMeta, connMethods)
+	return c
+}
+
+// _checkSocket returns the connection or packet connection at 1.
+func _checkSocket(ls LkState) socket {
+	switch x := ls.ToUserData(1).(type) {
+	case *lkConn:
+		return x.c
+	case *lkPacketConn:
+		return x.c
+	}
+	ls.ArgError(1, "conn expected")
+	return nil
+}
+
+// _netDo calls work, which waits for the network on c, as _await,
+// and makes it fail if the script is interrupted meanwhile.
+func _netDo(ls LkState, c socket, work func() error) error {
+	ctx := ls.Context()
+	var err error
+	_await(ls, func() {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.SetDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+		err = work()
+	})
+	return err
+}
@@ -7,9 +7,10 @@ import (
 )
 
 var numLib = map[string]GoFunction{
-	"abs":  numAbs,
-	"len":  numLen,
-	"char": numChar,
+	"abs":    numAbs,
+	"len":    numLen,
+	"char":   numChar,
+	"__call": numCall,
 }
 
 func OpenNumLib(ls LkState) int {
@@ -25,6 +26,12 @@ func OpenNumLib(ls LkState) int {
 	return 1
 }
 
+// num(v [, base]) converts v like the base function num
+func numCall(ls LkState) int {
+	ls.Remove(1) /* num library */
+	return baseToNumber(ls)
+}
+
 func numAbs(ls LkState) int {
 	n := ls.CheckNumber(1)
 	if n < 0 {
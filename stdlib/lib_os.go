@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"runtime"
 	"strings"
 	"time"
 
@@ -21,25 +22,39 @@ var (
 )
 
 var sysLib = map[string]GoFunction{
-	"time":      osTime,
-	"stat":      osStat,
-	"date":      osDate,
-	"rm":        osRemove,
-	"mv":        osRename,
-	"cp":        osCp,
-	"link":      osLink,
-	"tmp":       osTmpName,
-	"get_env":   osGetEnv,
-	"set_env":   osSetEnv,
-	"exec":      osExecute,
-	"exit":      osExit,
-	"ls":        osLs,
-	"read":      osRead,
-	"write":     osWrite,
-	"sleep":     osSleep,
-	"mkdir":     osMkdir,
-	"rand":      randRandom,
-	"rand_seed": randSeed,
+	"time":       _nondet("os.time", osTime),
+	"stat":       _nondet("os.stat", osStat),
+	"date":       _nondet("os.date", osDate),
+	"rm":         osRemove,
+	"mv":         osRename,
+	"cp":         osCp,
+	"link":       osLink,
+	"tmp":        osTmpName,
+	"get_env":    _nondet("os.get_env", osGetEnv),
+	"set_env":    osSetEnv,
+	"exec":       _nondet("os.exec", osExecute),
+	"proc":       osProc,
+	"kill":       osKill,
+	"on_signal":  osOnSignal,
+	"hostname":   _nondet("os.hostname", osHostname),
+	"user":       _nondet("os.user", osUser),
+	"home":       _nondet("os.home", osHome),
+	"platform":   osPlatform,
+	"arch":       osArch,
+	"cpus":       _nondet("os.cpus", osCpus),
+	"pid":        _nondet("os.pid", osPid),
+	"exit":       osExit,
+	"ls":         _nondet("os.ls", osLs),
+	"walk":       osWalk,
+	"glob":       _nondet("os.glob", osGlob),
+	"watch":      osWatch,
+	"read":       _nondet("os.read", osRead),
+	"write":      osWrite,
+	"stdin_read": _nondet("os.stdin_read", osStdinRead),
+	"sleep":      osSleep,
+	"mkdir":      osMkdir,
+	"rand":       _nondet("os.rand", randRandom),
+	"rand_seed":  randSeed,
 }
 
 func OpenOSLib(ls LkState) int {
@@ -48,14 +63,27 @@ func OpenOSLib(ls LkState) int {
 	return 1
 }
 
+// scriptArgs are os.args, see SetArgs
+var scriptArgs = os.Args
+
+// SetArgs sets os.args of the os libs opened from now on: the program,
+// the script, then the args of the script. Defaults to os.Args.
+func SetArgs(args []string) {
+	scriptArgs = args
+}
+
 func pushArgs(ls LkState) {
-	pushList(ls, os.Args)
+	pushList(ls, scriptArgs)
 	ls.SetField(-2, "args")
 }
 
 func osCp(ls LkState) int {
 	src := ls.CheckString(1)
 	dst := ls.CheckString(2)
+	if !_mutate(ls, "os.cp", src, dst) {
+		ls.PushNil()
+		return 1
+	}
 	if err := utils.Copy(src, dst); err != nil {
 		ls.PushString(err.Error())
 		return 1
@@ -66,27 +94,35 @@ func osCp(ls LkState) int {
 
 func osStat(ls LkState) int {
 	path := ls.CheckString(1)
+	ls.Audit("os.stat", path)
 	info, err := os.Stat(path)
 	if err != nil {
 		ls.PushNil()
 		ls.PushString(err.Error())
 		return 2
 	}
-	stat := lkMap{
+	_pushFileInfo(ls, info)
+	ls.PushNil()
+	return 2
+}
+
+func _pushFileInfo(ls LkState, info fs.FileInfo) {
+	pushTable(ls, lkMap{
 		"size":   info.Size(),
 		"mode":   info.Mode().String(),
 		"time":   info.ModTime().UnixMilli(),
 		"name":   info.Name(),
 		"is_dir": info.IsDir(),
-	}
-	pushTable(ls, stat)
-	ls.PushNil()
-	return 2
+	})
 }
 
 func osLink(ls LkState) int {
 	src := ls.CheckString(1)
 	dst := ls.CheckString(2)
+	if !_mutate(ls, "os.link", src, dst) {
+		ls.PushNil()
+		return 1
+	}
 	if err := os.Link(src, dst); err != nil {
 		ls.PushString(err.Error())
 		return 1
@@ -99,6 +135,10 @@ func osMkdir(ls LkState) int {
 	path := ls.CheckString(1)
 	rescusive := ls.OptBool(2, false)
 	perm := fs.FileMode(ls.OptInteger(3, 0744))
+	if !_mutate(ls, "os.mkdir", path, rescusive, int64(perm)) {
+		ls.PushNil()
+		return 1
+	}
 	if rescusive {
 		err := os.MkdirAll(path, perm)
 		if err != nil {
@@ -115,12 +155,26 @@ func osMkdir(ls LkState) int {
 
 func osSleep(ls LkState) int {
 	milliSec := ls.CheckInteger(1)
-	time.Sleep(time.Duration(milliSec) * time.Millisecond)
+	ctx := ls.Context()
+	var err error
+	_await(ls, func() {
+		timer := time.NewTimer(time.Duration(milliSec) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	if err != nil {
+		ls.Error2("interrupted: %v", err)
+	}
 	return 0
 }
 
 func osLs(ls LkState) int {
 	dir := ls.CheckString(1)
+	ls.Audit("os.ls", dir)
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		ls.PushNil()
@@ -138,6 +192,7 @@ func osLs(ls LkState) int {
 
 func osRead(ls LkState) int {
 	path := ls.CheckString(1)
+	ls.Audit("os.read", path)
 	data, err := os.ReadFile(path)
 	if err != nil {
 		ls.PushNil()
@@ -153,6 +208,10 @@ func osWrite(ls LkState) int {
 	path := ls.CheckString(1)
 	data := ls.CheckString(2)
 	perm := fs.FileMode(ls.OptInteger(3, 0744))
+	if !_mutate(ls, "os.write", path, data, int64(perm)) {
+		ls.PushNil()
+		return 1
+	}
 	if err := os.WriteFile(path, []byte(data), perm); err != nil {
 		ls.PushString(err.Error())
 		return 1
@@ -161,6 +220,13 @@ func osWrite(ls LkState) int {
 	return 1
 }
 
+// os.stdin_read ([fmt])
+// cat data.txt | lk filter.lk
+// Reads the standard input like io.stdin:read(fmt), but reads it all by default.
+func osStdinRead(ls LkState) int {
+	return _read(ls, stdinFile, _readFunc(ls, stdinFile, 1, "a"))
+}
+
 // os.time ([table, isUTC])
 // http://www.lua.org/manual/5.3/manual.html#pdf-os.time
 // lua-5.3.4/src/loslib.c#os_time()
@@ -171,6 +237,7 @@ func osTime(ls LkState) int {
 	} else {
 		ls.CheckType(1, LK_TTABLE)
 		isUTC := ls.OptBool(2, false)
+		ls.SetTop(1) /* the fields are read from the top */
 		sec := _getField(ls, "sec", 0)
 		min := _getField(ls, "min", 0)
 		hour := _getField(ls, "hour", 12)
@@ -193,13 +260,18 @@ func osTime(ls LkState) int {
 // os.date ([format [, time]])
 // http://www.lua.org/manual/5.3/manual.html#pdf-os.date
 // lua-5.3.4/src/loslib.c#os_date()
+// time is in seconds as in Lua, with a fraction if it is a float:
+// os.time returns milliseconds, so os.date(f, os.time() / 1000).
+// A format starting with ! is in UTC, else in the local time zone.
+// The conversions are the ones of the C strftime, such as %Y-%m-%d %H:%M:%S.
 func osDate(ls LkState) int {
 	format := ls.OptString(1, "%c")
 	var t time.Time
-	if ls.IsInteger(2) {
-		t = time.Unix(ls.ToInteger(2), 0)
-	} else {
+	if ls.IsNoneOrNil(2) {
 		t = time.Now()
+	} else {
+		sec, frac := math.Modf(ls.CheckNumber(2))
+		t = time.Unix(int64(sec), int64(frac*1e9))
 	}
 
 	if format != "" && format[0] == '!' { /* UTC? */
@@ -217,12 +289,16 @@ func osDate(ls LkState) int {
 		_setField(ls, "year", t.Year())
 		_setField(ls, "wday", int(t.Weekday())+1)
 		_setField(ls, "yday", t.YearDay())
-	} else if format == "%c" {
-		ls.PushString(t.Format(time.ANSIC))
-	} else {
-		ls.PushString(format) // TODO
+		ls.PushBoolean(t.IsDST())
+		ls.SetField(-2, "isdst")
+		return 1
 	}
 
+	s, err := _strftime(format, t)
+	if err != nil {
+		return ls.Error2("bad argument #1 to 'date' (%s)", err.Error())
+	}
+	ls.PushString(s)
 	return 1
 }
 
@@ -236,6 +312,9 @@ func _setField(ls LkState, key string, value int) {
 func osRemove(ls LkState) int {
 	filename := ls.CheckString(1)
 	rmdir := ls.OptBool(2, false)
+	if !_mutate(ls, "os.rm", filename, rmdir) {
+		goto SUC
+	}
 	if rmdir {
 		err := os.RemoveAll(filename)
 		if err != nil {
@@ -258,6 +337,10 @@ SUC:
 func osRename(ls LkState) int {
 	oldName := ls.CheckString(1)
 	newName := ls.CheckString(2)
+	if !_mutate(ls, "os.mv", oldName, newName) {
+		ls.PushNil()
+		return 1
+	}
 	if err := os.Rename(oldName, newName); err != nil {
 		ls.PushString(err.Error())
 		return 1
@@ -278,6 +361,7 @@ func osTmpName(ls LkState) int {
 // lua-5.3.4/src/loslib.c#os_getenv()
 func osGetEnv(ls LkState) int {
 	key := ls.CheckString(1)
+	ls.Audit("os.get_env", key)
 	if env := os.Getenv(key); env != "" {
 		ls.PushString(env)
 	} else {
@@ -289,6 +373,7 @@ func osGetEnv(ls LkState) int {
 func osSetEnv(ls LkState) int {
 	key := ls.CheckString(1)
 	value := ls.CheckString(2)
+	ls.Audit("os.set_env", key, value)
 	if err := os.Setenv(key, value); err != nil {
 		ls.PushString(err.Error())
 		return 1
@@ -297,18 +382,40 @@ func osSetEnv(ls LkState) int {
 	return 1
 }
 
+// _shell returns the shell running the scripts of os.exec:
+// bash, or sh where bash isn't installed.
+func _shell() string {
+	if _, err := exec.LookPath("bash"); err == nil {
+		return "bash"
+	}
+	return "sh"
+}
+
 // os.exec (script)
+// Runs script with the shell: bash (or sh) on unix, cmd on Windows.
+// For commands with args, prefer os.proc, which needs no shell.
 func osExecute(ls LkState) int {
 	script := ls.CheckString(1)
+	if !_mutate(ls, "os.exec", script) {
+		ls.PushString("")
+		ls.PushNil()
+		return 2
+	}
 	tempDir := os.TempDir()
 	path := path.Join(tempDir, "lkscript"+utils.Md5([]byte(script)))
+	if runtime.GOOS == "windows" {
+		path += ".bat"
+	}
 	err := ioutil.WriteFile(path, []byte(script), 0744)
 	if err != nil {
 		ls.PushNil()
 		ls.PushString(err.Error())
 		return 2
 	}
-	cmd := exec.Command("bash", path)
+	cmd := exec.Command(_shell(), path)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", path)
+	}
 	cmdOut := new(bytes.Buffer)
 	cmdErr := new(bytes.Buffer)
 	cmd.Stdout = cmdOut
@@ -329,6 +436,8 @@ func osExecute(ls LkState) int {
 // lua-5.3.4/src/loslib.c#os_exit()
 func osExit(ls LkState) int {
 	code := ls.OptInteger(1, 0)
+	ls.Audit("os.exit", code)
+	StopReplay() /* don't lose the end of a recording */
 	os.Exit(int(code))
 	return 0
 }
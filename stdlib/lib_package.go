@@ -1,6 +1,7 @@
 package stdlib
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -15,12 +16,17 @@ const LUA_LOADED_TABLE = "_LOADED"
 /* key, in the registry, for table of preloaded loaders */
 const LUA_PRELOAD_TABLE = "_PRELOAD"
 
+/* key, in the registry, for table of modules loaded from a bundle chunk */
+const LK_BUNDLE_TABLE = "_BUNDLE"
+
 const (
 	LUA_DIRSEP    = string(os.PathSeparator)
 	LUA_PATH_SEP  = ";"
 	LUA_PATH_MARK = "?"
 	LUA_EXEC_DIR  = "!"
 	LUA_IGMARK    = "-"
+	/* initial value of 'pkg.path' */
+	LK_PATH_DEFAULT = "?.lk;?.lkc;?/init.lk"
 )
 
 var pkgFuncs = map[string]GoFunction{
@@ -41,7 +47,7 @@ func OpenPackageLib(ls LkState) int {
 	ls.NewLib(pkgFuncs) /* create 'package' table */
 	createSearchersTable(ls)
 	/* set paths */
-	ls.PushString("?.lk;?.lkc;?/init.lk")
+	ls.PushString(LK_PATH_DEFAULT)
 	ls.SetField(-2, "path")
 	/* store config information */
 	ls.PushString(LUA_DIRSEP + "\n" + LUA_PATH_SEP + "\n" +
@@ -63,6 +69,7 @@ func OpenPackageLib(ls LkState) int {
 func createSearchersTable(ls LkState) {
 	searchers := []GoFunction{
 		preloadSearcher,
+		bundleSearcher,
 		lkSearcher,
 	}
 	/* create 'searchers' table */
@@ -85,6 +92,17 @@ func preloadSearcher(ls LkState) int {
 	return 1
 }
 
+func bundleSearcher(ls LkState) int {
+	name := ls.CheckString(1)
+	if ls.GetField(LK_REGISTRYINDEX, LK_BUNDLE_TABLE) != LK_TTABLE ||
+		ls.GetField(-1, name) == LK_TNIL { /* not bundled? */
+		ls.PushString("\n\tno module '" + name + "' in bundle")
+		return 1
+	}
+	ls.PushString(name) /* will be 2nd argument to module */
+	return 2
+}
+
 func lkSearcher(ls LkState) int {
 	name := ls.CheckString(1)
 	ls.GetField(LkUpvalueIndex(1), "path")
@@ -126,6 +144,16 @@ func pkgSearchPath(ls LkState) int {
 	}
 }
 
+// SearchModule finds the file of the module name in the default path,
+// as pkg.import does. Builtin modules have the consts.BuiltinPrefix.
+func SearchModule(name string) (content []byte, filename string, err error) {
+	content, filename, errMsg := _searchPath(name, LK_PATH_DEFAULT, ".", LUA_DIRSEP)
+	if errMsg != "" {
+		return nil, "", fmt.Errorf("module '%s' not found:%s", name, errMsg)
+	}
+	return content, filename, nil
+}
+
 func _searchPath(name, path, sep, dirSep string) (content []byte, fname, errMsg string) {
 	if sep != "" {
 		name = strings.Replace(name, sep, dirSep, -1)
@@ -22,6 +22,8 @@ var strLib = map[string]GoFunction{
 	"contains": strContains,
 	"match":    strMatch,
 	"replace":  strReplace,
+	"dump":     strDump,
+	"__call":   strCall,
 }
 
 func OpenStringLib(ls LkState) int {
@@ -37,6 +39,12 @@ func OpenStringLib(ls LkState) int {
 	return 1
 }
 
+// str(v) converts v like the base function str
+func strCall(ls LkState) int {
+	ls.Remove(1) /* string library */
+	return baseToString(ls)
+}
+
 func strReplace(ls LkState) int {
 	s := ls.CheckString(1)
 	old := ls.CheckString(2)
@@ -85,19 +93,34 @@ func strMatch(ls LkState) int {
 
 func strJoin(ls LkState) int {
 	sep := ls.CheckString(1)
-	list := CheckList(ls, 2)
-	l := make([]string, len(list))
-	for i := range list {
-		l[i] = list[i].(string)
+	ls.CheckType(2, LK_TTABLE)
+	n := ls.Len2(2)
+	b := ls.BuffInit()
+	for i := int64(0); i < n; i++ {
+		if i > 0 {
+			b.AddString(sep)
+		}
+		ls.GetI(2, i)
+		b.AddValue()
 	}
-	ls.PushString(strings.Join(l, sep))
+	b.PushResult()
 	return 1
 }
 
+// str.split (s, sep [, n])
+// The parts share memory with s, so only the list itself is allocated.
 func strSplit(ls LkState) int {
 	s := ls.CheckString(1)
 	sep := ls.CheckString(2)
-	pushList(ls, strings.Split(s, sep))
+	n := ls.OptInteger(3, -1)
+
+	parts := strings.SplitN(s, sep, int(n))
+	_detachParts(s, parts)
+	ls.CreateTable(len(parts), 0)
+	for i := range parts {
+		ls.PushString(parts[i])
+		ls.RawSetI(-2, int64(i))
+	}
 	return 1
 }
 
@@ -123,11 +146,15 @@ func strRep(ls LkState) int {
 	} else if n == 1 {
 		ls.PushString(s)
 	} else {
-		a := make([]string, n)
-		for i := 0; i < int(n); i++ {
-			a[i] = s
+		b := ls.BuffInit()
+		b.Grow(int(n)*len(s) + int(n-1)*len(sep))
+		for i := int64(0); i < n; i++ {
+			if i > 0 {
+				b.AddString(sep)
+			}
+			b.AddString(s)
 		}
-		ls.PushString(strings.Join(a, sep))
+		b.PushResult()
 	}
 
 	return 1
@@ -139,13 +166,13 @@ func strRep(ls LkState) int {
 func strReverse(ls LkState) int {
 	s := ls.CheckString(1)
 
-	if strLen := len(s); strLen > 1 {
-		a := make([]byte, strLen)
-		for i := 0; i < strLen; i++ {
-			a[i] = s[strLen-1-i]
-		}
-		ls.PushString(string(a))
+	strLen := len(s)
+	b := ls.BuffInit()
+	b.Grow(strLen)
+	for i := strLen - 1; i >= 0; i-- {
+		b.AddChar(s[i])
 	}
+	b.PushResult()
 
 	return 1
 }
@@ -185,7 +212,7 @@ func strSub(ls LkState) int {
 	}
 
 	if i <= j {
-		ls.PushString(s[i-1 : j])
+		ls.PushString(_substr(s, i-1, j))
 	} else {
 		ls.PushString("")
 	}
@@ -214,13 +241,29 @@ func strByte(ls LkState) int {
 func strChar(ls LkState) int {
 	nArgs := ls.GetTop()
 
-	s := make([]byte, nArgs)
+	b := ls.BuffInit()
+	b.Grow(nArgs)
 	for i := 1; i <= nArgs; i++ {
 		c := ls.CheckInteger(i)
 		ls.ArgCheck(int64(byte(c)) == c, i, "value out of range")
-		s[i-1] = byte(c)
+		b.AddChar(byte(c))
 	}
 
-	ls.PushString(string(s))
+	b.PushResult()
+	return 1
+}
+
+// string.dump (function [, strip])
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.dump
+// lua-5.3.4/src/lstrlib.c#str_dump()
+func strDump(ls LkState) int {
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, LK_TFUNCTION)
+	ls.SetTop(1)
+	data := ls.Dump(strip)
+	if data == nil {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(string(data))
 	return 1
 }
@@ -10,6 +10,17 @@ var coFuncs = map[string]GoFunction{
 	"is_yieldable": coYieldable,
 	"running":      coRunning,
 	"wrap":         coWrap,
+	"spawn":        coSpawn,
+	"run":          coRun,
+	"go":           coGo,
+	"chan":         coChan,
+	"select":       coSelect,
+	"mutex":        coMutex,
+	"waitgroup":    coWaitGroup,
+	"atomic_int":   coAtomicInt,
+	"close":        coClose,
+	"count":        coCount,
+	"list":         coList,
 }
 
 func OpenCoroutineLib(ls LkState) int {
@@ -85,25 +96,78 @@ func coYield(ls LkState) int {
 func coStatus(ls LkState) int {
 	co := ls.ToThread(1)
 	ls.ArgCheck(co != nil, 1, "thread expected")
+	ls.PushString(_coStatus(ls, co))
+	return 1
+}
+
+func _coStatus(ls, co LkState) string {
 	if ls == co {
-		ls.PushString("running")
-	} else {
-		switch co.Status() {
-		case LK_YIELD:
-			ls.PushString("suspended")
-		case LK_OK:
-			if co.GetStack() { /* does it have frames? */
-				ls.PushString("normal") /* it is running */
-			} else if co.GetTop() == 0 {
-				ls.PushString("dead")
-			} else {
-				ls.PushString("suspended")
-			}
-		default: /* some error occurred */
-			ls.PushString("dead")
+		return "running"
+	}
+	switch co.Status() {
+	case LK_YIELD:
+		return "suspended"
+	case LK_OK:
+		if co.GetStack() { /* does it have frames? */
+			return "normal" /* it is running */
+		} else if co.GetTop() == 0 {
+			return "dead"
 		}
+		return "suspended"
+	default: /* some error occurred */
+		return "dead"
 	}
+}
+
+// coroutine.close (co)
+// http://www.lua.org/manual/5.4/manual.html#pdf-coroutine.close
+func coClose(ls LkState) int {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "thread expected")
+	if co.CloseThread(ls) == LK_OK {
+		ls.PushBoolean(true)
+		return 1
+	}
+	co.XMove(ls, 1) /* move error message */
+	ls.PushBoolean(false)
+	ls.Insert(-2)
+	return 2
+}
 
+// sync.count ()
+// Returns the number of coroutines started and not finished yet.
+func coCount(ls LkState) int {
+	ls.PushInteger(int64(len(ls.Threads())))
+	return 1
+}
+
+// sync.list ()
+// Returns the coroutines started and not finished yet, as a list of
+// {co, status, source, line}, where line is the current line of co.
+func coList(ls LkState) int {
+	threads := ls.Threads()
+	ls.CreateTable(len(threads), 0)
+	for i, co := range threads {
+		ls.CreateTable(0, 4)
+		ls.Push(co)
+		ls.SetField(-2, "co")
+		ls.PushString(_coStatus(ls, co))
+		ls.SetField(-2, "status")
+		for level := 0; ; level++ {
+			info, ok := co.GetStackInfo(level)
+			if !ok {
+				break
+			}
+			if info.CurrentLine >= 0 { /* skip Go functions, such as yield */
+				ls.PushString(info.Source)
+				ls.SetField(-2, "source")
+				ls.PushInteger(int64(info.CurrentLine))
+				ls.SetField(-2, "line")
+				break
+			}
+		}
+		ls.SetI(-2, int64(i))
+	}
 	return 1
 }
 
@@ -124,6 +188,22 @@ func coRunning(ls LkState) int {
 
 // coroutine.wrap (f)
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+// lua-5.3.4/src/lcorolib.c#luaB_cowrap()
 func coWrap(ls LkState) int {
-	panic("todo: coWrap!")
+	coCreate(ls)
+	ls.PushGoClosure(_auxWrap, 1)
+	return 1
+}
+
+// lua-5.3.4/src/lcorolib.c#auxwrap()
+func _auxWrap(ls LkState) int {
+	co := ls.ToThread(LkUpvalueIndex(1))
+	r := _auxResume(ls, co, ls.GetTop())
+	if r < 0 {
+		if ls.Type(-1) == LK_TSTRING { /* error object is a string? */
+			return ls.Error2("%s", ls.ToString(-1)) /* add location */
+		}
+		return ls.Error() /* propagate error */
+	}
+	return r
 }
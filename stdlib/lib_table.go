@@ -9,6 +9,7 @@ var tableLib = map[string]GoFunction{
 	"keys":     tableKeys,
 	"values":   tableValues,
 	"contains": tableHave,
+	"reserve":  tableReserve,
 }
 
 func OpenTableLib(ls LkState) int {
@@ -34,6 +35,26 @@ func tableLen(ls LkState) int {
 	return 1
 }
 
+// Items reserved at most by table.reserve: it is only a hint,
+// so a huge n can't allocate all the memory at once.
+const maxReserve = 1 << 20
+
+// table.reserve (list, n)
+// Makes room for n items in list, so filling it doesn't reallocate.
+// At most 2^20 items are reserved, longer lists grow as they are filled.
+// Returns list.
+func tableReserve(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	n := ls.CheckInteger(2)
+	ls.ArgCheck(n >= 0, 2, "invalid size")
+	if n > maxReserve {
+		n = maxReserve
+	}
+	ls.Reserve(1, int(n))
+	ls.SetTop(1)
+	return 1
+}
+
 func tableKeys(ls LkState) int {
 	t := CheckTable(ls, 1)
 	keys := make([]interface{}, 0)
@@ -0,0 +1,122 @@
+package stdlib
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Timers run their function as tasks of sync.run (or async.run):
+// they don't block the other tasks while waiting.
+
+var timeLib = map[string]GoFunction{
+	"after":  timeAfter,
+	"every":  timeEvery,
+	"now":    _nondet("time.now", timeNow),
+	"tick":   _nondet("time.tick", timeTick),
+	"format": timeFormat,
+	"parse":  timeParse,
+	"date":   timeDate,
+	"add":    timeAdd,
+	"diff":   timeDiff,
+}
+
+// registry key of the metatable of timers
+const timerMeta = "_TIMER"
+
+var timerMethods = map[string]GoFunction{
+	"cancel": timerCancel,
+}
+
+type lkTimer struct {
+	active atomic.Bool
+	once   sync.Once
+	cancel chan struct{} // closed by cancel
+}
+
+func OpenTimeLib(ls LkState) int {
+	ls.NewLib(timeLib)
+	return 1
+}
+
+// time.after (ms, f)
+// Calls f once, ms milliseconds after the next sync.run starts.
+// Returns a timer.
+func timeAfter(ls LkState) int {
+	return _newTimer(ls, false)
+}
+
+// time.every (ms, f)
+// Calls f every ms milliseconds, from the next sync.run,
+// until the timer is canceled.
+func timeEvery(ls LkState) int {
+	return _newTimer(ls, true)
+}
+
+func _newTimer(ls LkState, every bool) int {
+	ms := ls.CheckInteger(1)
+	ls.ArgCheck(ms >= 0 && (ms > 0 || !every), 1, "invalid interval")
+	ls.CheckType(2, LK_TFUNCTION)
+	t := &lkTimer{cancel: make(chan struct{})}
+	t.active.Store(true)
+	d := time.Duration(ms) * time.Millisecond
+
+	ls.SetTop(2)
+	ls.Remove(1) /* keep f, the arg of the task */
+	ls.PushGoFunction(func(ls LkState) int {
+		ctx := ls.Context()
+		var ticks <-chan time.Time
+		if every {
+			ticker := time.NewTicker(d)
+			defer ticker.Stop()
+			ticks = ticker.C
+		} else {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			ticks = timer.C
+		}
+		for {
+			fired := false
+			var err error
+			_await(ls, func() {
+				select {
+				case <-ticks:
+					fired = true
+				case <-t.cancel:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			})
+			if err != nil {
+				ls.Error2("interrupted: %v", err)
+			}
+			if !fired {
+				return 0
+			}
+			if !every {
+				t.active.Store(false)
+			}
+			ls.PushValue(1)
+			ls.Call(0, 0)
+			if !every || !t.active.Load() {
+				return 0
+			}
+		}
+	})
+	ls.Insert(1)
+	coSpawn(ls)
+	ls.Pop(1)
+	_pushUserData(ls, t, timerMeta, timerMethods)
+	return 1
+}
+
+// t:cancel ()
+// Stops the timer, and returns whether it was still active.
+func timerCancel(ls LkState) int {
+	t := _checkUserData[*lkTimer](ls, 1, "timer")
+	ls.PushBoolean(t.active.Swap(false))
+	t.once.Do(func() { close(t.cancel) })
+	return 1
+}
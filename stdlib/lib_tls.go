@@ -0,0 +1,166 @@
+package stdlib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// The connections of tls are the ones of net, encrypted.
+// The options of tls.dial, tls.listen and http.req are:
+// ca, the certificates trusted to sign the peer's one,
+// cert and key, the certificate of the script and its private key,
+// each the path of a pem file, or pem data;
+// insecure, to skip the verification of the server's certificate;
+// server_name, the name expected in it (by default, the host of addr).
+
+var tlsLib = map[string]GoFunction{
+	"dial":   tlsDial,
+	"listen": tlsListen,
+}
+
+type tlsOpts struct {
+	ca, cert, key string
+	serverName    string
+	insecure      bool
+}
+
+func OpenTlsLib(ls LkState) int {
+	ls.NewLib(tlsLib)
+	return 1
+}
+
+// tls.dial (addr [, opts])
+// Connects to addr ('host:port') over tls, giving up after opts.timeout ms
+// (default none).
+// Returns the connection, or nil and the error.
+func tlsDial(ls LkState) int {
+	addr := ls.CheckString(1)
+	opts := _checkTlsOpts(ls, 2)
+	var timeout int64
+	if ls.Type(2) == LK_TTABLE {
+		timeout = _optField[int64](ls, getTable(ls, 2), "timeout", "integer")
+	}
+	ls.Audit("tls.dial", addr)
+	cfg, err := opts.config(false)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+
+	d := tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: time.Duration(timeout) * time.Millisecond},
+		Config:    cfg,
+	}
+	ctx := ls.Context()
+	var c net.Conn
+	_await(ls, func() {
+		c, err = d.DialContext(ctx, "tcp", addr)
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushUserData(ls, _newConn(c), connMeta, connMethods)
+	ls.PushNil()
+	return 2
+}
+
+// tls.listen (addr, f, opts)
+// As net.listen, over tls: opts must have cert and key.
+// With opts.ca, the clients must have a certificate signed by it.
+func tlsListen(ls LkState) int {
+	addr := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	ls.CheckType(3, LK_TTABLE)
+	opts := _checkTlsOpts(ls, 3)
+	ls.ArgCheck(opts.cert != "" && opts.key != "", 3, "cert and key expected")
+	ls.Audit("tls.listen", addr)
+	cfg, err := opts.config(true)
+	if err == nil {
+		var l net.Listener
+		if l, err = net.Listen("tcp", addr); err == nil {
+			return _serve(ls, tls.NewListener(l, cfg), _openConn)
+		}
+	}
+	ls.PushNil()
+	ls.PushString(err.Error())
+	return 2
+}
+
+// _checkTlsOpts returns the tls options of the table at arg, if any.
+func _checkTlsOpts(ls LkState, arg int) tlsOpts {
+	if ls.IsNoneOrNil(arg) {
+		return tlsOpts{}
+	}
+	ls.CheckType(arg, LK_TTABLE)
+	return _tlsOptsOf(ls, getTable(ls, arg))
+}
+
+// _tlsOptsOf returns the tls options among the options m.
+func _tlsOptsOf(ls LkState, m lkMap) tlsOpts {
+	return tlsOpts{
+		ca:         _optField[string](ls, m, "ca", "str"),
+		cert:       _optField[string](ls, m, "cert", "str"),
+		key:        _optField[string](ls, m, "key", "str"),
+		serverName: _optField[string](ls, m, "server_name", "str"),
+		insecure:   _optField[bool](ls, m, "insecure", "bool"),
+	}
+}
+
+// config returns the tls config of a client, or of a server.
+func (o tlsOpts) config(server bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.insecure, ServerName: o.serverName}
+	if o.ca != "" {
+		data, err := _readPem(o.ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("ca: no certificate found")
+		}
+		if server {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.RootCAs = pool
+		}
+	}
+	if o.cert != "" || o.key != "" {
+		cert, err := _readPem(o.cert)
+		if err != nil {
+			return nil, err
+		}
+		key, err := _readPem(o.key)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+func (o tlsOpts) isZero() bool {
+	return o == tlsOpts{}
+}
+
+// _readPem returns s if it is pem data, else the content of the file s.
+func _readPem(s string) ([]byte, error) {
+	if strings.Contains(s, "-----BEGIN ") {
+		return []byte(s), nil
+	}
+	return os.ReadFile(s)
+}
@@ -0,0 +1,96 @@
+package stdlib
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Like json, TOML tables are tables and arrays are lists.
+
+var tomlLib = map[string]GoFunction{
+	"decode": tomlDecode,
+	"encode": tomlEncode,
+}
+
+func OpenTomlLib(ls LkState) int {
+	ls.NewLib(tomlLib)
+	return 1
+}
+
+// toml.decode (s)
+// Returns the table of the TOML document s, or nil and the error.
+// Dates and times are kept as strings.
+func tomlDecode(ls LkState) int {
+	s := ls.CheckString(1)
+	v := map[string]any{}
+	if _, err := toml.Decode(s, &v); err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushValue(ls, _tomlValue(v))
+	ls.PushNil()
+	return 2
+}
+
+// toml.encode (t)
+// Returns the TOML of the table t, or nil and the error.
+// TOML has no nil: nil values in lists are errors.
+func tomlEncode(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	v, err := getValue(ls, 1)
+	var buf bytes.Buffer
+	if err == nil {
+		if _, ok := v.(map[string]any); !ok {
+			v = map[string]any{} /* an empty table */
+			if ls.Len2(1) > 0 {
+				ls.ArgError(1, "map expected, got a list")
+			}
+		}
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		err = enc.Encode(v)
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(buf.String())
+	ls.PushNil()
+	return 2
+}
+
+// _tomlValue returns v with dates and times as strings.
+func _tomlValue(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k := range x {
+			x[k] = _tomlValue(x[k])
+		}
+	case []map[string]any:
+		list := make([]any, len(x))
+		for i := range x {
+			list[i] = _tomlValue(x[i])
+		}
+		return list
+	case []any:
+		for i := range x {
+			x[i] = _tomlValue(x[i])
+		}
+	case time.Time:
+		switch x.Location().String() { /* the zones of the local ones */
+		case "date-local":
+			return x.Format("2006-01-02")
+		case "time-local":
+			return x.Format("15:04:05.999999999")
+		case "datetime-local":
+			return x.Format("2006-01-02T15:04:05.999999999")
+		}
+		return x.Format(time.RFC3339Nano)
+	}
+	return v
+}
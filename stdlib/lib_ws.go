@@ -0,0 +1,291 @@
+package stdlib
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/lollipopkit/lk/api"
+)
+
+// As the connections of net, the ones of ws block only the task using them.
+
+var wsLib = map[string]GoFunction{
+	"connect": wsConnect,
+	"listen":  wsListen,
+	"upgrade": wsUpgrade,
+}
+
+// registry key of the metatable of connections
+const wsMeta = "_WS"
+
+var wsMethods = map[string]GoFunction{
+	"send":        wsSend,
+	"recv":        wsRecv,
+	"remote_addr": wsRemoteAddr,
+	"close":       wsClose,
+}
+
+const wsHandshakeTimeout = 10 * time.Second
+
+// refuses the requests from the pages of other sites
+var wsUpgrader = websocket.Upgrader{HandshakeTimeout: wsHandshakeTimeout}
+
+type lkWs struct {
+	c   *websocket.Conn
+	rmu sync.Mutex // a reader
+	wmu sync.Mutex // and a writer at a time
+}
+
+func OpenWsLib(ls LkState) int {
+	ls.NewLib(wsLib)
+	return 1
+}
+
+// ws.connect (url [, headers [, opts]])
+// Connects to the websocket url ('ws://...' or 'wss://...'),
+// sending the headers with the handshake.
+// opts are the tls options, as tls.dial.
+// Returns the connection, or nil and the error.
+func wsConnect(ls LkState) int {
+	url := ls.CheckString(1)
+	header := http.Header{}
+	if !ls.IsNoneOrNil(2) {
+		ls.CheckType(2, LK_TTABLE)
+		for k, v := range getTable(ls, 2) {
+			header.Set(k, fmt.Sprint(v))
+		}
+	}
+	opts := _checkTlsOpts(ls, 3)
+	ls.Audit("ws.connect", url)
+
+	d := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: wsHandshakeTimeout,
+	}
+	var err error
+	if !opts.isZero() {
+		if d.TLSClientConfig, err = opts.config(false); err != nil {
+			ls.PushNil()
+			ls.PushString(err.Error())
+			return 2
+		}
+	}
+	ctx := ls.Context()
+	var c *websocket.Conn
+	_await(ls, func() {
+		var resp *http.Response
+		c, resp, err = d.DialContext(ctx, url, header)
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			err = fmt.Errorf("%v: %s", err, resp.Status)
+		}
+	})
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushUserData(ls, &lkWs{c: c}, wsMeta, wsMethods)
+	ls.PushNil()
+	return 2
+}
+
+// ws.listen (addr, f [, opts])
+// As net.listen, for websockets: f(conn) is called with the connection
+// of each handshake. With opts.cert and opts.key, as tls.listen, over tls.
+func wsListen(ls LkState) int {
+	addr := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	opts := _checkTlsOpts(ls, 3)
+	ls.Audit("ws.listen", addr)
+	var cfg *tls.Config
+	var err error
+	if opts.cert != "" || opts.key != "" {
+		cfg, err = opts.config(true)
+	}
+	if err == nil {
+		var l net.Listener
+		if l, err = net.Listen("tcp", addr); err == nil {
+			if cfg != nil {
+				l = tls.NewListener(l, cfg)
+			}
+			return _serve(ls, l, _openWs)
+		}
+	}
+	ls.PushNil()
+	ls.PushString(err.Error())
+	return 2
+}
+
+// ws.upgrade (req)
+// Answers req, the request of a function of http.listen, with the
+// handshake of a websocket. The connection is closed once the function
+// returns, and its results are ignored.
+// Returns the connection, or nil and the error, which is answered.
+func wsUpgrade(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	v, ok := httpExchanges.Load(ls.ToPointer(1))
+	ls.ArgCheck(ok, 1, "request of http.listen expected")
+	ex := v.(*httpExchange)
+	if ex.replied {
+		return ls.Error2("request already answered")
+	}
+	ex.replied = true
+	c, err := wsUpgrader.Upgrade(ex.w, ex.r, nil)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	w := &lkWs{c: c}
+	ex.upgraded = w
+	_pushUserData(ls, w, wsMeta, wsMethods)
+	ls.PushNil()
+	return 2
+}
+
+// conn:send (data [, binary])
+// Sends the message data, of text, or binary if binary is true.
+// Returns the error, or nil.
+func wsSend(ls LkState) int {
+	w := _checkUserData[*lkWs](ls, 1, "ws")
+	data := ls.CheckString(2)
+	kind := websocket.TextMessage
+	if ls.ToBoolean(3) {
+		kind = websocket.BinaryMessage
+	}
+	err := _netDo(ls, w.c.UnderlyingConn(), func() error {
+		w.wmu.Lock()
+		defer w.wmu.Unlock()
+		return w.c.WriteMessage(kind, []byte(data))
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// conn:recv ()
+// Waits for a message. Returns it, nil once the peer closed,
+// or nil and the error.
+func wsRecv(ls LkState) int {
+	w := _checkUserData[*lkWs](ls, 1, "ws")
+	var data []byte
+	err := _netDo(ls, w.c.UnderlyingConn(), func() (err error) {
+		w.rmu.Lock()
+		defer w.rmu.Unlock()
+		_, data, err = w.c.ReadMessage()
+		return err
+	})
+	if err != nil {
+		ls.PushNil()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) ||
+			errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			ls.PushNil()
+			return 2
+		}
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(string(data))
+	ls.PushNil()
+	return 2
+}
+
+// conn:remote_addr ()
+func wsRemoteAddr(ls LkState) int {
+	w := _checkUserData[*lkWs](ls, 1, "ws")
+	ls.PushString(w.c.RemoteAddr().String())
+	return 1
+}
+
+// conn:close ([code [, reason]])
+// Tells the peer the connection is closed, with code (default 1000,
+// normal) and reason, and closes it. Returns the error, or nil.
+func wsClose(ls LkState) int {
+	w := _checkUserData[*lkWs](ls, 1, "ws")
+	code := ls.OptInteger(2, websocket.CloseNormalClosure)
+	reason := ls.OptString(3, "")
+	if err := w.close(int(code), reason); err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+func (w *lkWs) close(code int, reason string) error {
+	w.wmu.Lock()
+	w.c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+	w.wmu.Unlock()
+	return w.c.Close()
+}
+
+func (w *lkWs) Close() error {
+	return w.close(websocket.CloseNormalClosure, "")
+}
+
+// _openWs answers the handshake of c, and pushes the connection, for _serve.
+func _openWs(ls LkState, c net.Conn) io.Closer {
+	var ws *websocket.Conn
+	err := _netDo(ls, c, func() error {
+		c.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+		br := bufio.NewReader(c)
+		r, err := http.ReadRequest(br)
+		if err != nil {
+			return err
+		}
+		h := &wsHandshake{c: c, brw: bufio.NewReadWriter(br, bufio.NewWriter(c)), header: http.Header{}}
+		if ws, err = wsUpgrader.Upgrade(h, r, nil); err != nil {
+			return err
+		}
+		return c.SetDeadline(time.Time{})
+	})
+	if err != nil {
+		c.Close()
+		return nil
+	}
+	w := &lkWs{c: ws}
+	_pushUserData(ls, w, wsMeta, wsMethods)
+	return w
+}
+
+// wsHandshake is the http.ResponseWriter of the handshake of a
+// connection of ws.listen.
+type wsHandshake struct {
+	c      net.Conn
+	brw    *bufio.ReadWriter
+	header http.Header
+}
+
+func (h *wsHandshake) Header() http.Header {
+	return h.header
+}
+
+func (h *wsHandshake) WriteHeader(code int) {
+	fmt.Fprintf(h.brw, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	h.header.Write(h.brw)
+	h.brw.WriteString("\r\n")
+}
+
+func (h *wsHandshake) Write(p []byte) (int, error) {
+	n, err := h.brw.Write(p)
+	if err == nil {
+		err = h.brw.Flush()
+	}
+	return n, err
+}
+
+func (h *wsHandshake) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.c, h.brw, nil
+}
@@ -0,0 +1,457 @@
+package stdlib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// An element is a table:
+// {'tag': name, 'attrs': {name: value}, 'children': {elements}, 'text': text}
+// where text is the text directly in the element, trimmed.
+// Namespace prefixes are dropped.
+
+var xmlLib = map[string]GoFunction{
+	"decode": xmlDecode,
+	"encode": xmlEncode,
+	"find":   xmlFind,
+	"first":  xmlFirst,
+}
+
+func OpenXmlLib(ls LkState) int {
+	ls.NewLib(xmlLib)
+	return 1
+}
+
+type xmlNode struct {
+	tag      string
+	attrs    map[string]string
+	children []*xmlNode
+	text     strings.Builder
+}
+
+// xml.decode (s)
+// Returns the root element of the XML s, or nil and the error.
+func xmlDecode(ls LkState) int {
+	s := ls.CheckString(1)
+	root, err := _parseXml(s)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	_pushXmlNode(ls, root)
+	ls.PushNil()
+	return 2
+}
+
+func _parseXml(s string) (*xmlNode, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	var root *xmlNode
+	stack := []*xmlNode{}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{tag: t.Name.Local, attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				name := a.Name.Local
+				if a.Name.Space == "xmlns" {
+					name = "xmlns:" + name
+				}
+				n.attrs[name] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+func _pushXmlNode(ls LkState, n *xmlNode) {
+	ls.CheckStack2(4, "too many nested elements")
+	ls.CreateTable(0, 4)
+	ls.PushString(n.tag)
+	ls.SetField(-2, "tag")
+	pushTable(ls, n.attrs)
+	ls.SetField(-2, "attrs")
+	ls.PushString(strings.TrimSpace(n.text.String()))
+	ls.SetField(-2, "text")
+	ls.CreateTable(len(n.children), 0)
+	for i, c := range n.children {
+		_pushXmlNode(ls, c)
+		ls.SetI(-2, int64(i))
+	}
+	ls.SetField(-2, "children")
+}
+
+// xml.encode (elem [, indent])
+// Returns the XML of the element elem, or nil and the error.
+// Its text is written before its children, which may be elements or
+// strings. With indent, each element is on its own line, indented,
+// unless it has string children.
+func xmlEncode(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	indent := ls.OptString(2, "")
+	ls.SetTop(1)
+	var buf bytes.Buffer
+	if err := _writeXml(ls, &buf, indent, 0); err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(buf.String())
+	ls.PushNil()
+	return 2
+}
+
+// _writeXml writes the element at the top of the stack.
+func _writeXml(ls LkState, buf *bytes.Buffer, indent string, depth int) error {
+	if depth >= 200 || !ls.CheckStack(4) {
+		return errors.New("elements nested too deep, or a cycle")
+	}
+	elem := ls.GetTop()
+	ls.GetField(elem, "tag")
+	tag, ok := ls.ToStringX(-1)
+	ls.Pop(1)
+	if !ok || tag == "" {
+		return errors.New("element without tag")
+	}
+	pad := ""
+	if indent != "" {
+		pad = strings.Repeat(indent, depth)
+	}
+	buf.WriteString(pad + "<" + tag)
+
+	if ls.GetField(elem, "attrs") == LK_TTABLE {
+		attrs := map[string]string{}
+		names := []string{}
+		for k, v := range getTable(ls, ls.GetTop()) {
+			attrs[k] = fmt.Sprint(v)
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buf.WriteString(" " + name + `="`)
+			xml.EscapeText(buf, []byte(attrs[name]))
+			buf.WriteByte('"')
+		}
+	}
+	ls.Pop(1)
+
+	ls.GetField(elem, "text")
+	text, _ := ls.ToStringX(-1)
+	ls.Pop(1)
+	ls.GetField(elem, "children")
+	children := ls.GetTop()
+	n := int64(0)
+	mixed := false
+	if ls.IsTable(children) {
+		n = ls.Len2(children)
+		for i := int64(0); i < n; i++ {
+			if ls.GetI(children, i) == LK_TSTRING {
+				mixed = true
+			}
+			ls.Pop(1)
+		}
+	}
+	if text == "" && n == 0 {
+		buf.WriteString("/>")
+		ls.Pop(1)
+		return nil
+	}
+	buf.WriteByte('>')
+	xml.EscapeText(buf, []byte(text))
+	for i := int64(0); i < n; i++ {
+		switch ls.GetI(children, i) {
+		case LK_TSTRING:
+			xml.EscapeText(buf, []byte(ls.ToString(-1)))
+		case LK_TTABLE:
+			if indent != "" && !mixed {
+				buf.WriteByte('\n')
+				if err := _writeXml(ls, buf, indent, depth+1); err != nil {
+					return err
+				}
+			} else if err := _writeXml(ls, buf, "", depth+1); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("child %d of %s is not an element", i, tag)
+		}
+		ls.Pop(1)
+	}
+	ls.Pop(1)
+	if indent != "" && !mixed && n > 0 {
+		buf.WriteString("\n" + pad)
+	}
+	buf.WriteString("</" + tag + ">")
+	return nil
+}
+
+// a step of a path of xml.find
+type xmlStep struct {
+	descendant bool   // after //
+	name       string // tag, * or .; @attr or text() at the end
+	attr       string // [@attr] or [@attr='value']
+	value      *string
+	index      int // [n], from 0; -1 if none
+}
+
+// xml.find (elem, path)
+// Returns the list of what matches path from elem. path is like XPath:
+// steps separated by / match the children, // the descendants.
+// A step is a tag, * or ., with an optional [@attr], [@attr='value']
+// or [n], the nth match (from 0). A path starting with / starts at elem
+// itself. The last step may be @attr for the values of an attribute,
+// or text() for the texts, e.g. //item[@id="3"]/name/text().
+func xmlFind(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	steps, absolute, err := _parseXPath(ls.CheckString(2))
+	if err != nil {
+		return ls.ArgError(2, err.Error())
+	}
+	ls.SetTop(2)
+	_xmlFind(ls, steps, absolute)
+	return 1
+}
+
+// xml.first (elem, path)
+// Returns the first match of path from elem, or nil.
+func xmlFirst(ls LkState) int {
+	xmlFind(ls)
+	ls.GetI(-1, 0)
+	return 1
+}
+
+// _parseXPath returns the steps of path, and whether it starts at elem.
+func _parseXPath(path string) ([]xmlStep, bool, error) {
+	steps := []xmlStep{}
+	descendant := false
+	for i := 0; i < len(path); {
+		if strings.HasPrefix(path[i:], "//") {
+			descendant, i = true, i+2
+			continue
+		}
+		if path[i] == '/' {
+			i++
+			continue
+		}
+		/* up to the next / out of a predicate */
+		j, depth := i, 0
+		for ; j < len(path) && (depth > 0 || path[j] != '/'); j++ {
+			switch path[j] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+		}
+		step, err := _parseXPathStep(path[i:j])
+		if err != nil {
+			return nil, false, fmt.Errorf("%v in '%s'", err, path)
+		}
+		step.descendant = descendant
+		descendant = false
+		steps = append(steps, step)
+		i = j
+	}
+	if len(steps) == 0 || descendant {
+		return nil, false, fmt.Errorf("invalid path '%s'", path)
+	}
+	for _, s := range steps[:len(steps)-1] {
+		if s.name == "text()" || strings.HasPrefix(s.name, "@") {
+			return nil, false, fmt.Errorf("%s must end path '%s'", s.name, path)
+		}
+	}
+	return steps, strings.HasPrefix(path, "/"), nil
+}
+
+func _parseXPathStep(s string) (xmlStep, error) {
+	step := xmlStep{name: s, index: -1}
+	b := strings.IndexByte(s, '[')
+	if b < 0 {
+		if s == "" {
+			return step, errors.New("empty step")
+		}
+		return step, nil
+	}
+	if !strings.HasSuffix(s, "]") || b == 0 {
+		return step, fmt.Errorf("invalid step '%s'", s)
+	}
+	step.name = s[:b]
+	pred := s[b+1 : len(s)-1]
+	if !strings.HasPrefix(pred, "@") {
+		n, err := strconv.Atoi(pred)
+		if err != nil || n < 0 {
+			return step, fmt.Errorf("invalid predicate '%s'", pred)
+		}
+		step.index = n
+		return step, nil
+	}
+	attr, value, hasValue := strings.Cut(pred[1:], "=")
+	step.attr = attr
+	if hasValue {
+		if len(value) < 2 || (value[0] != '\'' && value[0] != '"') || value[len(value)-1] != value[0] {
+			return step, fmt.Errorf("invalid predicate '%s'", pred)
+		}
+		value = value[1 : len(value)-1]
+		step.value = &value
+	}
+	return step, nil
+}
+
+// _xmlFind pushes the list of the matches of steps from the element at 1.
+func _xmlFind(ls LkState, steps []xmlStep, absolute bool) {
+	/* the current set, a list */
+	ls.CreateTable(1, 0)
+	ls.PushValue(1)
+	if absolute { /* elem is then the child of a root */
+		top := ls.GetTop()
+		ls.CreateTable(0, 1)
+		ls.CreateTable(1, 0)
+		ls.PushValue(1)
+		ls.SetI(-2, 0)
+		ls.SetField(-2, "children")
+		ls.Replace(top)
+	}
+	ls.SetI(-2, 0)
+	for _, step := range steps {
+		cur := ls.GetTop()
+		ls.CreateTable(0, 0)
+		out := ls.GetTop()
+		for i := int64(0); i < ls.Len2(cur); i++ {
+			ls.GetI(cur, i)
+			if step.descendant {
+				_xmlDescend(ls, step, ls.GetTop(), out, 0)
+			} else {
+				_xmlChildren(ls, step, ls.GetTop(), out)
+			}
+			ls.Pop(1)
+		}
+		ls.Remove(cur)
+	}
+}
+
+// _xmlDescend applies step to elem and its descendants.
+func _xmlDescend(ls LkState, step xmlStep, elem, out, depth int) {
+	if depth >= 200 || !ls.CheckStack(4) {
+		ls.Error2("elements nested too deep, or a cycle")
+	}
+	_xmlChildren(ls, step, elem, out)
+	if ls.GetField(elem, "children") == LK_TTABLE {
+		children := ls.GetTop()
+		for i := int64(0); i < ls.Len2(children); i++ {
+			if ls.GetI(children, i) == LK_TTABLE {
+				_xmlDescend(ls, step, ls.GetTop(), out, depth+1)
+			}
+			ls.Pop(1)
+		}
+	}
+	ls.Pop(1)
+}
+
+// _xmlChildren appends to out what step matches in the children of elem.
+func _xmlChildren(ls LkState, step xmlStep, elem, out int) {
+	switch {
+	case step.name == ".":
+		if _xmlMatch(ls, step, elem) {
+			_xmlAppend(ls, out, elem)
+		}
+		return
+	case step.name == "text()":
+		ls.GetField(elem, "text")
+		if ls.IsString(-1) {
+			_xmlAppend(ls, out, ls.GetTop())
+		}
+		ls.Pop(1)
+		return
+	case strings.HasPrefix(step.name, "@"):
+		if ls.GetField(elem, "attrs") == LK_TTABLE {
+			ls.GetField(-1, step.name[1:])
+			if !ls.IsNil(-1) {
+				_xmlAppend(ls, out, ls.GetTop())
+			}
+			ls.Pop(1)
+		}
+		ls.Pop(1)
+		return
+	}
+
+	if ls.GetField(elem, "children") != LK_TTABLE {
+		ls.Pop(1)
+		return
+	}
+	children := ls.GetTop()
+	nth := 0
+	for i := int64(0); i < ls.Len2(children); i++ {
+		ls.GetI(children, i)
+		if _xmlMatch(ls, step, ls.GetTop()) {
+			if step.index < 0 || nth == step.index {
+				_xmlAppend(ls, out, ls.GetTop())
+			}
+			nth++
+		}
+		ls.Pop(1)
+	}
+	ls.Pop(1)
+}
+
+// _xmlMatch reports whether the value at idx is an element matching
+// the tag and attribute of step.
+func _xmlMatch(ls LkState, step xmlStep, idx int) bool {
+	if !ls.IsTable(idx) {
+		return false
+	}
+	if step.name != "*" && step.name != "." {
+		ls.GetField(idx, "tag")
+		tag, _ := ls.ToStringX(-1)
+		ls.Pop(1)
+		if tag != step.name {
+			return false
+		}
+	}
+	if step.attr == "" {
+		return true
+	}
+	defer ls.SetTop(ls.GetTop())
+	if ls.GetField(idx, "attrs") != LK_TTABLE || ls.GetField(-1, step.attr) == LK_TNIL {
+		return false
+	}
+	if step.value == nil {
+		return true
+	}
+	v, _ := ls.ToStringX(-1)
+	return v == *step.value
+}
+
+func _xmlAppend(ls LkState, list, idx int) {
+	ls.PushValue(idx)
+	ls.SetI(list, ls.Len2(list))
+}
@@ -0,0 +1,86 @@
+package stdlib
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+	"gopkg.in/yaml.v3"
+)
+
+// Like json, YAML maps are tables and sequences are lists.
+
+var yamlLib = map[string]GoFunction{
+	"decode": yamlDecode,
+	"encode": yamlEncode,
+}
+
+func OpenYamlLib(ls LkState) int {
+	ls.NewLib(yamlLib)
+	return 1
+}
+
+// yaml.decode (s)
+// Returns the value of the YAML document s, or nil and the error.
+// Timestamps are kept as strings.
+func yamlDecode(ls LkState) int {
+	s := ls.CheckString(1)
+	var v any
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushValue(ls, _yamlValue(v))
+	ls.PushNil()
+	return 2
+}
+
+// yaml.encode (v)
+// Returns the YAML of v, indented by 2 spaces, or nil and the error.
+func yamlEncode(ls LkState) int {
+	v, err := getValue(ls, 1)
+	var buf bytes.Buffer
+	if err == nil {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(v); err == nil {
+			err = enc.Close()
+		}
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(buf.String())
+	ls.PushNil()
+	return 2
+}
+
+// _yamlValue returns v with string keys, and timestamps as strings.
+func _yamlValue(v any) any {
+	switch x := v.(type) {
+	case map[string]any:
+		for k := range x {
+			x[k] = _yamlValue(x[k])
+		}
+	case map[any]any:
+		m := make(map[string]any, len(x))
+		for k := range x {
+			m[fmt.Sprint(k)] = _yamlValue(x[k])
+		}
+		return m
+	case []any:
+		for i := range x {
+			x[i] = _yamlValue(x[i])
+		}
+	case time.Time:
+		if x.Equal(x.Truncate(24*time.Hour)) && x.Location() == time.UTC {
+			return x.Format("2006-01-02")
+		}
+		return x.Format(time.RFC3339Nano)
+	}
+	return v
+}
@@ -0,0 +1,164 @@
+package stdlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+var zipLib = map[string]GoFunction{
+	"gzip_compress":   _compressFunc("gzip"),
+	"gzip_decompress": _decompressFunc("gzip"),
+	"zlib_compress":   _compressFunc("zlib"),
+	"zlib_decompress": _decompressFunc("zlib"),
+	"compress_file":   zipCompressFile,
+	"decompress_file": zipDecompressFile,
+}
+
+func OpenZipLib(ls LkState) int {
+	ls.NewLib(zipLib)
+	return 1
+}
+
+// zip.gzip_compress (s [, level])
+// zip.zlib_compress (s [, level])
+// Returns s compressed, at level from 1 (fastest) to 9 (smallest),
+// by default 6.
+func _compressFunc(format string) GoFunction {
+	return func(ls LkState) int {
+		s := ls.CheckString(1)
+		level := _checkLevel(ls, 2)
+		var buf bytes.Buffer
+		w, _ := _newCompressor(format, &buf, level)
+		w.Write([]byte(s))
+		w.Close()
+		ls.PushString(buf.String())
+		return 1
+	}
+}
+
+// zip.gzip_decompress (s)
+// zip.zlib_decompress (s)
+// Returns s decompressed, or nil and the error.
+func _decompressFunc(format string) GoFunction {
+	return func(ls LkState) int {
+		s := ls.CheckString(1)
+		r, err := _newDecompressor(format, bytes.NewReader([]byte(s)))
+		if err != nil {
+			return _pushResult(ls, "", err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return _pushResult(ls, string(data), err)
+	}
+}
+
+// zip.compress_file (src, dst [, format [, level]])
+// Compresses the file src to dst, in format 'gzip' (default) or 'zlib',
+// without reading it all in memory. Returns the error, or nil.
+func zipCompressFile(ls LkState) int {
+	src := ls.CheckString(1)
+	dst := ls.CheckString(2)
+	format := _checkFormat(ls, 3)
+	level := _checkLevel(ls, 4)
+	if !_mutate(ls, "zip.compress_file", src, dst) {
+		ls.PushNil()
+		return 1
+	}
+	err := _copyFile(src, dst, func(w io.Writer, r io.Reader) error {
+		zw, _ := _newCompressor(format, w, level)
+		if _, err := io.Copy(zw, r); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// zip.decompress_file (src, dst [, format])
+// Decompresses the file src to dst, from format 'gzip' (default)
+// or 'zlib'. Returns the error, or nil.
+func zipDecompressFile(ls LkState) int {
+	src := ls.CheckString(1)
+	dst := ls.CheckString(2)
+	format := _checkFormat(ls, 3)
+	if !_mutate(ls, "zip.decompress_file", src, dst) {
+		ls.PushNil()
+		return 1
+	}
+	err := _copyFile(src, dst, func(w io.Writer, r io.Reader) error {
+		zr, err := _newDecompressor(format, r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		_, err = io.Copy(w, zr)
+		return err
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+func _checkFormat(ls LkState, arg int) string {
+	format := ls.OptString(arg, "gzip")
+	ls.ArgCheck(format == "gzip" || format == "zlib", arg, "invalid format '"+format+"'")
+	return format
+}
+
+func _checkLevel(ls LkState, arg int) int {
+	level := ls.OptInteger(arg, 6)
+	ls.ArgCheck(level >= 1 && level <= 9, arg, "level out of range")
+	return int(level)
+}
+
+// _newCompressor returns a gzip or zlib writer to w.
+// The error is for an invalid level only.
+func _newCompressor(format string, w io.Writer, level int) (io.WriteCloser, error) {
+	if format == "zlib" {
+		return zlib.NewWriterLevel(w, level)
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func _newDecompressor(format string, r io.Reader) (io.ReadCloser, error) {
+	if format == "zlib" {
+		return zlib.NewReader(r)
+	}
+	return gzip.NewReader(r)
+}
+
+// _copyFile writes dst with copy from src. dst is removed if copy fails.
+func _copyFile(src, dst string, copy func(w io.Writer, r io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	err = copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("%s: %v", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package stdlib
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// os.hostname ()
+// Returns the host name, or nil and the error.
+func osHostname(ls LkState) int {
+	name, err := os.Hostname()
+	return _pushResult(ls, name, err)
+}
+
+// os.user ()
+// Returns the name of the current user, or nil and the error.
+func osUser(ls LkState) int {
+	u, err := user.Current()
+	if err != nil {
+		return _pushResult(ls, "", err)
+	}
+	return _pushResult(ls, u.Username, nil)
+}
+
+// os.home ()
+// Returns the home dir of the current user, or nil and the error.
+func osHome(ls LkState) int {
+	dir, err := os.UserHomeDir()
+	return _pushResult(ls, dir, err)
+}
+
+// os.platform ()
+// Returns the OS: 'linux', 'darwin', 'windows'...
+func osPlatform(ls LkState) int {
+	ls.PushString(runtime.GOOS)
+	return 1
+}
+
+// os.arch ()
+// Returns the architecture: 'amd64', 'arm64'...
+func osArch(ls LkState) int {
+	ls.PushString(runtime.GOARCH)
+	return 1
+}
+
+// os.cpus ()
+// Returns the number of logical CPUs.
+func osCpus(ls LkState) int {
+	ls.PushInteger(int64(runtime.NumCPU()))
+	return 1
+}
+
+// os.pid ()
+// Returns the id of the process.
+func osPid(ls LkState) int {
+	ls.PushInteger(int64(os.Getpid()))
+	return 1
+}
+
+// _pushResult pushes s and nil, or nil and err.
+func _pushResult(ls LkState, s string, err error) int {
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushString(s)
+	ls.PushNil()
+	return 2
+}
@@ -0,0 +1,228 @@
+package stdlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// a line written by a process, see lineWriter
+type procLine struct {
+	stderr bool
+	line   string
+}
+
+// lineWriter sends the lines written to it on lines.
+type lineWriter struct {
+	stderr bool
+	buf    []byte
+	lines  chan<- procLine
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.lines <- procLine{w.stderr, strings.TrimSuffix(string(w.buf[:i]), "\r")}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush sends the last line, which has no newline.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.lines <- procLine{w.stderr, string(w.buf)}
+		w.buf = nil
+	}
+}
+
+// os.proc (opts)
+// Runs a command, without a shell, and waits for it. opts are:
+//   - cmd: list of the program and its args, required
+//   - env: table of vars added to the environment
+//   - cwd: dir to run in
+//   - stdin: string given as input
+//   - timeout: in ms, after which the process is killed
+//   - on_stdout, on_stderr: functions called with each line of the
+//     output as soon as it is written; returning false kills the process
+//
+// Returns {'code': exit code, 'stdout': output, 'stderr': error output},
+// without the streamed outputs, and nil, or:
+// nil and the error if the command can't start,
+// the result and 'timeout' or 'killed' if the process was killed.
+func osProc(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	ls.SetTop(1)
+	args := _procArgs(ls)
+	if !_mutate(ls, "os.proc", args) {
+		pushTable(ls, lkMap{"code": int64(0), "stdout": "", "stderr": ""})
+		ls.PushNil()
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(ls.Context())
+	defer cancel()
+	if ls.GetField(1, "timeout") != LK_TNIL {
+		ms, ok := ls.ToIntegerX(-1)
+		if !ok || ms <= 0 {
+			return ls.Error2("field 'timeout' is not a positive integer")
+		}
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+	ls.Pop(1)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.WaitDelay = time.Second /* don't wait for children holding the outputs */
+	cmd.Dir = _optStringField(ls, "cwd")
+	if ls.GetField(1, "env") == LK_TTABLE {
+		cmd.Env = os.Environ()
+		for k, v := range getTable(ls, ls.GetTop()) {
+			cmd.Env = append(cmd.Env, k+"="+fmt.Sprint(v))
+		}
+	}
+	ls.Pop(1)
+	if stdin := _optStringField(ls, "stdin"); stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	/* stack: opts, on_stdout, on_stderr */
+	lines := make(chan procLine)
+	defer func() {
+		go func() { /* let the writers end, even if a callback failed */
+			for range lines {
+			}
+		}()
+	}()
+	var stdout, stderr bytes.Buffer
+	writers := []*lineWriter{}
+	for i, name := range []string{"on_stdout", "on_stderr"} {
+		if ls.GetField(1, name) == LK_TNIL {
+			if i == 0 {
+				cmd.Stdout = &stdout
+			} else {
+				cmd.Stderr = &stderr
+			}
+			continue
+		}
+		if !ls.IsFunction(-1) {
+			return ls.Error2("field '%s' is not a function", name)
+		}
+		w := &lineWriter{stderr: i == 1, lines: lines}
+		writers = append(writers, w)
+		if i == 0 {
+			cmd.Stdout = w
+		} else {
+			cmd.Stderr = w
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		for _, w := range writers {
+			w.flush()
+		}
+		close(lines)
+	}()
+
+	killed := false
+	for {
+		var l procLine
+		ok := false
+		_await(ls, func() {
+			l, ok = <-lines
+		})
+		if !ok {
+			break
+		}
+		if killed { /* drain the output */
+			continue
+		}
+		if l.stderr {
+			ls.PushValue(3)
+		} else {
+			ls.PushValue(2)
+		}
+		ls.PushString(l.line)
+		ls.Call(1, 1)
+		if ls.Type(-1) == LK_TBOOLEAN && !ls.ToBoolean(-1) {
+			killed = true
+			cancel()
+		}
+		ls.Pop(1)
+	}
+
+	var exitErr *exec.ExitError
+	if waitErr != nil && !errors.As(waitErr, &exitErr) {
+		ls.PushNil()
+		ls.PushString(waitErr.Error())
+		return 2
+	}
+	pushTable(ls, lkMap{
+		"code":   int64(cmd.ProcessState.ExitCode()),
+		"stdout": stdout.String(),
+		"stderr": stderr.String(),
+	})
+	switch {
+	case killed:
+		ls.PushString("killed")
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		ls.PushString("timeout")
+	case ls.Context().Err() != nil:
+		return ls.Error2("interrupted: %v", ls.Context().Err())
+	default:
+		ls.PushNil()
+	}
+	return 2
+}
+
+// _procArgs returns the cmd list of the opts at 1.
+func _procArgs(ls LkState) []string {
+	if ls.GetField(1, "cmd") != LK_TTABLE {
+		ls.Error2("field 'cmd' is not a list")
+	}
+	n := ls.Len2(-1)
+	if n == 0 {
+		ls.Error2("field 'cmd' is empty")
+	}
+	args := make([]string, n)
+	for i := range args {
+		ls.GetI(-1, int64(i))
+		s, ok := ls.ToStringX(-1)
+		if !ok {
+			ls.Error2("cmd[%d] is not a str", i)
+		}
+		args[i] = s
+		ls.Pop(1)
+	}
+	ls.Pop(1)
+	return args
+}
+
+func _optStringField(ls LkState, key string) string {
+	defer ls.Pop(1)
+	if ls.GetField(1, key) == LK_TNIL {
+		return ""
+	}
+	s, ok := ls.ToStringX(-1)
+	if !ok {
+		ls.Error2("field '%s' is not a str", key)
+	}
+	return s
+}
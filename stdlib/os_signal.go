@@ -0,0 +1,142 @@
+package stdlib
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Like timers, signal handlers are tasks of sync.run (or async.run):
+// they don't block the other tasks while waiting.
+
+// registry key of the metatable of signal handlers
+const signalMeta = "_SIGNAL"
+
+var signalMethods = map[string]GoFunction{
+	"stop": signalStop,
+}
+
+type lkSignal struct {
+	active atomic.Bool
+	once   sync.Once
+	stop   chan struct{} // closed by stop
+}
+
+// count of the running handlers of each signal, see SignalHandled
+var handled = struct {
+	sync.Mutex
+	m map[os.Signal]int
+}{m: map[os.Signal]int{}}
+
+// SignalHandled reports whether a script handles sig,
+// which the runtime must then not handle itself.
+func SignalHandled(sig os.Signal) bool {
+	handled.Lock()
+	defer handled.Unlock()
+	return handled.m[sig] > 0
+}
+
+func _handle(sig os.Signal, d int) {
+	handled.Lock()
+	defer handled.Unlock()
+	handled.m[sig] += d
+}
+
+func _checkSignal(ls LkState, arg int) (string, os.Signal) {
+	name := strings.TrimPrefix(strings.ToUpper(ls.CheckString(arg)), "SIG")
+	sig, ok := signals[name]
+	if !ok {
+		ls.ArgError(arg, "unknown signal")
+	}
+	return name, sig
+}
+
+// os.on_signal (name, f)
+// Calls f(name) each time the process receives the signal name
+// ('INT', 'TERM', 'HUP'...), from the next sync.run, until the
+// handler is stopped. While it runs, Ctrl+C doesn't interrupt the script.
+// Returns the handler.
+func osOnSignal(ls LkState) int {
+	name, sig := _checkSignal(ls, 1)
+	ls.CheckType(2, LK_TFUNCTION)
+	s := &lkSignal{stop: make(chan struct{})}
+	s.active.Store(true)
+
+	ls.SetTop(2)
+	ls.Remove(1) /* keep f, the arg of the task */
+	ls.PushGoFunction(func(ls LkState) int {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, sig)
+		_handle(sig, 1)
+		defer func() {
+			signal.Stop(c)
+			_handle(sig, -1)
+		}()
+		ctx := ls.Context()
+		for {
+			received := false
+			var err error
+			_await(ls, func() {
+				select {
+				case <-c:
+					received = true
+				case <-s.stop:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			})
+			if err != nil {
+				ls.Error2("interrupted: %v", err)
+			}
+			if !received || !s.active.Load() {
+				return 0
+			}
+			ls.PushValue(1)
+			ls.PushString(name)
+			ls.Call(1, 0)
+		}
+	})
+	ls.Insert(1)
+	coSpawn(ls)
+	ls.Pop(1)
+	_pushUserData(ls, s, signalMeta, signalMethods)
+	return 1
+}
+
+// h:stop ()
+// Stops the handler, and returns whether it was still active.
+func signalStop(ls LkState) int {
+	s := _checkUserData[*lkSignal](ls, 1, "signal handler")
+	ls.PushBoolean(s.active.Swap(false))
+	s.once.Do(func() { close(s.stop) })
+	return 1
+}
+
+// os.kill (pid [, name])
+// Sends the signal name (default 'TERM') to the process pid.
+// Returns the error, or nil.
+func osKill(ls LkState) int {
+	pid := ls.CheckInteger(1)
+	name, sig := "TERM", signals["TERM"]
+	if !ls.IsNoneOrNil(2) {
+		name, sig = _checkSignal(ls, 2)
+	}
+	if !_mutate(ls, "os.kill", pid, name) {
+		ls.PushNil()
+		return 1
+	}
+	p, err := os.FindProcess(int(pid))
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package stdlib
+
+import (
+	"os"
+	"syscall"
+)
+
+// On Windows, only KILL can be sent, and only INT and TERM received.
+var signals = map[string]os.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+}
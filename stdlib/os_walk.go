@@ -0,0 +1,130 @@
+package stdlib
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// os.walk (dir, fn)
+// Calls fn(path, info) for dir and all the files and dirs under it,
+// in lexical order. info is a table like the one of os.stat.
+// fn returns false to stop the walk, or 'skip' to skip a dir.
+// Returns the error, or nil.
+func osWalk(ls LkState) int {
+	dir := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	ls.Audit("os.walk", dir)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		ls.PushValue(2)
+		ls.PushString(path)
+		_pushFileInfo(ls, info)
+		ls.Call(2, 1)
+		defer ls.Pop(1)
+		if ls.Type(-1) == LK_TBOOLEAN && !ls.ToBoolean(-1) {
+			return fs.SkipAll
+		}
+		if d.IsDir() && ls.IsString(-1) && ls.ToString(-1) == "skip" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		ls.PushString(err.Error())
+		return 1
+	}
+	ls.PushNil()
+	return 1
+}
+
+// os.glob (pattern)
+// Returns the paths matching pattern, sorted, like filepath.Glob,
+// where a ** part also matches any number of dirs: 'src/**/*.lk'.
+// Like filepath.Glob, unreadable dirs are ignored.
+func osGlob(ls LkState) int {
+	pattern := ls.CheckString(1)
+	ls.Audit("os.glob", pattern)
+	matches, err := _glob(pattern)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	pushList(ls, matches)
+	ls.PushNil()
+	return 2
+}
+
+func _glob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	/* walk from the longest dir without wildcards */
+	i := 0
+	for i < len(parts)-1 && !strings.ContainsAny(parts[i], `*?[\`) {
+		i++
+	}
+	root := filepath.FromSlash(strings.Join(parts[:i], "/"))
+	if root == "" && i > 0 { /* pattern starts with / */
+		root = string(filepath.Separator)
+	} else if root == "" {
+		root = "."
+	}
+	parts = parts[i:]
+
+	matches := []string{}
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		if _globMatch(parts, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	return matches, nil
+}
+
+// _globMatch reports whether the parts of a path match
+// the parts of a pattern, where ** matches any number of parts.
+func _globMatch(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for k := 0; k <= len(name); k++ {
+				if _globMatch(pattern[1:], name[k:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,135 @@
+package stdlib
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Like timers, watchers call their function as tasks of sync.run
+// (or async.run): they don't block the other tasks while waiting.
+
+// registry key of the metatable of watchers
+const watcherMeta = "_WATCHER"
+
+var watcherMethods = map[string]GoFunction{
+	"stop": watcherStop,
+}
+
+type lkWatcher struct {
+	w      *fsnotify.Watcher
+	active atomic.Bool
+	once   sync.Once
+	stop   chan struct{} // closed by stop
+}
+
+// os.watch (path, f [, recursive])
+// Calls f(event) for each change of the file or dir path, from the next
+// sync.run, until the watcher is stopped. With recursive, the dirs under
+// path are watched too, including the ones created later.
+// event is {'path': path, 'op': op}, where op is 'create', 'write',
+// 'remove', 'rename' or 'chmod'.
+// Returns the watcher, or nil and the error.
+func osWatch(ls LkState) int {
+	path := ls.CheckString(1)
+	ls.CheckType(2, LK_TFUNCTION)
+	recursive := ls.OptBool(3, false)
+	ls.Audit("os.watch", path)
+
+	fw, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = _watchPath(fw, path, recursive)
+		if err != nil {
+			fw.Close()
+		}
+	}
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	w := &lkWatcher{w: fw, stop: make(chan struct{})}
+	w.active.Store(true)
+
+	ls.SetTop(2)
+	ls.Remove(1) /* keep f, the arg of the task */
+	ls.PushGoFunction(func(ls LkState) int {
+		defer fw.Close()
+		ctx := ls.Context()
+		for {
+			var ev fsnotify.Event
+			var err error
+			ok := false
+			_await(ls, func() {
+				select {
+				case ev, ok = <-fw.Events:
+				case err, ok = <-fw.Errors:
+				case <-w.stop:
+				case <-ctx.Done():
+					err = ctx.Err()
+				}
+			})
+			if err != nil {
+				ls.Error2("watch %s: %v", path, err)
+			}
+			if !ok || !w.active.Load() {
+				return 0
+			}
+			if recursive && ev.Has(fsnotify.Create) {
+				_watchPath(fw, ev.Name, true) /* a new dir? */
+			}
+			ls.PushValue(1)
+			pushTable(ls, lkMap{"path": ev.Name, "op": _watchOp(ev.Op)})
+			ls.Call(1, 0)
+		}
+	})
+	ls.Insert(1)
+	coSpawn(ls)
+	ls.Pop(1)
+	_pushUserData(ls, w, watcherMeta, watcherMethods)
+	ls.PushNil()
+	return 2
+}
+
+// _watchPath adds path to fw, with the dirs under it if recursive.
+func _watchPath(fw *fsnotify.Watcher, path string, recursive bool) error {
+	if !recursive {
+		return fw.Add(path)
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || p == path {
+			return fw.Add(p)
+		}
+		return nil
+	})
+}
+
+func _watchOp(op fsnotify.Op) string {
+	switch {
+	case op.Has(fsnotify.Create):
+		return "create"
+	case op.Has(fsnotify.Write):
+		return "write"
+	case op.Has(fsnotify.Remove):
+		return "remove"
+	case op.Has(fsnotify.Rename):
+		return "rename"
+	}
+	return "chmod"
+}
+
+// w:stop ()
+// Stops the watcher, and returns whether it was still active.
+func watcherStop(ls LkState) int {
+	w := _checkUserData[*lkWatcher](ls, 1, "watcher")
+	ls.PushBoolean(w.active.Swap(false))
+	w.once.Do(func() { close(w.stop) })
+	return 1
+}
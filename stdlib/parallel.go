@@ -0,0 +1,473 @@
+package stdlib
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Parallel execution of the sync lib.
+// sync.go runs a function in a new state, on its own goroutine.
+// States share no values: the function with its upvalues, its args,
+// its results and the values sent on channels are copied (see Export).
+// Channels are userdata, shared by all the states.
+
+// registry keys of the metatables of the userdata
+const (
+	taskMeta      = "_TASK"
+	chanMeta      = "_CHAN"
+	mutexMeta     = "_MUTEX"
+	waitGroupMeta = "_WAITGROUP"
+	atomicMeta    = "_ATOMIC_INT"
+)
+
+type goTask struct {
+	done    chan struct{}
+	results []any // exported
+	err     any   // exported error, nil if the task succeeded
+}
+
+var taskMethods = map[string]GoFunction{
+	"wait": taskWait,
+}
+
+type lkChan struct {
+	ch chan any // of exported values
+}
+
+var chanMethods = map[string]GoFunction{
+	"send":   chanSend,
+	"recv":   chanRecv,
+	"close":  chanClose,
+	"__iter": chanIter,
+	"__len":  chanLen,
+}
+
+// _pushUserData pushes a userdata holding v,
+// with the metatable stored in the registry under name.
+func _pushUserData(ls LkState, v any, name string, methods map[string]GoFunction) {
+	ls.NewUserData(v)
+	if !ls.GetSubTable(LK_REGISTRYINDEX, name) { /* new metatable? */
+		ls.SetFuncs(methods, 0)
+		ls.PushValue(-1)
+		ls.SetField(-2, "__index")
+		ls.PushString(strings.ToLower(name[1:]))
+		ls.SetField(-2, "__name")
+	}
+	ls.SetMetatable(-2)
+}
+
+func _checkUserData[T any](ls LkState, arg int, name string) T {
+	v, ok := ls.ToUserData(arg).(T)
+	if !ok {
+		ls.ArgError(arg, name+" expected")
+	}
+	return v
+}
+
+// _wait blocks on ready like _await, and raises an error
+// if the state is interrupted meanwhile.
+func _wait(ls LkState, ready func(done <-chan struct{}) bool) {
+	ctx := ls.Context()
+	ok := true
+	_await(ls, func() {
+		ok = ready(ctx.Done())
+	})
+	if !ok {
+		ls.Error2("interrupted: %v", ctx.Err())
+	}
+}
+
+// _waitClosed waits until ch is closed, like _wait.
+func _waitClosed(ls LkState, ch <-chan struct{}) {
+	_wait(ls, func(done <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		case <-done:
+			return false
+		}
+	})
+}
+
+// sync.go (f, ···)
+// Calls f with the args in a new state, on its own goroutine.
+// Returns a task, whose wait method returns the results of f.
+func coGo(ls LkState) int {
+	ls.CheckType(1, LK_TFUNCTION)
+	ls.Audit("sync.go")
+	n := ls.GetTop()
+	vals := make([]any, n)
+	for i := range vals {
+		vals[i] = ls.Export(i + 1)
+	}
+
+	t := &goTask{done: make(chan struct{})}
+	ls2 := ls.NewState()
+	go func() {
+		defer close(t.done)
+		ls2.PushGoFunction(func(ls LkState) int {
+			ls.Call(ls.GetTop()-1, LK_MULTRET)
+			for i := 1; i <= ls.GetTop(); i++ {
+				t.results = append(t.results, ls.Export(i))
+			}
+			return 0
+		})
+		for _, v := range vals {
+			ls2.Import(v)
+		}
+		if ls2.PCall(n, 0, 0) != LK_OK {
+			t.err = ls2.Export(-1)
+		}
+	}()
+	_pushUserData(ls, t, taskMeta, taskMethods)
+	return 1
+}
+
+// task:wait ()
+// Waits for the task to finish, and returns its results.
+// An error in the task is raised here.
+func taskWait(ls LkState) int {
+	t := _checkUserData[*goTask](ls, 1, "task")
+	_waitClosed(ls, t.done)
+	if t.err != nil {
+		ls.Import(t.err)
+		return ls.Error()
+	}
+	ls.CheckStack(len(t.results))
+	for _, v := range t.results {
+		ls.Import(v)
+	}
+	return len(t.results)
+}
+
+// sync.chan ([n])
+// Returns a channel buffering n values, 0 by default.
+func coChan(ls LkState) int {
+	n := ls.OptInteger(1, 0)
+	ls.ArgCheck(n >= 0, 1, "negative size")
+	_pushUserData(ls, &lkChan{ch: make(chan any, n)}, chanMeta, chanMethods)
+	return 1
+}
+
+// ch:send (v)
+// Sends a copy of v, blocking until it is received or buffered.
+func chanSend(ls LkState) int {
+	c := _checkUserData[*lkChan](ls, 1, "chan")
+	ls.CheckAny(2)
+	v := ls.Export(2)
+	closed := false
+	_wait(ls, func(done <-chan struct{}) (ok bool) {
+		defer func() {
+			if recover() != nil { /* send on closed channel */
+				closed, ok = true, true
+			}
+		}()
+		select {
+		case c.ch <- v:
+			return true
+		case <-done:
+			return false
+		}
+	})
+	if closed {
+		return ls.Error2("send on closed channel")
+	}
+	return 0
+}
+
+// ch:recv ()
+// Receives a value, blocking until one is sent.
+// Returns v, true; or nil, false once the channel is closed and empty.
+func chanRecv(ls LkState) int {
+	c := _checkUserData[*lkChan](ls, 1, "chan")
+	var v any
+	ok := false
+	_wait(ls, func(done <-chan struct{}) bool {
+		select {
+		case v, ok = <-c.ch:
+			return true
+		case <-done:
+			return false
+		}
+	})
+	if ok {
+		ls.Import(v)
+	} else {
+		ls.PushNil()
+	}
+	ls.PushBoolean(ok)
+	return 2
+}
+
+// ch:close ()
+// Closes the channel: receivers get the values left, then nil, false.
+func chanClose(ls LkState) int {
+	c := _checkUserData[*lkChan](ls, 1, "chan")
+	defer func() {
+		if recover() != nil {
+			ls.Error2("close of closed channel")
+		}
+	}()
+	close(c.ch)
+	return 0
+}
+
+// for v in ch {}
+// Receives the values until the channel is closed.
+func chanIter(ls LkState) int {
+	ls.PushGoFunction(func(ls LkState) int {
+		chanRecv(ls)
+		ls.Pop(1) /* generator ends on nil */
+		return 1
+	})
+	ls.PushValue(1)
+	ls.PushNil()
+	return 3
+}
+
+// #ch
+// Returns the number of values buffered.
+func chanLen(ls LkState) int {
+	c := _checkUserData[*lkChan](ls, 1, "chan")
+	ls.PushInteger(int64(len(c.ch)))
+	return 1
+}
+
+// sync.select (cases [, nonblock])
+// Waits until one of the cases can proceed: a case is a channel to
+// receive from, or {ch, v} to send v on ch.
+// Returns the index of the case, and for a receive, its v and ok.
+// If nonblock is true and no case is ready, returns nil.
+func coSelect(ls LkState) int {
+	ls.CheckType(1, LK_TTABLE)
+	nonblock := ls.ToBoolean(2)
+	n := int(ls.Len2(1))
+	cases := make([]reflect.SelectCase, n, n+2)
+	for i := 0; i < n; i++ {
+		ls.GetI(1, int64(i))
+		if c, ok := ls.ToUserData(-1).(*lkChan); ok {
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)}
+		} else if ls.IsTable(-1) {
+			ls.GetI(-1, 0)
+			c, ok := ls.ToUserData(-1).(*lkChan)
+			if !ok {
+				return ls.Error2("bad case #%d to 'select' (chan expected)", i)
+			}
+			ls.GetI(-2, 1)
+			v := ls.Export(-1)
+			ls.Pop(2)
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.ch), Send: reflect.ValueOf(&v).Elem()}
+		} else {
+			return ls.Error2("bad case #%d to 'select' (chan or {chan, value} expected)", i)
+		}
+		ls.Pop(1)
+	}
+	if nonblock {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	chosen, closed := -1, false
+	var v reflect.Value
+	ok := false
+	_wait(ls, func(done <-chan struct{}) (ready bool) {
+		defer func() {
+			if recover() != nil { /* send on closed channel */
+				closed, ready = true, true
+			}
+		}()
+		all := append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+		chosen, v, ok = reflect.Select(all)
+		return chosen < len(cases)
+	})
+	if closed {
+		return ls.Error2("send on closed channel")
+	}
+	if chosen >= n { /* default */
+		ls.PushNil()
+		return 1
+	}
+	ls.PushInteger(int64(chosen))
+	if cases[chosen].Dir == reflect.SelectSend {
+		return 1
+	}
+	if ok {
+		ls.Import(v.Interface())
+	} else {
+		ls.PushNil()
+	}
+	ls.PushBoolean(ok)
+	return 3
+}
+
+// lkMutex is a channel holding a value while locked,
+// so waiting for the lock can be interrupted.
+type lkMutex struct {
+	ch chan struct{}
+}
+
+var mutexMethods = map[string]GoFunction{
+	"lock":     mutexLock,
+	"unlock":   mutexUnlock,
+	"try_lock": mutexTryLock,
+}
+
+// sync.mutex ()
+// Returns an unlocked mutex. Any task can unlock it.
+func coMutex(ls LkState) int {
+	_pushUserData(ls, &lkMutex{ch: make(chan struct{}, 1)}, mutexMeta, mutexMethods)
+	return 1
+}
+
+// m:lock ()
+func mutexLock(ls LkState) int {
+	m := _checkUserData[*lkMutex](ls, 1, "mutex")
+	_wait(ls, func(done <-chan struct{}) bool {
+		select {
+		case m.ch <- struct{}{}:
+			return true
+		case <-done:
+			return false
+		}
+	})
+	return 0
+}
+
+// m:unlock ()
+func mutexUnlock(ls LkState) int {
+	m := _checkUserData[*lkMutex](ls, 1, "mutex")
+	select {
+	case <-m.ch:
+		return 0
+	default:
+		return ls.Error2("unlock of unlocked mutex")
+	}
+}
+
+// m:try_lock ()
+// Locks m if it is unlocked, and returns whether it did.
+func mutexTryLock(ls LkState) int {
+	m := _checkUserData[*lkMutex](ls, 1, "mutex")
+	select {
+	case m.ch <- struct{}{}:
+		ls.PushBoolean(true)
+	default:
+		ls.PushBoolean(false)
+	}
+	return 1
+}
+
+// lkWaitGroup is a wait group whose wait can be interrupted:
+// zero is closed while the counter is 0, so waiters select on it
+// without a goroutine blocked in sync.WaitGroup.Wait.
+type lkWaitGroup struct {
+	mu   sync.Mutex
+	n    int
+	zero chan struct{}
+}
+
+var waitGroupMethods = map[string]GoFunction{
+	"add":  wgAdd,
+	"done": wgDone,
+	"wait": wgWait,
+}
+
+// sync.waitgroup ()
+// Returns a wait group, waiting for a counter of tasks to reach 0.
+func coWaitGroup(ls LkState) int {
+	wg := &lkWaitGroup{zero: make(chan struct{})}
+	close(wg.zero)
+	_pushUserData(ls, wg, waitGroupMeta, waitGroupMethods)
+	return 1
+}
+
+// wg:add ([n])
+// Adds n, 1 by default, to the counter.
+func wgAdd(ls LkState) int {
+	wg := _checkUserData[*lkWaitGroup](ls, 1, "waitgroup")
+	_wgAdd(ls, wg, int(ls.OptInteger(2, 1)))
+	return 0
+}
+
+// wg:done ()
+// Decrements the counter.
+func wgDone(ls LkState) int {
+	wg := _checkUserData[*lkWaitGroup](ls, 1, "waitgroup")
+	_wgAdd(ls, wg, -1)
+	return 0
+}
+
+func _wgAdd(ls LkState, wg *lkWaitGroup, n int) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if wg.n+n < 0 {
+		ls.Error2("negative waitgroup counter")
+	}
+	if wg.n == 0 && n > 0 {
+		wg.zero = make(chan struct{})
+	}
+	wg.n += n
+	if wg.n == 0 && n < 0 {
+		close(wg.zero)
+	}
+}
+
+// wg:wait ()
+// Waits until the counter is 0.
+func wgWait(ls LkState) int {
+	wg := _checkUserData[*lkWaitGroup](ls, 1, "waitgroup")
+	wg.mu.Lock()
+	zero := wg.zero
+	wg.mu.Unlock()
+	_waitClosed(ls, zero)
+	return 0
+}
+
+var atomicMethods = map[string]GoFunction{
+	"get": atomicGet,
+	"set": atomicSet,
+	"add": atomicAdd,
+	"cas": atomicCas,
+}
+
+// sync.atomic_int ([v])
+// Returns an integer, v or 0, updated atomically.
+func coAtomicInt(ls LkState) int {
+	a := &atomic.Int64{}
+	a.Store(ls.OptInteger(1, 0))
+	_pushUserData(ls, a, atomicMeta, atomicMethods)
+	return 1
+}
+
+// a:get ()
+func atomicGet(ls LkState) int {
+	a := _checkUserData[*atomic.Int64](ls, 1, "atomic_int")
+	ls.PushInteger(a.Load())
+	return 1
+}
+
+// a:set (v)
+func atomicSet(ls LkState) int {
+	a := _checkUserData[*atomic.Int64](ls, 1, "atomic_int")
+	a.Store(ls.CheckInteger(2))
+	return 0
+}
+
+// a:add ([d])
+// Adds d, 1 by default, and returns the new value.
+func atomicAdd(ls LkState) int {
+	a := _checkUserData[*atomic.Int64](ls, 1, "atomic_int")
+	ls.PushInteger(a.Add(ls.OptInteger(2, 1)))
+	return 1
+}
+
+// a:cas (old, new)
+// Sets new if the value is old, and returns whether it did.
+func atomicCas(ls LkState) int {
+	a := _checkUserData[*atomic.Int64](ls, 1, "atomic_int")
+	ls.PushBoolean(a.CompareAndSwap(ls.CheckInteger(2), ls.CheckInteger(3)))
+	return 1
+}
@@ -0,0 +1,227 @@
+package stdlib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	. "github.com/lollipopkit/lk/api"
+	. "github.com/lollipopkit/lk/json"
+)
+
+// Record & replay of non-deterministic calls (time, rand, files, http...).
+// When recording, the results of these calls are appended to a file,
+// one JSON object per line. When replaying, the calls are not executed:
+// their results are read back from the file, in the same order.
+
+type replayMode int
+
+const (
+	replayOff replayMode = iota
+	replayRecord
+	replayPlay
+)
+
+type replayEntry struct {
+	Call    string `json:"call"`
+	Results []any  `json:"results"`
+}
+
+var replay struct {
+	sync.Mutex
+	mode replayMode
+	file *os.File
+	w    *bufio.Writer
+	dec  *json.Decoder
+}
+
+// StartRecord starts recording non-deterministic calls to path.
+func StartRecord(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	replay.Lock()
+	defer replay.Unlock()
+	replay.mode = replayRecord
+	replay.file = f
+	replay.w = bufio.NewWriter(f)
+	return nil
+}
+
+// StartReplay feeds the results recorded in path back to the script.
+func StartReplay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	replay.Lock()
+	defer replay.Unlock()
+	replay.mode = replayPlay
+	replay.file = f
+	replay.dec = json.NewDecoder(bufio.NewReader(f))
+	replay.dec.UseNumber()
+	return nil
+}
+
+// StopReplay flushes the recording and closes the file.
+func StopReplay() error {
+	replay.Lock()
+	defer replay.Unlock()
+	if replay.mode == replayOff {
+		return nil
+	}
+	var err error
+	if replay.w != nil {
+		err = replay.w.Flush()
+	}
+	if err2 := replay.file.Close(); err == nil {
+		err = err2
+	}
+	replay.mode = replayOff
+	replay.file, replay.w, replay.dec = nil, nil, nil
+	return err
+}
+
+// _nondet wraps the lib function f, whose results are not deterministic,
+// so they are recorded or replayed under the name call.
+func _nondet(call string, f GoFunction) GoFunction {
+	return func(ls LkState) int {
+		replay.Lock()
+		mode := replay.mode
+		replay.Unlock()
+		switch mode {
+		case replayRecord:
+			n := f(ls)
+			_recordResults(ls, call, n)
+			return n
+		case replayPlay:
+			return _replayResults(ls, call)
+		}
+		return f(ls)
+	}
+}
+
+func _recordResults(ls LkState, call string, n int) {
+	entry := replayEntry{Call: call, Results: make([]any, n)}
+	top := ls.GetTop()
+	for i := 0; i < n; i++ {
+		entry.Results[i] = _encodeValue(ls, top-n+1+i)
+	}
+	data, err := Json.Marshal(entry)
+	if err != nil {
+		ls.Error2("record %s: %s", call, err.Error())
+	}
+
+	replay.Lock()
+	defer replay.Unlock()
+	replay.w.Write(data)
+	replay.w.WriteByte('\n')
+}
+
+func _replayResults(ls LkState, call string) int {
+	replay.Lock()
+	var entry replayEntry
+	err := replay.dec.Decode(&entry)
+	replay.Unlock()
+
+	if err != nil {
+		return ls.Error2("replay %s: no more recorded calls (%s)", call, err.Error())
+	}
+	if entry.Call != call {
+		return ls.Error2("replay: expect call to %s, but %s was recorded", call, entry.Call)
+	}
+	for _, v := range entry.Results {
+		if err := _decodeValue(ls, v); err != nil {
+			return ls.Error2("replay %s: %s", call, err.Error())
+		}
+	}
+	return len(entry.Results)
+}
+
+// Integers and floats are tagged, so they are replayed with their own type.
+// Strings that are not UTF-8, which JSON can't hold, are tagged as base64.
+// Tables are stored as a list of [key, value] pairs.
+func _encodeValue(ls LkState, idx int) any {
+	switch ls.Type(idx) {
+	case LK_TBOOLEAN:
+		return ls.ToBoolean(idx)
+	case LK_TSTRING:
+		s := ls.ToString(idx)
+		if !utf8.ValidString(s) {
+			return map[string]any{"bytes": []byte(s)}
+		}
+		return s
+	case LK_TNUMBER:
+		if ls.IsInteger(idx) {
+			return map[string]any{"int": ls.ToInteger(idx)}
+		}
+		return map[string]any{"num": ls.ToNumber(idx)}
+	case LK_TTABLE:
+		idx = ls.AbsIndex(idx)
+		pairs := [][2]any{}
+		ls.PushNil()
+		for ls.Next(idx) {
+			pairs = append(pairs, [2]any{_encodeValue(ls, -2), _encodeValue(ls, -1)})
+			ls.Pop(1)
+		}
+		return map[string]any{"table": pairs}
+	}
+	return nil
+}
+
+func _decodeValue(ls LkState, v any) error {
+	switch x := v.(type) {
+	case nil:
+		ls.PushNil()
+	case bool:
+		ls.PushBoolean(x)
+	case string:
+		ls.PushString(x)
+	case map[string]any:
+		if n, ok := x["int"].(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return err
+			}
+			ls.PushInteger(i)
+		} else if n, ok := x["num"].(json.Number); ok {
+			f, err := n.Float64()
+			if err != nil {
+				return err
+			}
+			ls.PushNumber(f)
+		} else if b, ok := x["bytes"].(string); ok {
+			data, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				return err
+			}
+			ls.PushString(string(data))
+		} else if pairs, ok := x["table"].([]any); ok {
+			ls.CreateTable(0, len(pairs))
+			for _, p := range pairs {
+				pair, ok := p.([]any)
+				if !ok || len(pair) != 2 {
+					return errors.New("invalid table pair")
+				}
+				if err := _decodeValue(ls, pair[0]); err != nil {
+					return err
+				}
+				if err := _decodeValue(ls, pair[1]); err != nil {
+					return err
+				}
+				ls.SetTable(-3)
+			}
+		} else {
+			return fmt.Errorf("invalid value: %v", x)
+		}
+	default:
+		return fmt.Errorf("invalid value: %v", x)
+	}
+	return nil
+}
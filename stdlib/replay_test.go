@@ -0,0 +1,72 @@
+package stdlib_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/lk/stdlib"
+)
+
+// replayed reads the results of non-deterministic calls into the global got.
+const replayed = `
+	shy data, err = os.read(file)
+	assert(err == nil)
+	got = fmt('%d %d %d %s', os.time(), time.now(), os.rand(1, 1000000), data)
+`
+
+// runReplayed runs src with the global file set to path, and returns
+// the global got, or the error.
+func runReplayed(t *testing.T, path, src string) (string, string) {
+	ls := newState()
+	ls.PushString(path)
+	ls.SetGlobal("file")
+	if err := runErr(ls, src); err != "" {
+		return "", err
+	}
+	ls.GetGlobal("got")
+	return ls.ToString(-1), ""
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls.log")
+	file := filepath.Join(dir, "data.txt")
+	/* not UTF-8 */
+	if err := os.WriteFile(file, []byte("recorded\xff\xfe"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stdlib.StartRecord(log); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := runReplayed(t, file, replayed)
+	if err2 := stdlib.StopReplay(); err != "" || err2 != nil {
+		t.Fatal(err, err2)
+	}
+	if !strings.HasSuffix(recorded, "recorded\xff\xfe") {
+		t.Fatalf("recorded %q", recorded)
+	}
+	/* replayed calls are not executed */
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		src, want, err string
+	}{
+		{src: replayed, want: recorded},
+		{src: replayed + "os.time()", err: "replay os.time: no more recorded calls"},
+		{src: "os.time()", err: "replay: expect call to os.time, but os.read was recorded"},
+	} {
+		if err := stdlib.StartReplay(log); err != nil {
+			t.Fatal(err)
+		}
+		got, err := runReplayed(t, file, tt.src)
+		stdlib.StopReplay()
+		if got != tt.want || !strings.Contains(err, tt.err) || (err == "") != (tt.err == "") {
+			t.Errorf("%q: got %q %q, want %q %q", tt.src, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package stdlib
+
+import (
+	"sync"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Cooperative scheduler of the sync lib.
+// Tasks are coroutines started with sync.spawn and run by sync.run.
+// Inside a task, blocking lib calls (os.sleep, http.req...) don't block
+// the other tasks: the blocking work runs aside, and the task yields to
+// the scheduler until it is done.
+
+// registry key of the tasks spawned but not yet run: list of threads
+const tasksKey = "_TASKS"
+
+type task struct {
+	co    LkState
+	nArgs int // args of the task function, -1 once started
+}
+
+type scheduler struct {
+	ready     []task
+	waiting   int           // tasks awaiting blocking work
+	awaiting  LkState       // task which yielded from _await
+	done      chan LkState  // tasks whose blocking work is done
+	stop      chan struct{} // closed once sync.run returns
+	parked    LkState       // task which yielded from p:await()
+	promises  map[LkState]*promise
+	unhandled []*promise // failed without waiters
+}
+
+var scheds = struct {
+	sync.Mutex
+	m map[LkState]*scheduler
+}{m: map[LkState]*scheduler{}}
+
+func _schedOf(ls LkState) *scheduler {
+	scheds.Lock()
+	defer scheds.Unlock()
+	return scheds.m[ls]
+}
+
+func _setSched(co LkState, s *scheduler) {
+	scheds.Lock()
+	defer scheds.Unlock()
+	if s == nil {
+		delete(scheds.m, co)
+	} else {
+		scheds.m[co] = s
+	}
+}
+
+// _await runs work, which must not use ls.
+// Inside a task, ls yields to the scheduler while work runs aside;
+// elsewhere, work simply blocks.
+func _await(ls LkState, work func()) {
+	s := _schedOf(ls)
+	if s == nil {
+		work()
+		return
+	}
+	go func() {
+		work()
+		select {
+		case s.done <- ls:
+		case <-s.stop: /* sync.run failed meanwhile */
+		}
+	}()
+	s.awaiting = ls
+	ls.Yield(0)
+}
+
+// sync.spawn (f, ···)
+// Creates a task calling f with the args, run by the next sync.run.
+func coSpawn(ls LkState) int {
+	ls.CheckType(1, LK_TFUNCTION)
+	n := ls.GetTop()
+	co := ls.NewThread()
+	ls.Insert(1)
+	ls.XMove(co, n) /* move f and args to co */
+	ls.GetSubTable(LK_REGISTRYINDEX, tasksKey)
+	ls.PushValue(1)
+	ls.SetI(-2, ls.Len2(-2))
+	ls.Pop(1)
+	return 1
+}
+
+// _spawn creates a task calling the function under the n values at the
+// top of the stack with them, as sync.spawn, and pops them.
+func _spawn(ls LkState, n int) {
+	idx := ls.GetTop() - n
+	co := ls.NewThread()
+	ls.Insert(idx)
+	ls.XMove(co, n+1)
+	ls.GetSubTable(LK_REGISTRYINDEX, tasksKey)
+	ls.PushValue(idx)
+	ls.SetI(-2, ls.Len2(-2))
+	ls.Pop(2)
+}
+
+// sync.run ()
+// Runs the spawned tasks, and the ones they spawn, until all are done.
+// An error in a task is raised here.
+func coRun(ls LkState) int {
+	s := &scheduler{done: make(chan LkState), stop: make(chan struct{}), promises: map[LkState]*promise{}}
+	started := []LkState{}
+	defer func() {
+		close(s.stop)
+		for _, co := range started {
+			_setSched(co, nil)
+		}
+	}()
+
+	ctx := ls.Context()
+	for {
+		tasks, promises := _takeTasks(ls)
+		for i, co := range tasks {
+			_setSched(co, s)
+			if p := promises[i]; p != nil {
+				s.promises[co] = p
+			}
+			started = append(started, co)
+			s.ready = append(s.ready, task{co: co, nArgs: co.GetTop() - 1})
+		}
+		if len(s.ready) == 0 {
+			if s.waiting == 0 {
+				for _, p := range s.unhandled {
+					if !p.awaited {
+						ls.Push(p.err)
+						ls.Error()
+					}
+				}
+				return 0
+			}
+			select {
+			case co := <-s.done:
+				s.waiting--
+				if co.Status() == LK_YIELD { /* not closed meanwhile */
+					s.ready = append(s.ready, task{co: co, nArgs: -1})
+				}
+			case <-ctx.Done():
+				return ls.Error2("interrupted: %v", ctx.Err())
+			}
+			continue
+		}
+
+		t := s.ready[0]
+		s.ready = s.ready[1:]
+		s.step(ls, t)
+	}
+}
+
+// step resumes t until it yields or finishes.
+func (s *scheduler) step(ls LkState, t task) {
+	co := t.co
+	nArgs := t.nArgs
+	if nArgs < 0 {
+		nArgs = 0
+	}
+	switch co.Resume(ls, nArgs) {
+	case LK_YIELD:
+		if s.awaiting == co {
+			s.awaiting = nil
+			s.waiting++
+			return
+		}
+		if s.parked == co {
+			s.parked = nil
+			return
+		}
+		co.SetTop(0) /* drop yielded values */
+		s.ready = append(s.ready, task{co: co, nArgs: -1})
+	case LK_OK:
+		if p := s.promises[co]; p != nil {
+			for i := 1; i <= co.GetTop(); i++ {
+				p.results = append(p.results, co.ToPointer(i))
+			}
+			s.settle(co, p)
+		}
+		co.SetTop(0)
+		_setSched(co, nil)
+	default:
+		_setSched(co, nil)
+		if p := s.promises[co]; p != nil {
+			p.err, p.failed = co.ToPointer(-1), true
+			co.SetTop(0)
+			s.settle(co, p)
+			return
+		}
+		co.XMove(ls, 1) /* move error message */
+		ls.Error()
+	}
+}
+
+// settle resumes the tasks awaiting p, the promise of co.
+func (s *scheduler) settle(co LkState, p *promise) {
+	p.done = true
+	delete(s.promises, co)
+	for _, w := range p.waiters {
+		s.ready = append(s.ready, task{co: w, nArgs: -1})
+	}
+	if p.failed && len(p.waiters) == 0 {
+		s.unhandled = append(s.unhandled, p)
+	}
+	p.waiters = nil
+}
+
+// _takeTasks empties the list of spawned tasks, and returns them
+// with their promises, nil for the tasks of sync.spawn.
+func _takeTasks(ls LkState) ([]LkState, []*promise) {
+	ls.GetSubTable(LK_REGISTRYINDEX, promisesKey)
+	ls.GetSubTable(LK_REGISTRYINDEX, tasksKey)
+	n := ls.Len2(-1)
+	tasks := make([]LkState, 0, n)
+	promises := make([]*promise, 0, n)
+	for i := int64(0); i < n; i++ {
+		ls.GetI(-1, i)
+		tasks = append(tasks, ls.ToThread(-1))
+		ls.GetTable(-3) /* promises[thread] */
+		p, _ := ls.ToUserData(-1).(*promise)
+		promises = append(promises, p)
+		ls.Pop(1)
+	}
+	ls.Pop(2)
+	if n > 0 {
+		ls.NewTable()
+		ls.SetField(LK_REGISTRYINDEX, tasksKey)
+		ls.NewTable()
+		ls.SetField(LK_REGISTRYINDEX, promisesKey)
+	}
+	return tasks, promises
+}
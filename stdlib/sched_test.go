@@ -0,0 +1,73 @@
+package stdlib_test
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// The blocking work of a task still running when sync.run fails
+// must not be left waiting for the scheduler.
+func TestAwaitAfterFailedRun(t *testing.T) {
+	ls := newState()
+	err := runErr(ls, `
+		sync.spawn(fn() { os.sleep(50) })
+		sync.spawn(fn() { error('boom') })
+		sync.run()
+	`)
+	if !strings.Contains(err, "boom") {
+		t.Fatalf("got %q", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		if !strings.Contains(string(buf), "stdlib._await.func") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("blocked awaiting work:\n%s", buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// The promises of tasks not run yet belong to their state:
+// they are settled by its next run, and dropped with it.
+func TestPromisesOfState(t *testing.T) {
+	ls := newState()
+	run(t, ls, `p = async.spawn(fn() { rt 1 })`)
+	other := newState()
+	run(t, other, `sync.run()`)
+	run(t, ls, `
+		sync.run()
+		assert(p:await() == 1)
+	`)
+	ls.GetField(LK_REGISTRYINDEX, "_PROMISES")
+	ls.PushNil()
+	if ls.Next(-2) {
+		t.Fatal("promises left after the run")
+	}
+}
+
+// An interrupted wg:wait leaves no goroutine waiting for the counter.
+func TestWaitGroupInterrupted(t *testing.T) {
+	ls := newState()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if ls.LoadString("shy wg = sync.waitgroup()\nwg:add()\nwg:wait()", "wg.lk") != LK_OK {
+		t.Fatal(ls.ToString2(-1))
+	}
+	if ls.PCallContext(ctx, 0, 0, 0) == LK_OK || !strings.Contains(ls.ToString2(-1), "interrupted") {
+		t.Fatalf("got %q", ls.ToString2(-1))
+	}
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	if strings.Contains(string(buf), "WaitGroup).Wait") {
+		t.Fatalf("goroutine left waiting:\n%s", buf)
+	}
+}
@@ -0,0 +1,82 @@
+package stdlib_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/state"
+	"github.com/lollipopkit/lk/stdlib"
+)
+
+// newState returns a state with the libs opened.
+func newState() LkState {
+	ls := state.New()
+	ls.OpenLibs()
+	return ls
+}
+
+// run runs the chunk src in ls, failing t on error.
+func run(t *testing.T, ls LkState, src string) {
+	t.Helper()
+	if err := runErr(ls, src); err != "" {
+		t.Fatal(err)
+	}
+}
+
+// runErr runs the chunk src in ls, and returns its error, or "".
+func runErr(ls LkState, src string) string {
+	if ls.LoadString(src, "test.lk") != LK_OK || ls.PCall(0, 0, 0) != LK_OK {
+		defer ls.Pop(1)
+		return ls.ToString2(-1)
+	}
+	return ""
+}
+
+type pushBase struct {
+	ID   int
+	Name string
+}
+
+type pushItem struct {
+	pushBase
+	Name string
+	Big  uint64 `json:"big"`
+	Next *pushItem
+}
+
+// pushErr pushes v in a protected call, and returns its error, or "".
+func pushErr(ls LkState, v any) string {
+	ls.PushGoFunction(func(ls LkState) int {
+		stdlib.PushGoValue(ls, v)
+		return 1
+	})
+	if ls.PCall(0, 1, 0) != LK_OK {
+		defer ls.Pop(1)
+		return ls.ToString2(-1)
+	}
+	ls.SetGlobal("v")
+	return ""
+}
+
+func TestPushGoValue(t *testing.T) {
+	ls := newState()
+	item := &pushItem{pushBase: pushBase{ID: 1, Name: "base"}, Name: "item", Big: math.MaxUint64}
+	item.Next = &pushItem{Name: "next"}
+	if err := pushErr(ls, item); err != "" {
+		t.Fatal(err)
+	}
+	run(t, ls, `assert(v.ID == 1 and v.Name == 'item' and v.pushBase == nil)
+assert(math.type(v.big) == 'float' and v.big > 0)
+assert(v.Next.Name == 'next' and v.Next.Next == nil)`)
+
+	item.Next = item
+	cycle := []any{nil}
+	cycle[0] = cycle
+	for _, v := range []any{item, cycle, map[string]any{"f": func() {}}, make(chan int)} {
+		if err := pushErr(ls, v); !strings.Contains(err, "cannot convert") {
+			t.Errorf("%T: got %q", v, err)
+		}
+	}
+}
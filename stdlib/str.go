@@ -41,20 +41,22 @@ func parseFmtStr(fmt string) []string {
 	return parsed
 }
 
-func _fmt(fmtStr string, ls LkState) string {
+// _fmt pushes the formatted string onto the stack
+func _fmt(fmtStr string, ls LkState) {
+	b := ls.BuffInit()
 	argIdx := 1
-	arr := parseFmtStr(fmtStr)
-	for i := range arr {
-		if arr[i][0] == '%' {
-			if arr[i] == "%%" {
-				arr[i] = "%"
-			} else {
-				argIdx += 1
-				arr[i] = _fmtArg(arr[i], ls, argIdx)
-			}
+	for _, s := range parseFmtStr(fmtStr) {
+		switch {
+		case s == "%%":
+			b.AddChar('%')
+		case strings.HasPrefix(s, "%"):
+			argIdx += 1
+			b.AddString(_fmtArg(s, ls, argIdx))
+		default:
+			b.AddString(s)
 		}
 	}
-	return strings.Join(arr, "")
+	b.PushResult()
 }
 
 func _fmtArg(tag string, ls LkState, argIdx int) string {
@@ -74,7 +76,9 @@ func _fmtArg(tag string, ls LkState, argIdx int) string {
 	case 'f': // float
 		return fmt.Sprintf(tag, ls.ToNumber(argIdx))
 	case 's', 'q': // string
-		return fmt.Sprintf(tag, ls.ToString2(argIdx))
+		str := ls.ToString2(argIdx)
+		ls.Pop(1)
+		return fmt.Sprintf(tag, str)
 	default:
 		panic("todo! tag=" + tag)
 	}
@@ -82,6 +86,47 @@ func _fmtArg(tag string, ls LkState, argIdx int) string {
 
 /* helper */
 
+// Sources longer than this are not kept alive by small substrings.
+const subRetainLimit = 64 * 1024
+
+// _substr returns s[i:j] without copying, as Go substrings share the
+// backing array of their source. When the source is large and the result
+// is only a small part of it, the result is copied instead,
+// so a few bytes taken from a 100MB input don't pin the whole input.
+func _substr(s string, i, j int) string {
+	return _detach(s, s[i:j])
+}
+
+// _detach returns sub, a substring of s, copied as _substr does.
+func _detach(s, sub string) string {
+	if _isSmallPart(s, sub) {
+		return strings.Clone(sub)
+	}
+	return sub
+}
+
+func _isSmallPart(s, sub string) bool {
+	return len(s) > subRetainLimit && len(sub) < len(s)/8
+}
+
+// _detachParts copies the small parts of s as _detach does, unless
+// they add up to a large part of s: copying them would then double
+// the memory, while sharing s keeps little more than they hold.
+func _detachParts(s string, parts []string) {
+	small := 0
+	for _, part := range parts {
+		if _isSmallPart(s, part) {
+			small += len(part)
+		}
+	}
+	if small >= len(s)/8 {
+		return
+	}
+	for i, part := range parts {
+		parts[i] = _detach(s, part)
+	}
+}
+
 /* translate a relative string position: negative means back from end */
 func posRelat(pos int64, _len int) int {
 	_pos := int(pos)
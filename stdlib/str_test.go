@@ -0,0 +1,34 @@
+package stdlib
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func shares(s, part string) bool {
+	start := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+	p := uintptr(unsafe.Pointer(unsafe.StringData(part)))
+	return p >= start && p < start+uintptr(len(s))
+}
+
+// A few small parts of a large string are copied, many are shared.
+func TestDetachParts(t *testing.T) {
+	large := strings.Repeat("x", 2*subRetainLimit)
+
+	s := large + ",a"
+	parts := strings.Split(s, ",")
+	_detachParts(s, parts)
+	if !shares(s, parts[0]) || shares(s, parts[1]) || parts[1] != "a" {
+		t.Error("the small part was not copied alone")
+	}
+
+	s = strings.Repeat("line\n", subRetainLimit)
+	parts = strings.Split(s, "\n")
+	_detachParts(s, parts)
+	for _, part := range parts[:len(parts)-1] {
+		if !shares(s, part) {
+			t.Fatal("the lines were copied")
+		}
+	}
+}
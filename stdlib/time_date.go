@@ -0,0 +1,218 @@
+package stdlib
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" /* IANA zones where the system has none */
+
+	. "github.com/lollipopkit/lk/api"
+)
+
+// Times are timestamps in milliseconds, as for os.time and os.date.
+// Layouts are strftime formats ('%Y-%m-%d'), or Go layouts
+// ('2006-01-02') when they have no '%'.
+// Zones are IANA names ('Asia/Shanghai'), 'UTC' or 'Local' (the default).
+
+// for time.tick
+var tickStart = time.Now()
+
+// loaded zones, by name
+var locations sync.Map
+
+// time.now ()
+// Returns the current time.
+func timeNow(ls LkState) int {
+	ls.PushInteger(time.Now().UnixMilli())
+	return 1
+}
+
+// time.tick ()
+// Returns the ms elapsed since the start, with a monotonic clock:
+// unlike time.now, it is not changed by clock adjustments.
+func timeTick(ls LkState) int {
+	ls.PushNumber(float64(time.Since(tickStart)) / float64(time.Millisecond))
+	return 1
+}
+
+// time.format (ts, layout [, zone])
+// Returns ts formatted with layout, in zone.
+func timeFormat(ls LkState) int {
+	ts := ls.CheckInteger(1)
+	layout := ls.CheckString(2)
+	t := time.UnixMilli(ts).In(_checkLocation(ls, 3))
+	if !strings.Contains(layout, "%") {
+		ls.PushString(t.Format(layout))
+		return 1
+	}
+	s, err := _strftime(layout, t)
+	if err != nil {
+		return ls.Error2("bad argument #2 to 'format' (%s)", err.Error())
+	}
+	ls.PushString(s)
+	return 1
+}
+
+// time.parse (layout, s [, zone])
+// Returns the time of s, formatted with layout, or nil and the error.
+// Without an offset in s, it is a time in zone.
+func timeParse(ls LkState) int {
+	layout := ls.CheckString(1)
+	s := ls.CheckString(2)
+	loc := _checkLocation(ls, 3)
+	if strings.Contains(layout, "%") {
+		var err error
+		if layout, err = _goLayout(layout); err != nil {
+			return ls.Error2("bad argument #1 to 'parse' (%s)", err.Error())
+		}
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	ls.PushInteger(t.UnixMilli())
+	ls.PushNil()
+	return 2
+}
+
+// time.date (ts [, zone])
+// Returns the fields of ts in zone: year, month, day, hour, min, sec, ms,
+// wday (1 is Sunday), yday, isdst, zone (its abbreviation) and offset
+// (in seconds east of UTC).
+func timeDate(ls LkState) int {
+	ts := ls.CheckInteger(1)
+	t := time.UnixMilli(ts).In(_checkLocation(ls, 2))
+	zone, offset := t.Zone()
+	pushTable(ls, lkMap{
+		"year":   t.Year(),
+		"month":  int(t.Month()),
+		"day":    t.Day(),
+		"hour":   t.Hour(),
+		"min":    t.Minute(),
+		"sec":    t.Second(),
+		"ms":     t.Nanosecond() / int(time.Millisecond),
+		"wday":   int(t.Weekday()) + 1,
+		"yday":   t.YearDay(),
+		"isdst":  t.IsDST(),
+		"zone":   zone,
+		"offset": offset,
+	})
+	return 1
+}
+
+// time.add (ts, d [, zone])
+// Returns ts plus the duration d: ms, or a table of years, months, days,
+// hours, minutes, seconds and ms, which may be negative.
+// Years, months and days are added to the date in zone, so
+// time.add(ts, {'days': 1}) is the same time on the next day,
+// even across a DST change.
+func timeAdd(ls LkState) int {
+	ts := ls.CheckInteger(1)
+	t := time.UnixMilli(ts).In(_checkLocation(ls, 3))
+	if ls.IsInteger(2) {
+		ls.PushInteger(t.Add(time.Duration(ls.ToInteger(2)) * time.Millisecond).UnixMilli())
+		return 1
+	}
+	ls.CheckType(2, LK_TTABLE)
+
+	var years, months, days int
+	var d time.Duration
+	ls.PushNil()
+	for ls.Next(2) {
+		key, _ := ls.ToStringX(-2)
+		n, ok := ls.ToIntegerX(-1)
+		if !ok {
+			return ls.Error2("field '%s' of the duration is not an integer", key)
+		}
+		switch key {
+		case "years":
+			years = int(n)
+		case "months":
+			months = int(n)
+		case "days":
+			days = int(n)
+		case "hours":
+			d += time.Duration(n) * time.Hour
+		case "minutes":
+			d += time.Duration(n) * time.Minute
+		case "seconds":
+			d += time.Duration(n) * time.Second
+		case "ms":
+			d += time.Duration(n) * time.Millisecond
+		default:
+			return ls.Error2("unknown duration field '%s'", key)
+		}
+		ls.Pop(1)
+	}
+	ls.PushInteger(t.AddDate(years, months, days).Add(d).UnixMilli())
+	return 1
+}
+
+// time.diff (a, b [, unit])
+// Returns a - b in unit: 'ms' (default), 'seconds', 'minutes', 'hours',
+// 'days' (of 24 hours) or 'weeks'.
+func timeDiff(ls LkState) int {
+	d := ls.CheckInteger(1) - ls.CheckInteger(2)
+	unit := ls.OptString(3, "ms")
+	if unit == "ms" {
+		ls.PushInteger(d)
+		return 1
+	}
+	per, ok := map[string]time.Duration{
+		"seconds": time.Second,
+		"minutes": time.Minute,
+		"hours":   time.Hour,
+		"days":    24 * time.Hour,
+		"weeks":   7 * 24 * time.Hour,
+	}[unit]
+	ls.ArgCheck(ok, 3, "invalid unit '"+unit+"'")
+	ls.PushNumber(float64(d) / float64(per/time.Millisecond))
+	return 1
+}
+
+// _checkLocation returns the zone named by the optional arg.
+func _checkLocation(ls LkState, arg int) *time.Location {
+	name := ls.OptString(arg, "Local")
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		ls.ArgError(arg, err.Error())
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// _goLayout returns the Go layout of a strftime format, for parsing.
+// The text around the conversions must not look like a Go layout.
+func _goLayout(format string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("invalid conversion '%%' to 'parse'")
+		}
+		layout, ok := map[byte]string{
+			'a': "Mon", 'A': "Monday", 'b': "Jan", 'h': "Jan", 'B': "January",
+			'c': time.ANSIC, 'd': "02", 'D': "01/02/06", 'e': "_2",
+			'F': "2006-01-02", 'H': "15", 'I': "03", 'j': "002", 'm': "01",
+			'M': "04", 'n': "\n", 'p': "PM", 'R': "15:04", 'S': "05", 't': "\t",
+			'T': "15:04:05", 'x': "01/02/06", 'X': "15:04:05", 'y': "06",
+			'Y': "2006", 'z': "-0700", 'Z': "MST", '%': "%",
+		}[format[i]]
+		if !ok {
+			return "", fmt.Errorf("invalid conversion '%%%c' to 'parse'", format[i])
+		}
+		b.WriteString(layout)
+	}
+	return b.String(), nil
+}
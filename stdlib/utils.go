@@ -2,7 +2,10 @@ package stdlib
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
 
 	. "github.com/lollipopkit/lk/api"
 )
@@ -14,6 +17,10 @@ import (
 type lkMap map[string]any
 
 func pushValue(ls LkState, item any) {
+	_pushValue(ls, item, nil)
+}
+
+func _pushValue(ls LkState, item any, seen map[seenKey]bool) {
 	switch i := item.(type) {
 	case string:
 		ls.PushString(i)
@@ -30,29 +37,155 @@ func pushValue(ls LkState, item any) {
 	case nil:
 		ls.PushNil()
 	default:
-		v := reflect.ValueOf(i)
-		switch v.Kind() {
-		case reflect.Slice:
-			items := make([]any, v.Len())
-			for i := 0; i < v.Len(); i++ {
-				items[i] = v.Index(i).Interface()
-			}
-			pushList(ls, items)
+		pushReflect(ls, reflect.ValueOf(i), seen)
+	}
+}
+
+// PushGoValue pushes v converted to lk values:
+// numbers, strings and bools as is (uint64 above the integers as floats),
+// slices and arrays as lists, maps and structs as tables.
+// Structs keep their exported fields, named by their json tag, and
+// the fields of their embedded structs, like encoding/json.
+// Other funcs than GoFunction, chans, complex numbers and
+// cycles of pointers, maps or slices raise an error.
+func PushGoValue(ls LkState, v any) {
+	pushValue(ls, v)
+}
+
+// seenKey is a pointer, map or slice being converted, to find cycles.
+// Slices of different lengths sharing an array are different values.
+type seenKey struct {
+	ptr uintptr
+	len int
+}
+
+// _enter marks the pointer, map or slice v as being converted,
+// raising an error if it already is. The caller calls the returned leave.
+func _enter(ls LkState, v reflect.Value, seen *map[seenKey]bool) (leave func()) {
+	key := seenKey{ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if *seen == nil {
+		*seen = map[seenKey]bool{}
+	}
+	if (*seen)[key] {
+		ls.Error2("cannot convert a cycle through %s", v.Type())
+	}
+	(*seen)[key] = true
+	return func() { delete(*seen, key) }
+}
+
+func pushReflect(ls LkState, v reflect.Value, seen map[seenKey]bool) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		ls.PushNil()
+	case reflect.Interface:
+		if v.IsNil() {
+			ls.PushNil()
+		} else {
+			_pushValue(ls, v.Elem().Interface(), seen)
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			ls.PushNil()
 			return
-		case reflect.Map:
-			items := make(lkMap)
-			keys := v.MapKeys()
-			for idx := range keys {
-				key := &keys[idx]
-				items[(*key).String()] = v.MapIndex(*key).Interface()
-			}
-			pushTable(ls, items)
+		}
+		defer _enter(ls, v, &seen)()
+		_pushValue(ls, v.Elem().Interface(), seen)
+	case reflect.Bool:
+		ls.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ls.PushInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u > math.MaxInt64 {
+			ls.PushNumber(float64(u))
+		} else {
+			ls.PushInteger(int64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		ls.PushNumber(v.Float())
+	case reflect.String:
+		ls.PushString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			ls.PushString(string(v.Bytes()))
 			return
 		}
-		panic(fmt.Sprintf("unsupported type: %T", item))
+		if v.Kind() == reflect.Slice && v.Len() > 0 {
+			defer _enter(ls, v, &seen)()
+		}
+		ls.CreateTable(v.Len(), 0)
+		for i := 0; i < v.Len(); i++ {
+			_pushValue(ls, v.Index(i).Interface(), seen)
+			ls.SetI(-2, int64(i))
+		}
+	case reflect.Map:
+		defer _enter(ls, v, &seen)()
+		ls.CreateTable(0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			_pushValue(ls, iter.Value().Interface(), seen)
+			ls.SetField(-2, fmt.Sprint(iter.Key().Interface()))
+		}
+	case reflect.Struct:
+		ls.CreateTable(0, v.NumField())
+		_setFields(ls, v, seen)
+	default:
+		ls.Error2("cannot convert a %s", v.Type())
 	}
 }
 
+// _setFields sets the fields of the struct v to the table on the top.
+// Like encoding/json, the fields of embedded structs without a json name
+// come first, so the fields of v take precedence over them.
+func _setFields(ls LkState, v reflect.Value, seen map[seenKey]bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag != "" {
+			continue
+		}
+		embedded := v.Field(i)
+		if embedded.Kind() == reflect.Pointer {
+			if embedded.IsNil() {
+				continue
+			}
+			embedded = embedded.Elem()
+		}
+		if embedded.Kind() == reflect.Struct {
+			_setFields(ls, embedded, seen)
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		} else if field.Anonymous && _isStruct(field.Type) {
+			continue
+		}
+		_pushValue(ls, v.Field(i).Interface(), seen)
+		ls.SetField(-2, name)
+	}
+}
+
+func _isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
 func pushList[T any](ls LkState, items []T) {
 	ls.CreateTable(len(items), 0)
 	for i := range items {
@@ -117,6 +250,16 @@ func OptTable(ls LkState, idx int, dft lkMap) lkMap {
 	return getTable(ls, idx)
 }
 
+// _optField returns the field key of the options m, the zero T if none.
+// Raises an error if it is not a T, named typ.
+func _optField[T any](ls LkState, m lkMap, key, typ string) T {
+	v, ok := m[key].(T)
+	if !ok && m[key] != nil {
+		ls.Error2("field '%s' is not a %s", key, typ)
+	}
+	return v
+}
+
 // lua-5.3.4/src/loslib.c#getfield()
 func _getField(ls LkState, key string, dft int64) int {
 	t := ls.GetField(-1, key) /* get field and its type */
@@ -137,3 +280,55 @@ func getFunc(ls LkState, idx int) GoFunction {
 	ls.CheckType(idx, LK_TFUNCTION)
 	return ls.ToGoFunction(idx)
 }
+
+// getValue returns the value at idx as a Go value, for the encoders:
+// nil, bool, int64, float64, string, []any for lists (tables of
+// the keys 0 to n-1) and map[string]any for the other tables.
+// Functions, userdata and nested tables too deep (cycles) are errors.
+func getValue(ls LkState, idx int) (any, error) {
+	return _getValue(ls, ls.AbsIndex(idx), 0)
+}
+
+func _getValue(ls LkState, idx, depth int) (any, error) {
+	switch ls.Type(idx) {
+	case LK_TNIL, LK_TNONE:
+		return nil, nil
+	case LK_TBOOLEAN:
+		return ls.ToBoolean(idx), nil
+	case LK_TNUMBER:
+		if ls.IsInteger(idx) {
+			return ls.ToInteger(idx), nil
+		}
+		return ls.ToNumber(idx), nil
+	case LK_TSTRING:
+		return ls.ToString(idx), nil
+	case LK_TTABLE:
+		if depth >= 100 || !ls.CheckStack(4) {
+			return nil, fmt.Errorf("table nested too deep, or a cycle")
+		}
+		n := ls.Len2(idx)
+		count := int64(0)
+		m := map[string]any{}
+		ls.PushNil()
+		for ls.Next(idx) {
+			v, err := _getValue(ls, ls.GetTop(), depth+1)
+			if err != nil {
+				ls.Pop(2)
+				return nil, err
+			}
+			ls.PushValue(-2)
+			m[ls.ToString2(-1)] = v
+			ls.Pop(3)
+			count++
+		}
+		if count == 0 || count != n {
+			return m, nil
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = m[strconv.Itoa(i)]
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("cannot encode a %s", ls.TypeName2(idx))
+}
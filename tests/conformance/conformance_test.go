@@ -0,0 +1,50 @@
+// Package conformance runs lk ports of the Lua 5.3 test suite,
+// for the parts where lk and Lua semantics overlap.
+// Each *.lk file must run without error: checks are done with assert.
+package conformance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/state"
+)
+
+func TestConformance(t *testing.T) {
+	files, err := filepath.Glob("*.lk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(file, func(t *testing.T) {
+			if err := run(file); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// run runs file in a new state. Syntax errors panic in the compiler,
+// so they are recovered too.
+func run(file string) (err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.Load(data, file, "bt") != LK_OK || ls.PCall(0, 0, 0) != LK_OK {
+		return fmt.Errorf("%v", ls.ToString2(-1))
+	}
+	return nil
+}
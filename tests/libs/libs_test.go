@@ -0,0 +1,60 @@
+// Package libs tests the standard libraries of lk with scripts:
+// each *.lk file must run without error, checks are done with assert.
+// Files the tests need are in testdata.
+package libs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/state"
+)
+
+// files running unix commands
+var unixOnly = map[string]bool{
+	"proc.lk":   true,
+	"signal.lk": true,
+}
+
+func TestLibs(t *testing.T) {
+	files, err := filepath.Glob("*.lk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file := file
+		t.Run(file, func(t *testing.T) {
+			if unixOnly[file] && runtime.GOOS == "windows" {
+				t.Skip("unix only")
+			}
+			if err := run(file); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// run runs file in a new state. Syntax errors panic in the compiler,
+// so they are recovered too.
+func run(file string) (err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.Load(data, file, "bt") != LK_OK || ls.PCall(0, 0, 0) != LK_OK {
+		return fmt.Errorf("%v", ls.ToString2(-1))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package vm
+
+import (
+	"github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/binchunk"
+)
+
+// Decoded is an instruction decoded once, before the VM runs it,
+// with the action of its opcode.
+type Decoded struct {
+	Inst    Instruction
+	A, B, C int // B is Bx or sBx and A is Ax, depending on the op mode
+	Action  func(i *Decoded, vm api.LkVM)
+	Next    *Decoded // the next instruction, for fused opcodes
+	Unfused *Decoded // the first instruction alone, for fused opcodes
+}
+
+// Code is the decoded code of a proto, with the code of its sub protos.
+type Code struct {
+	Insts  []Decoded
+	Protos []*Code
+}
+
+// Decode decodes the code of proto and its sub protos.
+// Like proto, the returned code must not be modified.
+func Decode(proto *binchunk.Prototype) *Code {
+	code := &Code{
+		Insts:  make([]Decoded, len(proto.Code)),
+		Protos: make([]*Code, len(proto.Protos)),
+	}
+	for pc, inst := range proto.Code {
+		d := &code.Insts[pc]
+		*d = Instruction(inst).decode()
+		if d.Inst.Opcode() >= OP_EQ_JMP && pc+1 < len(code.Insts) {
+			d.Next = &code.Insts[pc+1]
+			first := d.Inst.Unfused().decode()
+			d.Unfused = &first
+		}
+	}
+	for i, sub := range proto.Protos {
+		code.Protos[i] = Decode(sub)
+	}
+	return code
+}
+
+func (self Instruction) decode() Decoded {
+	d := Decoded{Inst: self, Action: opcodes[self.Opcode()].action}
+	switch self.OpMode() {
+	case IABC:
+		d.A, d.B, d.C = self.ABC()
+	case IABx:
+		d.A, d.B = self.ABx()
+	case IAsBx:
+		d.A, d.B = self.AsBx()
+	case IAx:
+		d.A = self.Ax()
+	}
+	if d.Action == nil {
+		d.Action = func(i *Decoded, vm api.LkVM) {
+			panic("No instruction: " + i.Inst.OpName())
+		}
+	}
+	return d
+}
+
+func (self *Decoded) ABC() (a, b, c int) {
+	return self.A, self.B, self.C
+}
+
+func (self *Decoded) ABx() (a, bx int) {
+	return self.A, self.B
+}
+
+func (self *Decoded) AsBx() (a, sbx int) {
+	return self.A, self.B
+}
+
+func (self *Decoded) Ax() int {
+	return self.A
+}
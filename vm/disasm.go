@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/lollipopkit/lk/binchunk"
+)
+
+// Disassemble writes the code of proto and its sub protos to w, like `luac -l -l`:
+// instructions with their line, then constants, locals and upvalues.
+func Disassemble(w io.Writer, proto *binchunk.Prototype) {
+	what := "fn"
+	if proto.LineDefined == 0 {
+		what = "main"
+	}
+	vararg := ""
+	if proto.IsVararg == 1 {
+		vararg = "+"
+	}
+	fmt.Fprintf(w, "%s <%s:%d,%d> (%d instructions)\n",
+		what, proto.Source, proto.LineDefined, proto.LastLineDefined, len(proto.Code))
+	fmt.Fprintf(w, "%d%s params, %d slots, %d upvalues, %d locals, %d constants, %d functions\n",
+		proto.NumParams, vararg, proto.MaxStackSize, len(proto.Upvalues),
+		len(proto.LocVars), len(proto.Constants), len(proto.Protos))
+
+	for pc, code := range proto.Code {
+		line := "-"
+		if pc < len(proto.LineInfo) {
+			line = fmt.Sprint(proto.LineInfo[pc])
+		}
+		fmt.Fprintf(w, "\t%d\t[%s]\t%s\n", pc+1, line, Instruction(code))
+	}
+
+	fmt.Fprintf(w, "constants (%d):\n", len(proto.Constants))
+	for i, k := range proto.Constants {
+		fmt.Fprintf(w, "\t%d\t%s\n", i+1, constantString(k))
+	}
+	fmt.Fprintf(w, "locals (%d):\n", len(proto.LocVars))
+	for i, v := range proto.LocVars {
+		fmt.Fprintf(w, "\t%d\t%s\t%d\t%d\n", i, v.VarName, v.StartPC+1, v.EndPC+1)
+	}
+	fmt.Fprintf(w, "upvalues (%d):\n", len(proto.Upvalues))
+	for i, uv := range proto.Upvalues {
+		name := "-"
+		if i < len(proto.UpvalueNames) {
+			name = proto.UpvalueNames[i]
+		}
+		fmt.Fprintf(w, "\t%d\t%s\t%d\t%d\n", i, name, uv.Instack, uv.Idx)
+	}
+
+	for _, sub := range proto.Protos {
+		fmt.Fprintln(w)
+		Disassemble(w, sub)
+	}
+}
+
+func constantString(k any) string {
+	switch k := k.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("%q", k)
+	case float64:
+		return fmt.Sprintf("%g (float)", k)
+	}
+	return fmt.Sprint(k)
+}
@@ -0,0 +1,143 @@
+package vm
+
+import "github.com/lollipopkit/lk/binchunk"
+
+// FuncName tells how the function called by the instruction at pc
+// of proto is named there: kind is "global", "local", "method", "field",
+// "upvalue", "constant" or "for iterator", or "" if unknown.
+// lua-5.3.4/src/ldebug.c#getfuncname()
+func FuncName(proto *binchunk.Prototype, pc int) (kind, name string) {
+	if pc < 0 || pc >= len(proto.Code) {
+		return "", ""
+	}
+	i := Instruction(proto.Code[pc]).Unfused()
+	switch i.Opcode() {
+	case OP_CALL, OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return objName(proto, pc, a)
+	case OP_TFORCALL:
+		return "for iterator", "for iterator"
+	}
+	return "", ""
+}
+
+// lua-5.3.4/src/ldebug.c#getobjname()
+func objName(proto *binchunk.Prototype, lastPC, reg int) (kind, name string) {
+	if name, ok := localName(proto, reg+1, lastPC); ok {
+		return "local", name
+	}
+	pc := findSetReg(proto, lastPC, reg)
+	if pc < 0 {
+		return "", ""
+	}
+	i := Instruction(proto.Code[pc]).Unfused()
+	a, b, c := i.ABC()
+	switch i.Opcode() {
+	case OP_MOVE:
+		if b < a {
+			return objName(proto, pc, b)
+		}
+	case OP_GETTABUP, OP_GETTABLE:
+		var t string
+		if i.Opcode() == OP_GETTABLE {
+			t, _ = localName(proto, b+1, pc)
+		} else {
+			t = upvalName(proto, b)
+		}
+		if k, ok := constName(proto, c); ok {
+			if t == "_ENV" {
+				return "global", k
+			}
+			return "field", k
+		}
+	case OP_GETUPVAL:
+		return "upvalue", upvalName(proto, b)
+	case OP_LOADK:
+		_, bx := i.ABx()
+		if k, ok := proto.Constants[bx].(string); ok {
+			return "constant", k
+		}
+	case OP_SELF:
+		if k, ok := constName(proto, c); ok {
+			return "method", k
+		}
+	}
+	return "", ""
+}
+
+// findSetReg returns the pc of the last instruction before lastPC
+// which sets reg, or -1 if it can't be known.
+// lua-5.3.4/src/ldebug.c#findsetreg()
+func findSetReg(proto *binchunk.Prototype, lastPC, reg int) int {
+	setReg := -1
+	jmpTarget := 0 /* any code before this address is conditional */
+	filter := func(pc int) int {
+		if pc < jmpTarget {
+			return -1 /* is code conditional (inside a jump)? */
+		}
+		return pc
+	}
+	for pc := 0; pc < lastPC; pc++ {
+		i := Instruction(proto.Code[pc]).Unfused()
+		a, b, _ := i.ABC()
+		switch op := i.Opcode(); op {
+		case OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setReg = filter(pc)
+			}
+		case OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setReg = filter(pc)
+			}
+		case OP_CALL, OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setReg = filter(pc)
+			}
+		case OP_JMP:
+			_, sbx := i.AsBx()
+			dest := pc + 1 + sbx
+			/* jump is forward and do not skip 'lastpc'? */
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest /* update 'jmptarget' */
+			}
+		default:
+			if opcodes[op].setAFlag == 1 && reg == a { /* any instruction that set A */
+				setReg = filter(pc)
+			}
+		}
+	}
+	return setReg
+}
+
+// localName returns the name of the n-th local variable active at pc.
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func localName(proto *binchunk.Prototype, n, pc int) (string, bool) {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			n--
+			if n == 0 {
+				return locVar.VarName, true
+			}
+		}
+	}
+	return "", false
+}
+
+func upvalName(proto *binchunk.Prototype, idx int) string {
+	if idx < len(proto.UpvalueNames) {
+		return proto.UpvalueNames[idx]
+	}
+	return "?"
+}
+
+// constName returns the constant string of the RK arg c, if it is one.
+func constName(proto *binchunk.Prototype, c int) (string, bool) {
+	if c > 0xFF {
+		k, ok := proto.Constants[c&0xFF].(string)
+		return k, ok
+	}
+	return "", false
+}
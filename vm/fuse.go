@@ -0,0 +1,89 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lollipopkit/lk/api"
+	"github.com/lollipopkit/lk/binchunk"
+)
+
+// Superinstructions: the first instruction of a common pair is given
+// a fused opcode, which runs it and then the next instruction in a single
+// dispatch of the VM loop. The next instruction stays in place, so jumps
+// to it and the debug info are unchanged.
+
+// fusions are the fused pairs, by fused opcode - OP_EQ_JMP.
+var fusions = []struct{ first, next int }{
+	{OP_EQ, OP_JMP},
+	{OP_LT, OP_JMP},
+	{OP_LE, OP_JMP},
+	{OP_IN, OP_JMP},
+	{OP_TEST, OP_JMP},
+	{OP_TESTSET, OP_JMP},
+	{OP_LOADBOOL, OP_TEST},
+	{OP_MOVE, OP_CALL},
+	{OP_LOADK, OP_CALL},
+	{OP_GETTABLE, OP_CALL},
+	{OP_GETTABUP, OP_GETTABLE},
+	{OP_TFORCALL, OP_TFORLOOP},
+}
+
+func init() {
+	for _, f := range fusions {
+		op := opcodes[f.first]
+		/* EQ+ reads as `EQ and the next one` */
+		op.name = fmt.Sprintf("%-8s", strings.TrimRight(op.name, " ")+"+")
+		op.action = fused(op.action)
+		opcodes = append(opcodes, op)
+	}
+}
+
+// fused returns the action of a fused opcode:
+// it runs action, then the next instruction unless action skipped it.
+func fused(action func(i *Decoded, vm api.LkVM)) func(i *Decoded, vm api.LkVM) {
+	return func(i *Decoded, vm api.LkVM) {
+		pc := vm.PC()
+		action(i, vm)
+		if vm.PC() == pc {
+			vm.AddPC(1)
+			i.Next.Action(i.Next, vm)
+		}
+	}
+}
+
+// Fuse gives fused opcodes to the pairs of instructions of proto
+// and its sub protos found in fusions.
+// Pairs spanning two lines are left alone, so line hooks still see every
+// line, but count hooks count a pair once. Instruction budgets run the
+// pairs unfused, see Decoded.Unfused.
+func Fuse(proto *binchunk.Prototype) {
+	code := proto.Code
+	for pc := 0; pc+1 < len(code); pc++ {
+		if pc+1 < len(proto.LineInfo) && proto.LineInfo[pc] != proto.LineInfo[pc+1] {
+			continue
+		}
+		i, next := Instruction(code[pc]), Instruction(code[pc+1]).Opcode()
+		for k, f := range fusions {
+			if i.Opcode() == f.first && next == f.next {
+				code[pc] = uint32(i.withOpcode(OP_EQ_JMP + k))
+				break
+			}
+		}
+	}
+	for _, p := range proto.Protos {
+		Fuse(p)
+	}
+}
+
+// Unfused returns i with the opcode of its first instruction if it is fused.
+func (self Instruction) Unfused() Instruction {
+	if op := self.Opcode(); op >= OP_EQ_JMP && op < OP_EQ_JMP+len(fusions) {
+		return self.withOpcode(fusions[op-OP_EQ_JMP].first)
+	}
+	return self
+}
+
+func (self Instruction) withOpcode(op int) Instruction {
+	return self&^0x3F | Instruction(op)
+}
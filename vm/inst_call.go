@@ -3,19 +3,18 @@ package vm
 import . "github.com/lollipopkit/lk/api"
 
 // R(A+1) := R(B); R(A) := R(B)[RK(C)]
-func self(i Instruction, vm LkVM) {
+func self(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 	b += 1
 
 	vm.Copy(b, a+1)
-	vm.GetRK(c)
-	vm.GetTable(b)
+	vm.GetTableRK(b, c)
 	vm.Replace(a)
 }
 
 // R(A) := closure(KPROTO[Bx])
-func closure(i Instruction, vm LkVM) {
+func closure(i *Decoded, vm LkVM) {
 	a, bx := i.ABx()
 	a += 1
 
@@ -24,7 +23,7 @@ func closure(i Instruction, vm LkVM) {
 }
 
 // R(A), R(A+1), ..., R(A+B-2) = vararg
-func vararg(i Instruction, vm LkVM) {
+func vararg(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 
@@ -35,7 +34,7 @@ func vararg(i Instruction, vm LkVM) {
 }
 
 // R(A+3), ... ,R(A+2+C) := R(A)(R(A+1), R(A+2));
-func tForCall(i Instruction, vm LkVM) {
+func tForCall(i *Decoded, vm LkVM) {
 	a, _, c := i.ABC()
 	a += 1
 
@@ -45,19 +44,20 @@ func tForCall(i Instruction, vm LkVM) {
 }
 
 // return R(A)(R(A+1), ... ,R(A+B-1))
-func tailCall(i Instruction, vm LkVM) {
+func tailCall(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 
-	// todo: optimize tail call!
-	c := 0
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	_popResults(a, c, vm)
+	if vm.TailCall(nArgs) {
+		return // the next instruction is the first of the called function
+	}
+	vm.Call(nArgs, -1)
+	_popResults(a, 0, vm)
 }
 
 // R(A), ... ,R(A+C-2) := R(A)(R(A+1), ... ,R(A+B-1))
-func call(i Instruction, vm LkVM) {
+func call(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 
@@ -106,7 +106,7 @@ func _popResults(a, c int, vm LkVM) {
 }
 
 // return R(A), ... ,R(A+B-2)
-func _return(i Instruction, vm LkVM) {
+func _return(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 
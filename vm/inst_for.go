@@ -3,7 +3,7 @@ package vm
 import . "github.com/lollipopkit/lk/api"
 
 // R(A)-=R(A+2); pc+=sBx
-func forPrep(i Instruction, vm LkVM) {
+func forPrep(i *Decoded, vm LkVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
@@ -32,7 +32,7 @@ func forPrep(i Instruction, vm LkVM) {
 //	if R(A) <?= R(A+1) then {
 //	  pc+=sBx; R(A+3)=R(A)
 //	}
-func forLoop(i Instruction, vm LkVM) {
+func forLoop(i *Decoded, vm LkVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
@@ -55,7 +55,7 @@ func forLoop(i Instruction, vm LkVM) {
 //	if R(A+1) ~= nil then {
 //	  R(A)=R(A+1); pc += sBx
 //	}
-func tForLoop(i Instruction, vm LkVM) {
+func tForLoop(i *Decoded, vm LkVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
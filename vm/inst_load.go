@@ -3,7 +3,7 @@ package vm
 import . "github.com/lollipopkit/lk/api"
 
 // R(A), R(A+1), ..., R(A+B) := nil
-func loadNil(i Instruction, vm LkVM) {
+func loadNil(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 
@@ -15,7 +15,7 @@ func loadNil(i Instruction, vm LkVM) {
 }
 
 // R(A) := (bool)B; if (C) pc++
-func loadBool(i Instruction, vm LkVM) {
+func loadBool(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 
@@ -28,7 +28,7 @@ func loadBool(i Instruction, vm LkVM) {
 }
 
 // R(A) := Kst(Bx)
-func loadK(i Instruction, vm LkVM) {
+func loadK(i *Decoded, vm LkVM) {
 	a, bx := i.ABx()
 	a += 1
 
@@ -37,7 +37,7 @@ func loadK(i Instruction, vm LkVM) {
 }
 
 // R(A) := Kst(extra arg)
-func loadKx(i Instruction, vm LkVM) {
+func loadKx(i *Decoded, vm LkVM) {
 	a, _ := i.ABx()
 	a += 1
 	ax := Instruction(vm.Fetch()).Ax()
@@ -3,7 +3,7 @@ package vm
 import . "github.com/lollipopkit/lk/api"
 
 // R(A) := R(B)
-func move(i Instruction, vm LkVM) {
+func move(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 	b += 1
@@ -12,7 +12,7 @@ func move(i Instruction, vm LkVM) {
 }
 
 // pc+=sBx; if (A) close all upvalues >= R(A - 1)
-func jmp(i Instruction, vm LkVM) {
+func jmp(i *Decoded, vm LkVM) {
 	a, sBx := i.AsBx()
 
 	vm.AddPC(sBx)
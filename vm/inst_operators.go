@@ -4,23 +4,23 @@ import . "github.com/lollipopkit/lk/api"
 
 /* arith */
 
-func add(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPADD) }  // +
-func sub(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPSUB) }  // -
-func mul(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPMUL) }  // *
-func mod(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPMOD) }  // %
-func pow(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPPOW) }  // ^
-func div(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPDIV) }  // /
-func idiv(i Instruction, vm LkVM) { _binaryArith(i, vm, LK_OPIDIV) } // //
-func band(i Instruction, vm LkVM) { _binaryArith(i, vm, LK_OPBAND) } // &
-func bor(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPBOR) }  // |
-func bxor(i Instruction, vm LkVM) { _binaryArith(i, vm, LK_OPBXOR) } // ~
-func shl(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPSHL) }  // <<
-func shr(i Instruction, vm LkVM)  { _binaryArith(i, vm, LK_OPSHR) }  // >>
-func unm(i Instruction, vm LkVM)  { _unaryArith(i, vm, LK_OPUNM) }   // -
-func bnot(i Instruction, vm LkVM) { _unaryArith(i, vm, LK_OPBNOT) }  // ~
+func add(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPADD) }  // +
+func sub(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPSUB) }  // -
+func mul(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPMUL) }  // *
+func mod(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPMOD) }  // %
+func pow(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPPOW) }  // ^
+func div(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPDIV) }  // /
+func idiv(i *Decoded, vm LkVM) { _binaryArith(i, vm, LK_OPIDIV) } // //
+func band(i *Decoded, vm LkVM) { _binaryArith(i, vm, LK_OPBAND) } // &
+func bor(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPBOR) }  // |
+func bxor(i *Decoded, vm LkVM) { _binaryArith(i, vm, LK_OPBXOR) } // ~
+func shl(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPSHL) }  // <<
+func shr(i *Decoded, vm LkVM)  { _binaryArith(i, vm, LK_OPSHR) }  // >>
+func unm(i *Decoded, vm LkVM)  { _unaryArith(i, vm, LK_OPUNM) }   // -
+func bnot(i *Decoded, vm LkVM) { _unaryArith(i, vm, LK_OPBNOT) }  // ~
 
 // R(A) := RK(B) op RK(C)
-func _binaryArith(i Instruction, vm LkVM, op ArithOp) {
+func _binaryArith(i *Decoded, vm LkVM, op ArithOp) {
 	a, b, c := i.ABC()
 	a += 1
 
@@ -31,7 +31,7 @@ func _binaryArith(i Instruction, vm LkVM, op ArithOp) {
 }
 
 // R(A) := op R(B)
-func _unaryArith(i Instruction, vm LkVM, op ArithOp) {
+func _unaryArith(i *Decoded, vm LkVM, op ArithOp) {
 	a, b, _ := i.ABC()
 	a += 1
 	b += 1
@@ -43,12 +43,13 @@ func _unaryArith(i Instruction, vm LkVM, op ArithOp) {
 
 /* compare */
 
-func eq(i Instruction, vm LkVM) { _compare(i, vm, LK_OPEQ) } // ==
-func lt(i Instruction, vm LkVM) { _compare(i, vm, LK_OPLT) } // <
-func le(i Instruction, vm LkVM) { _compare(i, vm, LK_OPLE) } // <=
+func eq(i *Decoded, vm LkVM) { _compare(i, vm, LK_OPEQ) } // ==
+func lt(i *Decoded, vm LkVM) { _compare(i, vm, LK_OPLT) } // <
+func le(i *Decoded, vm LkVM) { _compare(i, vm, LK_OPLE) } // <=
+func in(i *Decoded, vm LkVM) { _compare(i, vm, LK_OPIN) } // in
 
 // if ((RK(B) op RK(C)) ~= A) then pc++
-func _compare(i Instruction, vm LkVM, op CompareOp) {
+func _compare(i *Decoded, vm LkVM, op CompareOp) {
 	a, b, c := i.ABC()
 
 	vm.GetRK(b)
@@ -62,7 +63,7 @@ func _compare(i Instruction, vm LkVM, op CompareOp) {
 /* logical */
 
 // R(A) := not R(B)
-func not(i Instruction, vm LkVM) {
+func not(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 	b += 1
@@ -72,7 +73,7 @@ func not(i Instruction, vm LkVM) {
 }
 
 // if not (R(A) <=> C) then pc++
-func test(i Instruction, vm LkVM) {
+func test(i *Decoded, vm LkVM) {
 	a, _, c := i.ABC()
 	a += 1
 
@@ -82,7 +83,7 @@ func test(i Instruction, vm LkVM) {
 }
 
 // if (R(B) <=> C) then R(A) := R(B) else pc++
-func testSet(i Instruction, vm LkVM) {
+func testSet(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 	b += 1
@@ -97,7 +98,7 @@ func testSet(i Instruction, vm LkVM) {
 /* len & concat */
 
 // R(A) := length of R(B)
-func length(i Instruction, vm LkVM) {
+func length(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 	b += 1
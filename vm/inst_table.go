@@ -6,7 +6,7 @@ import . "github.com/lollipopkit/lk/api"
 const LFIELDS_PER_FLUSH = 50
 
 // R(A) := {} (size = B,C)
-func newTable(i Instruction, vm LkVM) {
+func newTable(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 
@@ -15,18 +15,17 @@ func newTable(i Instruction, vm LkVM) {
 }
 
 // R(A) := R(B)[RK(C)]
-func getTable(i Instruction, vm LkVM) {
+func getTable(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 	b += 1
 
-	vm.GetRK(c)
-	vm.GetTable(b)
+	vm.GetTableRK(b, c)
 	vm.Replace(a)
 }
 
 // R(A)[RK(B)] := RK(C)
-func setTable(i Instruction, vm LkVM) {
+func setTable(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 
@@ -36,7 +35,7 @@ func setTable(i Instruction, vm LkVM) {
 }
 
 // R(A)[(C-1)*FPF+i] := R(A+i), 1 <= i <= B
-func setList(i Instruction, vm LkVM) {
+func setList(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 
@@ -54,6 +53,10 @@ func setList(i Instruction, vm LkVM) {
 
 	vm.CheckStack(1)
 	idx := int64(c*LFIELDS_PER_FLUSH) - 1
+	if bIsZero {
+		/* the count of the trailing values is known now */
+		vm.Reserve(a, int(idx)+1+b+vm.GetTop()-vm.RegisterCount())
+	}
 	for j := 1; j <= b; j++ {
 		idx++
 		vm.PushValue(a + j)
@@ -3,7 +3,7 @@ package vm
 import . "github.com/lollipopkit/lk/api"
 
 // R(A) := UpValue[B]
-func getUpval(i Instruction, vm LkVM) {
+func getUpval(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 	b += 1
@@ -12,7 +12,7 @@ func getUpval(i Instruction, vm LkVM) {
 }
 
 // UpValue[B] := R(A)
-func setUpval(i Instruction, vm LkVM) {
+func setUpval(i *Decoded, vm LkVM) {
 	a, b, _ := i.ABC()
 	a += 1
 	b += 1
@@ -21,18 +21,17 @@ func setUpval(i Instruction, vm LkVM) {
 }
 
 // R(A) := UpValue[B][RK(C)]
-func getTabUp(i Instruction, vm LkVM) {
+func getTabUp(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 	b += 1
 
-	vm.GetRK(c)
-	vm.GetTable(LkUpvalueIndex(b))
+	vm.GetTableRK(LkUpvalueIndex(b), c)
 	vm.Replace(a)
 }
 
 // UpValue[A][RK(B)] := RK(C)
-func setTabUp(i Instruction, vm LkVM) {
+func setTabUp(i *Decoded, vm LkVM) {
 	a, b, c := i.ABC()
 	a += 1
 
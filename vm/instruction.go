@@ -1,8 +1,6 @@
 package vm
 
-import (
-	"github.com/lollipopkit/lk/api"
-)
+import "fmt"
 
 const MAXARG_Bx = 1<<18 - 1       // 262143
 const MAXARG_sBx = MAXARG_Bx >> 1 // 131071
@@ -66,11 +64,39 @@ func (self Instruction) CMode() byte {
 	return opcodes[self.Opcode()].argCMode
 }
 
-func (self Instruction) Execute(vm api.LkVM) {
-	action := opcodes[self.Opcode()].action
-	if action != nil {
-		action(self, vm)
-	} else {
-		panic("No instruction: " + self.OpName())
+// String disassembles the instruction like `luac -l`, eg: `ADD 0 0 -1`.
+// Constants are shown as negative numbers: -1 is the first constant.
+func (self Instruction) String() string {
+	name := self.OpName()
+	switch self.OpMode() {
+	case IABC:
+		a, b, c := self.ABC()
+		s := fmt.Sprintf("%s %d", name, a)
+		if self.BMode() != OpArgN {
+			s += " " + argRK(b, self.BMode())
+		}
+		if self.CMode() != OpArgN {
+			s += " " + argRK(c, self.CMode())
+		}
+		return s
+	case IABx:
+		a, bx := self.ABx()
+		if self.BMode() == OpArgK {
+			return fmt.Sprintf("%s %d %d", name, a, -1-bx)
+		}
+		return fmt.Sprintf("%s %d %d", name, a, bx)
+	case IAsBx:
+		a, sbx := self.AsBx()
+		return fmt.Sprintf("%s %d %d", name, a, sbx)
+	case IAx:
+		return fmt.Sprintf("%s %d", name, -1-self.Ax())
 	}
+	return name
+}
+
+func argRK(arg int, mode byte) string {
+	if mode == OpArgK && arg > 0xFF { // constant
+		return fmt.Sprint(-1 - arg&0xFF)
+	}
+	return fmt.Sprint(arg)
 }
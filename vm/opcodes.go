@@ -67,6 +67,20 @@ const (
 	OP_CLOSURE
 	OP_VARARG
 	OP_EXTRAARG
+	OP_IN
+	/* fused with the next instruction, see Fuse */
+	OP_EQ_JMP
+	OP_LT_JMP
+	OP_LE_JMP
+	OP_IN_JMP
+	OP_TEST_JMP
+	OP_TESTSET_JMP
+	OP_LOADBOOL_TEST
+	OP_MOVE_CALL
+	OP_LOADK_CALL
+	OP_GETTABLE_CALL
+	OP_GETTABUP_GETTABLE
+	OP_TFORCALL_TFORLOOP
 )
 
 type opcode struct {
@@ -76,7 +90,7 @@ type opcode struct {
 	argCMode byte // C arg mode
 	opMode   byte // op mode
 	name     string
-	action   func(i Instruction, vm api.LkVM)
+	action   func(i *Decoded, vm api.LkVM)
 }
 
 var opcodes = []opcode{
@@ -129,4 +143,5 @@ var opcodes = []opcode{
 	{0, 1, OpArgU, OpArgN, IABx /* */, "CLOSURE ", closure},  // R(A) := closure(KPROTO[Bx])
 	{0, 1, OpArgU, OpArgN, IABC /* */, "VARARG  ", vararg},   // R(A), R(A+1), ..., R(A+B-2) = vararg
 	{0, 0, OpArgU, OpArgU, IAx /*  */, "EXTRAARG", nil},      // extra (larger) argument for previous opcode
+	{1, 0, OpArgK, OpArgK, IABC /* */, "IN      ", in},       // if ((RK(B) in RK(C)) ~= A) then pc++
 }
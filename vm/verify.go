@@ -0,0 +1,255 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lollipopkit/lk/binchunk"
+)
+
+// Verify checks that the code of proto and its sub protos only uses
+// registers, constants, upvalues, protos and jump targets that exist,
+// so a corrupted or malicious chunk is refused before it runs.
+func Verify(proto *binchunk.Prototype) error {
+	return verifyProto(proto, nil)
+}
+
+type verifier struct {
+	proto *binchunk.Prototype
+	pc    int
+	inst  Instruction
+}
+
+func verifyProto(proto *binchunk.Prototype, parent *binchunk.Prototype) error {
+	if parent == nil && proto.LineDefined == 0 {
+		/* a main proto only has _ENV; dumped functions get nil upvalues */
+		if len(proto.Upvalues) > 1 {
+			return fmt.Errorf("%s: %d upvalues, at most 1", protoName(proto), len(proto.Upvalues))
+		}
+		if len(proto.Upvalues) == 1 && proto.Upvalues[0].Instack != 1 {
+			return fmt.Errorf("%s: upvalue 0 is not in the stack", protoName(proto))
+		}
+	} else if parent != nil {
+		for i, uv := range proto.Upvalues {
+			if uv.Instack == 1 && int(uv.Idx) >= int(parent.MaxStackSize) ||
+				uv.Instack != 1 && int(uv.Idx) >= len(parent.Upvalues) {
+				return fmt.Errorf("%s: upvalue %d: invalid index %d", protoName(proto), i, uv.Idx)
+			}
+		}
+	}
+	if n := len(proto.Code); n == 0 || Instruction(proto.Code[n-1]).Opcode() != OP_RETURN {
+		return fmt.Errorf("%s: must end with RETURN", protoName(proto))
+	}
+
+	v := &verifier{proto: proto}
+	for v.pc = range proto.Code {
+		v.inst = Instruction(proto.Code[v.pc])
+		if err := v.verifyInst(); err != nil {
+			return fmt.Errorf("%s: [%d] %w", protoName(proto), v.pc+1, err)
+		}
+	}
+
+	for _, p := range proto.Protos {
+		if err := verifyProto(p, proto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func protoName(proto *binchunk.Prototype) string {
+	if proto.LineDefined == 0 {
+		return "main " + proto.Source
+	}
+	return fmt.Sprintf("fn %s:%d", proto.Source, proto.LineDefined)
+}
+
+func (v *verifier) verifyInst() error {
+	op := v.inst.Opcode()
+	if op >= len(opcodes) {
+		return fmt.Errorf("invalid opcode %d", op)
+	}
+	if op >= OP_EQ_JMP { /* fused, checked as its first instruction */
+		if err := v.next(fusions[op-OP_EQ_JMP].next); err != nil {
+			return err
+		}
+		v.inst = v.inst.Unfused()
+		op = v.inst.Opcode()
+	}
+	if op == OP_EXTRAARG {
+		return nil // checked with the previous instruction
+	}
+	if opcodes[op].testFlag == 1 && v.next(OP_JMP) != nil {
+		return fmt.Errorf("%s must be followed by JMP", opName(op))
+	}
+
+	switch v.inst.OpMode() {
+	case IABC:
+		a, b, c := v.inst.ABC()
+		if err := v.abc(op, a, b, c); err != nil {
+			return err
+		}
+	case IABx:
+		a, bx := v.inst.ABx()
+		if err := v.reg(a); err != nil {
+			return err
+		}
+		switch op {
+		case OP_LOADK:
+			return v.constant(bx)
+		case OP_LOADKX:
+			if err := v.next(OP_EXTRAARG); err != nil {
+				return err
+			}
+			return v.constant(Instruction(v.proto.Code[v.pc+1]).Ax())
+		case OP_CLOSURE:
+			if bx >= len(v.proto.Protos) {
+				return fmt.Errorf("invalid proto %d", bx)
+			}
+		}
+	case IAsBx:
+		a, sbx := v.inst.AsBx()
+		if op == OP_JMP {
+			if a > 0 { /* close upvalues >= R(A - 1) */
+				if err := v.reg(a - 1); err != nil {
+					return err
+				}
+			}
+		} else if op == OP_TFORLOOP {
+			if err := v.regs(a, 2); err != nil {
+				return err
+			}
+		} else if err := v.regs(a, 4); err != nil { /* R(A)...R(A+3) */
+			return err
+		}
+		if target := v.pc + 1 + sbx; target < 0 || target >= len(v.proto.Code) {
+			return fmt.Errorf("invalid jump target %d", target+1)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) abc(op, a, b, c int) error {
+	switch op {
+	case OP_SETTABUP:
+		if err := v.upvalue(a); err != nil {
+			return err
+		}
+	case OP_EQ, OP_LT, OP_LE, OP_IN: /* A is a flag */
+	case OP_LOADNIL:
+		return v.regs(a, b+1)
+	case OP_GETUPVAL, OP_SETUPVAL:
+		if err := v.reg(a); err != nil {
+			return err
+		}
+		return v.upvalue(b)
+	case OP_GETTABUP:
+		if err := v.reg(a); err != nil {
+			return err
+		}
+		if err := v.upvalue(b); err != nil {
+			return err
+		}
+		return v.rk(c)
+	case OP_SELF:
+		if err := v.regs(a, 2); err != nil {
+			return err
+		}
+	case OP_CALL, OP_TAILCALL:
+		if b > 0 {
+			if err := v.regs(a, b); err != nil {
+				return err
+			}
+		}
+		if c > 1 {
+			return v.regs(a, c-1)
+		}
+		return v.reg(a)
+	case OP_RETURN, OP_VARARG:
+		if b > 1 {
+			return v.regs(a, b-1)
+		}
+		if op == OP_VARARG {
+			return v.reg(a)
+		}
+		return nil
+	case OP_TFORCALL:
+		return v.regs(a, 3+c)
+	case OP_SETLIST:
+		if c == 0 {
+			if err := v.next(OP_EXTRAARG); err != nil {
+				return err
+			}
+		}
+		return v.regs(a, b+1)
+	default:
+		if err := v.reg(a); err != nil {
+			return err
+		}
+	}
+
+	for _, arg := range []struct {
+		val  int
+		mode byte
+	}{{b, v.inst.BMode()}, {c, v.inst.CMode()}} {
+		var err error
+		switch arg.mode {
+		case OpArgR:
+			err = v.reg(arg.val)
+		case OpArgK:
+			err = v.rk(arg.val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) next(op int) error {
+	if v.pc+1 >= len(v.proto.Code) || Instruction(v.proto.Code[v.pc+1]).Opcode() != op {
+		return fmt.Errorf("%s must be followed by %s", opName(v.inst.Opcode()), opName(op))
+	}
+	return nil
+}
+
+// opName returns the name of op without its padding.
+func opName(op int) string {
+	return strings.TrimSpace(opcodes[op].name)
+}
+
+func (v *verifier) reg(r int) error {
+	if r < 0 || r >= int(v.proto.MaxStackSize) {
+		return fmt.Errorf("register %d out of stack size %d", r, v.proto.MaxStackSize)
+	}
+	return nil
+}
+
+// regs checks R(a)...R(a+n-1)
+func (v *verifier) regs(a, n int) error {
+	if err := v.reg(a); err != nil {
+		return err
+	}
+	return v.reg(a + n - 1)
+}
+
+func (v *verifier) rk(rk int) error {
+	if rk > 0xFF {
+		return v.constant(rk & 0xFF)
+	}
+	return v.reg(rk)
+}
+
+func (v *verifier) constant(k int) error {
+	if k >= len(v.proto.Constants) {
+		return fmt.Errorf("invalid constant %d", k)
+	}
+	return nil
+}
+
+func (v *verifier) upvalue(n int) error {
+	if n >= len(v.proto.Upvalues) {
+		return fmt.Errorf("invalid upvalue %d", n)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/lk/binchunk"
+)
+
+func abc(op, a, b, c int) uint32 { return uint32(b<<23 | c<<14 | a<<6 | op) }
+func abx(op, a, bx int) uint32   { return uint32(bx<<14 | a<<6 | op) }
+func asbx(op, a, sbx int) uint32 { return abx(op, a, sbx+MAXARG_sBx) }
+
+func TestVerify(t *testing.T) {
+	ret := abc(OP_RETURN, 0, 1, 0)
+	for _, tt := range []struct {
+		name string
+		code []uint32
+		err  string // "" if valid
+	}{
+		{"valid", []uint32{abx(OP_LOADK, 0, 0), abc(OP_ADD, 1, 0, 0x100), ret}, ""},
+		{"fused", []uint32{abc(OP_EQ_JMP, 0, 0, 1), asbx(OP_JMP, 0, 0), ret}, ""},
+		{"empty", nil, "must end with RETURN"},
+		{"no return", []uint32{abx(OP_LOADK, 0, 0)}, "must end with RETURN"},
+		{"bad opcode", []uint32{abc(0x3F, 0, 0, 0), ret}, "[1] invalid opcode 63"},
+		{"bad register", []uint32{abc(OP_MOVE, 2, 0, 0), ret}, "[1] register 2 out of stack size 2"},
+		{"bad rk register", []uint32{abc(OP_ADD, 0, 0, 5), ret}, "[1] register 5 out of stack size 2"},
+		{"bad constant", []uint32{abx(OP_LOADK, 0, 1), ret}, "[1] invalid constant 1"},
+		{"bad rk constant", []uint32{abc(OP_ADD, 0, 0, 0x101), ret}, "[1] invalid constant 1"},
+		{"bad upvalue", []uint32{abc(OP_GETUPVAL, 0, 0, 0), ret}, "[1] invalid upvalue 0"},
+		{"bad proto", []uint32{abx(OP_CLOSURE, 0, 0), ret}, "[1] invalid proto 0"},
+		{"jump past end", []uint32{asbx(OP_JMP, 0, 1), ret}, "[1] invalid jump target 3"},
+		{"jump before start", []uint32{asbx(OP_JMP, 0, -2), ret}, "[1] invalid jump target 0"},
+		{"test without jmp", []uint32{abc(OP_EQ, 0, 0, 1), ret}, "[1] EQ must be followed by JMP"},
+		{"fused last", []uint32{ret, abc(OP_MOVE_CALL, 0, 1, 0)}, "must end with RETURN"},
+		{"fused before another", []uint32{abc(OP_MOVE_CALL, 0, 1, 0), ret}, "[1] MOVE+ must be followed by CALL"},
+		{"loadkx without extraarg", []uint32{abx(OP_LOADKX, 0, 0), ret}, "must be followed by EXTRAARG"},
+	} {
+		proto := &binchunk.Prototype{
+			Source:       "test",
+			MaxStackSize: 2,
+			Code:         tt.code,
+			Constants:    []any{int64(1)},
+		}
+		err := Verify(proto)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifySubProto(t *testing.T) {
+	sub := &binchunk.Prototype{
+		Source:      "test",
+		LineDefined: 3,
+		Code:        []uint32{abc(OP_RETURN, 0, 1, 0)},
+		Upvalues:    []binchunk.Upvalue{{Instack: 1, Idx: 2}},
+	}
+	main := &binchunk.Prototype{
+		Source:       "test",
+		MaxStackSize: 2,
+		Code:         []uint32{abx(OP_CLOSURE, 0, 0), abc(OP_RETURN, 0, 1, 0)},
+		Protos:       []*binchunk.Prototype{sub},
+	}
+	if err := Verify(main); err == nil || err.Error() != "fn test:3: upvalue 0: invalid index 2" {
+		t.Fatalf("got %v", err)
+	}
+	sub.Upvalues[0].Idx = 1
+	if err := Verify(main); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyMainUpvalues(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		upvalues []binchunk.Upvalue
+		err      string // "" if valid
+	}{
+		{"none", nil, ""},
+		{"env", []binchunk.Upvalue{{Instack: 1, Idx: 0}}, ""},
+		{"two", []binchunk.Upvalue{{Instack: 1, Idx: 0}, {Instack: 1, Idx: 1}}, "main test: 2 upvalues, at most 1"},
+		{"not in stack", []binchunk.Upvalue{{Instack: 0, Idx: 0}}, "main test: upvalue 0 is not in the stack"},
+	} {
+		proto := &binchunk.Prototype{
+			Source:   "test",
+			Code:     []uint32{abc(OP_RETURN, 0, 1, 0)},
+			Upvalues: tt.upvalues,
+		}
+		err := Verify(proto)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}